@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/runner"
+	"crypto-trading-bot-engine/strategy"
+	"crypto-trading-bot-engine/strategy/contract"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+)
+
+// Events of EventsCh recorded into 'strategy_events'
+const (
+	AUDIT_EVENT_ENABLE      = "enable"
+	AUDIT_EVENT_DISABLE     = "disable"
+	AUDIT_EVENT_OUT_OF_SYNC = "out_of_sync"
+	AUDIT_EVENT_RESET       = "reset"
+	AUDIT_EVENT_RESTART     = "restart"
+	AUDIT_EVENT_OUTCOME     = "outcome_" // + outcome event e.g. outcome_entry
+)
+
+// Process the event sent to handler and record it, the outcome is the state of the strategy after it's processed
+// NOTE Event handlers log the errors instead of returning, the state tells whether it worked e.g. 'enabled: 0' after enable
+func (h *runnerHandler) auditEvent(market string, event string, uuid string, process func(string)) {
+	h.auditParamsEvent(market, event, uuid, func(uuid string) datatypes.JSONMap {
+		process(uuid)
+		return datatypes.JSONMap{} // events don't change params
+	})
+}
+
+// Same as auditEvent, for the event changing params e.g. restart, process returns what has been changed
+func (h *runnerHandler) auditParamsEvent(market string, event string, uuid string, process func(string) datatypes.JSONMap) {
+	markPrice := h.lastMarkPrice(market, uuid)
+	diff := process(uuid)
+
+	e := db.StrategyEvent{
+		StrategyUuid: uuid,
+		Market:       market,
+		Source:       db.STRATEGY_EVENT_SOURCE_EVENTS_CH,
+		Event:        event,
+		MarkPrice:    markPrice,
+		ParamsDiff:   diff,
+		Outcome:      h.strategyState(market, uuid),
+	}
+	if _, _, err := h.db.CreateStrategyEvent(e); err != nil {
+		h.logger.Printf("[Error] auditEvent - strategy: '%s', event: '%s', failed to record, err: %v", uuid, event, err)
+	}
+}
+
+func (h *runnerHandler) auditOutcome(outcome strategy.Outcome) {
+	h.auditEvent(db.MARKET_CONTRACT, AUDIT_EVENT_OUTCOME+outcome.Event, outcome.Uuid, func(string) {
+		h.handleOutcome(outcome)
+	})
+}
+
+// Zero if the runner isn't running
+func (h *runnerHandler) lastMarkPrice(market string, uuid string) decimal.Decimal {
+	switch market {
+	case db.MARKET_CONTRACT:
+		if r, ok := h.runnerByUuidMap.Load(uuid); ok {
+			return r.(*runner.ContractStrategyRunner).LastMarkPrice()
+		}
+	case db.MARKET_SPOT:
+		if r, ok := h.spotRunnerByUuidMap.Load(uuid); ok {
+			return r.(*runner.SpotStrategyRunner).LastMarkPrice()
+		}
+	case db.MARKET_PAIR:
+		if r, ok := h.pairRunnerByUuidMap.Load(uuid); ok {
+			return r.(*runner.PairStrategyRunner).LastMarkPrice()
+		}
+	}
+	return decimal.Zero
+}
+
+func (h *runnerHandler) strategyState(market string, uuid string) string {
+	var enabled, positionStatus int64
+	var err error
+	switch market {
+	case db.MARKET_CONTRACT:
+		var cs *db.ContractStrategy
+		if cs, err = h.db.GetContractStrategyByUuid(uuid); err == nil {
+			enabled, positionStatus = cs.Enabled, cs.PositionStatus
+		}
+	case db.MARKET_SPOT:
+		var ss *db.SpotStrategy
+		if ss, err = h.db.GetSpotStrategyByUuid(uuid); err == nil {
+			enabled, positionStatus = ss.Enabled, ss.PositionStatus
+		}
+	case db.MARKET_PAIR:
+		var ps *db.PairStrategy
+		if ps, err = h.db.GetPairStrategyByUuid(uuid); err == nil {
+			enabled, positionStatus = ps.Enabled, ps.PositionStatus
+		}
+	}
+	if err != nil {
+		return fmt.Sprintf("error: failed to get strategy, err: %v", err)
+	}
+	return fmt.Sprintf("enabled: %d, position_status: %s", enabled, contract.TranslateStatusByInt(positionStatus))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/backtest"
+	"crypto-trading-bot-engine/strategy/order"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// e.g. backtest -data trades.csv -params params.json -side long -margin 1000
+func runBacktest(args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	dataPath := fs.String("data", "", "trades or candles file (.csv or .jsonl)")
+	paramsPath := fs.String("params", "", "params JSON file, same as 'contract_strategies.params'")
+	side := fs.String("side", "long", "long or short")
+	margin := fs.Float64("margin", 1000, "margin of each position")
+	fee := fs.Float64("fee", 0.0007, "taker fee")
+	slippage := fs.Float64("slippage", 0.05, "slippage percent of market orders")
+	fs.Parse(args)
+
+	config, err := newBacktestConfig(*side, *margin, *fee, *slippage)
+	if err != nil {
+		return err
+	}
+	params, err := ioutil.ReadFile(*paramsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read params, err: %v", err)
+	}
+	marks, err := backtest.LoadMarks(*dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to load data, err: %v", err)
+	}
+
+	report, err := backtest.Run(config, params, marks)
+	if err != nil {
+		return err
+	}
+	report.Write(os.Stdout)
+	return nil
+}
+
+func newBacktestConfig(side string, margin float64, fee float64, slippage float64) (config backtest.Config, err error) {
+	switch strings.ToLower(side) {
+	case "long":
+		config.Side = order.LONG
+	case "short":
+		config.Side = order.SHORT
+	default:
+		return config, fmt.Errorf("side '%s' not supported", side)
+	}
+	config.Margin = decimal.NewFromFloat(margin)
+	config.TakerFee = decimal.NewFromFloat(fee)
+	config.SlippagePercent = decimal.NewFromFloat(slippage)
+	return
+}
+
+// e.g. sweep -data trades.csv -params template.json -side long -margin 1000 -out results.csv
+func runSweep(args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	dataPath := fs.String("data", "", "trades or candles file (.csv or .jsonl)")
+	paramsPath := fs.String("params", "", "params template JSON file, numeric fields can be {\"range\": [min, max, step]} or {\"list\": [...]}")
+	side := fs.String("side", "long", "long or short")
+	margin := fs.Float64("margin", 1000, "margin of each position")
+	fee := fs.Float64("fee", 0.0007, "taker fee")
+	slippage := fs.Float64("slippage", 0.05, "slippage percent of market orders")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of combinations replayed in parallel")
+	outPath := fs.String("out", "", "output CSV file, stdout if it's empty")
+	fs.Parse(args)
+
+	config, err := newBacktestConfig(*side, *margin, *fee, *slippage)
+	if err != nil {
+		return err
+	}
+	template, err := ioutil.ReadFile(*paramsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read params template, err: %v", err)
+	}
+	combinations, err := backtest.ExpandParams(template)
+	if err != nil {
+		return err
+	}
+	marks, err := backtest.LoadMarks(*dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to load data, err: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "replaying %d combinations over %d marks with %d workers\n", len(combinations), len(marks), *workers)
+	results := backtest.Sweep(config, combinations, marks, *workers)
+
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	return backtest.WriteSweepCsv(out, results)
+}
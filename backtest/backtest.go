@@ -0,0 +1,114 @@
+package backtest
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/shopspring/decimal"
+)
+
+type Config struct {
+	Side            order.Side
+	Margin          decimal.Decimal // e.g. 1000 USD per position
+	TakerFee        decimal.Decimal // e.g. 0.0007
+	SlippagePercent decimal.Decimal // e.g. 0.05 means market orders are filled at mark +/- 0.05%
+}
+
+type Report struct {
+	Trades      []Trade
+	Events      []Event
+	NetPnL      decimal.Decimal
+	Fees        decimal.Decimal
+	MaxDrawdown decimal.Decimal // the largest drop of realised equity from its peak
+	WinRate     decimal.Decimal // e.g. 0.6 means 60% of the trades are profitable
+	Halted      bool            // strategy was halted e.g. take-profit, the rest of data was skipped
+}
+
+// Replay the marks through contract.Contract
+// params is the same JSON as 'contract_strategies.params'
+func Run(config Config, params []byte, marks []contract.Mark) (*Report, error) {
+	// NOTE Decode params for every run, as the contract and hook update the params in place
+	var data map[string]interface{}
+	if err := json.Unmarshal(params, &data); err != nil {
+		return nil, fmt.Errorf("failed to decode params, err: %v", err)
+	}
+	c, err := contract.NewContract(config.Side, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid params, err: %v", err)
+	}
+	h := newSimHook(config)
+	c.SetHook(h)
+
+	report := &Report{}
+	for _, mark := range marks {
+		h.checkStopOrder(mark)
+		halted, err := c.CheckPrice(mark)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check price %s at '%s', err: %v", mark.Price, mark.Time, err)
+		}
+		if halted {
+			report.Halted = true
+			break
+		}
+	}
+
+	// Close the position at the last mark, so that PnL can be compared
+	if h.position != nil {
+		h.addEvent("EndOfData", h.mark.Price)
+		h.close(h.mark.Price, REASON_END_OF_DATA)
+	}
+
+	report.Trades = h.trades
+	report.Events = h.events
+	report.calculate()
+	return report, nil
+}
+
+func (r *Report) calculate() {
+	var equity, peak decimal.Decimal
+	wins := 0
+	for _, t := range r.Trades {
+		r.Fees = r.Fees.Add(t.Fee)
+		equity = equity.Add(t.PnL)
+		if equity.GreaterThan(peak) {
+			peak = equity
+		}
+		if dd := peak.Sub(equity); dd.GreaterThan(r.MaxDrawdown) {
+			r.MaxDrawdown = dd
+		}
+		if t.PnL.IsPositive() {
+			wins++
+		}
+	}
+	r.NetPnL = equity
+	if len(r.Trades) > 0 {
+		r.WinRate = decimal.NewFromInt(int64(wins)).DivRound(decimal.NewFromInt(int64(len(r.Trades))), 4)
+	}
+}
+
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintln(w, "# Events")
+	for _, e := range r.Events {
+		fmt.Fprintf(w, "%s  %-30s %s\n", e.Time.Format("2006-01-02 15:04:05"), e.Name, e.Price.StringFixed(4))
+	}
+
+	fmt.Fprintln(w, "\n# Trades")
+	for i, t := range r.Trades {
+		fmt.Fprintf(w, "%3d %-5s size: %s entry: %s @%s exit: %s @%s reason: %s fee: %s pnl: %s\n",
+			i+1, order.TranslateSide(t.Side), t.Size, t.EntryPrice.StringFixed(4), t.EntryTime.Format("2006-01-02 15:04:05"),
+			t.ExitPrice.StringFixed(4), t.ExitTime.Format("2006-01-02 15:04:05"), t.Reason, t.Fee.StringFixed(4), t.PnL.StringFixed(4))
+	}
+
+	fmt.Fprintln(w, "\n# Summary")
+	fmt.Fprintf(w, "trades: %d\n", len(r.Trades))
+	fmt.Fprintf(w, "net pnl: %s\n", r.NetPnL.StringFixed(4))
+	fmt.Fprintf(w, "fees: %s\n", r.Fees.StringFixed(4))
+	fmt.Fprintf(w, "max drawdown: %s\n", r.MaxDrawdown.StringFixed(4))
+	fmt.Fprintf(w, "win rate: %s%%\n", r.WinRate.Mul(decimal.NewFromInt(100)).StringFixed(2))
+	if r.Halted {
+		fmt.Fprintln(w, "halted: true (the rest of data was skipped)")
+	}
+}
@@ -0,0 +1,194 @@
+package backtest
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const testLimitParams = `{
+  "entry_type": "limit",
+  "entry_order": {
+    "trigger": {"trigger_type": "limit", "operator": ">=", "price": "100"}
+  },
+  "stop_loss_order": {
+    "trigger": {"trigger_type": "limit", "operator": "<=", "price": "90"}
+  },
+  "take_profit_order": {
+    "trigger": {"trigger_type": "limit", "operator": ">=", "price": "120"}
+  }
+}`
+
+func testMarks(prices ...int64) []contract.Mark {
+	start := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	marks := make([]contract.Mark, len(prices))
+	for i, p := range prices {
+		marks[i] = contract.Mark{Price: decimal.NewFromInt(p), Time: start.Add(time.Minute * time.Duration(i))}
+	}
+	return marks
+}
+
+func TestRun(t *testing.T) {
+	testcases := []struct {
+		title               string
+		marks               []contract.Mark
+		expectedReasons     []string
+		expectedNetPnL      string
+		expectedMaxDrawdown string
+		expectedWinRate     string
+		expectedHalted      bool
+	}{
+		{
+			title:               "stop-loss then take-profit",
+			marks:               testMarks(95, 100, 95, 90, 95, 100, 110, 120, 130),
+			expectedReasons:     []string{REASON_STOP_LOSS, REASON_TAKE_PROFIT},
+			expectedNetPnL:      "100",
+			expectedMaxDrawdown: "100",
+			expectedWinRate:     "0.5",
+			expectedHalted:      true,
+		},
+		{
+			title:               "closed at the end of data",
+			marks:               testMarks(95, 100, 110),
+			expectedReasons:     []string{REASON_END_OF_DATA},
+			expectedNetPnL:      "100",
+			expectedMaxDrawdown: "0",
+			expectedWinRate:     "1",
+			expectedHalted:      false,
+		},
+		{
+			title:               "no entry",
+			marks:               testMarks(95, 96, 97),
+			expectedReasons:     nil,
+			expectedNetPnL:      "0",
+			expectedMaxDrawdown: "0",
+			expectedWinRate:     "0",
+			expectedHalted:      false,
+		},
+	}
+
+	// No fee and slippage to make numbers simple, size is 10
+	config := Config{Side: order.LONG, Margin: decimal.NewFromInt(1000)}
+	for _, tc := range testcases {
+		report, err := Run(config, []byte(testLimitParams), tc.marks)
+		if err != nil {
+			t.Fatalf("TestRun case '%s' - unexpected error: %v", tc.title, err)
+		}
+		var reasons []string
+		for _, trade := range report.Trades {
+			reasons = append(reasons, trade.Reason)
+		}
+		if !reflect.DeepEqual(reasons, tc.expectedReasons) {
+			t.Errorf("TestRun case '%s' - expect reasons %v, but got %v", tc.title, tc.expectedReasons, reasons)
+		}
+		if !report.NetPnL.Equal(decimal.RequireFromString(tc.expectedNetPnL)) {
+			t.Errorf("TestRun case '%s' - expect net pnl '%s', but got '%s'", tc.title, tc.expectedNetPnL, report.NetPnL)
+		}
+		if !report.MaxDrawdown.Equal(decimal.RequireFromString(tc.expectedMaxDrawdown)) {
+			t.Errorf("TestRun case '%s' - expect max drawdown '%s', but got '%s'", tc.title, tc.expectedMaxDrawdown, report.MaxDrawdown)
+		}
+		if !report.WinRate.Equal(decimal.RequireFromString(tc.expectedWinRate)) {
+			t.Errorf("TestRun case '%s' - expect win rate '%s', but got '%s'", tc.title, tc.expectedWinRate, report.WinRate)
+		}
+		if report.Halted != tc.expectedHalted {
+			t.Errorf("TestRun case '%s' - expect halted '%t', but got '%t'", tc.title, tc.expectedHalted, report.Halted)
+		}
+	}
+}
+
+func TestRunWithFeeAndStopOrder(t *testing.T) {
+	config := Config{
+		Side:            order.LONG,
+		Margin:          decimal.NewFromInt(1000),
+		TakerFee:        decimal.NewFromFloat(0.001),
+		SlippagePercent: decimal.NewFromFloat(0.1),
+	}
+	report, err := Run(config, []byte(testLimitParams), testMarks(100, 85))
+	if err != nil {
+		t.Fatalf("TestRunWithFeeAndStopOrder - unexpected error: %v", err)
+	}
+
+	// The stop order on the exchange is filled before the engine sees the mark
+	var names []string
+	for _, e := range report.Events {
+		names = append(names, e.Name)
+	}
+	expectedNames := []string{"EntryTriggered", "StopLossTriggerCreated", "ParamsUpdated", "ExchangeStopOrderFilled", "StopLossTriggered", "ParamsUpdated"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("TestRunWithFeeAndStopOrder - expect events %v, but got %v", expectedNames, names)
+	}
+
+	// buy 10 @100.1, sell 10 @84.915, fee 1.001 + 0.84915
+	if len(report.Trades) != 1 {
+		t.Fatalf("TestRunWithFeeAndStopOrder - expect 1 trade, but got %d", len(report.Trades))
+	}
+	trade := report.Trades[0]
+	if !trade.Fee.Equal(decimal.RequireFromString("1.85015")) || !trade.PnL.Equal(decimal.RequireFromString("-153.70015")) {
+		t.Errorf("TestRunWithFeeAndStopOrder - unexpected fee '%s' and pnl '%s'", trade.Fee, trade.PnL)
+	}
+}
+
+func TestRunInvalidParams(t *testing.T) {
+	config := Config{Side: order.LONG, Margin: decimal.NewFromInt(1000)}
+	if _, err := Run(config, []byte(`{"entry_type": "limit"}`), testMarks(100)); err == nil {
+		t.Error("TestRunInvalidParams - expect error")
+	}
+}
+
+func TestReadData(t *testing.T) {
+	testcases := []struct {
+		title          string
+		reader         func() ([]contract.Mark, error)
+		expectedPrices []string
+		expectedError  bool
+	}{
+		{
+			title: "csv trades",
+			reader: func() ([]contract.Mark, error) {
+				return readCsv(strings.NewReader("time,price\n2021-11-01T00:00:00Z,100\n1635724860,101.5\n"))
+			},
+			expectedPrices: []string{"100", "101.5"},
+		},
+		{
+			title: "csv bullish candle",
+			reader: func() ([]contract.Mark, error) {
+				return readCsv(strings.NewReader("time,open,high,low,close\n1635724800000,100,110,90,105\n"))
+			},
+			expectedPrices: []string{"100", "90", "110", "105"},
+		},
+		{
+			title: "jsonl trades and bearish candle",
+			reader: func() ([]contract.Mark, error) {
+				return readJsonl(strings.NewReader(`{"time": "2021-11-01T00:00:00Z", "price": "100"}` + "\n" + `{"time": 1635724860, "open": 105, "high": 110, "low": 90, "close": 95}`))
+			},
+			expectedPrices: []string{"100", "105", "110", "90", "95"},
+		},
+		{
+			title: "invalid price",
+			reader: func() ([]contract.Mark, error) {
+				return readCsv(strings.NewReader("time,price\n2021-11-01T00:00:00Z,abc\n"))
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		marks, err := tc.reader()
+		if hasError := (err != nil); hasError != tc.expectedError {
+			t.Errorf("TestReadData case '%s' - expect error '%t', but got '%v'", tc.title, tc.expectedError, err)
+			continue
+		}
+		var prices []string
+		for _, m := range marks {
+			prices = append(prices, m.Price.String())
+		}
+		if !reflect.DeepEqual(prices, tc.expectedPrices) {
+			t.Errorf("TestReadData case '%s' - expect prices %v, but got %v", tc.title, tc.expectedPrices, prices)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package backtest
+
+import (
+	"bufio"
+	"crypto-trading-bot-engine/strategy/contract"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Load marks from historical trades or candles, the format is decided by the extension of the file
+//
+// CSV requires the header, trades: 'time,price', candles: 'time,open,high,low,close'
+// JSONL trades: {"time": "...", "price": "..."}, candles: {"time": "...", "open": "...", "high": "...", "low": "...", "close": "..."}
+// time can be RFC3339 or unix timestamp in seconds or milliseconds
+func LoadMarks(path string) ([]contract.Mark, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCsv(f)
+	case ".jsonl":
+		return readJsonl(f)
+	}
+	return nil, fmt.Errorf("file extension of '%s' not supported", path)
+}
+
+func readCsv(r io.Reader) ([]contract.Mark, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header, err: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var marks []contract.Mark
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d, err: %v", line, err)
+		}
+		row := make(map[string]string)
+		for name, i := range columns {
+			if i < len(record) {
+				row[name] = strings.TrimSpace(record[i])
+			}
+		}
+		ms, err := rowToMarks(row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d, err: %v", line, err)
+		}
+		marks = append(marks, ms...)
+	}
+	return marks, nil
+}
+
+func readJsonl(r io.Reader) ([]contract.Mark, error) {
+	var marks []contract.Mark
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &data); err != nil {
+			return nil, fmt.Errorf("line %d, err: %v", line, err)
+		}
+		row := make(map[string]string)
+		for name, v := range data {
+			switch vv := v.(type) {
+			case string:
+				row[name] = vv
+			case float64:
+				row[name] = strconv.FormatFloat(vv, 'f', -1, 64)
+			}
+		}
+		ms, err := rowToMarks(row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d, err: %v", line, err)
+		}
+		marks = append(marks, ms...)
+	}
+	return marks, scanner.Err()
+}
+
+// Trade turns into 1 mark, candle turns into 4 marks
+func rowToMarks(row map[string]string) ([]contract.Mark, error) {
+	t, err := parseTime(row["time"])
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := row["price"]; ok {
+		p, err := decimal.NewFromString(row["price"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid price '%s'", row["price"])
+		}
+		return []contract.Mark{{Price: p, Time: t}}, nil
+	}
+
+	var ohlc [4]decimal.Decimal
+	for i, name := range []string{"open", "high", "low", "close"} {
+		if ohlc[i], err = decimal.NewFromString(row[name]); err != nil {
+			return nil, fmt.Errorf("invalid %s '%s'", name, row[name])
+		}
+	}
+	return candleToMarks(t, ohlc[0], ohlc[1], ohlc[2], ohlc[3]), nil
+}
+
+// NOTE The path within the candle is unknown, assume that bullish candle goes low first and bearish candle goes high first
+func candleToMarks(t time.Time, open, high, low, close decimal.Decimal) []contract.Mark {
+	prices := []decimal.Decimal{open, high, low, close}
+	if close.GreaterThanOrEqual(open) {
+		prices = []decimal.Decimal{open, low, high, close}
+	}
+	marks := make([]contract.Mark, len(prices))
+	for i, p := range prices {
+		// Keep the order of marks by time, it matters to trendline
+		marks[i] = contract.Mark{Price: p, Time: t.Add(time.Millisecond * time.Duration(i))}
+	}
+	return marks
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("'time' is missing")
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// unix timestamp in milliseconds has 13 digits
+		if len(s) >= 13 {
+			return time.UnixMilli(n).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time '%s'", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,168 @@
+package backtest
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	REASON_STOP_LOSS   = "stop_loss"
+	REASON_TAKE_PROFIT = "take_profit"
+	REASON_END_OF_DATA = "end_of_data"
+)
+
+type Event struct {
+	Time  time.Time
+	Name  string // name of the hook function
+	Price decimal.Decimal
+}
+
+type Trade struct {
+	Side       order.Side
+	Size       decimal.Decimal
+	EntryTime  time.Time
+	EntryPrice decimal.Decimal
+	ExitTime   time.Time
+	ExitPrice  decimal.Decimal
+	Reason     string // stop_loss, take_profit, end_of_data
+	Fee        decimal.Decimal
+	PnL        decimal.Decimal // realised PnL after fees
+}
+
+// Simulated hook, it models the fills of market orders and the stop order on the exchange
+// NOTE See runner.contractHook, the flow should be the same
+type simHook struct {
+	config Config
+
+	// Current mark, CheckPrice doesn't pass time to every hook function
+	mark contract.Mark
+
+	// Open position
+	position *Trade
+
+	// Stop order on the exchange, it's filled by the mark before the engine sees it
+	stopOrderPrice  *decimal.Decimal
+	stopOrderFilled bool
+
+	trades []Trade
+	events []Event
+}
+
+func newSimHook(config Config) *simHook {
+	return &simHook{
+		config: config,
+	}
+}
+
+// Fill the stop order on the exchange if the mark hits the trigger price
+func (h *simHook) checkStopOrder(mark contract.Mark) {
+	h.mark = mark
+	if h.position == nil || h.stopOrderPrice == nil || h.stopOrderFilled {
+		return
+	}
+	triggered := false
+	switch h.position.Side {
+	case order.LONG:
+		triggered = mark.Price.LessThanOrEqual(*h.stopOrderPrice)
+	case order.SHORT:
+		triggered = mark.Price.GreaterThanOrEqual(*h.stopOrderPrice)
+	}
+	if triggered {
+		h.addEvent("ExchangeStopOrderFilled", mark.Price)
+		h.close(mark.Price, REASON_STOP_LOSS)
+		h.stopOrderFilled = true
+	}
+}
+
+func (h *simHook) EntryTriggered(c *contract.Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	h.addEvent("EntryTriggered", p)
+
+	price := h.fillPrice(c.Side == order.LONG, p)
+	size := h.config.Margin.DivRound(p, 8)
+	h.position = &Trade{
+		Side:       c.Side,
+		Size:       size,
+		EntryTime:  t,
+		EntryPrice: price,
+		Fee:        price.Mul(size).Mul(h.config.TakerFee),
+	}
+	h.stopOrderPrice = nil
+	h.stopOrderFilled = false
+	return p, false, nil
+}
+
+func (h *simHook) StopLossTriggerCreated(c *contract.Contract) (bool, error) {
+	p := c.StopLossOrder.(*order.StopLoss).Trigger.GetPrice(h.mark.Time)
+	h.addEvent("StopLossTriggerCreated", p)
+	h.stopOrderPrice = &p
+	return false, nil
+}
+
+// The position might have been closed by the stop order on the exchange already
+func (h *simHook) StopLossTriggered(c *contract.Contract, p decimal.Decimal) (bool, error) {
+	h.addEvent("StopLossTriggered", p)
+	if h.position != nil {
+		h.close(p, REASON_STOP_LOSS)
+	}
+	h.stopOrderPrice = nil
+	return false, nil
+}
+
+func (h *simHook) EntryTrendlineTriggerUpdated(c *contract.Contract) {
+	h.addEvent("EntryTrendlineTriggerUpdated", h.mark.Price)
+}
+
+func (h *simHook) EntryTriggerOperatorUpdated(c *contract.Contract) {
+	h.addEvent("EntryTriggerOperatorUpdated", h.mark.Price)
+}
+
+func (h *simHook) TakeProfitTriggered(c *contract.Contract, p decimal.Decimal) error {
+	h.addEvent("TakeProfitTriggered", p)
+	if h.position != nil {
+		h.close(p, REASON_TAKE_PROFIT)
+	}
+	h.stopOrderPrice = nil
+	return nil
+}
+
+func (h *simHook) ParamsUpdated(c *contract.Contract) (bool, error) {
+	h.addEvent("ParamsUpdated", h.mark.Price)
+	return false, nil
+}
+
+func (h *simHook) BreakoutPeakUpdated(c *contract.Contract) {
+	h.addEvent("BreakoutPeakUpdated", c.BreakoutPeak.Price)
+}
+
+// Close the position with market order
+func (h *simHook) close(p decimal.Decimal, reason string) {
+	t := h.position
+	t.ExitTime = h.mark.Time
+	t.ExitPrice = h.fillPrice(t.Side == order.SHORT, p)
+	t.Reason = reason
+	t.Fee = t.Fee.Add(t.ExitPrice.Mul(t.Size).Mul(h.config.TakerFee))
+	t.PnL = t.ExitPrice.Sub(t.EntryPrice).Mul(t.Size)
+	if t.Side == order.SHORT {
+		t.PnL = t.PnL.Neg()
+	}
+	t.PnL = t.PnL.Sub(t.Fee)
+
+	h.trades = append(h.trades, *t)
+	h.position = nil
+}
+
+// Market order is filled with slippage, buy at higher and sell at lower price
+func (h *simHook) fillPrice(buy bool, p decimal.Decimal) decimal.Decimal {
+	slippage := h.config.SlippagePercent.Div(decimal.NewFromInt(100))
+	if buy {
+		return p.Mul(decimal.NewFromInt(1).Add(slippage))
+	}
+	return p.Mul(decimal.NewFromInt(1).Sub(slippage))
+}
+
+func (h *simHook) addEvent(name string, p decimal.Decimal) {
+	h.events = append(h.events, Event{Time: h.mark.Time, Name: name, Price: p})
+}
@@ -0,0 +1,251 @@
+package backtest
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	MAX_COMBINATIONS = 100000 // avoid a typo of step running forever
+)
+
+// A combination of params expanded from the template
+type Combination struct {
+	Params []byte
+	Values map[string]string // key is the path of the field e.g. 'stop_loss_order.loss_tolerance_percent'
+}
+
+type SweepResult struct {
+	Combination
+	Report *Report
+	Err    error
+}
+
+// field in the template to be swept
+type sweepField struct {
+	path   []string
+	values []interface{}
+}
+
+// Expand the params template into combinations
+//
+// Numeric fields can be replaced with a range or a list, the other fields are kept as they are
+//
+//	{"range": [min, max, step]} e.g. {"range": [0.001, 0.005, 0.001]}, {"range": ["48000", "50000", "500"]}
+//	{"list": [...]} e.g. {"list": ["48000", "49000"]}
+//
+// Values of range keep the type of min, string for prices and number for percents
+func ExpandParams(template []byte) ([]Combination, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(template, &data); err != nil {
+		return nil, fmt.Errorf("failed to decode params template, err: %v", err)
+	}
+	fields, err := findSweepFields(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return strings.Join(fields[i].path, ".") < strings.Join(fields[j].path, ".")
+	})
+
+	total := 1
+	for _, f := range fields {
+		total *= len(f.values)
+		if total > MAX_COMBINATIONS {
+			return nil, fmt.Errorf("too many combinations, max: %d", MAX_COMBINATIONS)
+		}
+	}
+
+	combinations := make([]Combination, 0, total)
+	for n := 0; n < total; n++ {
+		// Decode the template again, so that combinations don't share the maps
+		var params map[string]interface{}
+		json.Unmarshal(template, &params)
+
+		values := make(map[string]string)
+		i := n
+		for _, f := range fields {
+			v := f.values[i%len(f.values)]
+			i /= len(f.values)
+			setField(params, f.path, v)
+			values[strings.Join(f.path, ".")] = fmt.Sprint(v)
+		}
+		b, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		combinations = append(combinations, Combination{Params: b, Values: values})
+	}
+	return combinations, nil
+}
+
+func findSweepFields(data map[string]interface{}, path []string) ([]sweepField, error) {
+	var fields []sweepField
+	for key, v := range data {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		p := append(append([]string{}, path...), key)
+
+		values, isSweep, err := sweepValues(m)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' %v", strings.Join(p, "."), err)
+		}
+		if isSweep {
+			fields = append(fields, sweepField{path: p, values: values})
+			continue
+		}
+
+		fs, err := findSweepFields(m, p)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, fs...)
+	}
+	return fields, nil
+}
+
+func sweepValues(m map[string]interface{}) (values []interface{}, isSweep bool, err error) {
+	if len(m) != 1 {
+		return
+	}
+	if list, ok := m["list"].([]interface{}); ok {
+		if len(list) == 0 {
+			return nil, true, errors.New("'list' is empty")
+		}
+		return list, true, nil
+	}
+	r, ok := m["range"].([]interface{})
+	if !ok {
+		return
+	}
+	isSweep = true
+	if len(r) != 3 {
+		return nil, true, errors.New("'range' should be [min, max, step]")
+	}
+	var nums [3]decimal.Decimal
+	for i, v := range r {
+		if nums[i], err = decimal.NewFromString(fmt.Sprint(v)); err != nil {
+			return nil, true, fmt.Errorf("invalid range value '%v'", v)
+		}
+	}
+	min, max, step := nums[0], nums[1], nums[2]
+	if !step.IsPositive() || min.GreaterThan(max) {
+		return nil, true, errors.New("'range' should be [min, max, step] with positive step")
+	}
+	_, isString := r[0].(string)
+	for v := min; v.LessThanOrEqual(max); v = v.Add(step) {
+		if len(values) >= MAX_COMBINATIONS {
+			return nil, true, fmt.Errorf("too many values, max: %d", MAX_COMBINATIONS)
+		}
+		if isString {
+			values = append(values, v.String())
+		} else {
+			f, _ := v.Float64()
+			values = append(values, f)
+		}
+	}
+	return
+}
+
+func setField(data map[string]interface{}, path []string, v interface{}) {
+	for _, key := range path[:len(path)-1] {
+		data = data[key].(map[string]interface{})
+	}
+	data[path[len(path)-1]] = v
+}
+
+// Replay every combination in parallel, results are ranked by net PnL, then max drawdown
+func Sweep(config Config, combinations []Combination, marks []contract.Mark, workers int) []SweepResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]SweepResult, len(combinations))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// NOTE marks are read only, it's safe to share them
+				report, err := Run(config, combinations[i].Params, marks)
+				if report != nil {
+					// Events aren't reported by sweep, release them
+					report.Events = nil
+				}
+				results[i] = SweepResult{Combination: combinations[i], Report: report, Err: err}
+			}
+		}()
+	}
+	for i := range combinations {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if (a.Err == nil) != (b.Err == nil) {
+			return a.Err == nil
+		}
+		if a.Err != nil {
+			return false
+		}
+		if !a.Report.NetPnL.Equal(b.Report.NetPnL) {
+			return a.Report.NetPnL.GreaterThan(b.Report.NetPnL)
+		}
+		return a.Report.MaxDrawdown.LessThan(b.Report.MaxDrawdown)
+	})
+	return results
+}
+
+func WriteSweepCsv(w io.Writer, results []SweepResult) error {
+	var keys []string
+	if len(results) > 0 {
+		for k := range results[0].Values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+
+	cw := csv.NewWriter(w)
+	header := append([]string{"rank"}, keys...)
+	header = append(header, "net_pnl", "max_drawdown", "trades", "win_rate", "fees", "error")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for i, r := range results {
+		row := []string{fmt.Sprint(i + 1)}
+		for _, k := range keys {
+			row = append(row, r.Values[k])
+		}
+		if r.Err != nil {
+			row = append(row, "", "", "", "", "", r.Err.Error())
+		} else {
+			row = append(row,
+				r.Report.NetPnL.StringFixed(4),
+				r.Report.MaxDrawdown.StringFixed(4),
+				fmt.Sprint(len(r.Report.Trades)),
+				r.Report.WinRate.StringFixed(4),
+				r.Report.Fees.StringFixed(4),
+				"",
+			)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,106 @@
+package backtest
+
+import (
+	"bytes"
+	"crypto-trading-bot-engine/strategy/order"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestExpandParams(t *testing.T) {
+	testcases := []struct {
+		title          string
+		template       string
+		expectedValues []map[string]string
+		expectedError  bool
+	}{
+		{
+			title:          "without sweep fields",
+			template:       testLimitParams,
+			expectedValues: []map[string]string{{}},
+		},
+		{
+			title: "range of string and list of number",
+			template: `{
+			  "entry_type": "trendline",
+			  "entry_order": {"trendline_offset_percent": {"list": [0.001, 0.002]}},
+			  "take_profit_order": {"trigger": {"trigger_type": "limit", "operator": ">=", "price": {"range": ["100", "110", "5"]}}}
+			}`,
+			expectedValues: []map[string]string{
+				{"entry_order.trendline_offset_percent": "0.001", "take_profit_order.trigger.price": "100"},
+				{"entry_order.trendline_offset_percent": "0.002", "take_profit_order.trigger.price": "100"},
+				{"entry_order.trendline_offset_percent": "0.001", "take_profit_order.trigger.price": "105"},
+				{"entry_order.trendline_offset_percent": "0.002", "take_profit_order.trigger.price": "105"},
+				{"entry_order.trendline_offset_percent": "0.001", "take_profit_order.trigger.price": "110"},
+				{"entry_order.trendline_offset_percent": "0.002", "take_profit_order.trigger.price": "110"},
+			},
+		},
+		{
+			title:         "invalid range",
+			template:      `{"stop_loss_order": {"loss_tolerance_percent": {"range": [0.01, 0.001, 0.001]}}}`,
+			expectedError: true,
+		},
+		{
+			title:         "too many combinations",
+			template:      `{"a": {"range": [1, 1000, 1]}, "b": {"range": [1, 1000, 1]}}`,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		combinations, err := ExpandParams([]byte(tc.template))
+		if hasError := (err != nil); hasError != tc.expectedError {
+			t.Errorf("TestExpandParams case '%s' - expect error '%t', but got '%v'", tc.title, tc.expectedError, err)
+			continue
+		}
+		if len(combinations) != len(tc.expectedValues) {
+			t.Errorf("TestExpandParams case '%s' - expect %d combinations, but got %d", tc.title, len(tc.expectedValues), len(combinations))
+			continue
+		}
+		for i, c := range combinations {
+			for k, v := range tc.expectedValues[i] {
+				if c.Values[k] != v {
+					t.Errorf("TestExpandParams case '%s' - combination %d expect '%s' to be '%s', but got '%s'", tc.title, i, k, v, c.Values[k])
+				}
+			}
+			var params map[string]interface{}
+			if err := json.Unmarshal(c.Params, &params); err != nil {
+				t.Errorf("TestExpandParams case '%s' - invalid params: %v", tc.title, err)
+			}
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	template := strings.Replace(testLimitParams, `"price": "120"`, `"price": {"list": ["110", "120", "200"]}`, 1)
+	combinations, err := ExpandParams([]byte(template))
+	if err != nil {
+		t.Fatalf("TestSweep - unexpected error: %v", err)
+	}
+
+	config := Config{Side: order.LONG, Margin: decimal.NewFromInt(1000)}
+	results := Sweep(config, combinations, testMarks(95, 100, 110, 120, 115), 2)
+
+	// take-profit 120: +200, 110: +100, 200: closed at the end of data +150
+	expectedPrices := []string{"120", "200", "110"}
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("TestSweep - unexpected error: %v", r.Err)
+		}
+		if price := r.Values["take_profit_order.trigger.price"]; price != expectedPrices[i] {
+			t.Errorf("TestSweep - rank %d expect take-profit price '%s', but got '%s'", i+1, expectedPrices[i], price)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := WriteSweepCsv(&b, results); err != nil {
+		t.Fatalf("TestSweep - unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if lines[0] != "rank,take_profit_order.trigger.price,net_pnl,max_drawdown,trades,win_rate,fees,error" || len(lines) != 4 {
+		t.Errorf("TestSweep - unexpected csv: %s", b.String())
+	}
+}
@@ -9,6 +9,11 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	EXECUTION_EXCHANGE   = "exchange"   // Place orders on the exchange
+	EXECUTION_ALERT_ONLY = "alert_only" // Send the notifications only
+)
+
 type ContractStrategy struct {
 	Id                    int64
 	Uuid                  string
@@ -19,8 +24,12 @@ type ContractStrategy struct {
 	Params                datatypes.JSONMap
 	Enabled               int64  // 0: disabled  1: enabled
 	PositionStatus        int64  // 0: closed  1: opened  2: unknown
-	Exchange              string // e.g. FTX
+	Exchange              string // e.g. FTX, PAPER
 	ExchangeOrdersDetails datatypes.JSONMap
+	GroupUuid             string // Only one strategy of the group can take the entry, empty means no group
+	Execution             string // exchange, alert_only
+	OrderAttempt          int64  // Number of orders placed, for deriving client order id
+	PendingClientOrderId  string // Client order id of the order being placed, empty means none
 	Comment               string
 	LastPositionAt        time.Time
 	CreatedAt             time.Time
@@ -47,6 +56,27 @@ func (db *DB) GetNonClosedContractStrategiesBySymbol(userUuid string, symbol str
 	return css, result.RowsAffected, result.Error
 }
 
+// Opened strategies placing orders on the exchange of the user, except the given one
+// NOTE Empty execution is taken as 'exchange', the column default isn't applied to the rows created by CreateContractStrategy
+func (db *DB) GetOpenedContractStrategiesByUser(userUuid string, uuid string) ([]ContractStrategy, int64, error) {
+	var css []ContractStrategy
+	result := db.GormDB.Where("user_uuid = ? AND position_status = 1 AND execution != ? AND uuid != ?", userUuid, EXECUTION_ALERT_ONLY, uuid).Find(&css)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return css, 0, result.Error
+	}
+	return css, result.RowsAffected, result.Error
+}
+
+// Other strategies in the same group
+func (db *DB) GetContractStrategiesByGroupUuid(groupUuid string, uuid string) ([]ContractStrategy, int64, error) {
+	var css []ContractStrategy
+	result := db.GormDB.Where("group_uuid = ? AND uuid != ?", groupUuid, uuid).Find(&css)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return css, 0, result.Error
+	}
+	return css, result.RowsAffected, result.Error
+}
+
 // NOTE Struct doesn't support 0 value, use map instead
 func (db *DB) UpdateContractStrategy(uuid string, contractStrategy map[string]interface{}) (int64, error) {
 	result := db.GormDB.Model(ContractStrategy{}).Where("uuid = ?", uuid).Updates(contractStrategy)
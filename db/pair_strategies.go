@@ -0,0 +1,71 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type PairStrategy struct {
+	Id                    int64
+	Uuid                  string
+	UserUuid              string
+	SymbolA               string          // Leg A e.g. ETH-PERP
+	SymbolB               string          // Leg B e.g. BTC-PERP
+	Formula               string          // ratio: price(A) / price(B)  spread: price(A) - k * price(B)
+	HedgeRatio            decimal.Decimal // k, formula 'spread' only
+	Margin                decimal.Decimal // Margin of leg A
+	Side                  int64           // 0: short A and long B  1: long A and short B
+	Params                datatypes.JSONMap
+	Enabled               int64  // 0: disabled  1: enabled
+	PositionStatus        int64  // 0: closed  1: opened  2: unknown
+	Exchange              string // e.g. FTX
+	ExchangeOrdersDetails datatypes.JSONMap
+	OrderAttempt          int64  // Number of orders placed, for deriving client order id
+	PendingClientOrderId  string // Client order id of the entry orders being placed, legs are suffixed with 'a' and 'b', empty means none
+	Comment               string
+	LastPositionAt        time.Time
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// TODO Loop with LIMIT until no more
+func (db *DB) GetEnabledPairStrategies() ([]PairStrategy, int64, error) {
+	var pss []PairStrategy
+	result := db.GormDB.Where("enabled = 1").Find(&pss)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return pss, 0, result.Error
+	}
+	return pss, result.RowsAffected, result.Error
+}
+
+// NOTE Struct doesn't support 0 value, use map instead
+func (db *DB) UpdatePairStrategy(uuid string, pairStrategy map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(PairStrategy{}).Where("uuid = ?", uuid).Updates(pairStrategy)
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) GetPairStrategyByUuid(uuid string) (*PairStrategy, error) {
+	var s PairStrategy
+	result := db.GormDB.Where("uuid = ?", uuid).First(&s)
+	return &s, result.Error
+}
+
+// for API
+func (db *DB) GetPairStrategiesByUser(userUuid string) ([]PairStrategy, int64, error) {
+	var pss []PairStrategy
+	result := db.GormDB.Where("user_uuid = ?", userUuid).Order("position_status DESC, enabled DESC, symbol_a").Find(&pss)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return pss, 0, result.Error
+	}
+	return pss, result.RowsAffected, result.Error
+}
+
+// for API
+func (db *DB) CreatePairStrategy(pairStrategy PairStrategy) (int64, int64, error) {
+	result := db.GormDB.Model(PairStrategy{}).Create(&pairStrategy)
+	return pairStrategy.Id, result.RowsAffected, result.Error
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Simulated account of PAPER exchange
+type PaperAccount struct {
+	Id            int64
+	UserUuid      string
+	Balances      datatypes.JSON // e.g. {"USD": "10000", "BTC": "0.1"}
+	Positions     datatypes.JSON // e.g. {"BTC-PERP": {"side": 1, "size": "0.1", "entry_price": "60000"}}
+	TriggerOrders datatypes.JSON // e.g. {"3": {"symbol": "BTC-PERP", "side": 1, "trigger_price": "58000", "size": "0.1"}}
+	ClientOrders  datatypes.JSON // e.g. {"3f1c2e7a9b0d4c5e8f6a7b1c-1": {"order_id": 2, "symbol": "BTC-PERP", "size": "0.1", "price": "60030"}}
+	NextOrderId   int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (db *DB) GetPaperAccountByUserUuid(userUuid string) (*PaperAccount, error) {
+	var pa PaperAccount
+	result := db.GormDB.Where("user_uuid = ?", userUuid).First(&pa)
+	return &pa, result.Error
+}
+
+func (db *DB) CreatePaperAccount(pa *PaperAccount) (int64, error) {
+	result := db.GormDB.Create(pa)
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) UpdatePaperAccount(userUuid string, pa map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(PaperAccount{}).Where("user_uuid = ?", userUuid).Updates(pa)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type SpotStrategy struct {
+	Id                    int64
+	Uuid                  string
+	UserUuid              string
+	Symbol                string          // e.g. BTC/USD
+	Amount                decimal.Decimal // Amount of quote currency to spend e.g. USD
+	Params                datatypes.JSONMap
+	Enabled               int64  // 0: disabled  1: enabled
+	PositionStatus        int64  // 0: closed  1: opened  2: unknown
+	Exchange              string // e.g. FTX
+	ExchangeOrdersDetails datatypes.JSONMap
+	Comment               string
+	LastPositionAt        time.Time
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// TODO Loop with LIMIT until no more
+func (db *DB) GetEnabledSpotStrategies() ([]SpotStrategy, int64, error) {
+	var sss []SpotStrategy
+	result := db.GormDB.Where("enabled = 1").Find(&sss)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return sss, 0, result.Error
+	}
+	return sss, result.RowsAffected, result.Error
+}
+
+// NOTE Struct doesn't support 0 value, use map instead
+func (db *DB) UpdateSpotStrategy(uuid string, spotStrategy map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(SpotStrategy{}).Where("uuid = ?", uuid).Updates(spotStrategy)
+	return result.RowsAffected, result.Error
+}
+
+func (db *DB) GetSpotStrategyByUuid(uuid string) (*SpotStrategy, error) {
+	var s SpotStrategy
+	result := db.GormDB.Where("uuid = ?", uuid).First(&s)
+	return &s, result.Error
+}
+
+// for API
+func (db *DB) GetSpotStrategiesByUser(userUuid string) ([]SpotStrategy, int64, error) {
+	var sss []SpotStrategy
+	result := db.GormDB.Where("user_uuid = ?", userUuid).Order("position_status DESC, enabled DESC, symbol").Find(&sss)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return sss, 0, result.Error
+	}
+	return sss, result.RowsAffected, result.Error
+}
+
+// for API
+func (db *DB) CreateSpotStrategy(spotStrategy SpotStrategy) (int64, int64, error) {
+	result := db.GormDB.Model(SpotStrategy{}).Create(&spotStrategy)
+	return spotStrategy.Id, result.RowsAffected, result.Error
+}
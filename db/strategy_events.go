@@ -0,0 +1,52 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Markets of strategies
+const (
+	MARKET_CONTRACT = "contract"
+	MARKET_SPOT     = "spot"
+	MARKET_PAIR     = "pair"
+)
+
+// Sources of strategy events
+const (
+	STRATEGY_EVENT_SOURCE_HOOK      = "hook"      // Hooker callback e.g. EntryTriggered, ParamsUpdated
+	STRATEGY_EVENT_SOURCE_EVENTS_CH = "events_ch" // event sent to handler e.g. enable, disable
+)
+
+// Audit log of the lifecycle of strategies
+type StrategyEvent struct {
+	Id           int64
+	StrategyUuid string
+	Market       string // contract, spot, pair
+	Source       string // hook, events_ch
+	Event        string // e.g. EntryTriggered, ParamsUpdated, enable, out_of_sync
+	MarkPrice    decimal.Decimal
+	ParamsDiff   datatypes.JSONMap // {"entry_order.trendline_trigger.price_1": {"before": "1", "after": "2"}}
+	Outcome      string            // e.g. ok, halted, error: xxx
+	CreatedAt    time.Time
+}
+
+func (db *DB) CreateStrategyEvent(event StrategyEvent) (int64, int64, error) {
+	result := db.GormDB.Model(StrategyEvent{}).Create(&event)
+	return event.Id, result.RowsAffected, result.Error
+}
+
+// Events within [from, to), the latest first
+// for API
+func (db *DB) GetStrategyEventsByStrategyUuidByDateRange(strategyUuid string, from time.Time, to time.Time) ([]StrategyEvent, int64, error) {
+	var es []StrategyEvent
+	result := db.GormDB.Where("strategy_uuid = ? AND created_at >= ? AND created_at < ?", strategyUuid, from, to).Order("id DESC").Find(&es)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return es, 0, result.Error
+	}
+	return es, result.RowsAffected, result.Error
+}
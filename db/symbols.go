@@ -8,13 +8,14 @@ import (
 )
 
 type Symbol struct {
-	Id         int64
-	MarketType int64  // 1: spot   0: contract
-	Exchange   string // Exchange name e.g. FTX
-	Name       string // Symbol name e.g. BTC-PERP
-	Enabled    int64  // 1: enabled   0: disabled
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	Id             int64
+	MarketType     int64  // 1: spot   0: contract
+	Exchange       string // Exchange name e.g. FTX
+	Name           string // Symbol name e.g. BTC-PERP
+	ExchangeSymbol string // Symbol name on the exchange e.g. BTCUSDT of Binance, empty if it's the same as Name
+	Enabled        int64  // 1: enabled   0: disabled
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
 
 // TODO Filter by exchange
@@ -26,3 +27,22 @@ func (db *DB) GetEnabledContractSymbols(exchange string) ([]Symbol, int64, error
 	}
 	return ss, result.RowsAffected, result.Error
 }
+
+func (db *DB) GetEnabledSpotSymbols(exchange string) ([]Symbol, int64, error) {
+	var ss []Symbol
+	result := db.GormDB.Where("enabled = 1 AND market_type = 1 AND exchange = ?", exchange).Order("created_at ASC").Find(&ss)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ss, 0, result.Error
+	}
+	return ss, result.RowsAffected, result.Error
+}
+
+// Both contract and spot symbols of the exchange
+func (db *DB) GetEnabledSymbolsByExchange(exchange string) ([]Symbol, int64, error) {
+	var ss []Symbol
+	result := db.GormDB.Where("enabled = 1 AND exchange = ?", exchange).Order("created_at ASC").Find(&ss)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ss, 0, result.Error
+	}
+	return ss, result.RowsAffected, result.Error
+}
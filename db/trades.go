@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Exit reasons of trades
+const (
+	TRADE_EXIT_STOP_LOSS   = "stop_loss"
+	TRADE_EXIT_TAKE_PROFIT = "take_profit"
+	TRADE_EXIT_MANUAL      = "manual"  // closed on the exchange by the user
+	TRADE_EXIT_ERROR       = "error"   // closed by the engine as something went wrong e.g. stop-loss order couldn't be placed
+	TRADE_EXIT_RESET       = "reset"   // the strategy has been reset, the position is left to the user
+	TRADE_EXIT_UNKNOWN     = "unknown" // the strategy has been flagged as UNKNOWN, the position is left to the user
+)
+
+// Journal of contract strategies, a row is created on entry and completed on exit
+type Trade struct {
+	Id           int64
+	UserUuid     string
+	StrategyUuid string // contract strategy uuid
+	Exchange     string
+	Symbol       string
+	Side         int64 // 0: short  1: long
+	EntryPrice   decimal.Decimal
+	EntrySize    decimal.Decimal
+	EntryAt      time.Time
+	ExitPrice    decimal.Decimal
+	ExitReason   string     // empty means the trade is still open
+	ExitAt       *time.Time // nil means the trade is still open
+	Fees         decimal.Decimal
+	RealizedPnl  decimal.Decimal // fees included
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (db *DB) CreateTrade(trade Trade) (int64, int64, error) {
+	result := db.GormDB.Model(Trade{}).Create(&trade)
+	return trade.Id, result.RowsAffected, result.Error
+}
+
+// The latest trade that hasn't exited yet
+func (db *DB) GetOpenTradeByStrategyUuid(strategyUuid string) (*Trade, error) {
+	var t Trade
+	result := db.GormDB.Where("strategy_uuid = ? AND exit_at IS NULL", strategyUuid).Order("id DESC").First(&t)
+	return &t, result.Error
+}
+
+func (db *DB) UpdateTrade(id int64, data map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(Trade{}).Where("id = ?", id).Updates(data)
+	return result.RowsAffected, result.Error
+}
+
+// Sum of realized PnL of the trades exited since the time
+func (db *DB) GetRealizedPnlByUserSince(userUuid string, since time.Time) (decimal.Decimal, error) {
+	var pnl decimal.Decimal
+	result := db.GormDB.Model(Trade{}).Select("COALESCE(SUM(realized_pnl), 0)").Where("user_uuid = ? AND exit_at >= ?", userUuid, since).Row()
+	if err := result.Err(); err != nil {
+		return pnl, err
+	}
+	err := result.Scan(&pnl)
+	return pnl, err
+}
+
+// Trades entered within [from, to), the latest first
+// for API
+func (db *DB) GetTradesByUserByDateRange(userUuid string, from time.Time, to time.Time) ([]Trade, int64, error) {
+	var ts []Trade
+	result := db.GormDB.Where("user_uuid = ? AND entry_at >= ? AND entry_at < ?", userUuid, from, to).Order("entry_at DESC").Find(&ts)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ts, 0, result.Error
+	}
+	return ts, result.RowsAffected, result.Error
+}
+
+// for API
+func (db *DB) GetTradesByStrategyUuid(strategyUuid string) ([]Trade, int64, error) {
+	var ts []Trade
+	result := db.GormDB.Where("strategy_uuid = ?", strategyUuid).Order("entry_at DESC").Find(&ts)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ts, 0, result.Error
+	}
+	return ts, result.RowsAffected, result.Error
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Enable the target strategy when the source strategy reaches the event
+type TriggersOn struct {
+	Id         int64
+	SourceUuid string // contract strategy uuid e.g. strategy A
+	TargetUuid string // contract strategy uuid to be enabled e.g. strategy B
+	Event      string // entry, stop_loss, take_profit
+	Enabled    int64  // 0: disabled  1: enabled
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (TriggersOn) TableName() string {
+	return "triggers_on"
+}
+
+func (db *DB) GetEnabledTriggersOnBySourceByEvent(sourceUuid string, event string) ([]TriggersOn, int64, error) {
+	var ts []TriggersOn
+	result := db.GormDB.Where("source_uuid = ? AND event = ? AND enabled = 1", sourceUuid, event).Find(&ts)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ts, 0, result.Error
+	}
+	return ts, result.RowsAffected, result.Error
+}
+
+// for API
+func (db *DB) GetTriggersOnBySource(sourceUuid string) ([]TriggersOn, int64, error) {
+	var ts []TriggersOn
+	result := db.GormDB.Where("source_uuid = ?", sourceUuid).Find(&ts)
+	if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return ts, 0, result.Error
+	}
+	return ts, result.RowsAffected, result.Error
+}
+
+// for API
+func (db *DB) CreateTriggersOn(triggersOn TriggersOn) (int64, int64, error) {
+	result := db.GormDB.Model(TriggersOn{}).Create(&triggersOn)
+	return triggersOn.Id, result.RowsAffected, result.Error
+}
+
+// for API
+func (db *DB) DeleteTriggersOn(id int64) (int64, error) {
+	result := db.GormDB.Where("id = ?", id).Delete(TriggersOn{})
+	return result.RowsAffected, result.Error
+}
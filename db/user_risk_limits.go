@@ -0,0 +1,37 @@
+package db
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Guardrails of the user above individual strategies, 0 means no limit
+type UserRiskLimit struct {
+	Id                int64
+	UserUuid          string
+	MaxOpenPositions  int64           // opened contract strategies at once
+	MaxTotalMargin    decimal.Decimal // total margin of opened contract strategies
+	MaxSymbolNotional decimal.Decimal // notional of opened contract strategies of the same symbol
+	DailyLossLimit    decimal.Decimal // positive amount of realized loss since 00:00 UTC
+	KillSwitchAt      *time.Time      // last time all strategies were disabled by the daily loss limit
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func (db *DB) GetUserRiskLimitByUser(userUuid string) (*UserRiskLimit, error) {
+	var l UserRiskLimit
+	result := db.GormDB.Where("user_uuid = ?", userUuid).First(&l)
+	return &l, result.Error
+}
+
+func (db *DB) UpdateUserRiskLimit(userUuid string, data map[string]interface{}) (int64, error) {
+	result := db.GormDB.Model(UserRiskLimit{}).Where("user_uuid = ?", userUuid).Updates(data)
+	return result.RowsAffected, result.Error
+}
+
+// for API
+func (db *DB) CreateUserRiskLimit(limit UserRiskLimit) (int64, int64, error) {
+	result := db.GormDB.Model(UserRiskLimit{}).Create(&limit)
+	return limit.Id, result.RowsAffected, result.Error
+}
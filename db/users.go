@@ -9,7 +9,7 @@ type User struct {
 	Uuid           string
 	TelegramChatId int64
 	Username       string
-	ExchangeApiKey string // {"FTX":{"api_key":"", "api_secret": "", "subaccount": ""}}
+	ExchangeApiKey string // {"FTX":{"api_key":"", "api_secret": "", "subaccount": ""}, "BINANCE":{"api_key":"", "api_secret": ""}, "BYBIT":{"api_key":"", "api_secret": ""}}
 	Activated      int64
 	Role           int64 // 0: general user, 99: admin
 	LastLoginAt    time.Time
@@ -32,8 +32,12 @@ CREATE TABLE `contract_strategies` (
   `params` longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '{}' COMMENT 'Params for entry, stop-loss and take-profit orders' CHECK (json_valid(`params`)),
   `enabled` tinyint(3) unsigned NOT NULL DEFAULT 0 COMMENT '0: disabled 1: enabled',
   `position_status` tinyint(4) unsigned NOT NULL DEFAULT 0 COMMENT ' 0: closed 1: opened 2: unknown',
-  `exchange` varchar(20) NOT NULL COMMENT 'Exchange name e.g. FTX',
+  `exchange` varchar(20) NOT NULL COMMENT 'Exchange name e.g. FTX, PAPER',
   `exchange_orders_details` longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '\'{}\'' COMMENT 'Bespoke orders details by exchange' CHECK (json_valid(`exchange_orders_details`)),
+  `group_uuid` char(36) NOT NULL DEFAULT '' COMMENT 'One-cancels-other group uuid, empty means no group',
+  `execution` varchar(20) NOT NULL DEFAULT 'exchange' COMMENT 'exchange: place orders alert_only: send notifications only',
+  `order_attempt` int(10) unsigned NOT NULL DEFAULT 0 COMMENT 'Number of orders placed, for deriving client order id',
+  `pending_client_order_id` varchar(36) NOT NULL DEFAULT '' COMMENT 'Client order id of the order being placed, empty means none',
   `comment` varchar(100) NOT NULL COMMENT 'Comment',
   `last_position_at` datetime DEFAULT NULL COMMENT 'Last position created time',
   `created_at` datetime NOT NULL DEFAULT current_timestamp() COMMENT 'Create time',
@@ -42,7 +46,8 @@ CREATE TABLE `contract_strategies` (
   UNIQUE KEY `uuid` (`uuid`),
   KEY `enabled_positionStatus` (`enabled`,`position_status`),
   KEY `updated_at` (`updated_at`),
-  KEY `userUuid_positionStatus_enabled` (`user_uuid`,`position_status`,`enabled`) USING BTREE
+  KEY `userUuid_positionStatus_enabled` (`user_uuid`,`position_status`,`enabled`) USING BTREE,
+  KEY `group_uuid` (`group_uuid`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Contract Strategies';
 /*!40101 SET character_set_client = @saved_cs_client */;
 /*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
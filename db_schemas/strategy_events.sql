@@ -0,0 +1,49 @@
+-- MySQL dump 10.13  Distrib 8.0.19, for osx10.15 (x86_64)
+--
+-- Host: 127.0.0.1    Database: crypto
+-- ------------------------------------------------------
+-- Server version	5.5.5-10.6.2-MariaDB-1:10.6.2+maria~focal
+
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
+/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;
+/*!50503 SET NAMES utf8mb4 */;
+/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;
+/*!40103 SET TIME_ZONE='+00:00' */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+
+--
+-- Table structure for table `strategy_events`
+--
+
+DROP TABLE IF EXISTS `strategy_events`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `strategy_events` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'AI id',
+  `strategy_uuid` char(36) NOT NULL COMMENT 'Strategy uuid',
+  `market` varchar(20) NOT NULL COMMENT 'contract, spot, pair',
+  `source` varchar(20) NOT NULL COMMENT 'hook: Hooker callback events_ch: event sent to handler',
+  `event` varchar(40) NOT NULL COMMENT 'e.g. EntryTriggered, ParamsUpdated, enable, out_of_sync',
+  `mark_price` decimal(20,8) NOT NULL DEFAULT 0.00000000 COMMENT 'Mark price being checked, 0 means unknown',
+  `params_diff` longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '{}' COMMENT 'Changed params by path e.g. {"a.b": {"before": 1, "after": 2}}' CHECK (json_valid(`params_diff`)),
+  `outcome` varchar(255) NOT NULL DEFAULT '' COMMENT 'e.g. ok, halted, error: xxx',
+  `created_at` datetime(3) NOT NULL DEFAULT current_timestamp(3) COMMENT 'Create time',
+  PRIMARY KEY (`id`),
+  KEY `strategyUuid_createdAt` (`strategy_uuid`,`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Audit log of the lifecycle of strategies';
+/*!40101 SET character_set_client = @saved_cs_client */;
+/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;
+/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
+
+-- Dump completed on 2021-11-20 10:12:45
@@ -27,6 +27,7 @@ CREATE TABLE `symbols` (
   `market_type` tinyint(3) unsigned NOT NULL DEFAULT 0 COMMENT '0: contract 1: spot',
   `exchange` varchar(20) NOT NULL COMMENT 'Exchange name e.g. FTX',
   `name` varchar(20) NOT NULL COMMENT 'Symbol name e.g. BTC-PERP',
+  `exchange_symbol` varchar(20) NOT NULL DEFAULT '' COMMENT 'Symbol name on the exchange e.g. BTCUSDT of Binance, empty if it''s the same as name',
   `enabled` tinyint(3) unsigned NOT NULL DEFAULT 1 COMMENT '0: disabled 1: enabled',
   `created_at` datetime NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Create time',
   `updated_at` datetime NOT NULL DEFAULT current_timestamp() COMMENT 'Update time',
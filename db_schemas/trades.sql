@@ -0,0 +1,57 @@
+-- MySQL dump 10.13  Distrib 8.0.19, for osx10.15 (x86_64)
+--
+-- Host: 127.0.0.1    Database: crypto
+-- ------------------------------------------------------
+-- Server version	5.5.5-10.6.2-MariaDB-1:10.6.2+maria~focal
+
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET @OLD_CHARACTER_SET_RESULTS=@@CHARACTER_SET_RESULTS */;
+/*!40101 SET @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION */;
+/*!50503 SET NAMES utf8mb4 */;
+/*!40103 SET @OLD_TIME_ZONE=@@TIME_ZONE */;
+/*!40103 SET TIME_ZONE='+00:00' */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+
+--
+-- Table structure for table `trades`
+--
+
+DROP TABLE IF EXISTS `trades`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!50503 SET character_set_client = utf8mb4 */;
+CREATE TABLE `trades` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT COMMENT 'AI id',
+  `user_uuid` char(36) NOT NULL COMMENT 'User uuid',
+  `strategy_uuid` char(36) NOT NULL COMMENT 'Contract strategy uuid',
+  `exchange` varchar(20) NOT NULL COMMENT 'Exchange name e.g. FTX, PAPER',
+  `symbol` varchar(20) NOT NULL COMMENT 'Symbol e.g. BTC-PERP',
+  `side` tinyint(4) unsigned NOT NULL COMMENT '0: short 1: long',
+  `entry_price` decimal(20,8) NOT NULL COMMENT 'Entry price',
+  `entry_size` decimal(20,8) NOT NULL COMMENT 'Entry size',
+  `entry_at` datetime NOT NULL COMMENT 'Entry time',
+  `exit_price` decimal(20,8) NOT NULL DEFAULT 0.00000000 COMMENT 'Exit price',
+  `exit_reason` varchar(20) NOT NULL DEFAULT '' COMMENT 'stop_loss, take_profit, manual, error, reset, unknown. Empty means open',
+  `exit_at` datetime DEFAULT NULL COMMENT 'Exit time, NULL means open',
+  `fees` decimal(20,8) NOT NULL DEFAULT 0.00000000 COMMENT 'Estimated fees of entry and exit by taker fee',
+  `realized_pnl` decimal(20,8) NOT NULL DEFAULT 0.00000000 COMMENT 'Realized PnL, fees included',
+  `created_at` datetime NOT NULL DEFAULT current_timestamp() COMMENT 'Create time',
+  `updated_at` datetime NOT NULL DEFAULT current_timestamp() ON UPDATE current_timestamp() COMMENT 'Update time',
+  PRIMARY KEY (`id`),
+  KEY `userUuid_entryAt` (`user_uuid`,`entry_at`),
+  KEY `strategyUuid_exitAt` (`strategy_uuid`,`exit_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='Journal of entries and exits of contract strategies';
+/*!40101 SET character_set_client = @saved_cs_client */;
+/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+/*!40101 SET CHARACTER_SET_RESULTS=@OLD_CHARACTER_SET_RESULTS */;
+/*!40101 SET COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
+
+-- Dump completed on 2021-11-20 10:12:45
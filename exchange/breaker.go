@@ -0,0 +1,238 @@
+package exchange
+
+import (
+	"context"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/util/retry"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// New entry is refused without calling the exchange, as the exchange keeps failing
+var ErrCircuitOpen = errors.New("circuit breaker is open, new entries are paused")
+
+// Track consecutive failures of an account, it opens after threshold failures and closes after any success
+// NOTE Only new entries are refused while it's open, protective actions e.g. stop-loss, close keep going
+type CircuitBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration // an entry is let through to probe the exchange after it
+
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+
+	// Called once per transition, err is the last failure when it opens
+	onChange func(open bool, failures int, err error)
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration, onChange func(bool, int, error)) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+	}
+}
+
+func (cb *CircuitBreaker) IsOpen() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.open
+}
+
+// Check without taking the probe
+func (cb *CircuitBreaker) entryAllowed() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return !cb.open || (!cb.probing && time.Since(cb.openedAt) >= cb.cooldown)
+}
+
+// Only one entry is let through to probe after cooldown, the others are refused until it's done
+func (cb *CircuitBreaker) takeEntry() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if !cb.open {
+		return true
+	}
+	if !cb.probing && time.Since(cb.openedAt) >= cb.cooldown {
+		cb.probing = true
+		return true
+	}
+	return false
+}
+
+// Known errors e.g. size too small aren't failures, the exchange works
+func (cb *CircuitBreaker) record(err error) {
+	cb.mutex.Lock()
+	changed := false
+	if err == nil || exerr.IsKnown(err) {
+		cb.failures = 0
+		cb.probing = false
+		if cb.open {
+			cb.open = false
+			changed = true
+		}
+	} else {
+		cb.failures++
+		if cb.probing {
+			// Probe failed, wait for another cooldown
+			cb.probing = false
+			cb.openedAt = time.Now()
+		}
+		if !cb.open && cb.failures >= cb.threshold {
+			cb.open = true
+			cb.openedAt = time.Now()
+			changed = true
+		}
+	}
+	open, failures := cb.open, cb.failures
+	cb.mutex.Unlock()
+
+	if changed && cb.onChange != nil {
+		cb.onChange(open, failures, err)
+	}
+}
+
+// Refuse the entry before doing anything for it e.g. saving client order id, if the breaker of the exchange is open
+func EntryAllowed(ex Exchanger) error {
+	if b, ok := ex.(*BreakerExchanger); ok && !b.cb.entryAllowed() {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Exchanger with circuit breaker, results of the calls are recorded by the breaker
+// NOTE GetPosition isn't recorded, as it fails when there is no position
+type BreakerExchanger struct {
+	Exchanger
+	cb *CircuitBreaker
+}
+
+func NewBreakerExchanger(ex Exchanger, cb *CircuitBreaker) *BreakerExchanger {
+	return &BreakerExchanger{
+		Exchanger: ex,
+		cb:        cb,
+	}
+}
+
+func (b *BreakerExchanger) GetAccountInfo() (map[string]interface{}, error) {
+	r, err := b.Exchanger.GetAccountInfo()
+	b.cb.record(err)
+	return r, err
+}
+
+func (b *BreakerExchanger) PlaceEntryOrder(symbol string, side order.Side, size decimal.Decimal, clientId string) (int64, error) {
+	if !b.cb.takeEntry() {
+		return 0, ErrCircuitOpen
+	}
+	orderId, err := b.Exchanger.PlaceEntryOrder(symbol, side, size, clientId)
+	b.cb.record(err)
+	return orderId, err
+}
+
+func (b *BreakerExchanger) PlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal) (int64, error) {
+	orderId, err := b.Exchanger.PlaceStopLossOrder(symbol, side, price, size)
+	b.cb.record(err)
+	return orderId, err
+}
+
+func (b *BreakerExchanger) RetryPlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	orderId, err := b.Exchanger.RetryPlaceStopLossOrder(symbol, side, price, size, p)
+	b.cb.record(err)
+	return orderId, err
+}
+
+func (b *BreakerExchanger) RetryPlaceStopLossOrderContext(ctx context.Context, symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	orderId, err := RetryPlaceStopLossOrder(ctx, b.Exchanger, symbol, side, price, size, p)
+	b.recordUnlessCancelled(ctx, err)
+	return orderId, err
+}
+
+func (b *BreakerExchanger) CancelStopLossOrder(orderId int64) error {
+	err := b.Exchanger.CancelStopLossOrder(orderId)
+	b.cb.record(err)
+	return err
+}
+
+func (b *BreakerExchanger) ClosePosition(symbol string, side order.Side, size decimal.Decimal, clientId string) error {
+	err := b.Exchanger.ClosePosition(symbol, side, size, clientId)
+	b.cb.record(err)
+	return err
+}
+
+func (b *BreakerExchanger) CancelOpenTriggerOrder(orderId int64) error {
+	err := b.Exchanger.CancelOpenTriggerOrder(orderId)
+	b.cb.record(err)
+	return err
+}
+
+func (b *BreakerExchanger) RetryCancelOpenTriggerOrder(orderId int64, p retry.Policy) error {
+	err := b.Exchanger.RetryCancelOpenTriggerOrder(orderId, p)
+	b.cb.record(err)
+	return err
+}
+
+func (b *BreakerExchanger) RetryCancelOpenTriggerOrderContext(ctx context.Context, orderId int64, p retry.Policy) error {
+	err := RetryCancelOpenTriggerOrder(ctx, b.Exchanger, orderId, p)
+	b.recordUnlessCancelled(ctx, err)
+	return err
+}
+
+func (b *BreakerExchanger) RetryGetPositionContext(ctx context.Context, symbol string, p retry.Policy) (map[string]interface{}, error) {
+	r, err := RetryGetPosition(ctx, b.Exchanger, symbol, p)
+	b.recordUnlessCancelled(ctx, err)
+	return r, err
+}
+
+// Giving up because of ctx e.g. shutdown isn't the failure of the exchange
+func (b *BreakerExchanger) recordUnlessCancelled(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	b.cb.record(err)
+}
+
+func (b *BreakerExchanger) StopLostOrderExists(symbol string, orderId int64) (bool, error) {
+	existed, err := b.Exchanger.StopLostOrderExists(symbol, orderId)
+	b.cb.record(err)
+	return existed, err
+}
+
+func (b *BreakerExchanger) GetPositions() (map[string]map[string]interface{}, error) {
+	r, err := b.Exchanger.GetPositions()
+	b.cb.record(err)
+	return r, err
+}
+
+func (b *BreakerExchanger) GetOpenStopLossOrders() (map[int64]map[string]interface{}, error) {
+	r, err := b.Exchanger.GetOpenStopLossOrders()
+	b.cb.record(err)
+	return r, err
+}
+
+func (b *BreakerExchanger) GetOrderByClientId(symbol string, clientId string) (map[string]interface{}, bool, error) {
+	r, found, err := b.Exchanger.GetOrderByClientId(symbol, clientId)
+	b.cb.record(err)
+	return r, found, err
+}
+
+func (b *BreakerExchanger) GetBalance(coin string) (decimal.Decimal, error) {
+	balance, err := b.Exchanger.GetBalance(coin)
+	b.cb.record(err)
+	return balance, err
+}
+
+// Buying is the entry of spot strategies, selling is protective
+func (b *BreakerExchanger) PlaceSpotMarketOrder(symbol string, side order.Side, size decimal.Decimal) (int64, error) {
+	if side == order.LONG && !b.cb.takeEntry() {
+		return 0, ErrCircuitOpen
+	}
+	orderId, err := b.Exchanger.PlaceSpotMarketOrder(symbol, side, size)
+	b.cb.record(err)
+	return orderId, err
+}
@@ -0,0 +1,114 @@
+package exchange
+
+import (
+	"context"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/util/retry"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Only the methods used by the test are implemented
+type fakeExchange struct {
+	Exchanger
+	err error
+}
+
+func (f *fakeExchange) PlaceEntryOrder(string, order.Side, decimal.Decimal, string) (int64, error) {
+	return 1, f.err
+}
+
+func (f *fakeExchange) ClosePosition(string, order.Side, decimal.Decimal, string) error {
+	return f.err
+}
+
+func (f *fakeExchange) RetryCancelOpenTriggerOrder(int64, retry.Policy) error {
+	return f.err
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []bool
+	cb := NewCircuitBreaker(3, 50*time.Millisecond, func(open bool, failures int, err error) {
+		transitions = append(transitions, open)
+	})
+	fake := &fakeExchange{err: errors.New("Status Code: 503")}
+	ex := NewBreakerExchanger(fake, cb)
+
+	// Known errors don't count, the exchange works
+	fake.err = exerr.ErrSizeTooSmall
+	for i := 0; i < 5; i++ {
+		ex.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromInt(1), "")
+	}
+	if cb.IsOpen() {
+		t.Fatal("TestCircuitBreaker - expect closed after known errors")
+	}
+
+	// Open after 3 consecutive failures
+	fake.err = errors.New("Status Code: 503")
+	for i := 0; i < 3; i++ {
+		ex.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromInt(1), "")
+	}
+	if !cb.IsOpen() {
+		t.Fatal("TestCircuitBreaker - expect open after 3 failures")
+	}
+
+	// Entries are refused, protective actions still call the exchange
+	if _, err := ex.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromInt(1), ""); err != ErrCircuitOpen {
+		t.Errorf("TestCircuitBreaker - expect entry refused, but got '%v'", err)
+	}
+	if err := EntryAllowed(ex); err != ErrCircuitOpen {
+		t.Errorf("TestCircuitBreaker - expect EntryAllowed '%v', but got '%v'", ErrCircuitOpen, err)
+	}
+	if err := ex.ClosePosition("BTC-PERP", order.LONG, decimal.NewFromInt(1), ""); err != fake.err {
+		t.Errorf("TestCircuitBreaker - expect close to call the exchange, but got '%v'", err)
+	}
+
+	// One entry is let through to probe after cooldown, a failed probe keeps it open
+	time.Sleep(60 * time.Millisecond)
+	if _, err := ex.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromInt(1), ""); err != fake.err {
+		t.Errorf("TestCircuitBreaker - expect probe to call the exchange, but got '%v'", err)
+	}
+	if _, err := ex.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromInt(1), ""); err != ErrCircuitOpen {
+		t.Errorf("TestCircuitBreaker - expect entry refused after failed probe, but got '%v'", err)
+	}
+
+	// Any success closes it
+	fake.err = nil
+	ex.ClosePosition("BTC-PERP", order.LONG, decimal.NewFromInt(1), "")
+	if cb.IsOpen() || EntryAllowed(ex) != nil {
+		t.Error("TestCircuitBreaker - expect closed after success")
+	}
+
+	// One notification per transition
+	if len(transitions) != 2 || !transitions[0] || transitions[1] {
+		t.Errorf("TestCircuitBreaker - expect transitions [true false], but got %v", transitions)
+	}
+}
+
+func TestCircuitBreakerCancelled(t *testing.T) {
+	cb := NewCircuitBreaker(3, time.Minute, func(bool, int, error) {})
+	fake := &fakeExchange{err: context.Canceled}
+	ex := NewBreakerExchanger(fake, cb)
+
+	// Giving up because of shutdown isn't the failure of the exchange
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		RetryCancelOpenTriggerOrder(ctx, ex, 1, retry.Policy{MaxAttempts: 1})
+	}
+	if cb.IsOpen() {
+		t.Fatal("TestCircuitBreakerCancelled - expect closed after calls cancelled by ctx")
+	}
+
+	// The same errors count without ctx cancelled
+	for i := 0; i < 3; i++ {
+		RetryCancelOpenTriggerOrder(context.Background(), ex, 1, retry.Policy{MaxAttempts: 1})
+	}
+	if !cb.IsOpen() {
+		t.Error("TestCircuitBreakerCancelled - expect open after 3 failures")
+	}
+}
@@ -2,11 +2,14 @@ package exchange
 
 import (
 	"bytes"
+	"context"
 	"crypto-trading-bot-engine/exchange/rest"
+	"crypto-trading-bot-engine/exchange/symbols"
 	"crypto-trading-bot-engine/exchange/ws"
 	"crypto-trading-bot-engine/strategy/contract"
 	"crypto-trading-bot-engine/strategy/order"
 	"crypto-trading-bot-engine/util/aes"
+	"crypto-trading-bot-engine/util/retry"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -20,16 +23,58 @@ import (
 type Exchanger interface {
 	NewClient(map[string]interface{}) error
 	GetAccountInfo() (map[string]interface{}, error)
-	PlaceEntryOrder(string, order.Side, decimal.Decimal) (int64, error)
+	PlaceEntryOrder(string, order.Side, decimal.Decimal, string) (int64, error)
 	PlaceStopLossOrder(string, order.Side, decimal.Decimal, decimal.Decimal) (int64, error)
-	RetryPlaceStopLossOrder(string, order.Side, decimal.Decimal, decimal.Decimal, int64, int64) (int64, error)
+	RetryPlaceStopLossOrder(string, order.Side, decimal.Decimal, decimal.Decimal, retry.Policy) (int64, error)
 	CancelStopLossOrder(int64) error
-	ClosePosition(string, order.Side, decimal.Decimal) error
+	ClosePosition(string, order.Side, decimal.Decimal, string) error
 	CancelOpenTriggerOrder(int64) error
-	RetryCancelOpenTriggerOrder(int64, int64, int64) error
+	RetryCancelOpenTriggerOrder(int64, retry.Policy) error
 	GetPosition(string) (map[string]interface{}, error)
-	RetryGetPosition(string, int64, int64) (map[string]interface{}, error)
+	RetryGetPosition(string, retry.Policy) (map[string]interface{}, error)
 	StopLostOrderExists(string, int64) (bool, error)
+
+	// All open positions and stop-loss orders of the account, for reconciliation
+	GetPositions() (map[string]map[string]interface{}, error)
+	GetOpenStopLossOrders() (map[int64]map[string]interface{}, error)
+
+	// Look up the order of the symbol by client order id, for recovering the order that was placed but not saved
+	GetOrderByClientId(string, string) (map[string]interface{}, bool, error)
+
+	// Spot
+	GetBalance(string) (decimal.Decimal, error)
+	PlaceSpotMarketOrder(string, order.Side, decimal.Decimal) (int64, error)
+}
+
+// Context-aware variants of the calls that retry, they give up as soon as ctx is done e.g. shutdown, disable
+// NOTE The request in flight isn't aborted, goftx doesn't take ctx
+type ContextExchanger interface {
+	Exchanger
+	RetryPlaceStopLossOrderContext(context.Context, string, order.Side, decimal.Decimal, decimal.Decimal, retry.Policy) (int64, error)
+	RetryCancelOpenTriggerOrderContext(context.Context, int64, retry.Policy) error
+	RetryGetPositionContext(context.Context, string, retry.Policy) (map[string]interface{}, error)
+}
+
+// Call the context-aware variant if the exchange supports it
+func RetryPlaceStopLossOrder(ctx context.Context, ex Exchanger, symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	if cex, ok := ex.(ContextExchanger); ok {
+		return cex.RetryPlaceStopLossOrderContext(ctx, symbol, side, price, size, p)
+	}
+	return ex.RetryPlaceStopLossOrder(symbol, side, price, size, p)
+}
+
+func RetryCancelOpenTriggerOrder(ctx context.Context, ex Exchanger, orderId int64, p retry.Policy) error {
+	if cex, ok := ex.(ContextExchanger); ok {
+		return cex.RetryCancelOpenTriggerOrderContext(ctx, orderId, p)
+	}
+	return ex.RetryCancelOpenTriggerOrder(orderId, p)
+}
+
+func RetryGetPosition(ctx context.Context, ex Exchanger, symbol string, p retry.Policy) (map[string]interface{}, error) {
+	if cex, ok := ex.(ContextExchanger); ok {
+		return cex.RetryGetPositionContext(ctx, symbol, p)
+	}
+	return ex.RetryGetPosition(symbol, p)
 }
 
 type WsExchanger interface {
@@ -37,6 +82,15 @@ type WsExchanger interface {
 	SetStopCh(chan bool)
 	SetStopAllFunc(func())
 	ListenPublicTradesChannel([]string, bool) (bool, error)
+
+	// Symbols can be subscribed or unsubscribed while listening
+	Subscribe(string) error
+	Unsubscribe(string) error
+}
+
+// The exchange names symbols differently from strategies e.g. 'BTCUSDT' of Binance for 'BTC-PERP', see symbols.exchange_symbol
+type SymbolMapper interface {
+	SetSymbolMap(*symbols.Map)
 }
 
 // Private endpoints
@@ -50,6 +104,22 @@ func NewExchange(exName string, encryptedAesPair string) (ex Exchanger, err erro
 		}
 		ex = rest.NewFtxRest()
 		err = ex.NewClient(exData)
+	case "BINANCE":
+		exData := make(map[string]interface{})
+		exData, err = validateData(exName, encryptedAesPair)
+		if err != nil {
+			break
+		}
+		ex = rest.NewBinanceRest()
+		err = ex.NewClient(exData)
+	case "BYBIT":
+		exData := make(map[string]interface{})
+		exData, err = validateData(exName, encryptedAesPair)
+		if err != nil {
+			break
+		}
+		ex = rest.NewBybitRest()
+		err = ex.NewClient(exData)
 	default:
 		err = fmt.Errorf("exchange '%s' no supported", exName)
 	}
@@ -61,6 +131,13 @@ func NewWsExchange(exName string) (ex WsExchanger, err error) {
 	switch exName {
 	case "FTX":
 		ex = ws.NewFtxWs()
+	case "BINANCE":
+		ex = ws.NewBinanceWs(viper.GetString("BINANCE_WS_STREAM"))
+	case "BYBIT":
+		ex = ws.NewBybitWs()
+	case "REPLAY":
+		// Feed the recording of marks instead of the live stream
+		ex = ws.NewReplayWs(viper.GetString("REPLAY_PATH"), viper.GetFloat64("REPLAY_SPEED"))
 	default:
 		err = fmt.Errorf("exchange '%s' no supported", exName)
 	}
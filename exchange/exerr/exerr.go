@@ -0,0 +1,72 @@
+package exerr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Known errors of the exchanges, messages are the same as FTX
+var (
+	ErrSizeTooSmall         = errors.New("Size too small")
+	ErrInsufficientMargin   = errors.New("Account does not have enough margin for order")
+	ErrOrderAlreadyClosed   = errors.New("Order already closed")
+	ErrInvalidReduceOnly    = errors.New("Invalid reduce-only order")
+	ErrOrderNotFound        = errors.New("Order not found")
+	ErrDuplicateClientOrder = errors.New("Duplicate client order ID")
+)
+
+var known = []error{
+	ErrSizeTooSmall,
+	ErrInsufficientMargin,
+	ErrOrderAlreadyClosed,
+	ErrInvalidReduceOnly,
+	ErrOrderNotFound,
+	ErrDuplicateClientOrder,
+}
+
+// Wrap the error of the exchange with the known error, so that callers can check it by errors.Is
+// NOTE The exchange only returns the message, it's the only place that matches the message
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, e := range known {
+		if errors.Is(err, e) {
+			return err
+		}
+		if strings.Contains(err.Error(), e.Error()) {
+			return fmt.Errorf("%w, %v", e, err)
+		}
+	}
+	return err
+}
+
+// The exchange responded with a known error, it works
+func IsKnown(err error) bool {
+	for _, e := range known {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Known errors that retrying doesn't help
+// NOTE 'Invalid reduce-only order' isn't one of them, FTX could return it for unknown reasons that go away later
+var permanent = []error{
+	ErrSizeTooSmall,
+	ErrInsufficientMargin,
+	ErrOrderAlreadyClosed,
+	ErrOrderNotFound,
+	ErrDuplicateClientOrder,
+}
+
+func IsPermanent(err error) bool {
+	for _, e := range permanent {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
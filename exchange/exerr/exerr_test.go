@@ -0,0 +1,31 @@
+package exerr
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	testcases := []struct {
+		err               error
+		expected          error
+		expectedPermanent bool
+	}{
+		{err: errors.New("Status Code: 400	Error: Order already closed"), expected: ErrOrderAlreadyClosed, expectedPermanent: true},
+		{err: errors.New("Status Code: 400	Error: Size too small"), expected: ErrSizeTooSmall, expectedPermanent: true},
+		{err: ErrInvalidReduceOnly, expected: ErrInvalidReduceOnly},
+		{err: errors.New("Status Code: 429	Error: Do not send more than 30 requests per second")},
+	}
+	for _, tc := range testcases {
+		err := Classify(tc.err)
+		if tc.expected != nil && !errors.Is(err, tc.expected) {
+			t.Errorf("TestClassify '%v' - expect '%v', but got '%v'", tc.err, tc.expected, err)
+		}
+		if IsPermanent(err) != tc.expectedPermanent {
+			t.Errorf("TestClassify '%v' - expect permanent '%t', but got '%t'", tc.err, tc.expectedPermanent, IsPermanent(err))
+		}
+	}
+	if Classify(nil) != nil {
+		t.Error("TestClassify - expect nil")
+	}
+}
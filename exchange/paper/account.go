@@ -0,0 +1,383 @@
+package paper
+
+import (
+	"context"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/util/retry"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Simulated account of a user, it implements exchange.Exchanger
+type Account struct {
+	market   *Market
+	userUuid string
+
+	balances      map[string]decimal.Decimal // key is coin
+	positions     map[string]*position       // key is symbol
+	triggerOrders map[int64]*triggerOrder    // key is order id
+	clientOrders  map[string]*clientOrder    // key is client order id
+	nextOrderId   int64
+}
+
+// Nothing to do, there is no api key for paper trading
+func (a *Account) NewClient(data map[string]interface{}) error {
+	return nil
+}
+
+func (a *Account) GetAccountInfo() (map[string]interface{}, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	r := make(map[string]interface{})
+	r["collateral"] = a.balances[QUOTE_COIN]  // decimal.Decimal
+	r["free_collateral"] = a.freeCollateral() // decimal.Decimal
+	r["maker_fee"] = a.market.config.MakerFee // decimal.Decimal
+	r["taker_fee"] = a.market.config.TakerFee // decimal.Decimal
+	r["username"] = a.userUuid                // string
+	r["leverage"] = decimal.NewFromInt(1)     // decimal.Decimal
+	return r, nil
+}
+
+func (a *Account) PlaceEntryOrder(symbol string, side order.Side, size decimal.Decimal, clientId string) (int64, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	if err := a.checkClientId(clientId); err != nil {
+		return 0, err
+	}
+	if !size.IsPositive() {
+		return 0, exerr.ErrSizeTooSmall
+	}
+	price, err := a.market.fillPrice(symbol, side == order.LONG)
+	if err != nil {
+		return 0, err
+	}
+	if err := a.checkMargin(symbol, side, size, price); err != nil {
+		return 0, err
+	}
+	a.trade(symbol, side, size, price)
+
+	orderId := a.newOrderId()
+	a.addClientOrder(clientId, orderId, symbol, size, price)
+	a.save()
+	return orderId, nil
+}
+
+// side is the side of the position, the order will close it once triggered
+func (a *Account) PlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal) (int64, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	if !size.IsPositive() {
+		return 0, exerr.ErrSizeTooSmall
+	}
+	orderId := a.newOrderId()
+	a.triggerOrders[orderId] = &triggerOrder{
+		Symbol:       symbol,
+		Side:         side,
+		TriggerPrice: price,
+		Size:         size,
+	}
+	a.save()
+	return orderId, nil
+}
+
+// There is no network issue, retry isn't needed
+func (a *Account) RetryPlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	return a.PlaceStopLossOrder(symbol, side, price, size)
+}
+
+func (a *Account) RetryPlaceStopLossOrderContext(ctx context.Context, symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	return a.PlaceStopLossOrder(symbol, side, price, size)
+}
+
+func (a *Account) CancelStopLossOrder(orderId int64) error {
+	return a.CancelOpenTriggerOrder(orderId)
+}
+
+func (a *Account) CancelOpenTriggerOrder(orderId int64) error {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	if _, ok := a.triggerOrders[orderId]; !ok {
+		return exerr.ErrOrderAlreadyClosed
+	}
+	delete(a.triggerOrders, orderId)
+	a.save()
+	return nil
+}
+
+func (a *Account) RetryCancelOpenTriggerOrder(orderId int64, p retry.Policy) error {
+	return a.CancelOpenTriggerOrder(orderId)
+}
+
+func (a *Account) RetryCancelOpenTriggerOrderContext(ctx context.Context, orderId int64, p retry.Policy) error {
+	return a.CancelOpenTriggerOrder(orderId)
+}
+
+// NOTE See FtxRest.GetPosition
+func (a *Account) GetPosition(symbol string) (map[string]interface{}, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	p := make(map[string]interface{})
+	pos, ok := a.positions[symbol]
+	if !ok {
+		return p, fmt.Errorf("failed to get %s position", symbol)
+	}
+	p["cost"] = pos.Size.Mul(pos.EntryPrice).String()
+	p["entry_price"] = pos.EntryPrice.String()
+	p["size"] = pos.Size.String()
+	p["symbol"] = symbol
+	p["side"] = float64(pos.Side)
+	return p, nil
+}
+
+func (a *Account) GetPositions() (map[string]map[string]interface{}, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	r := make(map[string]map[string]interface{})
+	for symbol, pos := range a.positions {
+		r[symbol] = map[string]interface{}{
+			"cost":        pos.Size.Mul(pos.EntryPrice).String(),
+			"entry_price": pos.EntryPrice.String(),
+			"size":        pos.Size.String(),
+			"symbol":      symbol,
+			"side":        float64(pos.Side),
+		}
+	}
+	return r, nil
+}
+
+func (a *Account) RetryGetPosition(symbol string, p retry.Policy) (map[string]interface{}, error) {
+	return a.GetPosition(symbol)
+}
+
+func (a *Account) RetryGetPositionContext(ctx context.Context, symbol string, p retry.Policy) (map[string]interface{}, error) {
+	return a.GetPosition(symbol)
+}
+
+// Reduce-only market order, side is the side of the position
+func (a *Account) ClosePosition(symbol string, side order.Side, size decimal.Decimal, clientId string) error {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	if err := a.checkClientId(clientId); err != nil {
+		return err
+	}
+	filledSize, price, err := a.closePosition(symbol, side, size)
+	if err != nil {
+		return err
+	}
+	a.addClientOrder(clientId, a.newOrderId(), symbol, filledSize, price)
+	a.save()
+	return nil
+}
+
+func (a *Account) StopLostOrderExists(symbol string, orderId int64) (bool, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	o, ok := a.triggerOrders[orderId]
+	return ok && o.Symbol == symbol, nil
+}
+
+func (a *Account) GetOpenStopLossOrders() (map[int64]map[string]interface{}, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	r := make(map[int64]map[string]interface{})
+	for id, o := range a.triggerOrders {
+		r[id] = map[string]interface{}{
+			"order_id":      float64(id),
+			"symbol":        o.Symbol,
+			"side":          float64(o.Side),
+			"trigger_price": o.TriggerPrice.String(),
+			"size":          o.Size.String(),
+		}
+	}
+	return r, nil
+}
+
+// NOTE See FtxRest.GetOrderByClientId, orders of paper account are filled once they are placed
+func (a *Account) GetOrderByClientId(symbol string, clientId string) (map[string]interface{}, bool, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	r := make(map[string]interface{})
+	o, ok := a.clientOrders[clientId]
+	if !ok {
+		return r, false, nil
+	}
+	r["order_id"] = float64(o.OrderId)
+	r["status"] = "closed"
+	r["size"] = o.Size.String()
+	r["price"] = o.Price.String()
+	return r, true, nil
+}
+
+func (a *Account) GetBalance(coin string) (decimal.Decimal, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	return a.balances[coin], nil
+}
+
+// Spot market order, side LONG means buy and SHORT means sell
+func (a *Account) PlaceSpotMarketOrder(symbol string, side order.Side, size decimal.Decimal) (int64, error) {
+	a.market.mutex.Lock()
+	defer a.market.mutex.Unlock()
+
+	base, quote, err := splitSpotSymbol(symbol)
+	if err != nil {
+		return 0, err
+	}
+	if !size.IsPositive() {
+		return 0, exerr.ErrSizeTooSmall
+	}
+	price, err := a.market.fillPrice(symbol, side == order.LONG)
+	if err != nil {
+		return 0, err
+	}
+	cost := price.Mul(size)
+	fee := cost.Mul(a.market.config.TakerFee)
+
+	switch side {
+	case order.LONG:
+		if a.balances[quote].LessThan(cost.Add(fee)) {
+			return 0, errors.New("Not enough balances")
+		}
+		a.balances[quote] = a.balances[quote].Sub(cost).Sub(fee)
+		a.balances[base] = a.balances[base].Add(size)
+	case order.SHORT:
+		if a.balances[base].LessThan(size) {
+			return 0, errors.New("Not enough balances")
+		}
+		a.balances[base] = a.balances[base].Sub(size)
+		a.balances[quote] = a.balances[quote].Add(cost).Sub(fee)
+	default:
+		return 0, fmt.Errorf("side '%d' not supported", side)
+	}
+
+	orderId := a.newOrderId()
+	a.save()
+	return orderId, nil
+}
+
+// Close the position by the size given, the size will be capped by the size of the position
+func (a *Account) closePosition(symbol string, side order.Side, size decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	pos, ok := a.positions[symbol]
+	if !ok || pos.Side != side {
+		return decimal.Zero, decimal.Zero, exerr.ErrInvalidReduceOnly
+	}
+	if size.GreaterThan(pos.Size) {
+		size = pos.Size
+	}
+	price, err := a.market.fillPrice(symbol, side == order.SHORT)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+	a.trade(symbol, flipSide(side), size, price)
+	return size, price, nil
+}
+
+// Leverage is 1, the part of the order that opens a position needs the same amount of free collateral, plus the fee
+// NOTE The part that reduces the opposite position releases its margin first
+func (a *Account) checkMargin(symbol string, side order.Side, size decimal.Decimal, price decimal.Decimal) error {
+	free := a.freeCollateral()
+	openSize := size
+	if pos, ok := a.positions[symbol]; ok && pos.Side != side {
+		closeSize := decimal.Min(pos.Size, size)
+		free = free.Add(closeSize.Mul(pos.EntryPrice))
+		openSize = size.Sub(closeSize)
+	}
+	required := price.Mul(openSize).Add(price.Mul(size).Mul(a.market.config.TakerFee))
+	if free.LessThan(required) {
+		return exerr.ErrInsufficientMargin
+	}
+	return nil
+}
+
+// Collateral that isn't used by the positions, leverage is 1 so the margin of a position is its cost
+func (a *Account) freeCollateral() decimal.Decimal {
+	free := a.balances[QUOTE_COIN]
+	for _, pos := range a.positions {
+		free = free.Sub(pos.Size.Mul(pos.EntryPrice))
+	}
+	return free
+}
+
+// NOTE Keep the same error message as FTX
+func (a *Account) checkClientId(clientId string) error {
+	if _, ok := a.clientOrders[clientId]; clientId != "" && ok {
+		return exerr.ErrDuplicateClientOrder
+	}
+	return nil
+}
+
+func (a *Account) addClientOrder(clientId string, orderId int64, symbol string, size decimal.Decimal, price decimal.Decimal) {
+	if clientId == "" {
+		return
+	}
+	a.clientOrders[clientId] = &clientOrder{
+		OrderId: orderId,
+		Symbol:  symbol,
+		Size:    size,
+		Price:   price,
+	}
+}
+
+// Fill the order against the position of the symbol, realised PnL and fee are settled in USD
+func (a *Account) trade(symbol string, side order.Side, size decimal.Decimal, price decimal.Decimal) {
+	a.balances[QUOTE_COIN] = a.balances[QUOTE_COIN].Sub(price.Mul(size).Mul(a.market.config.TakerFee))
+
+	pos, ok := a.positions[symbol]
+	if !ok {
+		a.positions[symbol] = &position{Side: side, Size: size, EntryPrice: price}
+		return
+	}
+
+	// Add to the position
+	if pos.Side == side {
+		total := pos.Size.Add(size)
+		pos.EntryPrice = pos.EntryPrice.Mul(pos.Size).Add(price.Mul(size)).DivRound(total, 8)
+		pos.Size = total
+		return
+	}
+
+	// Reduce the position
+	closeSize := decimal.Min(pos.Size, size)
+	pnl := price.Sub(pos.EntryPrice).Mul(closeSize)
+	if pos.Side == order.SHORT {
+		pnl = pnl.Neg()
+	}
+	a.balances[QUOTE_COIN] = a.balances[QUOTE_COIN].Add(pnl)
+	pos.Size = pos.Size.Sub(closeSize)
+
+	// Open the opposite position with the rest
+	if rest := size.Sub(closeSize); rest.IsPositive() {
+		a.positions[symbol] = &position{Side: side, Size: rest, EntryPrice: price}
+		return
+	}
+	if pos.Size.IsZero() {
+		delete(a.positions, symbol)
+	}
+}
+
+func (a *Account) newOrderId() int64 {
+	id := a.nextOrderId
+	a.nextOrderId++
+	return id
+}
+
+func flipSide(s order.Side) order.Side {
+	if s == order.LONG {
+		return order.SHORT
+	}
+	return order.LONG
+}
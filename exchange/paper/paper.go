@@ -0,0 +1,321 @@
+package paper
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/order"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	QUOTE_COIN = "USD" // Contracts are settled in USD
+)
+
+type Config struct {
+	SlippagePercent decimal.Decimal // e.g. 0.05 means market orders are filled at mark +/- 0.05%
+	MakerFee        decimal.Decimal // e.g. 0.0002, NOTE all orders placed by the engine are market orders, it's reserved for limit orders
+	TakerFee        decimal.Decimal // e.g. 0.0007
+	InitialBalance  decimal.Decimal // USD balance of a new account
+}
+
+// For persisting accounts, it's implemented by db.DB
+type Store interface {
+	GetPaperAccountByUserUuid(string) (*db.PaperAccount, error)
+	CreatePaperAccount(*db.PaperAccount) (int64, error)
+	UpdatePaperAccount(string, map[string]interface{}) (int64, error)
+}
+
+// Market keeps the latest marks and the accounts of all users
+// NOTE All accounts share the same mutex, as stop orders of all accounts are checked by the mark stream
+type Market struct {
+	mutex    sync.Mutex
+	config   Config
+	store    Store
+	marks    map[string]decimal.Decimal // key is symbol
+	accounts map[string]*Account        // key is user_uuid
+
+	// Accounts waiting to be saved by Run, only the latest data of each account is kept
+	// NOTE Saving is kept out of the mutex above, so that the mark stream isn't blocked by DB
+	pendingMutex sync.Mutex
+	pending      map[string]map[string]interface{} // key is user_uuid
+	stopping     bool                              // Stop has been called
+	stopped      bool                              // Run has exited, accounts are saved right away
+
+	wakeCh chan struct{}
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+type position struct {
+	Side       order.Side      `json:"side"`
+	Size       decimal.Decimal `json:"size"`
+	EntryPrice decimal.Decimal `json:"entry_price"`
+}
+
+type triggerOrder struct {
+	Symbol       string          `json:"symbol"`
+	Side         order.Side      `json:"side"` // side of the position, the order is placed to close it
+	TriggerPrice decimal.Decimal `json:"trigger_price"`
+	Size         decimal.Decimal `json:"size"`
+}
+
+// Filled market order placed with client order id
+type clientOrder struct {
+	OrderId int64           `json:"order_id"`
+	Symbol  string          `json:"symbol"`
+	Size    decimal.Decimal `json:"size"`
+	Price   decimal.Decimal `json:"price"`
+}
+
+func NewMarket(config Config) *Market {
+	return &Market{
+		config:   config,
+		marks:    make(map[string]decimal.Decimal),
+		accounts: make(map[string]*Account),
+		pending:  make(map[string]map[string]interface{}),
+		wakeCh:   make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+func (m *Market) SetStore(store Store) {
+	m.store = store
+}
+
+// Save the accounts in the background until it's stopped
+func (m *Market) Run() {
+	defer close(m.doneCh)
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-m.wakeCh:
+		}
+		m.flush()
+	}
+}
+
+// Accounts waiting to be saved are still saved before it stops, they are saved right away after that
+func (m *Market) Stop() {
+	m.pendingMutex.Lock()
+	if m.stopping {
+		m.pendingMutex.Unlock()
+		return
+	}
+	m.stopping = true
+	m.pendingMutex.Unlock()
+
+	close(m.stopCh)
+	<-m.doneCh
+
+	m.pendingMutex.Lock()
+	defer m.pendingMutex.Unlock()
+	for userUuid, data := range m.pending {
+		m.write(userUuid, data)
+	}
+	m.pending = make(map[string]map[string]interface{})
+	m.stopped = true
+}
+
+// Get the account of the user, a new account with initial balance will be created if it doesn't exist
+func (m *Market) Account(userUuid string) (*Account, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if a, ok := m.accounts[userUuid]; ok {
+		return a, nil
+	}
+
+	a := &Account{
+		market:        m,
+		userUuid:      userUuid,
+		balances:      make(map[string]decimal.Decimal),
+		positions:     make(map[string]*position),
+		triggerOrders: make(map[int64]*triggerOrder),
+		clientOrders:  make(map[string]*clientOrder),
+		nextOrderId:   1,
+	}
+	pa, err := m.store.GetPaperAccountByUserUuid(userUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		a.balances[QUOTE_COIN] = m.config.InitialBalance
+		if err = a.create(); err != nil {
+			return nil, fmt.Errorf("failed to create paper account, err: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get paper account, err: %v", err)
+	} else if err = a.load(pa); err != nil {
+		return nil, fmt.Errorf("failed to load paper account, err: %v", err)
+	}
+
+	m.accounts[userUuid] = a
+	return a, nil
+}
+
+// Update the latest mark and fill the stop orders that have been triggered
+func (m *Market) UpdateMark(symbol string, price decimal.Decimal) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.marks[symbol] = price
+	for _, a := range m.accounts {
+		changed := false
+		for id, o := range a.triggerOrders {
+			if o.Symbol != symbol || !o.triggered(price) {
+				continue
+			}
+			delete(a.triggerOrders, id)
+			changed = true
+
+			// NOTE Like reduce-only order, it's just dropped if the position has been closed
+			if _, _, err := a.closePosition(o.Symbol, o.Side, o.Size); err != nil {
+				log.Printf("[Warn] paper account '%s' stop order '%d' wasn't filled, err: %v", a.userUuid, id, err)
+			}
+		}
+		if changed {
+			a.save()
+		}
+	}
+}
+
+// Stop order of long position is triggered when price drops to trigger price, and vice versa
+func (o *triggerOrder) triggered(price decimal.Decimal) bool {
+	if o.Side == order.LONG {
+		return price.LessThanOrEqual(o.TriggerPrice)
+	}
+	return price.GreaterThanOrEqual(o.TriggerPrice)
+}
+
+// Market order is filled at mark plus slippage, buy at higher and sell at lower price
+func (m *Market) fillPrice(symbol string, buy bool) (decimal.Decimal, error) {
+	mark, ok := m.marks[symbol]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("no mark price of '%s' yet", symbol)
+	}
+	slippage := m.config.SlippagePercent.Div(decimal.NewFromInt(100))
+	if buy {
+		return mark.Mul(decimal.NewFromInt(1).Add(slippage)), nil
+	}
+	return mark.Mul(decimal.NewFromInt(1).Sub(slippage)), nil
+}
+
+// e.g. 'BTC/USD' => 'BTC', 'USD'
+func splitSpotSymbol(symbol string) (base string, quote string, err error) {
+	s := strings.Split(symbol, "/")
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return "", "", fmt.Errorf("invalid spot symbol '%s'", symbol)
+	}
+	return s[0], s[1], nil
+}
+
+func (a *Account) load(pa *db.PaperAccount) (err error) {
+	if err = json.Unmarshal(pa.Balances, &a.balances); err != nil {
+		return
+	}
+	if err = json.Unmarshal(pa.Positions, &a.positions); err != nil {
+		return
+	}
+	if err = json.Unmarshal(pa.TriggerOrders, &a.triggerOrders); err != nil {
+		return
+	}
+	if err = json.Unmarshal(pa.ClientOrders, &a.clientOrders); err != nil {
+		return
+	}
+	a.nextOrderId = pa.NextOrderId
+	return
+}
+
+func (a *Account) create() error {
+	data, err := a.marshal()
+	if err != nil {
+		return err
+	}
+	pa := &db.PaperAccount{
+		UserUuid:      a.userUuid,
+		Balances:      data["balances"].([]byte),
+		Positions:     data["positions"].([]byte),
+		TriggerOrders: data["trigger_orders"].([]byte),
+		ClientOrders:  data["client_orders"].([]byte),
+		NextOrderId:   a.nextOrderId,
+	}
+	_, err = a.market.store.CreatePaperAccount(pa)
+	return err
+}
+
+// NOTE Memory data is the source of truth once the order has been filled, so the error is only logged
+func (a *Account) save() {
+	data, err := a.marshal()
+	if err != nil {
+		log.Printf("[ERROR] failed to save paper account '%s', err: %v", a.userUuid, err)
+		return
+	}
+	a.market.persist(a.userUuid, data)
+}
+
+// Queue the data of the account for Run, it's saved right away once the market has been stopped
+func (m *Market) persist(userUuid string, data map[string]interface{}) {
+	m.pendingMutex.Lock()
+	if m.stopped {
+		defer m.pendingMutex.Unlock()
+		m.write(userUuid, data)
+		return
+	}
+	m.pending[userUuid] = data
+	m.pendingMutex.Unlock()
+
+	select {
+	case m.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Save all accounts waiting, the ones changed meanwhile are saved by the next round
+func (m *Market) flush() {
+	m.pendingMutex.Lock()
+	pending := m.pending
+	m.pending = make(map[string]map[string]interface{})
+	m.pendingMutex.Unlock()
+
+	for userUuid, data := range pending {
+		m.write(userUuid, data)
+	}
+}
+
+func (m *Market) write(userUuid string, data map[string]interface{}) {
+	if _, err := m.store.UpdatePaperAccount(userUuid, data); err != nil {
+		log.Printf("[ERROR] failed to save paper account '%s', err: %v", userUuid, err)
+	}
+}
+
+func (a *Account) marshal() (map[string]interface{}, error) {
+	balances, err := json.Marshal(a.balances)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := json.Marshal(a.positions)
+	if err != nil {
+		return nil, err
+	}
+	triggerOrders, err := json.Marshal(a.triggerOrders)
+	if err != nil {
+		return nil, err
+	}
+	clientOrders, err := json.Marshal(a.clientOrders)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"balances":       balances,
+		"positions":      positions,
+		"trigger_orders": triggerOrders,
+		"client_orders":  clientOrders,
+		"next_order_id":  a.nextOrderId,
+	}, nil
+}
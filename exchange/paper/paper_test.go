@@ -0,0 +1,333 @@
+package paper
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Keep accounts in memory instead of DB
+type memoryStore struct {
+	mutex    sync.Mutex
+	accounts map[string]*db.PaperAccount
+	blockCh  chan struct{} // block the updates until it's closed if it's set
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{accounts: make(map[string]*db.PaperAccount)}
+}
+
+func (s *memoryStore) GetPaperAccountByUserUuid(userUuid string) (*db.PaperAccount, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pa, ok := s.accounts[userUuid]
+	if !ok {
+		return &db.PaperAccount{}, gorm.ErrRecordNotFound
+	}
+	return pa, nil
+}
+
+func (s *memoryStore) CreatePaperAccount(pa *db.PaperAccount) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.accounts[pa.UserUuid] = pa
+	return 1, nil
+}
+
+func (s *memoryStore) UpdatePaperAccount(userUuid string, data map[string]interface{}) (int64, error) {
+	s.mutex.Lock()
+	blockCh := s.blockCh
+	s.mutex.Unlock()
+	if blockCh != nil {
+		<-blockCh
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pa := s.accounts[userUuid]
+	pa.Balances = data["balances"].([]byte)
+	pa.Positions = data["positions"].([]byte)
+	pa.TriggerOrders = data["trigger_orders"].([]byte)
+	pa.ClientOrders = data["client_orders"].([]byte)
+	pa.NextOrderId = data["next_order_id"].(int64)
+	return 1, nil
+}
+
+func newTestMarket(store Store) *Market {
+	m := NewMarket(Config{
+		SlippagePercent: decimal.NewFromFloat(0.1),
+		MakerFee:        decimal.NewFromFloat(0.0002),
+		TakerFee:        decimal.NewFromFloat(0.001),
+		InitialBalance:  decimal.NewFromInt(10000),
+	})
+	m.SetStore(store)
+	go m.Run()
+	return m
+}
+
+func TestEntryAndClosePosition(t *testing.T) {
+	testcases := []struct {
+		title           string
+		side            order.Side
+		entryMark       int64
+		closeMark       int64
+		expectedBalance string
+	}{
+		// buy @1001, sell @1098.9, pnl 97.9, fee 1.001 + 1.0989
+		{title: "long with profit", side: order.LONG, entryMark: 1000, closeMark: 1100, expectedBalance: "10095.8001"},
+		// sell @999, buy @1101.1, pnl -102.1, fee 0.999 + 1.1011
+		{title: "short with loss", side: order.SHORT, entryMark: 1000, closeMark: 1100, expectedBalance: "9895.7999"},
+	}
+
+	for _, tc := range testcases {
+		m := newTestMarket(newMemoryStore())
+		a, _ := m.Account("user")
+		if _, err := a.PlaceEntryOrder("ETH-PERP", tc.side, decimal.NewFromInt(1), ""); err == nil {
+			t.Errorf("TestEntryAndClosePosition case '%s' - expect error without mark", tc.title)
+		}
+
+		m.UpdateMark("ETH-PERP", decimal.NewFromInt(tc.entryMark))
+		if _, err := a.PlaceEntryOrder("ETH-PERP", tc.side, decimal.NewFromInt(1), ""); err != nil {
+			t.Errorf("TestEntryAndClosePosition case '%s' - unexpected error: %v", tc.title, err)
+		}
+		p, err := a.GetPosition("ETH-PERP")
+		if err != nil || p["side"].(float64) != float64(tc.side) || p["size"].(string) != "1" {
+			t.Errorf("TestEntryAndClosePosition case '%s' - unexpected position: %v, err: %v", tc.title, p, err)
+		}
+
+		m.UpdateMark("ETH-PERP", decimal.NewFromInt(tc.closeMark))
+		if err := a.ClosePosition("ETH-PERP", tc.side, decimal.NewFromInt(2), ""); err != nil {
+			t.Errorf("TestEntryAndClosePosition case '%s' - unexpected error: %v", tc.title, err)
+		}
+		if _, err := a.GetPosition("ETH-PERP"); err == nil {
+			t.Errorf("TestEntryAndClosePosition case '%s' - expect position to be closed", tc.title)
+		}
+		if err := a.ClosePosition("ETH-PERP", tc.side, decimal.NewFromInt(1), ""); err == nil {
+			t.Errorf("TestEntryAndClosePosition case '%s' - expect reduce-only error", tc.title)
+		}
+
+		balance, _ := a.GetBalance(QUOTE_COIN)
+		if !balance.Equal(decimal.RequireFromString(tc.expectedBalance)) {
+			t.Errorf("TestEntryAndClosePosition case '%s' - expect balance '%s', but got '%s'", tc.title, tc.expectedBalance, balance)
+		}
+	}
+}
+
+func TestStopOrderTriggeredByMark(t *testing.T) {
+	testcases := []struct {
+		title           string
+		side            order.Side
+		triggerPrice    int64
+		marks           []int64
+		expectedClosed  bool
+		expectedExisted bool
+	}{
+		{title: "long, not triggered", side: order.LONG, triggerPrice: 900, marks: []int64{950, 901}, expectedClosed: false, expectedExisted: true},
+		{title: "long, triggered", side: order.LONG, triggerPrice: 900, marks: []int64{950, 900}, expectedClosed: true, expectedExisted: false},
+		{title: "short, not triggered", side: order.SHORT, triggerPrice: 1100, marks: []int64{1050, 1099}, expectedClosed: false, expectedExisted: true},
+		{title: "short, triggered", side: order.SHORT, triggerPrice: 1100, marks: []int64{1050, 1150}, expectedClosed: true, expectedExisted: false},
+	}
+
+	for _, tc := range testcases {
+		m := newTestMarket(newMemoryStore())
+		a, _ := m.Account("user")
+		m.UpdateMark("ETH-PERP", decimal.NewFromInt(1000))
+		a.PlaceEntryOrder("ETH-PERP", tc.side, decimal.NewFromInt(1), "")
+		orderId, _ := a.PlaceStopLossOrder("ETH-PERP", tc.side, decimal.NewFromInt(tc.triggerPrice), decimal.NewFromInt(1))
+
+		for _, mark := range tc.marks {
+			m.UpdateMark("ETH-PERP", decimal.NewFromInt(mark))
+		}
+
+		_, err := a.GetPosition("ETH-PERP")
+		if closed := (err != nil); closed != tc.expectedClosed {
+			t.Errorf("TestStopOrderTriggeredByMark case '%s' - expect closed '%t', but got '%t'", tc.title, tc.expectedClosed, closed)
+		}
+		existed, _ := a.StopLostOrderExists("ETH-PERP", orderId)
+		if existed != tc.expectedExisted {
+			t.Errorf("TestStopOrderTriggeredByMark case '%s' - expect existed '%t', but got '%t'", tc.title, tc.expectedExisted, existed)
+		}
+	}
+}
+
+func TestSpotMarketOrder(t *testing.T) {
+	m := newTestMarket(newMemoryStore())
+	a, _ := m.Account("user")
+	m.UpdateMark("ETH/USD", decimal.NewFromInt(1000))
+
+	if _, err := a.PlaceSpotMarketOrder("ETH/USD", order.SHORT, decimal.NewFromInt(1)); err == nil {
+		t.Error("TestSpotMarketOrder - expect error when selling without balance")
+	}
+	if _, err := a.PlaceSpotMarketOrder("ETH/USD", order.LONG, decimal.NewFromInt(2)); err != nil {
+		t.Errorf("TestSpotMarketOrder - unexpected error: %v", err)
+	}
+	if _, err := a.PlaceSpotMarketOrder("ETH/USD", order.LONG, decimal.NewFromInt(10)); err == nil {
+		t.Error("TestSpotMarketOrder - expect error when buying without enough balance")
+	}
+
+	// buy 2 @1001, fee 2.002
+	usd, _ := a.GetBalance("USD")
+	eth, _ := a.GetBalance("ETH")
+	if !usd.Equal(decimal.RequireFromString("7995.998")) || !eth.Equal(decimal.NewFromInt(2)) {
+		t.Errorf("TestSpotMarketOrder - unexpected balances USD: '%s', ETH: '%s'", usd, eth)
+	}
+}
+
+func TestAccountRestored(t *testing.T) {
+	store := newMemoryStore()
+	m := newTestMarket(store)
+	a, _ := m.Account("user")
+	m.UpdateMark("ETH-PERP", decimal.NewFromInt(1000))
+	entryOrderId, _ := a.PlaceEntryOrder("ETH-PERP", order.LONG, decimal.NewFromInt(1), "client-1")
+	orderId, _ := a.PlaceStopLossOrder("ETH-PERP", order.LONG, decimal.NewFromInt(900), decimal.NewFromInt(1))
+
+	// Simulate restart
+	m.Stop()
+	m = newTestMarket(store)
+	a, err := m.Account("user")
+	if err != nil {
+		t.Fatalf("TestAccountRestored - unexpected error: %v", err)
+	}
+	if _, err := a.GetPosition("ETH-PERP"); err != nil {
+		t.Errorf("TestAccountRestored - expect position to be restored, err: %v", err)
+	}
+	if existed, _ := a.StopLostOrderExists("ETH-PERP", orderId); !existed {
+		t.Error("TestAccountRestored - expect stop order to be restored")
+	}
+	if o, found, _ := a.GetOrderByClientId("BTC-PERP", "client-1"); !found || o["order_id"].(float64) != float64(entryOrderId) {
+		t.Errorf("TestAccountRestored - expect client order to be restored, but got %v", o)
+	}
+	if newOrderId, _ := a.PlaceStopLossOrder("ETH-PERP", order.LONG, decimal.NewFromInt(800), decimal.NewFromInt(1)); newOrderId <= orderId {
+		t.Errorf("TestAccountRestored - expect order id greater than '%d', but got '%d'", orderId, newOrderId)
+	}
+}
+
+func TestClientOrderId(t *testing.T) {
+	m := newTestMarket(newMemoryStore())
+	a, _ := m.Account("user")
+	m.UpdateMark("ETH-PERP", decimal.NewFromInt(1000))
+
+	if _, found, _ := a.GetOrderByClientId("BTC-PERP", "client-1"); found {
+		t.Error("TestClientOrderId - expect order not found before it's placed")
+	}
+	orderId, err := a.PlaceEntryOrder("ETH-PERP", order.LONG, decimal.NewFromInt(1), "client-1")
+	if err != nil {
+		t.Fatalf("TestClientOrderId - unexpected error: %v", err)
+	}
+
+	// The same client order id can't be placed twice, the position isn't doubled
+	if _, err := a.PlaceEntryOrder("ETH-PERP", order.LONG, decimal.NewFromInt(1), "client-1"); err == nil {
+		t.Error("TestClientOrderId - expect error of duplicate client order id")
+	}
+	if p, _ := a.GetPosition("ETH-PERP"); p["size"].(string) != "1" {
+		t.Errorf("TestClientOrderId - expect size '1', but got '%v'", p["size"])
+	}
+
+	// buy @1001
+	o, found, err := a.GetOrderByClientId("BTC-PERP", "client-1")
+	if err != nil || !found {
+		t.Fatalf("TestClientOrderId - expect order found, err: %v", err)
+	}
+	if o["order_id"].(float64) != float64(orderId) || o["size"].(string) != "1" || o["price"].(string) != "1001" {
+		t.Errorf("TestClientOrderId - unexpected order: %v", o)
+	}
+
+	// Closing order, the size is capped by the position
+	if err := a.ClosePosition("ETH-PERP", order.LONG, decimal.NewFromInt(2), "client-2"); err != nil {
+		t.Fatalf("TestClientOrderId - unexpected error: %v", err)
+	}
+	if o, found, _ := a.GetOrderByClientId("BTC-PERP", "client-2"); !found || o["size"].(string) != "1" {
+		t.Errorf("TestClientOrderId - unexpected closing order: %v", o)
+	}
+}
+
+func TestEntryOrderMargin(t *testing.T) {
+	testcases := []struct {
+		title         string
+		openSide      order.Side
+		openSize      int64
+		side          order.Side
+		size          int64
+		expectedError error
+	}{
+		// buy 9 @1001 with fee 9.009
+		{title: "affordable", side: order.LONG, size: 9, expectedError: nil},
+		// buy 10 @1001 costs 10010
+		{title: "more than balance", side: order.LONG, size: 10, expectedError: exerr.ErrInsufficientMargin},
+		// 5 @1001 is used by the position
+		{title: "more than free collateral", openSide: order.LONG, openSize: 5, side: order.LONG, size: 5, expectedError: exerr.ErrInsufficientMargin},
+		// Closing the short position of 5 frees its margin, only 6 is opened
+		{title: "flip the position", openSide: order.SHORT, openSize: 5, side: order.LONG, size: 11, expectedError: nil},
+	}
+
+	for _, tc := range testcases {
+		m := newTestMarket(newMemoryStore())
+		a, _ := m.Account("user")
+		m.UpdateMark("ETH-PERP", decimal.NewFromInt(1000))
+		if tc.openSize > 0 {
+			if _, err := a.PlaceEntryOrder("ETH-PERP", tc.openSide, decimal.NewFromInt(tc.openSize), ""); err != nil {
+				t.Fatalf("TestEntryOrderMargin case '%s' - unexpected error: %v", tc.title, err)
+			}
+		}
+
+		_, err := a.PlaceEntryOrder("ETH-PERP", tc.side, decimal.NewFromInt(tc.size), "")
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("TestEntryOrderMargin case '%s' - expect error '%v', but got '%v'", tc.title, tc.expectedError, err)
+		}
+		m.Stop()
+	}
+}
+
+func TestMarkNotBlockedBySaving(t *testing.T) {
+	store := newMemoryStore()
+	m := newTestMarket(store)
+	a, _ := m.Account("user")
+	m.UpdateMark("ETH-PERP", decimal.NewFromInt(1000))
+	a.PlaceEntryOrder("ETH-PERP", order.LONG, decimal.NewFromInt(1), "")
+	a.PlaceStopLossOrder("ETH-PERP", order.LONG, decimal.NewFromInt(900), decimal.NewFromInt(1))
+
+	// The stop order is filled by the mark while the DB hangs
+	blockCh := make(chan struct{})
+	store.mutex.Lock()
+	store.blockCh = blockCh
+	store.mutex.Unlock()
+	doneCh := make(chan struct{})
+	go func() {
+		m.UpdateMark("ETH-PERP", decimal.NewFromInt(800))
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("TestMarkNotBlockedBySaving - expect the mark not to be blocked by saving")
+	}
+
+	// The latest data is saved before it stops
+	close(blockCh)
+	m.Stop()
+	m = newTestMarket(store)
+	a, _ = m.Account("user")
+	if _, err := a.GetPosition("ETH-PERP"); err == nil {
+		t.Error("TestMarkNotBlockedBySaving - expect the closed position to be saved")
+	}
+}
+
+func TestStopConcurrently(t *testing.T) {
+	m := newTestMarket(newMemoryStore())
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Stop()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,497 @@
+package rest
+
+import (
+	"context"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/util/retry"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	BINANCE_FUTURES_API_URL = "https://fapi.binance.com"
+
+	// NOTE Fees of the regular user without BNB discount, Binance only returns the fee rate by symbol
+	BINANCE_MAKER_FEE = 0.0002
+	BINANCE_TAKER_FEE = 0.0005
+)
+
+// Binance USDⓈ-M perpetual futures, the account is in one-way mode
+type BinanceRest struct {
+	httpClient *http.Client
+	baseUrl    string
+	key        string
+	secret     string
+
+	// Symbols of strategies and Binance e.g. 'BTC-PERP' and 'BTCUSDT'
+	symbols *symbols.Map
+
+	// Symbols of the orders placed, as Binance requires the symbol to look up or cancel an order
+	orderSymbols sync.Map // map[order id or client order id]symbol of Binance
+}
+
+func NewBinanceRest() *BinanceRest {
+	return &BinanceRest{
+		baseUrl: BINANCE_FUTURES_API_URL,
+	}
+}
+
+func (rest *BinanceRest) NewClient(data map[string]interface{}) (err error) {
+	err = rest.validate(data)
+	if err != nil {
+		return
+	}
+	rest.key = data["api_key"].(string)
+	rest.secret = data["api_secret"].(string)
+	rest.httpClient = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	return
+}
+
+func (rest *BinanceRest) validate(data map[string]interface{}) error {
+	_, ok := data["api_key"].(string)
+	if !ok {
+		return errors.New("'api_key' is missing")
+	}
+	_, ok = data["api_secret"].(string)
+	if !ok {
+		return errors.New("'api_secret' is missing")
+	}
+	return nil
+}
+
+func (rest *BinanceRest) SetSymbolMap(m *symbols.Map) {
+	rest.symbols = m
+}
+
+// See FtxRest.GetAccountInfo
+func (rest *BinanceRest) GetAccountInfo() (map[string]interface{}, error) {
+	r := make(map[string]interface{})
+	account, err := rest.getAccount()
+	if err != nil {
+		return r, err
+	}
+	r["collateral"] = account.TotalMarginBalance    // decimal.Decimal
+	r["free_collateral"] = account.AvailableBalance // decimal.Decimal
+	r["maker_fee"] = decimal.NewFromFloat(BINANCE_MAKER_FEE)
+	r["taker_fee"] = decimal.NewFromFloat(BINANCE_TAKER_FEE)
+	return r, nil
+}
+
+func (rest *BinanceRest) PlaceEntryOrder(symbol string, side order.Side, size decimal.Decimal, clientId string) (int64, error) {
+	return rest.placeMarketOrder(symbol, rest.translateSide(side), size, false, clientId)
+}
+
+// Reduce-only STOP_MARKET order, it's triggered by the last price
+func (rest *BinanceRest) PlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal) (int64, error) {
+	exSymbol := rest.symbols.ToExchange(symbol)
+	params := url.Values{}
+	params.Set("symbol", exSymbol)
+	params.Set("side", rest.translateAndFlipSide(side))
+	params.Set("type", "STOP_MARKET")
+	params.Set("stopPrice", price.String())
+	params.Set("quantity", size.String())
+	params.Set("reduceOnly", "true")
+	resp, err := rest.privateRequest(http.MethodPost, "/fapi/v1/order", params)
+	if err != nil {
+		return 0, err
+	}
+
+	var o binanceOrder
+	if err = json.Unmarshal(resp, &o); err != nil {
+		return 0, err
+	}
+	rest.orderSymbols.Store(o.OrderId, exSymbol)
+	return o.OrderId, nil
+}
+
+func (rest *BinanceRest) RetryPlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	return rest.RetryPlaceStopLossOrderContext(context.Background(), symbol, side, price, size, p)
+}
+
+func (rest *BinanceRest) RetryPlaceStopLossOrderContext(ctx context.Context, symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (orderId int64, err error) {
+	err = retry.Do(ctx, p, func(attempt int) (e error) {
+		orderId, e = rest.PlaceStopLossOrder(symbol, side, price, size)
+		return retryable("RetryPlaceStopLossOrder", attempt, e)
+	})
+	return
+}
+
+func (rest *BinanceRest) CancelStopLossOrder(orderId int64) error {
+	return rest.CancelOpenTriggerOrder(orderId)
+}
+
+// See FtxRest.GetPosition
+// NOTE Binance returns the symbol with 'positionAmt' 0 if there is no position
+func (rest *BinanceRest) GetPosition(symbol string) (map[string]interface{}, error) {
+	p := make(map[string]interface{})
+	params := url.Values{}
+	params.Set("symbol", rest.symbols.ToExchange(symbol))
+	positions, err := rest.getPositionRisk(params)
+	if err != nil {
+		return p, err
+	}
+	for _, position := range positions {
+		if position.PositionAmt.IsZero() {
+			continue
+		}
+		return rest.position(position), nil
+	}
+	return p, fmt.Errorf("failed to get %s position", symbol)
+}
+
+// Open positions by symbol, see GetPosition for the format
+func (rest *BinanceRest) GetPositions() (map[string]map[string]interface{}, error) {
+	r := make(map[string]map[string]interface{})
+	positions, err := rest.getPositionRisk(url.Values{})
+	if err != nil {
+		return r, err
+	}
+	for _, position := range positions {
+		if position.PositionAmt.IsZero() {
+			continue
+		}
+		p := rest.position(position)
+		r[p["symbol"].(string)] = p
+	}
+	return r, nil
+}
+
+func (rest *BinanceRest) RetryGetPosition(symbol string, p retry.Policy) (map[string]interface{}, error) {
+	return rest.RetryGetPositionContext(context.Background(), symbol, p)
+}
+
+func (rest *BinanceRest) RetryGetPositionContext(ctx context.Context, symbol string, p retry.Policy) (position map[string]interface{}, err error) {
+	err = retry.Do(ctx, p, func(attempt int) (e error) {
+		position, e = rest.GetPosition(symbol)
+		return retryable("RetryGetPosition", attempt, e)
+	})
+	return
+}
+
+func (rest *BinanceRest) ClosePosition(symbol string, side order.Side, size decimal.Decimal, clientId string) error {
+	_, err := rest.placeMarketOrder(symbol, rest.translateAndFlipSide(side), size, true, clientId)
+	return err
+}
+
+// The order that isn't open any more is 'Order already closed', the same as FTX
+func (rest *BinanceRest) CancelOpenTriggerOrder(orderId int64) error {
+	exSymbol, err := rest.orderSymbol(orderId)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("symbol", exSymbol)
+	params.Set("orderId", strconv.FormatInt(orderId, 10))
+	_, err = rest.privateRequest(http.MethodDelete, "/fapi/v1/order", params)
+	return err
+}
+
+func (rest *BinanceRest) RetryCancelOpenTriggerOrder(orderId int64, p retry.Policy) error {
+	return rest.RetryCancelOpenTriggerOrderContext(context.Background(), orderId, p)
+}
+
+func (rest *BinanceRest) RetryCancelOpenTriggerOrderContext(ctx context.Context, orderId int64, p retry.Policy) error {
+	return retry.Do(ctx, p, func(attempt int) error {
+		return retryable("RetryCancelOpenTriggerOrder", attempt, rest.CancelOpenTriggerOrder(orderId))
+	})
+}
+
+func (rest *BinanceRest) StopLostOrderExists(symbol string, orderId int64) (bool, error) {
+	params := url.Values{}
+	params.Set("symbol", rest.symbols.ToExchange(symbol))
+	orders, err := rest.getOpenOrders(params)
+	if err != nil {
+		return true, err
+	}
+	for _, o := range orders {
+		if o.OrderId == orderId {
+			return true, nil
+		}
+	}
+
+	// If stop-loss order isn't open, it might have been triggered by Binance already
+	return false, nil
+}
+
+// See FtxRest.GetOpenStopLossOrders
+func (rest *BinanceRest) GetOpenStopLossOrders() (map[int64]map[string]interface{}, error) {
+	r := make(map[int64]map[string]interface{})
+	orders, err := rest.getOpenOrders(url.Values{})
+	if err != nil {
+		return r, err
+	}
+	for _, o := range orders {
+		if o.Type != "STOP_MARKET" || !o.ReduceOnly {
+			continue
+		}
+		side := order.LONG
+		if o.Side == "BUY" {
+			side = order.SHORT
+		}
+		r[o.OrderId] = map[string]interface{}{
+			"order_id":      float64(o.OrderId),
+			"symbol":        rest.symbols.FromExchange(o.Symbol),
+			"side":          float64(side),
+			"trigger_price": o.StopPrice.String(),
+			"size":          o.OrigQty.String(),
+		}
+	}
+	return r, nil
+}
+
+// See FtxRest.GetOrderByClientId
+// NOTE Binance looks up the order by symbol and client order id, the symbol is given as the order might not be placed by this client e.g. the process died
+func (rest *BinanceRest) GetOrderByClientId(symbol string, clientId string) (map[string]interface{}, bool, error) {
+	r := make(map[string]interface{})
+	params := url.Values{}
+	params.Set("symbol", rest.symbols.ToExchange(symbol))
+	params.Set("origClientOrderId", clientId)
+	resp, err := rest.privateRequest(http.MethodGet, "/fapi/v1/order", params)
+	if err != nil {
+		if errors.Is(err, exerr.ErrOrderNotFound) {
+			return r, false, nil
+		}
+		return r, false, err
+	}
+
+	var o binanceOrder
+	if err = json.Unmarshal(resp, &o); err != nil {
+		return r, false, err
+	}
+	r["order_id"] = float64(o.OrderId)
+	r["status"] = o.Status
+	r["size"] = o.ExecutedQty.String()
+	r["price"] = o.AvgPrice.String()
+	return r, true, nil
+}
+
+// Available balance of the asset in the futures wallet e.g. 'USDT'
+func (rest *BinanceRest) GetBalance(coin string) (decimal.Decimal, error) {
+	account, err := rest.getAccount()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	for _, a := range account.Assets {
+		if a.Asset == coin {
+			return a.AvailableBalance, nil
+		}
+	}
+	return decimal.Zero, nil
+}
+
+func (rest *BinanceRest) PlaceSpotMarketOrder(symbol string, side order.Side, size decimal.Decimal) (int64, error) {
+	return 0, errors.New("spot isn't supported by Binance USDⓈ-M futures")
+}
+
+// Order of '/fapi/v1/order' and '/fapi/v1/openOrders'
+type binanceOrder struct {
+	OrderId       int64           `json:"orderId"`
+	ClientOrderId string          `json:"clientOrderId"`
+	Symbol        string          `json:"symbol"`
+	Status        string          `json:"status"` // NEW, PARTIALLY_FILLED, FILLED, CANCELED, EXPIRED
+	Type          string          `json:"type"`
+	Side          string          `json:"side"`
+	ReduceOnly    bool            `json:"reduceOnly"`
+	OrigQty       decimal.Decimal `json:"origQty"`
+	ExecutedQty   decimal.Decimal `json:"executedQty"`
+	AvgPrice      decimal.Decimal `json:"avgPrice"`
+	StopPrice     decimal.Decimal `json:"stopPrice"`
+}
+
+type binancePosition struct {
+	Symbol      string          `json:"symbol"`
+	PositionAmt decimal.Decimal `json:"positionAmt"` // negative if it's short
+	EntryPrice  decimal.Decimal `json:"entryPrice"`
+}
+
+type binanceAccount struct {
+	TotalMarginBalance decimal.Decimal `json:"totalMarginBalance"`
+	AvailableBalance   decimal.Decimal `json:"availableBalance"`
+	Assets             []struct {
+		Asset            string          `json:"asset"`
+		AvailableBalance decimal.Decimal `json:"availableBalance"`
+	} `json:"assets"`
+}
+
+func (rest *BinanceRest) getAccount() (account binanceAccount, err error) {
+	resp, err := rest.privateRequest(http.MethodGet, "/fapi/v2/account", url.Values{})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp, &account)
+	return
+}
+
+func (rest *BinanceRest) getPositionRisk(params url.Values) (positions []binancePosition, err error) {
+	resp, err := rest.privateRequest(http.MethodGet, "/fapi/v2/positionRisk", params)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp, &positions)
+	return
+}
+
+// Open orders of the symbol, or all symbols if it isn't given
+func (rest *BinanceRest) getOpenOrders(params url.Values) (orders []binanceOrder, err error) {
+	resp, err := rest.privateRequest(http.MethodGet, "/fapi/v1/openOrders", params)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(resp, &orders); err != nil {
+		return
+	}
+	for _, o := range orders {
+		rest.orderSymbols.Store(o.OrderId, o.Symbol)
+	}
+	return
+}
+
+// NOTE cost is the value at entry price, the same as paper account
+func (rest *BinanceRest) position(position binancePosition) map[string]interface{} {
+	p := map[string]interface{}{
+		"cost":        position.PositionAmt.Abs().Mul(position.EntryPrice).String(),
+		"entry_price": position.EntryPrice.String(),
+		"size":        position.PositionAmt.Abs().String(),
+		"symbol":      rest.symbols.FromExchange(position.Symbol),
+		"side":        float64(order.LONG),
+	}
+	if position.PositionAmt.IsNegative() {
+		p["side"] = float64(order.SHORT)
+	}
+	return p
+}
+
+// Symbol of the order placed or listed before, otherwise look it up from open orders
+func (rest *BinanceRest) orderSymbol(orderId int64) (string, error) {
+	if exSymbol, ok := rest.orderSymbols.Load(orderId); ok {
+		return exSymbol.(string), nil
+	}
+	if _, err := rest.getOpenOrders(url.Values{}); err != nil {
+		return "", err
+	}
+	if exSymbol, ok := rest.orderSymbols.Load(orderId); ok {
+		return exSymbol.(string), nil
+	}
+	return "", fmt.Errorf("%w, order '%d' isn't open", exerr.ErrOrderAlreadyClosed, orderId)
+}
+
+func (rest *BinanceRest) placeMarketOrder(symbol string, side string, size decimal.Decimal, reduceOnly bool, clientId string) (int64, error) {
+	exSymbol := rest.symbols.ToExchange(symbol)
+	params := url.Values{}
+	params.Set("symbol", exSymbol)
+	params.Set("side", side)
+	params.Set("type", "MARKET")
+	params.Set("quantity", size.String())
+	params.Set("newOrderRespType", "RESULT")
+	if reduceOnly {
+		params.Set("reduceOnly", "true")
+	}
+	if clientId != "" {
+		params.Set("newClientOrderId", clientId)
+		rest.orderSymbols.Store(clientId, exSymbol)
+	}
+	resp, err := rest.privateRequest(http.MethodPost, "/fapi/v1/order", params)
+	if err != nil {
+		return 0, err
+	}
+
+	var o binanceOrder
+	if err = json.Unmarshal(resp, &o); err != nil {
+		return 0, err
+	}
+	rest.orderSymbols.Store(o.OrderId, exSymbol)
+	return o.OrderId, nil
+}
+
+// Signed request, params are sent as query string for all methods
+func (rest *BinanceRest) privateRequest(method string, path string, params url.Values) ([]byte, error) {
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	params.Set("recvWindow", "5000")
+	query := params.Encode()
+	mac := hmac.New(sha256.New, []byte(rest.secret))
+	mac.Write([]byte(query))
+	query += "&signature=" + hex.EncodeToString(mac.Sum(nil))
+
+	req, err := http.NewRequest(method, rest.baseUrl+path+"?"+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-MBX-APIKEY", rest.key)
+
+	resp, err := rest.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		if err = json.Unmarshal(b, &e); err != nil {
+			return nil, fmt.Errorf("Status Code: %d	Error: %s", resp.StatusCode, string(b))
+		}
+		return nil, classifyBinanceError(resp.StatusCode, e.Code, e.Msg)
+	}
+	return b, nil
+}
+
+// Binance error codes of the known errors
+var binanceErrors = map[int]error{
+	-2019: exerr.ErrInsufficientMargin,   // Margin is insufficient.
+	-4003: exerr.ErrSizeTooSmall,         // Quantity less than or equal to zero.
+	-4164: exerr.ErrSizeTooSmall,         // Order's notional must be no smaller than 5.0
+	-2011: exerr.ErrOrderAlreadyClosed,   // Unknown order sent.
+	-2013: exerr.ErrOrderNotFound,        // Order does not exist.
+	-2022: exerr.ErrInvalidReduceOnly,    // ReduceOnly Order is rejected.
+	-4116: exerr.ErrDuplicateClientOrder, // ClientOrderId is duplicated.
+}
+
+func classifyBinanceError(statusCode int, code int, msg string) error {
+	err := fmt.Errorf("Status Code: %d	Error: %d %s", statusCode, code, msg)
+	if known, ok := binanceErrors[code]; ok {
+		return fmt.Errorf("%w, %v", known, err)
+	}
+	return err
+}
+
+func (rest *BinanceRest) translateSide(s order.Side) string {
+	switch s {
+	case order.LONG:
+		return "BUY"
+	case order.SHORT:
+		return "SELL"
+	}
+	return ""
+}
+
+func (rest *BinanceRest) translateAndFlipSide(s order.Side) string {
+	switch s {
+	case order.LONG:
+		return "SELL"
+	case order.SHORT:
+		return "BUY"
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package rest
+
+import (
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// Local stand-in of Binance USDⓈ-M REST endpoints, it checks the signature and keeps the requests
+type binanceStandIn struct {
+	t         *testing.T
+	mu        sync.Mutex
+	requests  []*http.Request
+	responses map[string]string // key is 'METHOD /path', the value is status code and body separated by space
+}
+
+func (s *binanceStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	s.mu.Unlock()
+
+	if r.Header.Get("X-MBX-APIKEY") != "key" {
+		s.t.Errorf("binanceStandIn - unexpected api key '%s'", r.Header.Get("X-MBX-APIKEY"))
+	}
+	query := r.URL.RawQuery
+	i := strings.LastIndex(query, "&signature=")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(query[:i]))
+	if i < 0 || query[i+len("&signature="):] != hex.EncodeToString(mac.Sum(nil)) {
+		s.t.Errorf("binanceStandIn - invalid signature of '%s'", query)
+	}
+
+	resp, ok := s.responses[r.Method+" "+r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code":-1000,"msg":"not found"}`)
+		return
+	}
+	var statusCode int
+	var body string
+	fmt.Sscanf(resp, "%d", &statusCode)
+	body = resp[strings.Index(resp, " ")+1:]
+	w.WriteHeader(statusCode)
+	fmt.Fprint(w, body)
+}
+
+func (s *binanceStandIn) lastQuery() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1].URL.Query()
+}
+
+func newBinanceTestRest(t *testing.T, responses map[string]string) (*BinanceRest, *binanceStandIn) {
+	standIn := &binanceStandIn{t: t, responses: responses}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	rest := NewBinanceRest()
+	if err := rest.NewClient(map[string]interface{}{"api_key": "key", "api_secret": "secret"}); err != nil {
+		t.Fatalf("newBinanceTestRest - unexpected error: %v", err)
+	}
+	rest.baseUrl = server.URL
+	rest.SetSymbolMap(symbols.NewMap(map[string]string{"BTC-PERP": "BTCUSDT", "ETH-PERP": "ETHUSDT"}))
+	return rest, standIn
+}
+
+func TestBinancePlaceOrders(t *testing.T) {
+	rest, standIn := newBinanceTestRest(t, map[string]string{
+		"POST /fapi/v1/order":   `200 {"orderId":22542179,"symbol":"BTCUSDT","status":"FILLED","clientOrderId":"3f1c2e7a9b0d4c5e8f6a7b1c-1","executedQty":"0.010","avgPrice":"60012.10","origQty":"0.010","type":"MARKET","side":"BUY","reduceOnly":false}`,
+		"DELETE /fapi/v1/order": `200 {"orderId":22542179,"symbol":"BTCUSDT","status":"CANCELED"}`,
+	})
+
+	orderId, err := rest.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromFloat(0.01), "3f1c2e7a9b0d4c5e8f6a7b1c-1")
+	if err != nil || orderId != 22542179 {
+		t.Fatalf("TestBinancePlaceOrders - unexpected order id: %d, err: %v", orderId, err)
+	}
+	q := standIn.lastQuery()
+	if q.Get("symbol") != "BTCUSDT" || q.Get("side") != "BUY" || q.Get("type") != "MARKET" || q.Get("quantity") != "0.01" || q.Get("newClientOrderId") != "3f1c2e7a9b0d4c5e8f6a7b1c-1" || q.Get("reduceOnly") != "" {
+		t.Errorf("TestBinancePlaceOrders - unexpected entry order %v", q)
+	}
+
+	if _, err = rest.PlaceStopLossOrder("BTC-PERP", order.LONG, decimal.NewFromInt(58000), decimal.NewFromFloat(0.01)); err != nil {
+		t.Fatalf("TestBinancePlaceOrders - unexpected error: %v", err)
+	}
+	q = standIn.lastQuery()
+	if q.Get("side") != "SELL" || q.Get("type") != "STOP_MARKET" || q.Get("stopPrice") != "58000" || q.Get("reduceOnly") != "true" {
+		t.Errorf("TestBinancePlaceOrders - unexpected stop-loss order %v", q)
+	}
+
+	if err = rest.ClosePosition("ETH-PERP", order.SHORT, decimal.NewFromInt(1), ""); err != nil {
+		t.Fatalf("TestBinancePlaceOrders - unexpected error: %v", err)
+	}
+	q = standIn.lastQuery()
+	if q.Get("symbol") != "ETHUSDT" || q.Get("side") != "BUY" || q.Get("reduceOnly") != "true" {
+		t.Errorf("TestBinancePlaceOrders - unexpected closing order %v", q)
+	}
+
+	// Symbol of the order placed is known
+	if err = rest.CancelOpenTriggerOrder(22542179); err != nil {
+		t.Fatalf("TestBinancePlaceOrders - unexpected error: %v", err)
+	}
+	q = standIn.lastQuery()
+	if q.Get("symbol") != "ETHUSDT" || q.Get("orderId") != "22542179" {
+		t.Errorf("TestBinancePlaceOrders - unexpected cancel %v", q)
+	}
+}
+
+func TestBinanceCancelOpenTriggerOrder(t *testing.T) {
+	rest, standIn := newBinanceTestRest(t, map[string]string{
+		"GET /fapi/v1/openOrders": `200 [{"orderId":8886774,"symbol":"ETHUSDT","status":"NEW","type":"STOP_MARKET","side":"BUY","reduceOnly":true,"origQty":"1.000","stopPrice":"4200"}]`,
+		"DELETE /fapi/v1/order":   `400 {"code":-2011,"msg":"Unknown order sent."}`,
+	})
+
+	// Symbol is looked up from open orders
+	err := rest.CancelOpenTriggerOrder(8886774)
+	if !errors.Is(err, exerr.ErrOrderAlreadyClosed) {
+		t.Errorf("TestBinanceCancelOpenTriggerOrder - expect '%v', but got '%v'", exerr.ErrOrderAlreadyClosed, err)
+	}
+	if q := standIn.lastQuery(); q.Get("symbol") != "ETHUSDT" {
+		t.Errorf("TestBinanceCancelOpenTriggerOrder - unexpected cancel %v", q)
+	}
+
+	// The order isn't open
+	err = rest.CancelOpenTriggerOrder(1)
+	if !errors.Is(err, exerr.ErrOrderAlreadyClosed) || !exerr.IsPermanent(err) {
+		t.Errorf("TestBinanceCancelOpenTriggerOrder - expect '%v', but got '%v'", exerr.ErrOrderAlreadyClosed, err)
+	}
+
+	orders, err := rest.GetOpenStopLossOrders()
+	if err != nil {
+		t.Fatalf("TestBinanceCancelOpenTriggerOrder - unexpected error: %v", err)
+	}
+	o := orders[8886774]
+	if o["symbol"] != "ETH-PERP" || o["side"] != float64(order.SHORT) || o["trigger_price"] != "4200" || o["size"] != "1" {
+		t.Errorf("TestBinanceCancelOpenTriggerOrder - unexpected stop-loss order %v", o)
+	}
+
+	existed, err := rest.StopLostOrderExists("ETH-PERP", 8886774)
+	if err != nil || !existed {
+		t.Errorf("TestBinanceCancelOpenTriggerOrder - expect order to exist, err: %v", err)
+	}
+	existed, err = rest.StopLostOrderExists("ETH-PERP", 1)
+	if err != nil || existed {
+		t.Errorf("TestBinanceCancelOpenTriggerOrder - expect order not to exist, err: %v", err)
+	}
+}
+
+func TestBinanceGetPosition(t *testing.T) {
+	rest, _ := newBinanceTestRest(t, map[string]string{
+		"GET /fapi/v2/positionRisk": `200 [{"symbol":"BTCUSDT","positionAmt":"-0.010","entryPrice":"60000.0","positionSide":"BOTH"},{"symbol":"ETHUSDT","positionAmt":"0.000","entryPrice":"0.0","positionSide":"BOTH"}]`,
+	})
+
+	p, err := rest.GetPosition("BTC-PERP")
+	if err != nil {
+		t.Fatalf("TestBinanceGetPosition - unexpected error: %v", err)
+	}
+	if p["symbol"] != "BTC-PERP" || p["side"] != float64(order.SHORT) || p["size"] != "0.01" || p["cost"] != "600" || p["entry_price"] != "60000" {
+		t.Errorf("TestBinanceGetPosition - unexpected position %v", p)
+	}
+
+	positions, err := rest.GetPositions()
+	if err != nil || len(positions) != 1 || positions["BTC-PERP"] == nil {
+		t.Errorf("TestBinanceGetPosition - unexpected positions %v, err: %v", positions, err)
+	}
+}
+
+func TestBinanceGetOrderByClientId(t *testing.T) {
+	rest, standIn := newBinanceTestRest(t, map[string]string{
+		"GET /fapi/v1/order": `400 {"code":-2013,"msg":"Order does not exist."}`,
+	})
+
+	// The order isn't found
+	_, found, err := rest.GetOrderByClientId("BTC-PERP", "3f1c2e7a9b0d4c5e8f6a7b1c-2")
+	if err != nil || found {
+		t.Errorf("TestBinanceGetOrderByClientId - unexpected found: %t, err: %v", found, err)
+	}
+	if len(standIn.requests) != 1 || standIn.requests[0].URL.Query().Get("symbol") != "BTCUSDT" {
+		t.Errorf("TestBinanceGetOrderByClientId - expect 1 request of BTCUSDT, but got %v", standIn.requests)
+	}
+
+	standIn.responses["GET /fapi/v1/order"] = `200 {"orderId":22542180,"symbol":"BTCUSDT","status":"FILLED","clientOrderId":"3f1c2e7a9b0d4c5e8f6a7b1c-2","executedQty":"0.010","avgPrice":"60012.10"}`
+	o, found, err := rest.GetOrderByClientId("BTC-PERP", "3f1c2e7a9b0d4c5e8f6a7b1c-2")
+	if err != nil || !found || o["size"] != "0.01" || o["price"] != "60012.1" || o["order_id"] != float64(22542180) {
+		t.Errorf("TestBinanceGetOrderByClientId - unexpected order %v, found: %t, err: %v", o, found, err)
+	}
+}
+
+func TestBinanceErrors(t *testing.T) {
+	rest, _ := newBinanceTestRest(t, map[string]string{
+		"POST /fapi/v1/order":  `400 {"code":-2019,"msg":"Margin is insufficient."}`,
+		"GET /fapi/v2/account": `200 {"totalMarginBalance":"1000.5","availableBalance":"800","assets":[{"asset":"USDT","availableBalance":"800"}]}`,
+	})
+
+	_, err := rest.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromInt(1), "")
+	if !errors.Is(err, exerr.ErrInsufficientMargin) || !exerr.IsPermanent(err) {
+		t.Errorf("TestBinanceErrors - expect '%v', but got '%v'", exerr.ErrInsufficientMargin, err)
+	}
+
+	info, err := rest.GetAccountInfo()
+	if err != nil || !info["free_collateral"].(decimal.Decimal).Equal(decimal.NewFromInt(800)) {
+		t.Errorf("TestBinanceErrors - unexpected account info %v, err: %v", info, err)
+	}
+	balance, err := rest.GetBalance("USDT")
+	if err != nil || !balance.Equal(decimal.NewFromInt(800)) {
+		t.Errorf("TestBinanceErrors - unexpected balance %s, err: %v", balance, err)
+	}
+}
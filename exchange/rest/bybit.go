@@ -0,0 +1,632 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/util/retry"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	BYBIT_API_URL = "https://api.bybit.com"
+
+	// NOTE Fees of the non-VIP user of derivatives
+	BYBIT_MAKER_FEE = 0.0002
+	BYBIT_TAKER_FEE = 0.00055
+
+	// Linear perpetuals settled in USDT
+	BYBIT_CATEGORY    = "linear"
+	BYBIT_SETTLE_COIN = "USDT"
+)
+
+// Bybit linear perpetuals of the unified trading account, the position is in one-way mode
+type BybitRest struct {
+	httpClient *http.Client
+	baseUrl    string
+	key        string
+	secret     string
+
+	// Symbols of strategies and Bybit e.g. 'BTC-PERP' and 'BTCUSDT'
+	symbols *symbols.Map
+
+	// Bybit order ids are UUID strings, the orders placed or listed are kept for mapping them back from int64
+	orders sync.Map // map[int64]bybitOrderRef
+}
+
+type bybitOrderRef struct {
+	orderId string
+	symbol  string // symbol of Bybit
+}
+
+func NewBybitRest() *BybitRest {
+	return &BybitRest{
+		baseUrl: BYBIT_API_URL,
+	}
+}
+
+func (rest *BybitRest) NewClient(data map[string]interface{}) (err error) {
+	err = rest.validate(data)
+	if err != nil {
+		return
+	}
+	rest.key = data["api_key"].(string)
+	rest.secret = data["api_secret"].(string)
+	rest.httpClient = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	return
+}
+
+func (rest *BybitRest) validate(data map[string]interface{}) error {
+	_, ok := data["api_key"].(string)
+	if !ok {
+		return errors.New("'api_key' is missing")
+	}
+	_, ok = data["api_secret"].(string)
+	if !ok {
+		return errors.New("'api_secret' is missing")
+	}
+	return nil
+}
+
+func (rest *BybitRest) SetSymbolMap(m *symbols.Map) {
+	rest.symbols = m
+}
+
+// See FtxRest.GetAccountInfo
+func (rest *BybitRest) GetAccountInfo() (map[string]interface{}, error) {
+	r := make(map[string]interface{})
+	wallet, err := rest.getWalletBalance()
+	if err != nil {
+		return r, err
+	}
+	r["collateral"] = bybitDecimal(wallet.TotalMarginBalance)         // decimal.Decimal
+	r["free_collateral"] = bybitDecimal(wallet.TotalAvailableBalance) // decimal.Decimal
+	r["maker_fee"] = decimal.NewFromFloat(BYBIT_MAKER_FEE)
+	r["taker_fee"] = decimal.NewFromFloat(BYBIT_TAKER_FEE)
+	return r, nil
+}
+
+func (rest *BybitRest) PlaceEntryOrder(symbol string, side order.Side, size decimal.Decimal, clientId string) (int64, error) {
+	return rest.placeOrder(bybitOrderRequest{
+		Symbol:      rest.symbols.ToExchange(symbol),
+		Side:        rest.translateSide(side),
+		Qty:         size.String(),
+		OrderLinkId: clientId,
+	})
+}
+
+// Reduce-only conditional market order, it's triggered by the last price
+// triggerDirection 1 is triggered when the price rises to the trigger price, 2 is when it falls to
+func (rest *BybitRest) PlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal) (int64, error) {
+	triggerDirection := 2
+	if side == order.SHORT {
+		triggerDirection = 1
+	}
+	return rest.placeOrder(bybitOrderRequest{
+		Symbol:           rest.symbols.ToExchange(symbol),
+		Side:             rest.translateAndFlipSide(side),
+		Qty:              size.String(),
+		ReduceOnly:       true,
+		TriggerPrice:     price.String(),
+		TriggerDirection: triggerDirection,
+		TriggerBy:        "LastPrice",
+	})
+}
+
+func (rest *BybitRest) RetryPlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	return rest.RetryPlaceStopLossOrderContext(context.Background(), symbol, side, price, size, p)
+}
+
+func (rest *BybitRest) RetryPlaceStopLossOrderContext(ctx context.Context, symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (orderId int64, err error) {
+	err = retry.Do(ctx, p, func(attempt int) (e error) {
+		orderId, e = rest.PlaceStopLossOrder(symbol, side, price, size)
+		return retryable("RetryPlaceStopLossOrder", attempt, e)
+	})
+	return
+}
+
+func (rest *BybitRest) CancelStopLossOrder(orderId int64) error {
+	return rest.CancelOpenTriggerOrder(orderId)
+}
+
+// See FtxRest.GetPosition
+// NOTE Bybit returns the symbol with 'size' 0 and 'side' empty if there is no position
+func (rest *BybitRest) GetPosition(symbol string) (map[string]interface{}, error) {
+	p := make(map[string]interface{})
+	params := url.Values{}
+	params.Set("symbol", rest.symbols.ToExchange(symbol))
+	positions, err := rest.getPositions(params)
+	if err != nil {
+		return p, err
+	}
+	for _, position := range positions {
+		if bybitDecimal(position.Size).IsZero() {
+			continue
+		}
+		return rest.position(position), nil
+	}
+	return p, fmt.Errorf("failed to get %s position", symbol)
+}
+
+// Open positions by symbol, see GetPosition for the format
+func (rest *BybitRest) GetPositions() (map[string]map[string]interface{}, error) {
+	r := make(map[string]map[string]interface{})
+	params := url.Values{}
+	params.Set("settleCoin", BYBIT_SETTLE_COIN)
+	params.Set("limit", "200")
+	positions, err := rest.getPositions(params)
+	if err != nil {
+		return r, err
+	}
+	for _, position := range positions {
+		if bybitDecimal(position.Size).IsZero() {
+			continue
+		}
+		p := rest.position(position)
+		r[p["symbol"].(string)] = p
+	}
+	return r, nil
+}
+
+func (rest *BybitRest) RetryGetPosition(symbol string, p retry.Policy) (map[string]interface{}, error) {
+	return rest.RetryGetPositionContext(context.Background(), symbol, p)
+}
+
+func (rest *BybitRest) RetryGetPositionContext(ctx context.Context, symbol string, p retry.Policy) (position map[string]interface{}, err error) {
+	err = retry.Do(ctx, p, func(attempt int) (e error) {
+		position, e = rest.GetPosition(symbol)
+		return retryable("RetryGetPosition", attempt, e)
+	})
+	return
+}
+
+func (rest *BybitRest) ClosePosition(symbol string, side order.Side, size decimal.Decimal, clientId string) error {
+	_, err := rest.placeOrder(bybitOrderRequest{
+		Symbol:      rest.symbols.ToExchange(symbol),
+		Side:        rest.translateAndFlipSide(side),
+		Qty:         size.String(),
+		ReduceOnly:  true,
+		OrderLinkId: clientId,
+	})
+	return err
+}
+
+// The order that isn't open any more is 'Order already closed', the same as FTX
+func (rest *BybitRest) CancelOpenTriggerOrder(orderId int64) error {
+	ref, err := rest.orderRef(orderId)
+	if err != nil {
+		return err
+	}
+	_, err = rest.privateRequest(http.MethodPost, "/v5/order/cancel", nil, map[string]string{
+		"category": BYBIT_CATEGORY,
+		"symbol":   ref.symbol,
+		"orderId":  ref.orderId,
+	})
+	return err
+}
+
+func (rest *BybitRest) RetryCancelOpenTriggerOrder(orderId int64, p retry.Policy) error {
+	return rest.RetryCancelOpenTriggerOrderContext(context.Background(), orderId, p)
+}
+
+func (rest *BybitRest) RetryCancelOpenTriggerOrderContext(ctx context.Context, orderId int64, p retry.Policy) error {
+	return retry.Do(ctx, p, func(attempt int) error {
+		return retryable("RetryCancelOpenTriggerOrder", attempt, rest.CancelOpenTriggerOrder(orderId))
+	})
+}
+
+func (rest *BybitRest) StopLostOrderExists(symbol string, orderId int64) (bool, error) {
+	params := url.Values{}
+	params.Set("symbol", rest.symbols.ToExchange(symbol))
+	orders, err := rest.getOpenOrders(params)
+	if err != nil {
+		return true, err
+	}
+	for _, o := range orders {
+		if bybitOrderId(o.OrderId) == orderId {
+			return true, nil
+		}
+	}
+
+	// If stop-loss order isn't open, it might have been triggered by Bybit already
+	return false, nil
+}
+
+// See FtxRest.GetOpenStopLossOrders
+func (rest *BybitRest) GetOpenStopLossOrders() (map[int64]map[string]interface{}, error) {
+	r := make(map[int64]map[string]interface{})
+	params := url.Values{}
+	params.Set("settleCoin", BYBIT_SETTLE_COIN)
+	orders, err := rest.getOpenOrders(params)
+	if err != nil {
+		return r, err
+	}
+	for _, o := range orders {
+		triggerPrice := bybitDecimal(o.TriggerPrice)
+		if triggerPrice.IsZero() || !o.ReduceOnly {
+			continue
+		}
+		side := order.LONG
+		if o.Side == "Buy" {
+			side = order.SHORT
+		}
+		id := bybitOrderId(o.OrderId)
+		r[id] = map[string]interface{}{
+			"order_id":      float64(id),
+			"symbol":        rest.symbols.FromExchange(o.Symbol),
+			"side":          float64(side),
+			"trigger_price": triggerPrice.String(),
+			"size":          bybitDecimal(o.Qty).String(),
+		}
+	}
+	return r, nil
+}
+
+// See FtxRest.GetOrderByClientId
+// NOTE Bybit keeps the order history of the unified account for 2 years, so it can be looked up without the symbol
+func (rest *BybitRest) GetOrderByClientId(symbol string, clientId string) (map[string]interface{}, bool, error) {
+	r := make(map[string]interface{})
+	params := url.Values{}
+	params.Set("category", BYBIT_CATEGORY)
+	params.Set("orderLinkId", clientId)
+	resp, err := rest.privateRequest(http.MethodGet, "/v5/order/history", params, nil)
+	if err != nil {
+		return r, false, err
+	}
+
+	var list bybitOrderList
+	if err = json.Unmarshal(resp, &list); err != nil {
+		return r, false, err
+	}
+	if len(list.List) == 0 {
+		return r, false, nil
+	}
+	o := list.List[0]
+	r["order_id"] = float64(rest.storeOrder(o.OrderId, o.Symbol))
+	r["status"] = o.OrderStatus
+	r["size"] = bybitDecimal(o.CumExecQty).String()
+	r["price"] = bybitDecimal(o.AvgPrice).String()
+	return r, true, nil
+}
+
+// Wallet balance of the coin that isn't locked by spot orders e.g. 'USDT'
+func (rest *BybitRest) GetBalance(coin string) (decimal.Decimal, error) {
+	wallet, err := rest.getWalletBalance()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	for _, c := range wallet.Coin {
+		if c.Coin == coin {
+			return bybitDecimal(c.WalletBalance).Sub(bybitDecimal(c.Locked)), nil
+		}
+	}
+	return decimal.Zero, nil
+}
+
+func (rest *BybitRest) PlaceSpotMarketOrder(symbol string, side order.Side, size decimal.Decimal) (int64, error) {
+	return 0, errors.New("spot isn't supported by Bybit linear perpetuals")
+}
+
+// Body of '/v5/order/create', the order is conditional if the trigger price is given
+type bybitOrderRequest struct {
+	Category         string `json:"category"`
+	Symbol           string `json:"symbol"`
+	Side             string `json:"side"`
+	OrderType        string `json:"orderType"`
+	Qty              string `json:"qty"`
+	ReduceOnly       bool   `json:"reduceOnly,omitempty"`
+	OrderLinkId      string `json:"orderLinkId,omitempty"`
+	TriggerPrice     string `json:"triggerPrice,omitempty"`
+	TriggerDirection int    `json:"triggerDirection,omitempty"`
+	TriggerBy        string `json:"triggerBy,omitempty"`
+}
+
+// Order of '/v5/order/realtime' and '/v5/order/history'
+// NOTE Numbers are strings and might be empty
+type bybitOrder struct {
+	OrderId      string `json:"orderId"`
+	OrderLinkId  string `json:"orderLinkId"`
+	Symbol       string `json:"symbol"`
+	OrderStatus  string `json:"orderStatus"` // New, PartiallyFilled, Untriggered, Filled, Cancelled, Rejected, Deactivated
+	OrderType    string `json:"orderType"`
+	Side         string `json:"side"`
+	ReduceOnly   bool   `json:"reduceOnly"`
+	Qty          string `json:"qty"`
+	CumExecQty   string `json:"cumExecQty"`
+	AvgPrice     string `json:"avgPrice"`
+	TriggerPrice string `json:"triggerPrice"`
+}
+
+type bybitOrderList struct {
+	List []bybitOrder `json:"list"`
+}
+
+type bybitPosition struct {
+	Symbol   string `json:"symbol"`
+	Side     string `json:"side"` // Buy, Sell, or empty if there is no position
+	Size     string `json:"size"`
+	AvgPrice string `json:"avgPrice"`
+}
+
+type bybitWallet struct {
+	TotalMarginBalance    string `json:"totalMarginBalance"`
+	TotalAvailableBalance string `json:"totalAvailableBalance"`
+	Coin                  []struct {
+		Coin          string `json:"coin"`
+		WalletBalance string `json:"walletBalance"`
+		Locked        string `json:"locked"`
+	} `json:"coin"`
+}
+
+func (rest *BybitRest) getWalletBalance() (wallet bybitWallet, err error) {
+	params := url.Values{}
+	params.Set("accountType", "UNIFIED")
+	resp, err := rest.privateRequest(http.MethodGet, "/v5/account/wallet-balance", params, nil)
+	if err != nil {
+		return
+	}
+	var result struct {
+		List []bybitWallet `json:"list"`
+	}
+	if err = json.Unmarshal(resp, &result); err != nil {
+		return
+	}
+	if len(result.List) == 0 {
+		err = errors.New("unified account is missing")
+		return
+	}
+	return result.List[0], nil
+}
+
+func (rest *BybitRest) getPositions(params url.Values) (positions []bybitPosition, err error) {
+	params.Set("category", BYBIT_CATEGORY)
+	err = rest.getPages("/v5/position/list", params, func(list []byte) error {
+		var page []bybitPosition
+		if err := json.Unmarshal(list, &page); err != nil {
+			return err
+		}
+		positions = append(positions, page...)
+		return nil
+	})
+	return
+}
+
+// Open orders including the untriggered conditional orders, of the symbol or the settle coin
+func (rest *BybitRest) getOpenOrders(params url.Values) (orders []bybitOrder, err error) {
+	params.Set("category", BYBIT_CATEGORY)
+	params.Set("limit", "50")
+	err = rest.getPages("/v5/order/realtime", params, func(list []byte) error {
+		var page []bybitOrder
+		if err := json.Unmarshal(list, &page); err != nil {
+			return err
+		}
+		for _, o := range page {
+			rest.storeOrder(o.OrderId, o.Symbol)
+		}
+		orders = append(orders, page...)
+		return nil
+	})
+	return
+}
+
+// Request the pages one by one until 'nextPageCursor' is empty, the list of each page is passed to f
+func (rest *BybitRest) getPages(path string, params url.Values, f func([]byte) error) error {
+	for {
+		resp, err := rest.privateRequest(http.MethodGet, path, params, nil)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			NextPageCursor string          `json:"nextPageCursor"`
+			List           json.RawMessage `json:"list"`
+		}
+		if err = json.Unmarshal(resp, &page); err != nil {
+			return err
+		}
+		if len(page.List) > 0 {
+			if err = f(page.List); err != nil {
+				return err
+			}
+		}
+		if page.NextPageCursor == "" {
+			return nil
+		}
+		// NOTE The cursor is url-encoded already
+		cursor, err := url.QueryUnescape(page.NextPageCursor)
+		if err != nil {
+			return err
+		}
+		params.Set("cursor", cursor)
+	}
+}
+
+// NOTE cost is the value at entry price, the same as paper account
+func (rest *BybitRest) position(position bybitPosition) map[string]interface{} {
+	size := bybitDecimal(position.Size)
+	avgPrice := bybitDecimal(position.AvgPrice)
+	p := map[string]interface{}{
+		"cost":        size.Mul(avgPrice).String(),
+		"entry_price": avgPrice.String(),
+		"size":        size.String(),
+		"symbol":      rest.symbols.FromExchange(position.Symbol),
+		"side":        float64(order.LONG),
+	}
+	if position.Side == "Sell" {
+		p["side"] = float64(order.SHORT)
+	}
+	return p
+}
+
+func (rest *BybitRest) storeOrder(orderId string, symbol string) int64 {
+	id := bybitOrderId(orderId)
+	rest.orders.Store(id, bybitOrderRef{orderId: orderId, symbol: symbol})
+	return id
+}
+
+// Order placed or listed before, otherwise look it up from open orders
+func (rest *BybitRest) orderRef(orderId int64) (bybitOrderRef, error) {
+	if ref, ok := rest.orders.Load(orderId); ok {
+		return ref.(bybitOrderRef), nil
+	}
+	params := url.Values{}
+	params.Set("settleCoin", BYBIT_SETTLE_COIN)
+	if _, err := rest.getOpenOrders(params); err != nil {
+		return bybitOrderRef{}, err
+	}
+	if ref, ok := rest.orders.Load(orderId); ok {
+		return ref.(bybitOrderRef), nil
+	}
+	return bybitOrderRef{}, fmt.Errorf("%w, order '%d' isn't open", exerr.ErrOrderAlreadyClosed, orderId)
+}
+
+func (rest *BybitRest) placeOrder(body bybitOrderRequest) (int64, error) {
+	body.Category = BYBIT_CATEGORY
+	body.OrderType = "Market"
+	resp, err := rest.privateRequest(http.MethodPost, "/v5/order/create", nil, body)
+	if err != nil {
+		return 0, err
+	}
+
+	var o bybitOrder
+	if err = json.Unmarshal(resp, &o); err != nil {
+		return 0, err
+	}
+	return rest.storeOrder(o.OrderId, body.Symbol), nil
+}
+
+// Signed request, params are sent as query string for GET and body as JSON for POST
+// The 'result' of the response is returned
+func (rest *BybitRest) privateRequest(method string, path string, params url.Values, body interface{}) ([]byte, error) {
+	var payload, query string
+	var reqBody []byte
+	if method == http.MethodGet {
+		query = params.Encode()
+		payload = query
+	} else {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+		payload = string(reqBody)
+	}
+	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	recvWindow := "5000"
+	mac := hmac.New(sha256.New, []byte(rest.secret))
+	mac.Write([]byte(timestamp + rest.key + recvWindow + payload))
+
+	u := rest.baseUrl + path
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-BAPI-API-KEY", rest.key)
+	req.Header.Set("X-BAPI-TIMESTAMP", timestamp)
+	req.Header.Set("X-BAPI-RECV-WINDOW", recvWindow)
+	req.Header.Set("X-BAPI-SIGN", hex.EncodeToString(mac.Sum(nil)))
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := rest.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Status Code: %d	Error: %s", resp.StatusCode, string(b))
+	}
+
+	// NOTE Bybit responds with status 200 for the errors of the request, see 'retCode'
+	var r struct {
+		RetCode int             `json:"retCode"`
+		RetMsg  string          `json:"retMsg"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("Status Code: %d	Error: %s", resp.StatusCode, string(b))
+	}
+	if r.RetCode != 0 {
+		return nil, classifyBybitError(r.RetCode, r.RetMsg)
+	}
+	return r.Result, nil
+}
+
+// Bybit error codes of the known errors
+var bybitErrors = map[int]error{
+	110004: exerr.ErrInsufficientMargin,   // Wallet balance is insufficient
+	110007: exerr.ErrInsufficientMargin,   // Available balance is insufficient
+	110094: exerr.ErrSizeTooSmall,         // Order does not meet minimum order value
+	110001: exerr.ErrOrderAlreadyClosed,   // Order does not exist
+	110017: exerr.ErrInvalidReduceOnly,    // Reduce-only rule not satisfied
+	110072: exerr.ErrDuplicateClientOrder, // OrderLinkedID is duplicate
+}
+
+func classifyBybitError(code int, msg string) error {
+	err := fmt.Errorf("Error: %d %s", code, msg)
+	if known, ok := bybitErrors[code]; ok {
+		return fmt.Errorf("%w, %v", known, err)
+	}
+	return err
+}
+
+// Order ids of Bybit are UUID strings, they're hashed into 53 bits so that they survive being stored as float64 e.g. JSON
+func bybitOrderId(orderId string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(orderId))
+	return int64(h.Sum64() & (1<<53 - 1))
+}
+
+// Bybit returns numbers as strings, empty string is zero
+func bybitDecimal(s string) decimal.Decimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
+
+func (rest *BybitRest) translateSide(s order.Side) string {
+	switch s {
+	case order.LONG:
+		return "Buy"
+	case order.SHORT:
+		return "Sell"
+	}
+	return ""
+}
+
+func (rest *BybitRest) translateAndFlipSide(s order.Side) string {
+	switch s {
+	case order.LONG:
+		return "Sell"
+	case order.SHORT:
+		return "Buy"
+	}
+	return ""
+}
@@ -0,0 +1,291 @@
+package rest
+
+import (
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// Local stand-in of Bybit v5 endpoints, it checks the signature and serves the responses recorded in testdata/bybit
+type bybitStandIn struct {
+	t        *testing.T
+	mu       sync.Mutex
+	requests []bybitRequest
+	fixtures map[string]string // key is 'METHOD /path' or 'METHOD /path?cursor=', the value is the fixture name
+}
+
+type bybitRequest struct {
+	method string
+	path   string
+	query  string
+	body   map[string]interface{}
+}
+
+func (s *bybitStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	req := bybitRequest{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery}
+	payload := r.URL.RawQuery
+	if r.Method != http.MethodGet {
+		payload = string(b)
+		if err := json.Unmarshal(b, &req.body); err != nil {
+			s.t.Errorf("bybitStandIn - invalid body '%s'", string(b))
+		}
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	key := r.Method + " " + r.URL.Path
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		key += "?cursor=" + cursor
+	}
+	fixture, ok := s.fixtures[key]
+	s.mu.Unlock()
+
+	if r.Header.Get("X-BAPI-API-KEY") != "key" {
+		s.t.Errorf("bybitStandIn - unexpected api key '%s'", r.Header.Get("X-BAPI-API-KEY"))
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(r.Header.Get("X-BAPI-TIMESTAMP") + "key" + r.Header.Get("X-BAPI-RECV-WINDOW") + payload))
+	if r.Header.Get("X-BAPI-SIGN") != hex.EncodeToString(mac.Sum(nil)) {
+		s.t.Errorf("bybitStandIn - invalid signature of '%s %s'", r.Method, r.URL)
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	resp, err := ioutil.ReadFile(filepath.Join("testdata", "bybit", fixture+".json"))
+	if err != nil {
+		s.t.Fatalf("bybitStandIn - failed to read fixture '%s': %v", fixture, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+func (s *bybitStandIn) setFixture(key string, fixture string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures[key] = fixture
+}
+
+func (s *bybitStandIn) lastRequest() bybitRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+func newBybitTestRest(t *testing.T, fixtures map[string]string) (*BybitRest, *bybitStandIn) {
+	standIn := &bybitStandIn{t: t, fixtures: fixtures}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	rest := NewBybitRest()
+	if err := rest.NewClient(map[string]interface{}{"api_key": "key", "api_secret": "secret"}); err != nil {
+		t.Fatalf("newBybitTestRest - unexpected error: %v", err)
+	}
+	rest.baseUrl = server.URL
+	rest.SetSymbolMap(symbols.NewMap(map[string]string{"BTC-PERP": "BTCUSDT", "ETH-PERP": "ETHUSDT"}))
+	return rest, standIn
+}
+
+func TestBybitPlaceOrders(t *testing.T) {
+	rest, standIn := newBybitTestRest(t, map[string]string{
+		"POST /v5/order/create": "order_create",
+		"POST /v5/order/cancel": "order_cancel",
+	})
+
+	orderId, err := rest.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromFloat(0.01), "3f1c2e7a9b0d4c5e8f6a7b1c-1")
+	if err != nil || orderId != bybitOrderId("1b7c4f1e-5d3a-4a61-9c1b-7f2e6c0b8d21") {
+		t.Fatalf("TestBybitPlaceOrders - unexpected order id: %d, err: %v", orderId, err)
+	}
+	if float64(orderId) != float64(int64(float64(orderId))) || orderId >= 1<<53 {
+		t.Errorf("TestBybitPlaceOrders - expect order id to survive float64, but got %d", orderId)
+	}
+	b := standIn.lastRequest().body
+	if b["category"] != "linear" || b["symbol"] != "BTCUSDT" || b["side"] != "Buy" || b["orderType"] != "Market" || b["qty"] != "0.01" || b["orderLinkId"] != "3f1c2e7a9b0d4c5e8f6a7b1c-1" || b["reduceOnly"] != nil || b["triggerPrice"] != nil {
+		t.Errorf("TestBybitPlaceOrders - unexpected entry order %v", b)
+	}
+
+	// Stop-loss of the long position
+	if _, err = rest.PlaceStopLossOrder("BTC-PERP", order.LONG, decimal.NewFromInt(58000), decimal.NewFromFloat(0.01)); err != nil {
+		t.Fatalf("TestBybitPlaceOrders - unexpected error: %v", err)
+	}
+	b = standIn.lastRequest().body
+	if b["side"] != "Sell" || b["triggerPrice"] != "58000" || b["triggerDirection"] != float64(2) || b["triggerBy"] != "LastPrice" || b["reduceOnly"] != true || b["orderLinkId"] != nil {
+		t.Errorf("TestBybitPlaceOrders - unexpected stop-loss order %v", b)
+	}
+
+	// Stop-loss of the short position
+	if _, err = rest.PlaceStopLossOrder("ETH-PERP", order.SHORT, decimal.NewFromInt(4200), decimal.NewFromInt(1)); err != nil {
+		t.Fatalf("TestBybitPlaceOrders - unexpected error: %v", err)
+	}
+	b = standIn.lastRequest().body
+	if b["symbol"] != "ETHUSDT" || b["side"] != "Buy" || b["triggerDirection"] != float64(1) {
+		t.Errorf("TestBybitPlaceOrders - unexpected stop-loss order %v", b)
+	}
+
+	if err = rest.ClosePosition("ETH-PERP", order.SHORT, decimal.NewFromInt(1), ""); err != nil {
+		t.Fatalf("TestBybitPlaceOrders - unexpected error: %v", err)
+	}
+	b = standIn.lastRequest().body
+	if b["symbol"] != "ETHUSDT" || b["side"] != "Buy" || b["reduceOnly"] != true {
+		t.Errorf("TestBybitPlaceOrders - unexpected closing order %v", b)
+	}
+
+	// Order placed is known, it's cancelled without looking up open orders
+	n := len(standIn.requests)
+	if err = rest.CancelOpenTriggerOrder(orderId); err != nil {
+		t.Fatalf("TestBybitPlaceOrders - unexpected error: %v", err)
+	}
+	r := standIn.lastRequest()
+	if len(standIn.requests) != n+1 || r.path != "/v5/order/cancel" || r.body["symbol"] != "ETHUSDT" || r.body["orderId"] != "1b7c4f1e-5d3a-4a61-9c1b-7f2e6c0b8d21" {
+		t.Errorf("TestBybitPlaceOrders - unexpected cancel %v", r)
+	}
+}
+
+func TestBybitCancelOpenTriggerOrder(t *testing.T) {
+	rest, standIn := newBybitTestRest(t, map[string]string{
+		"GET /v5/order/realtime": "order_realtime",
+		"POST /v5/order/cancel":  "order_cancel_not_exists",
+	})
+	stopLossId := bybitOrderId("9e0d2a35-1c4b-4f0e-8a7d-3b5c6d7e8f90")
+
+	// Order is looked up from open orders
+	err := rest.CancelOpenTriggerOrder(stopLossId)
+	if !errors.Is(err, exerr.ErrOrderAlreadyClosed) {
+		t.Errorf("TestBybitCancelOpenTriggerOrder - expect '%v', but got '%v'", exerr.ErrOrderAlreadyClosed, err)
+	}
+	if r := standIn.lastRequest(); r.body["symbol"] != "ETHUSDT" || r.body["orderId"] != "9e0d2a35-1c4b-4f0e-8a7d-3b5c6d7e8f90" {
+		t.Errorf("TestBybitCancelOpenTriggerOrder - unexpected cancel %v", r)
+	}
+
+	// The order isn't open
+	err = rest.CancelOpenTriggerOrder(1)
+	if !errors.Is(err, exerr.ErrOrderAlreadyClosed) || !exerr.IsPermanent(err) {
+		t.Errorf("TestBybitCancelOpenTriggerOrder - expect '%v', but got '%v'", exerr.ErrOrderAlreadyClosed, err)
+	}
+
+	// Limit order isn't a stop-loss order
+	orders, err := rest.GetOpenStopLossOrders()
+	if err != nil {
+		t.Fatalf("TestBybitCancelOpenTriggerOrder - unexpected error: %v", err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("TestBybitCancelOpenTriggerOrder - expect 1 stop-loss order, but got %v", orders)
+	}
+	o := orders[stopLossId]
+	if o["order_id"] != float64(stopLossId) || o["symbol"] != "ETH-PERP" || o["side"] != float64(order.SHORT) || o["trigger_price"] != "4200" || o["size"] != "1" {
+		t.Errorf("TestBybitCancelOpenTriggerOrder - unexpected stop-loss order %v", o)
+	}
+
+	existed, err := rest.StopLostOrderExists("ETH-PERP", stopLossId)
+	if err != nil || !existed {
+		t.Errorf("TestBybitCancelOpenTriggerOrder - expect order to exist, err: %v", err)
+	}
+	existed, err = rest.StopLostOrderExists("ETH-PERP", 1)
+	if err != nil || existed {
+		t.Errorf("TestBybitCancelOpenTriggerOrder - expect order not to exist, err: %v", err)
+	}
+}
+
+func TestBybitGetPosition(t *testing.T) {
+	rest, standIn := newBybitTestRest(t, map[string]string{
+		"GET /v5/position/list": "position_list",
+	})
+
+	p, err := rest.GetPosition("BTC-PERP")
+	if err != nil {
+		t.Fatalf("TestBybitGetPosition - unexpected error: %v", err)
+	}
+	if p["symbol"] != "BTC-PERP" || p["side"] != float64(order.SHORT) || p["size"] != "0.01" || p["cost"] != "600" || p["entry_price"] != "60000" {
+		t.Errorf("TestBybitGetPosition - unexpected position %v", p)
+	}
+	if r := standIn.lastRequest(); r.query != "category=linear&symbol=BTCUSDT" {
+		t.Errorf("TestBybitGetPosition - unexpected query '%s'", r.query)
+	}
+
+	positions, err := rest.GetPositions()
+	if err != nil || len(positions) != 1 || positions["BTC-PERP"] == nil {
+		t.Errorf("TestBybitGetPosition - unexpected positions %v, err: %v", positions, err)
+	}
+}
+
+func TestBybitPagination(t *testing.T) {
+	rest, standIn := newBybitTestRest(t, map[string]string{
+		"GET /v5/position/list":                              "position_list_page_1",
+		"GET /v5/position/list?cursor=ETHUSDT:1697529700118": "position_list_page_2",
+		"GET /v5/order/realtime":                             "order_realtime_page_1",
+		"GET /v5/order/realtime?cursor=4a5b6c7d-8e9f-4011-a2b3-c4d5e6f70819:1697529790118": "order_realtime_page_2",
+	})
+
+	positions, err := rest.GetPositions()
+	if err != nil || len(positions) != 2 || positions["BTC-PERP"] == nil || positions["ETH-PERP"]["size"] != "0.5" {
+		t.Errorf("TestBybitPagination - unexpected positions %v, err: %v", positions, err)
+	}
+	if r := standIn.lastRequest(); r.query != "category=linear&cursor=ETHUSDT%3A1697529700118&limit=200&settleCoin=USDT" {
+		t.Errorf("TestBybitPagination - unexpected query '%s'", r.query)
+	}
+
+	orders, err := rest.GetOpenStopLossOrders()
+	if err != nil || len(orders) != 2 || orders[bybitOrderId("1b2c3d4e-5f60-4718-8293-a4b5c6d7e8f9")]["symbol"] != "BTC-PERP" {
+		t.Errorf("TestBybitPagination - unexpected stop-loss orders %v, err: %v", orders, err)
+	}
+}
+
+func TestBybitGetOrderByClientId(t *testing.T) {
+	rest, standIn := newBybitTestRest(t, map[string]string{
+		"GET /v5/order/history": "order_history_empty",
+	})
+
+	_, found, err := rest.GetOrderByClientId("BTC-PERP", "3f1c2e7a9b0d4c5e8f6a7b1c-2")
+	if err != nil || found {
+		t.Errorf("TestBybitGetOrderByClientId - unexpected found: %t, err: %v", found, err)
+	}
+
+	standIn.setFixture("GET /v5/order/history", "order_history")
+	o, found, err := rest.GetOrderByClientId("BTC-PERP", "3f1c2e7a9b0d4c5e8f6a7b1c-2")
+	if err != nil || !found || o["size"] != "0.01" || o["price"] != "60012.1" || o["status"] != "Filled" || o["order_id"] != float64(bybitOrderId("6f7e8d9c-0b1a-4c2d-9e3f-a4b5c6d7e8f9")) {
+		t.Errorf("TestBybitGetOrderByClientId - unexpected order %v, found: %t, err: %v", o, found, err)
+	}
+	if r := standIn.lastRequest(); r.query != "category=linear&orderLinkId=3f1c2e7a9b0d4c5e8f6a7b1c-2" {
+		t.Errorf("TestBybitGetOrderByClientId - unexpected query '%s'", r.query)
+	}
+}
+
+func TestBybitErrors(t *testing.T) {
+	rest, _ := newBybitTestRest(t, map[string]string{
+		"POST /v5/order/create":          "order_create_insufficient",
+		"GET /v5/account/wallet-balance": "wallet_balance",
+	})
+
+	_, err := rest.PlaceEntryOrder("BTC-PERP", order.LONG, decimal.NewFromInt(1), "")
+	if !errors.Is(err, exerr.ErrInsufficientMargin) || !exerr.IsPermanent(err) {
+		t.Errorf("TestBybitErrors - expect '%v', but got '%v'", exerr.ErrInsufficientMargin, err)
+	}
+
+	// Endpoint isn't recorded
+	if _, err = rest.GetPositions(); err == nil {
+		t.Errorf("TestBybitErrors - expect error, but got nil")
+	}
+
+	info, err := rest.GetAccountInfo()
+	if err != nil || !info["collateral"].(decimal.Decimal).Equal(decimal.RequireFromString("1021.3381")) || !info["free_collateral"].(decimal.Decimal).Equal(decimal.RequireFromString("1004.1623")) {
+		t.Errorf("TestBybitErrors - unexpected account info %v, err: %v", info, err)
+	}
+	balance, err := rest.GetBalance("USDT")
+	if err != nil || !balance.Equal(decimal.RequireFromString("1000.1455")) {
+		t.Errorf("TestBybitErrors - unexpected balance %s, err: %v", balance, err)
+	}
+}
@@ -1,12 +1,22 @@
 package rest
 
 import (
+	"bytes"
+	"context"
+	"crypto-trading-bot-engine/exchange/exerr"
 	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/util/retry"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/grishinsana/goftx"
@@ -14,8 +24,18 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+const (
+	FTX_API_URL = "https://ftx.com/api"
+)
+
 type FtxRest struct {
 	client *goftx.Client
+
+	// For the endpoints that goftx doesn't support e.g. wallet balances
+	httpClient *http.Client
+	key        string
+	secret     string
+	subaccount string
 }
 
 func NewFtxRest() *FtxRest {
@@ -31,11 +51,15 @@ func (rest *FtxRest) NewClient(data map[string]interface{}) (err error) {
 	secret := data["api_secret"].(string)
 	subaccount := data["subaccount"].(string)
 
+	rest.httpClient = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	rest.key = key
+	rest.secret = secret
+	rest.subaccount = subaccount
 	rest.client = goftx.New(
 		goftx.WithAuth(key, secret, subaccount),
-		goftx.WithHTTPClient(&http.Client{
-			Timeout: 5 * time.Second,
-		}),
+		goftx.WithHTTPClient(rest.httpClient),
 	)
 	return
 }
@@ -72,19 +96,12 @@ func (rest *FtxRest) GetAccountInfo() (map[string]interface{}, error) {
 	return r, nil
 }
 
-func (rest *FtxRest) PlaceEntryOrder(symbol string, side order.Side, size decimal.Decimal) (int64, error) {
-	order, err := rest.client.Orders.PlaceOrder(&models.PlaceOrderPayload{
-		Market: symbol,
-		Side:   rest.translateSide(side),
-		Type:   models.MarketOrder,
-		Size:   size,
-	})
-	if err != nil {
-		return 0, err
-	}
-	return order.ID, err
+// NOTE FTX rejects the client order id that has been used, so the order can't be placed twice
+func (rest *FtxRest) PlaceEntryOrder(symbol string, side order.Side, size decimal.Decimal, clientId string) (int64, error) {
+	return rest.placeMarketOrder(symbol, rest.translateSide(side), size, false, clientId)
 }
 
+// NOTE FTX trigger orders don't support client order id
 func (rest *FtxRest) PlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal) (int64, error) {
 	reduceOnly := true
 	retryUntilFilled := true
@@ -99,26 +116,21 @@ func (rest *FtxRest) PlaceStopLossOrder(symbol string, side order.Side, price de
 		TriggerPrice:     &triggerPrice,
 	})
 	if err != nil {
-		return 0, err
+		return 0, exerr.Classify(err)
 	}
 	return order.ID, nil
 }
 
-// retry: retry times
-// interval: sleep seconds
-func (rest *FtxRest) RetryPlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, retry int64, interval int64) (orderId int64, err error) {
-	for i := int64(0); i <= retry; i++ {
-		orderId, err = rest.PlaceStopLossOrder(symbol, side, price, size)
-		if err != nil {
-			if rest.ignoreError(err) {
-				break
-			}
-			log.Println("RetryPlaceStopLossOrder err:", err)
-			time.Sleep(time.Second * time.Duration(interval))
-			continue
-		}
-		break
-	}
+// Known errors e.g. size too small aren't retried
+func (rest *FtxRest) RetryPlaceStopLossOrder(symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (int64, error) {
+	return rest.RetryPlaceStopLossOrderContext(context.Background(), symbol, side, price, size, p)
+}
+
+func (rest *FtxRest) RetryPlaceStopLossOrderContext(ctx context.Context, symbol string, side order.Side, price decimal.Decimal, size decimal.Decimal, p retry.Policy) (orderId int64, err error) {
+	err = retry.Do(ctx, p, func(attempt int) (e error) {
+		orderId, e = rest.PlaceStopLossOrder(symbol, side, price, size)
+		return retryable("RetryPlaceStopLossOrder", attempt, e)
+	})
 	return
 }
 
@@ -172,31 +184,51 @@ func (rest *FtxRest) GetPosition(symbol string) (map[string]interface{}, error)
 	return p, fmt.Errorf("failed to get %s position", symbol)
 }
 
-func (rest *FtxRest) RetryGetPosition(symbol string, retry int64, interval int64) (p map[string]interface{}, err error) {
-	for i := int64(0); i <= retry; i++ {
-		p, err = rest.GetPosition(symbol)
-		if err != nil {
-			if strings.Contains(err.Error(), "wrong side") {
-				break
-			}
-			log.Println("RetryGetPosition err:", err)
-			time.Sleep(time.Second * time.Duration(interval))
+// Open positions by symbol, see GetPosition for the format
+// NOTE FTX keeps the futures traded before with size 0, they are skipped
+func (rest *FtxRest) GetPositions() (map[string]map[string]interface{}, error) {
+	r := make(map[string]map[string]interface{})
+	positions, err := rest.client.Account.GetPositions()
+	if err != nil {
+		return r, err
+	}
+	for _, position := range positions {
+		if position.Size.IsZero() {
 			continue
 		}
-		break
+		p := map[string]interface{}{
+			"cost":        position.Cost.Abs().String(),
+			"entry_price": position.EntryPrice.String(),
+			"size":        position.Size.String(),
+			"symbol":      position.Future,
+		}
+		switch models.Side(position.Side) {
+		case models.Buy:
+			p["side"] = float64(order.LONG)
+		case models.Sell:
+			p["side"] = float64(order.SHORT)
+		default:
+			return r, fmt.Errorf("side '%s' not supported", position.Side)
+		}
+		r[position.Future] = p
 	}
-	return
+	return r, nil
 }
 
-func (rest *FtxRest) ClosePosition(symbol string, side order.Side, size decimal.Decimal) error {
-	reduceOnly := true
-	_, err := rest.client.Orders.PlaceOrder(&models.PlaceOrderPayload{
-		Market:     symbol,
-		Side:       rest.translateAndFlipSide(side),
-		Type:       models.MarketOrder,
-		Size:       size,
-		ReduceOnly: &reduceOnly,
+func (rest *FtxRest) RetryGetPosition(symbol string, p retry.Policy) (map[string]interface{}, error) {
+	return rest.RetryGetPositionContext(context.Background(), symbol, p)
+}
+
+func (rest *FtxRest) RetryGetPositionContext(ctx context.Context, symbol string, p retry.Policy) (position map[string]interface{}, err error) {
+	err = retry.Do(ctx, p, func(attempt int) (e error) {
+		position, e = rest.GetPosition(symbol)
+		return retryable("RetryGetPosition", attempt, e)
 	})
+	return
+}
+
+func (rest *FtxRest) ClosePosition(symbol string, side order.Side, size decimal.Decimal, clientId string) error {
+	_, err := rest.placeMarketOrder(symbol, rest.translateAndFlipSide(side), size, true, clientId)
 	return err
 }
 
@@ -204,7 +236,7 @@ func (rest *FtxRest) ClosePosition(symbol string, side order.Side, size decimal.
 // func (rest *FtxRest) RetryClosePosition(symbol string, side order.Side, size decimal.Decimal, retry int64, interval int64) (err error) {
 //	for i := int64(0); i <= retry; i++ {
 //		if err = rest.ClosePosition(symbol, side, size); err != nil {
-//			if rest.ignoreError(err) {
+//			if exerr.IsPermanent(err) {
 //				break
 //			}
 //			log.Printf("RetryClosePosition err: %v", err)
@@ -217,23 +249,18 @@ func (rest *FtxRest) ClosePosition(symbol string, side order.Side, size decimal.
 // }
 
 func (rest *FtxRest) CancelOpenTriggerOrder(orderId int64) error {
-	return rest.client.Orders.CancelOpenTriggerOrder(orderId)
+	return exerr.Classify(rest.client.Orders.CancelOpenTriggerOrder(orderId))
 }
 
-func (rest *FtxRest) RetryCancelOpenTriggerOrder(orderId int64, retry int64, interval int64) (err error) {
-	for i := int64(0); i <= retry; i++ {
-		err = rest.client.Orders.CancelOpenTriggerOrder(orderId)
-		if err != nil {
-			if rest.ignoreError(err) {
-				break
-			}
-			log.Println("RetryCancelOpenTriggerOrder err:", err)
-			time.Sleep(time.Second * time.Duration(interval))
-			continue
-		}
-		break
-	}
-	return
+// Known errors e.g. order already closed aren't retried
+func (rest *FtxRest) RetryCancelOpenTriggerOrder(orderId int64, p retry.Policy) error {
+	return rest.RetryCancelOpenTriggerOrderContext(context.Background(), orderId, p)
+}
+
+func (rest *FtxRest) RetryCancelOpenTriggerOrderContext(ctx context.Context, orderId int64, p retry.Policy) error {
+	return retry.Do(ctx, p, func(attempt int) error {
+		return retryable("RetryCancelOpenTriggerOrder", attempt, rest.CancelOpenTriggerOrder(orderId))
+	})
 }
 
 func (rest *FtxRest) StopLostOrderExists(symbol string, orderId int64) (bool, error) {
@@ -279,6 +306,190 @@ func (rest *FtxRest) StopLostOrderExists(symbol string, orderId int64) (bool, er
 	return false, nil
 }
 
+// Open stop-loss trigger orders of all symbols by order id
+// NOTE side is the side of the position that the order closes, the same as PlaceStopLossOrder
+func (rest *FtxRest) GetOpenStopLossOrders() (map[int64]map[string]interface{}, error) {
+	r := make(map[int64]map[string]interface{})
+	triggerType := models.Stop
+	orders, err := rest.client.Orders.GetOpenTriggerOrders(&models.GetOpenTriggerOrdersParams{
+		Type: &triggerType,
+	})
+	if err != nil {
+		return r, err
+	}
+	for _, o := range orders {
+		side := order.LONG
+		if o.Side == models.Buy {
+			side = order.SHORT
+		}
+		r[o.ID] = map[string]interface{}{
+			"order_id":      float64(o.ID),
+			"symbol":        o.Market,
+			"side":          float64(side),
+			"trigger_price": o.TriggerPrice.String(),
+			"size":          o.Size.String(),
+		}
+	}
+	return r, nil
+}
+
+// order: {
+//	   "id": 9596912,
+//	   "clientId": "3f1c2e7a9b0d4c5e8f6a7b1c-12",
+//	   "market": "BTC-PERP",
+//	   "type": "market",
+//	   "side": "buy",
+//	   "size": 0.0001,
+//	   "status": "closed",		// new, open, closed
+//	   "filledSize": 0.0001,
+//	   "avgFillPrice": 61234,	// null if it hasn't been filled
+//	   "reduceOnly": false,
+//	   ...
+// }
+// NOTE goftx takes int64 as client order id, call it directly
+func (rest *FtxRest) GetOrderByClientId(symbol string, clientId string) (map[string]interface{}, bool, error) {
+	r := make(map[string]interface{})
+	resp, err := rest.privateRequest(http.MethodGet, "/orders/by_client_id/"+url.PathEscape(clientId), nil)
+	if err != nil {
+		if errors.Is(err, exerr.ErrOrderNotFound) {
+			return r, false, nil
+		}
+		return r, false, err
+	}
+
+	var o ftxOrder
+	if err = json.Unmarshal(resp, &o); err != nil {
+		return r, false, err
+	}
+	r["order_id"] = float64(o.ID)
+	r["status"] = o.Status
+	r["size"] = o.FilledSize.String()
+	r["price"] = o.AvgFillPrice.String()
+	return r, true, nil
+}
+
+// Get free balance of the coin in the wallet e.g. 'USD', 'BTC'
+// NOTE goftx doesn't support '/wallet/balances', call it directly
+func (rest *FtxRest) GetBalance(coin string) (decimal.Decimal, error) {
+	resp, err := rest.privateRequest(http.MethodGet, "/wallet/balances", nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	var balances []struct {
+		Coin  string          `json:"coin"`
+		Free  decimal.Decimal `json:"free"`
+		Total decimal.Decimal `json:"total"`
+	}
+	if err = json.Unmarshal(resp, &balances); err != nil {
+		return decimal.Zero, err
+	}
+	for _, b := range balances {
+		if b.Coin == coin {
+			return b.Free, nil
+		}
+	}
+
+	// The coin won't be listed if it has never been held
+	return decimal.Zero, nil
+}
+
+// Spot market order, side LONG means buy and SHORT means sell
+func (rest *FtxRest) PlaceSpotMarketOrder(symbol string, side order.Side, size decimal.Decimal) (int64, error) {
+	order, err := rest.client.Orders.PlaceOrder(&models.PlaceOrderPayload{
+		Market: symbol,
+		Side:   rest.translateSide(side),
+		Type:   models.MarketOrder,
+		Size:   size,
+	})
+	if err != nil {
+		return 0, exerr.Classify(err)
+	}
+	return order.ID, err
+}
+
+// Order of the endpoints called directly
+type ftxOrder struct {
+	ID           int64           `json:"id"`
+	ClientId     string          `json:"clientId"`
+	Status       string          `json:"status"`
+	FilledSize   decimal.Decimal `json:"filledSize"`
+	AvgFillPrice decimal.Decimal `json:"avgFillPrice"`
+}
+
+// NOTE goftx sends client order id as 'clienId', so the order is placed directly
+func (rest *FtxRest) placeMarketOrder(symbol string, side models.Side, size decimal.Decimal, reduceOnly bool, clientId string) (int64, error) {
+	payload := map[string]interface{}{
+		"market":     symbol,
+		"side":       side,
+		"price":      nil,
+		"type":       models.MarketOrder,
+		"size":       size,
+		"reduceOnly": reduceOnly,
+	}
+	if clientId != "" {
+		payload["clientId"] = clientId
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := rest.privateRequest(http.MethodPost, "/orders", body)
+	if err != nil {
+		return 0, err
+	}
+
+	var o ftxOrder
+	if err = json.Unmarshal(resp, &o); err != nil {
+		return 0, err
+	}
+	return o.ID, nil
+}
+
+// Signed request for the endpoints that goftx doesn't support, returns 'result' of the response
+func (rest *FtxRest) privateRequest(method string, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, FTX_API_URL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	payload := ts + method + "/api" + path + string(body)
+	mac := hmac.New(sha256.New, []byte(rest.secret))
+	mac.Write([]byte(payload))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("FTX-KEY", rest.key)
+	req.Header.Set("FTX-SIGN", hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("FTX-TS", ts)
+	if rest.subaccount != "" {
+		req.Header.Set("FTX-SUBACCOUNT", rest.subaccount)
+	}
+
+	resp, err := rest.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Success bool            `json:"success"`
+		Error   string          `json:"error"`
+		Result  json.RawMessage `json:"result"`
+	}
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	if !r.Success {
+		return nil, exerr.Classify(fmt.Errorf("Status Code: %d	Error: %s", resp.StatusCode, r.Error))
+	}
+	return r.Result, nil
+}
+
 func (rest *FtxRest) translateSide(s order.Side) models.Side {
 	switch s {
 	case order.LONG:
@@ -299,33 +510,20 @@ func (rest *FtxRest) translateAndFlipSide(s order.Side) models.Side {
 	return ""
 }
 
-func (rest *FtxRest) ignoreError(err error) bool {
-	// Scenario: try to place an order with small size for a symbol
-	// Reproduction: spend below $5 dollars
-	if strings.Contains(err.Error(), "Size too small") {
-		return true
+// Stop retrying on the known errors, e.g.
+// - Size too small: spend below $5 dollars
+// - Order already closed: the stop-loss order has been closed manually on app
+// - Account does not have enough margin for order: place an order with money more than you have
+// NOTE 'Invalid reduce-only order' is retried, it could be thrown for unknown reasons
+func retryable(name string, attempt int, err error) error {
+	if err == nil {
+		return nil
 	}
-
-	// Scenario: try to get an open position that has been closed already
-	// Reproduction: get entry_order triggered, then sleep 30s, close position manually on app during this 30s, then get position
-	// NOTE: update: found that it could be thrown for other unknow reason
-	// if strings.Contains(err.Error(), "Invalid reduce-only order") {
-	//	return true
-	// }
-
-	// Scenario: try to get an open trigger order that has been closed
-	// Reproduction: get stop-loss order created, then sleep 30s, close order manually on app during this 30s, then get trigger order
-	if strings.Contains(err.Error(), "Order already closed") {
-		return true
+	if exerr.IsPermanent(err) {
+		return retry.Permanent(err)
 	}
-
-	// Scenario: try to place an order with insufficient margin
-	// Reproduction: place an order with money more than you have
-	if strings.Contains(err.Error(), "Account does not have enough margin for order") {
-		return true
-	}
-
-	return false
+	log.Printf("%s attempt: %d, err: %v", name, attempt, err)
+	return err
 }
 
 // NOTE This function can't be used to check position status, it only returns the same data when it is created
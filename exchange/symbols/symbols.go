@@ -0,0 +1,58 @@
+package symbols
+
+import (
+	"sync"
+)
+
+// Names of symbols of strategies and the ones on the exchange e.g. 'BTC-PERP' and 'BTCUSDT' of Binance
+// NOTE The name is used as it is if it isn't mapped, nil Map maps nothing
+type Map struct {
+	mu           sync.RWMutex
+	toExchange   map[string]string
+	fromExchange map[string]string
+}
+
+func NewMap(m map[string]string) *Map {
+	sm := &Map{}
+	sm.Set(m)
+	return sm
+}
+
+// Replace all names, key is the name of strategies, value is the name on the exchange
+func (sm *Map) Set(m map[string]string) {
+	toExchange := make(map[string]string, len(m))
+	fromExchange := make(map[string]string, len(m))
+	for name, exName := range m {
+		toExchange[name] = exName
+		fromExchange[exName] = name
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.toExchange = toExchange
+	sm.fromExchange = fromExchange
+}
+
+func (sm *Map) ToExchange(name string) string {
+	if sm == nil {
+		return name
+	}
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if exName, ok := sm.toExchange[name]; ok {
+		return exName
+	}
+	return name
+}
+
+func (sm *Map) FromExchange(exName string) string {
+	if sm == nil {
+		return exName
+	}
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if name, ok := sm.fromExchange[exName]; ok {
+		return name
+	}
+	return exName
+}
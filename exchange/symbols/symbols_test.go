@@ -0,0 +1,27 @@
+package symbols
+
+import (
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	sm := NewMap(map[string]string{"BTC-PERP": "BTCUSDT", "ETH-PERP": "ETHUSDT"})
+	if sm.ToExchange("BTC-PERP") != "BTCUSDT" || sm.FromExchange("ETHUSDT") != "ETH-PERP" {
+		t.Errorf("TestMap - unexpected names '%s', '%s'", sm.ToExchange("BTC-PERP"), sm.FromExchange("ETHUSDT"))
+	}
+
+	// The name isn't mapped
+	if sm.ToExchange("SOLUSDT") != "SOLUSDT" || sm.FromExchange("SOLUSDT") != "SOLUSDT" {
+		t.Error("TestMap - expect the name not mapped to be used as it is")
+	}
+
+	sm.Set(map[string]string{"SOL-PERP": "SOLUSDT"})
+	if sm.ToExchange("BTC-PERP") != "BTC-PERP" || sm.FromExchange("SOLUSDT") != "SOL-PERP" {
+		t.Error("TestMap - expect names to be replaced")
+	}
+
+	var nilMap *Map
+	if nilMap.ToExchange("BTC-PERP") != "BTC-PERP" || nilMap.FromExchange("BTCUSDT") != "BTCUSDT" {
+		t.Error("TestMap - expect nil map to map nothing")
+	}
+}
@@ -0,0 +1,209 @@
+package ws
+
+import (
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/contract"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	BINANCE_FUTURES_WS_URL = "wss://fstream.binance.com/ws"
+
+	// Streams of marks
+	BINANCE_STREAM_AGG_TRADE  = "aggTrade"
+	BINANCE_STREAM_MARK_PRICE = "markPrice@1s"
+)
+
+// Binance USDⓈ-M futures, symbols are subscribed and unsubscribed on the connection opened
+// NOTE A connection can't have more than 200 streams and is disconnected by Binance every 24 hours
+type BinanceWs struct {
+	broadcastMark func(string, contract.Mark)
+	stopCh        chan bool
+	stopAll       func()
+	subs          *subscriptions
+
+	// Symbols of strategies and Binance e.g. 'BTC-PERP' and 'BTCUSDT'
+	symbols *symbols.Map
+
+	url    string
+	stream string
+
+	// id of the request of subscribe and unsubscribe
+	requestId int64
+}
+
+func NewBinanceWs(stream string) *BinanceWs {
+	if stream == "" {
+		stream = BINANCE_STREAM_AGG_TRADE
+	}
+	return &BinanceWs{
+		subs:   newSubscriptions(),
+		url:    BINANCE_FUTURES_WS_URL,
+		stream: stream,
+	}
+}
+
+func (ws *BinanceWs) SetBroadcastMarkFunc(f func(string, contract.Mark)) {
+	ws.broadcastMark = f
+}
+
+func (ws *BinanceWs) SetStopCh(ch chan bool) {
+	ws.stopCh = ch
+}
+
+func (ws *BinanceWs) SetStopAllFunc(f func()) {
+	ws.stopAll = f
+}
+
+func (ws *BinanceWs) SetSymbolMap(m *symbols.Map) {
+	ws.symbols = m
+}
+
+func (ws *BinanceWs) Subscribe(symbol string) error {
+	ws.subs.add(symbol)
+	return nil
+}
+
+func (ws *BinanceWs) Unsubscribe(symbol string) error {
+	ws.subs.remove(symbol)
+	return nil
+}
+
+// symbols are subscribed in addition to the ones of Subscribe
+func (ws *BinanceWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bool, err error) {
+	for _, symbol := range symbols {
+		ws.subs.add(symbol)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(ws.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Read messages until the connection is closed
+	msgCh := make(chan []byte)
+	errCh := make(chan error, 1)
+	doneCh := make(chan bool)
+	defer close(doneCh)
+	go readMessages(conn, msgCh, errCh, doneCh)
+
+	// Streams subscribed on the connection
+	streams := make(map[string]bool)
+	if err = ws.syncStreams(conn, streams, debug); err != nil {
+		return false, err
+	}
+
+	// Filter incoming response by last timestamp
+	var lastTradeTs sync.Map
+
+	for {
+		select {
+		case msg := <-msgCh:
+			symbol, mark, ok, err := ws.parse(msg)
+			if err != nil {
+				return false, fmt.Errorf("failed to parse '%s', err: %v", string(msg), err)
+			}
+			// Responses of subscribe and unsubscribe, and unsubscribed symbols
+			if !ok || !ws.subs.has(symbol) {
+				break
+			}
+			if ignoreResp(&lastTradeTs, symbol) {
+				break
+			}
+			ws.broadcastMark(symbol, mark)
+		case err := <-errCh:
+			return false, err
+		case <-ws.subs.changedCh:
+			if err = ws.syncStreams(conn, streams, debug); err != nil {
+				return false, err
+			}
+		case <-ws.stopCh:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			ws.stopAll()
+			return true, nil
+		}
+	}
+}
+
+// Subscribe and unsubscribe the streams of the connection to match the symbols subscribed
+func (ws *BinanceWs) syncStreams(conn *websocket.Conn, streams map[string]bool, debug bool) error {
+	expected := make(map[string]bool)
+	for _, symbol := range ws.subs.list() {
+		expected[ws.streamName(symbol)] = true
+	}
+
+	subscribe, unsubscribe := diffTopics(expected, streams)
+	if err := ws.request(conn, "SUBSCRIBE", subscribe, debug); err != nil {
+		return err
+	}
+	if err := ws.request(conn, "UNSUBSCRIBE", unsubscribe, debug); err != nil {
+		return err
+	}
+	for _, stream := range subscribe {
+		streams[stream] = true
+	}
+	for _, stream := range unsubscribe {
+		delete(streams, stream)
+	}
+	return nil
+}
+
+func (ws *BinanceWs) request(conn *websocket.Conn, method string, streams []string, debug bool) error {
+	if len(streams) == 0 {
+		return nil
+	}
+	ws.requestId++
+	if debug {
+		log.Printf("[binance] %s %v", method, streams)
+	}
+	return conn.WriteJSON(map[string]interface{}{
+		"method": method,
+		"params": streams,
+		"id":     ws.requestId,
+	})
+}
+
+// e.g. 'btcusdt@aggTrade'
+func (ws *BinanceWs) streamName(symbol string) string {
+	return strings.ToLower(ws.symbols.ToExchange(symbol)) + "@" + ws.stream
+}
+
+// aggTrade: {"e":"aggTrade","E":123456789,"s":"BTCUSDT","a":5933014,"p":"0.001","q":"100","f":100,"l":105,"T":123456785,"m":true}
+// markPriceUpdate: {"e":"markPriceUpdate","E":1562305380000,"s":"BTCUSDT","p":"11794.15000000","i":"11784.62659091","P":"11784.25641265","r":"0.00038167","T":1562306400000}
+type binanceEvent struct {
+	Event     string          `json:"e"`
+	EventTime int64           `json:"E"`
+	Symbol    string          `json:"s"`
+	Price     decimal.Decimal `json:"p"`
+	Time      int64           `json:"T"` // trade time of aggTrade, next funding time of markPriceUpdate
+
+	// NOTE Keys are matched case-insensitively, estimated settle price of markPriceUpdate would overwrite 'p' without it
+	SettlePrice decimal.Decimal `json:"P"`
+}
+
+// Return false if it isn't a mark e.g. the response of subscribe
+func (ws *BinanceWs) parse(msg []byte) (string, contract.Mark, bool, error) {
+	var e binanceEvent
+	if err := json.Unmarshal(msg, &e); err != nil {
+		return "", contract.Mark{}, false, err
+	}
+	mark := contract.Mark{Price: e.Price}
+	switch e.Event {
+	case "aggTrade":
+		mark.Time = time.UnixMilli(e.Time)
+	case "markPriceUpdate":
+		mark.Time = time.UnixMilli(e.EventTime)
+	default:
+		return "", mark, false, nil
+	}
+	return ws.symbols.FromExchange(e.Symbol), mark, true, nil
+}
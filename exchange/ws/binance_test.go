@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/contract"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type binanceRequest struct {
+	Method string   `json:"method"`
+	Params []string `json:"params"`
+	Id     int64    `json:"id"`
+}
+
+// Local stand-in of Binance USDⓈ-M ws, requests are sent to requestCh, messages of messageCh are sent to the client
+func newBinanceWsStandIn(t *testing.T, requestCh chan binanceRequest, messageCh chan string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("newBinanceWsStandIn - unexpected error: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// NOTE gorilla doesn't support concurrent writers
+		var mu sync.Mutex
+		go func() {
+			for msg := range messageCh {
+				mu.Lock()
+				err := conn.WriteMessage(websocket.TextMessage, []byte(msg))
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			var req binanceRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			requestCh <- req
+			mu.Lock()
+			conn.WriteJSON(map[string]interface{}{"result": nil, "id": req.Id})
+			mu.Unlock()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBinanceWs(t *testing.T) {
+	requestCh := make(chan binanceRequest, 10)
+	messageCh := make(chan string, 10)
+	server := newBinanceWsStandIn(t, requestCh, messageCh)
+
+	marks := make(chan RecordedMark, 10)
+	stopCh := make(chan bool)
+	stoppedAll := false
+	ws := NewBinanceWs("")
+	ws.url = "ws" + strings.TrimPrefix(server.URL, "http")
+	ws.SetSymbolMap(symbols.NewMap(map[string]string{"BTC-PERP": "BTCUSDT", "ETH-PERP": "ETHUSDT"}))
+	ws.SetStopCh(stopCh)
+	ws.SetStopAllFunc(func() { stoppedAll = true })
+	ws.SetBroadcastMarkFunc(func(symbol string, mark contract.Mark) {
+		marks <- RecordedMark{Symbol: symbol, Price: mark.Price, Time: mark.Time}
+	})
+	ws.Subscribe("BTC-PERP")
+
+	type result struct {
+		end bool
+		err error
+	}
+	resultCh := make(chan result)
+	go func() {
+		end, err := ws.ListenPublicTradesChannel(nil, false)
+		resultCh <- result{end, err}
+	}()
+
+	expectRequest := func(method string, params []string) {
+		select {
+		case req := <-requestCh:
+			sort.Strings(req.Params)
+			if req.Method != method || !reflect.DeepEqual(req.Params, params) {
+				t.Errorf("TestBinanceWs - expect %s %v, but got %s %v", method, params, req.Method, req.Params)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("TestBinanceWs - expect %s %v, but got nothing", method, params)
+		}
+	}
+	expectRequest("SUBSCRIBE", []string{"btcusdt@aggTrade"})
+
+	// Mark is broadcast by the symbol of strategies
+	messageCh <- `{"e":"aggTrade","E":1637402400100,"s":"BTCUSDT","a":5933014,"p":"60000.10","q":"0.5","f":100,"l":105,"T":1637402400000,"m":true}`
+	select {
+	case m := <-marks:
+		if m.Symbol != "BTC-PERP" || m.Price.String() != "60000.1" || !m.Time.Equal(time.UnixMilli(1637402400000)) {
+			t.Errorf("TestBinanceWs - unexpected mark %+v", m)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("TestBinanceWs - expect a mark, but got nothing")
+	}
+
+	// Subscribe and unsubscribe on the connection opened
+	ws.Subscribe("ETH-PERP")
+	expectRequest("SUBSCRIBE", []string{"ethusdt@aggTrade"})
+	ws.Unsubscribe("BTC-PERP")
+	expectRequest("UNSUBSCRIBE", []string{"btcusdt@aggTrade"})
+
+	// Marks of the symbol unsubscribed are dropped
+	messageCh <- `{"e":"aggTrade","E":1637402401100,"s":"BTCUSDT","p":"60001","T":1637402401000}`
+	messageCh <- `{"e":"aggTrade","E":1637402401200,"s":"ETHUSDT","p":"4000.5","T":1637402401100}`
+	select {
+	case m := <-marks:
+		if m.Symbol != "ETH-PERP" {
+			t.Errorf("TestBinanceWs - unexpected mark %+v", m)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("TestBinanceWs - expect a mark, but got nothing")
+	}
+
+	close(stopCh)
+	select {
+	case r := <-resultCh:
+		if !r.end || r.err != nil || !stoppedAll {
+			t.Errorf("TestBinanceWs - unexpected end: %t, stoppedAll: %t, err: %v", r.end, stoppedAll, r.err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("TestBinanceWs - expect it to be stopped")
+	}
+	close(messageCh)
+}
+
+func TestBinanceWsParse(t *testing.T) {
+	ws := NewBinanceWs(BINANCE_STREAM_MARK_PRICE)
+	ws.SetSymbolMap(symbols.NewMap(map[string]string{"BTC-PERP": "BTCUSDT"}))
+	if name := ws.streamName("BTC-PERP"); name != "btcusdt@markPrice@1s" {
+		t.Errorf("TestBinanceWsParse - unexpected stream '%s'", name)
+	}
+
+	symbol, mark, ok, err := ws.parse([]byte(`{"e":"markPriceUpdate","E":1562305380000,"s":"BTCUSDT","p":"11794.15000000","i":"11784.62659091","P":"11784.25641265","r":"0.00038167","T":1562306400000}`))
+	if err != nil || !ok || symbol != "BTC-PERP" || mark.Price.String() != "11794.15" || !mark.Time.Equal(time.UnixMilli(1562305380000)) {
+		t.Errorf("TestBinanceWsParse - unexpected symbol: '%s', mark: %+v, ok: %t, err: %v", symbol, mark, ok, err)
+	}
+
+	// Response of subscribe
+	if _, _, ok, err = ws.parse([]byte(`{"result":null,"id":1}`)); ok || err != nil {
+		t.Errorf("TestBinanceWsParse - expect response not to be a mark, err: %v", err)
+	}
+}
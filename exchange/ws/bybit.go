@@ -0,0 +1,248 @@
+package ws
+
+import (
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/contract"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	BYBIT_LINEAR_WS_URL = "wss://stream.bybit.com/v5/public/linear"
+
+	// NOTE Bybit closes the connection without any message for 10 minutes, 20 seconds is recommended
+	BYBIT_PING_INTERVAL = 20 * time.Second
+)
+
+// Bybit linear perpetuals, symbols are subscribed and unsubscribed on the connection opened
+type BybitWs struct {
+	broadcastMark func(string, contract.Mark)
+	stopCh        chan bool
+	stopAll       func()
+	subs          *subscriptions
+
+	// Symbols of strategies and Bybit e.g. 'BTC-PERP' and 'BTCUSDT'
+	symbols *symbols.Map
+
+	url          string
+	pingInterval time.Duration
+
+	// id of the request of subscribe and unsubscribe, and the topics of the requests waiting for the response
+	requestId int64
+	requests  map[string][]string
+}
+
+// Response of the op which failed e.g. subscribing the symbol which doesn't exist
+type bybitOpError struct {
+	op     string
+	reqId  string
+	retMsg string
+	topics []string
+}
+
+func (e *bybitOpError) Error() string {
+	return fmt.Sprintf("failed to %s, req_id: %s, %s", e.op, e.reqId, e.retMsg)
+}
+
+func NewBybitWs() *BybitWs {
+	return &BybitWs{
+		subs:         newSubscriptions(),
+		url:          BYBIT_LINEAR_WS_URL,
+		pingInterval: BYBIT_PING_INTERVAL,
+	}
+}
+
+func (ws *BybitWs) SetBroadcastMarkFunc(f func(string, contract.Mark)) {
+	ws.broadcastMark = f
+}
+
+func (ws *BybitWs) SetStopCh(ch chan bool) {
+	ws.stopCh = ch
+}
+
+func (ws *BybitWs) SetStopAllFunc(f func()) {
+	ws.stopAll = f
+}
+
+func (ws *BybitWs) SetSymbolMap(m *symbols.Map) {
+	ws.symbols = m
+}
+
+func (ws *BybitWs) Subscribe(symbol string) error {
+	ws.subs.add(symbol)
+	return nil
+}
+
+func (ws *BybitWs) Unsubscribe(symbol string) error {
+	ws.subs.remove(symbol)
+	return nil
+}
+
+// symbols are subscribed in addition to the ones of Subscribe
+func (ws *BybitWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bool, err error) {
+	for _, symbol := range symbols {
+		ws.subs.add(symbol)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(ws.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Read messages until the connection is closed
+	msgCh := make(chan []byte)
+	errCh := make(chan error, 1)
+	doneCh := make(chan bool)
+	defer close(doneCh)
+	go readMessages(conn, msgCh, errCh, doneCh)
+
+	// Topics subscribed on the connection
+	topics := make(map[string]bool)
+	ws.requests = make(map[string][]string)
+	if err = ws.syncTopics(conn, topics, debug); err != nil {
+		return false, err
+	}
+
+	ticker := time.NewTicker(ws.pingInterval)
+	defer ticker.Stop()
+
+	// Filter incoming response by last timestamp
+	var lastTradeTs sync.Map
+
+	for {
+		select {
+		case msg := <-msgCh:
+			symbol, mark, ok, err := ws.parse(msg)
+			var opErr *bybitOpError
+			if errors.As(err, &opErr) {
+				// Keep the connection for the other topics, the topics failed to subscribe are dropped
+				log.Printf("[bybit] %v", err)
+				if opErr.op == "subscribe" {
+					for _, topic := range opErr.topics {
+						delete(topics, topic)
+					}
+				}
+				break
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to parse '%s', err: %v", string(msg), err)
+			}
+			// Responses of ops, and unsubscribed symbols
+			if !ok || !ws.subs.has(symbol) {
+				break
+			}
+			if ignoreResp(&lastTradeTs, symbol) {
+				break
+			}
+			ws.broadcastMark(symbol, mark)
+		case err := <-errCh:
+			return false, err
+		case <-ticker.C:
+			if err = conn.WriteJSON(map[string]string{"op": "ping"}); err != nil {
+				return false, err
+			}
+		case <-ws.subs.changedCh:
+			if err = ws.syncTopics(conn, topics, debug); err != nil {
+				return false, err
+			}
+		case <-ws.stopCh:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			ws.stopAll()
+			return true, nil
+		}
+	}
+}
+
+// Subscribe and unsubscribe the topics of the connection to match the symbols subscribed
+func (ws *BybitWs) syncTopics(conn *websocket.Conn, topics map[string]bool, debug bool) error {
+	expected := make(map[string]bool)
+	for _, symbol := range ws.subs.list() {
+		expected[ws.topicName(symbol)] = true
+	}
+
+	subscribe, unsubscribe := diffTopics(expected, topics)
+	if err := ws.request(conn, "subscribe", subscribe, debug); err != nil {
+		return err
+	}
+	if err := ws.request(conn, "unsubscribe", unsubscribe, debug); err != nil {
+		return err
+	}
+	for _, topic := range subscribe {
+		topics[topic] = true
+	}
+	for _, topic := range unsubscribe {
+		delete(topics, topic)
+	}
+	return nil
+}
+
+func (ws *BybitWs) request(conn *websocket.Conn, op string, topics []string, debug bool) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	ws.requestId++
+	reqId := strconv.FormatInt(ws.requestId, 10)
+	if debug {
+		log.Printf("[bybit] %s %v", op, topics)
+	}
+	ws.requests[reqId] = topics
+	return conn.WriteJSON(map[string]interface{}{
+		"op":     op,
+		"args":   topics,
+		"req_id": reqId,
+	})
+}
+
+// e.g. 'publicTrade.BTCUSDT'
+func (ws *BybitWs) topicName(symbol string) string {
+	return "publicTrade." + ws.symbols.ToExchange(symbol)
+}
+
+// publicTrade: {"topic":"publicTrade.BTCUSDT","type":"snapshot","ts":1672304486868,"data":[{"T":1672304486865,"s":"BTCUSDT","S":"Buy","v":"0.001","p":"16578.50","L":"PlusTick","i":"20f43950-d8dd-5b31-9112-a178eb6023af","BT":false}]}
+// Response of op: {"success":true,"ret_msg":"subscribe","conn_id":"2324d924-aa4d-45b0-a858-7b8be29ab52b","req_id":"1","op":"subscribe"}
+type bybitMessage struct {
+	Topic   string `json:"topic"`
+	Op      string `json:"op"`
+	Success *bool  `json:"success"`
+	RetMsg  string `json:"ret_msg"`
+	ReqId   string `json:"req_id"`
+	Data    []struct {
+		Time   int64           `json:"T"`
+		Symbol string          `json:"s"`
+		Price  decimal.Decimal `json:"p"`
+
+		// NOTE Keys are matched case-insensitively, taker side would overwrite 's' without it
+		Side string `json:"S"`
+	} `json:"data"`
+}
+
+// Return false if it isn't a mark e.g. the response of subscribe
+// Trades of a message are in time order, the last one is the mark
+func (ws *BybitWs) parse(msg []byte) (string, contract.Mark, bool, error) {
+	var m bybitMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return "", contract.Mark{}, false, err
+	}
+	if m.Success != nil {
+		topics := ws.requests[m.ReqId]
+		delete(ws.requests, m.ReqId)
+		if !*m.Success {
+			return "", contract.Mark{}, false, &bybitOpError{op: m.Op, reqId: m.ReqId, retMsg: m.RetMsg, topics: topics}
+		}
+	}
+	if len(m.Data) == 0 {
+		return "", contract.Mark{}, false, nil
+	}
+	trade := m.Data[len(m.Data)-1]
+	mark := contract.Mark{Price: trade.Price, Time: time.UnixMilli(trade.Time)}
+	return ws.symbols.FromExchange(trade.Symbol), mark, true, nil
+}
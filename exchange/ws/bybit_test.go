@@ -0,0 +1,204 @@
+package ws
+
+import (
+	"crypto-trading-bot-engine/exchange/symbols"
+	"crypto-trading-bot-engine/strategy/contract"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type bybitRequest struct {
+	Op    string   `json:"op"`
+	Args  []string `json:"args"`
+	ReqId string   `json:"req_id"`
+}
+
+// Local stand-in of Bybit public ws, requests are sent to requestCh, messages of messageCh are sent to the client
+// NOTE Requests of 'XYZUSDT' aren't responded, the response is sent by the test through messageCh
+func newBybitWsStandIn(t *testing.T, requestCh chan bybitRequest, messageCh chan string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("newBybitWsStandIn - unexpected error: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// NOTE gorilla doesn't support concurrent writers
+		var mu sync.Mutex
+		go func() {
+			for msg := range messageCh {
+				mu.Lock()
+				err := conn.WriteMessage(websocket.TextMessage, []byte(msg))
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+		for {
+			var req bybitRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			requestCh <- req
+			if strings.Contains(strings.Join(req.Args, ","), "XYZUSDT") {
+				continue
+			}
+			mu.Lock()
+			conn.WriteJSON(map[string]interface{}{"success": true, "ret_msg": req.Op, "conn_id": "c6a5b4e3", "req_id": req.ReqId, "op": req.Op})
+			mu.Unlock()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBybitWs(t *testing.T) {
+	requestCh := make(chan bybitRequest, 10)
+	messageCh := make(chan string, 10)
+	server := newBybitWsStandIn(t, requestCh, messageCh)
+
+	marks := make(chan RecordedMark, 10)
+	stopCh := make(chan bool)
+	stoppedAll := false
+	ws := NewBybitWs()
+	ws.url = "ws" + strings.TrimPrefix(server.URL, "http")
+	ws.pingInterval = 100 * time.Millisecond
+	ws.SetSymbolMap(symbols.NewMap(map[string]string{"BTC-PERP": "BTCUSDT", "ETH-PERP": "ETHUSDT", "XYZ-PERP": "XYZUSDT"}))
+	ws.SetStopCh(stopCh)
+	ws.SetStopAllFunc(func() { stoppedAll = true })
+	ws.SetBroadcastMarkFunc(func(symbol string, mark contract.Mark) {
+		marks <- RecordedMark{Symbol: symbol, Price: mark.Price, Time: mark.Time}
+	})
+	ws.Subscribe("BTC-PERP")
+
+	type result struct {
+		end bool
+		err error
+	}
+	resultCh := make(chan result)
+	go func() {
+		end, err := ws.ListenPublicTradesChannel(nil, false)
+		resultCh <- result{end, err}
+	}()
+
+	// Pings are skipped
+	expectRequest := func(op string, args []string) bybitRequest {
+		timeout := time.After(time.Second * 3)
+		for {
+			select {
+			case req := <-requestCh:
+				if req.Op == "ping" {
+					continue
+				}
+				sort.Strings(req.Args)
+				if req.Op != op || !reflect.DeepEqual(req.Args, args) {
+					t.Errorf("TestBybitWs - expect %s %v, but got %s %v", op, args, req.Op, req.Args)
+				}
+				return req
+			case <-timeout:
+				t.Fatalf("TestBybitWs - expect %s %v, but got nothing", op, args)
+			}
+		}
+	}
+	expectMark := func(symbol string) {
+		select {
+		case m := <-marks:
+			if m.Symbol != symbol {
+				t.Errorf("TestBybitWs - expect mark of %s, but got %+v", symbol, m)
+			}
+		case r := <-resultCh:
+			t.Fatalf("TestBybitWs - expect mark of %s, but it ended with err: %v", symbol, r.err)
+		case <-time.After(time.Second * 3):
+			t.Fatalf("TestBybitWs - expect mark of %s, but got nothing", symbol)
+		}
+	}
+	expectRequest("subscribe", []string{"publicTrade.BTCUSDT"})
+
+	// The last trade of the message is broadcast by the symbol of strategies
+	messageCh <- `{"topic":"publicTrade.BTCUSDT","type":"snapshot","ts":1637402400101,"data":[{"T":1637402400000,"s":"BTCUSDT","S":"Buy","v":"0.001","p":"60000.10","L":"PlusTick","i":"20f43950-d8dd-5b31-9112-a178eb6023af","BT":false},{"T":1637402400050,"s":"BTCUSDT","S":"Sell","v":"0.002","p":"60000.20","L":"PlusTick","i":"20f43950-d8dd-5b31-9112-a178eb6023b0","BT":false}]}`
+	select {
+	case m := <-marks:
+		if m.Symbol != "BTC-PERP" || m.Price.String() != "60000.2" || !m.Time.Equal(time.UnixMilli(1637402400050)) {
+			t.Errorf("TestBybitWs - unexpected mark %+v", m)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("TestBybitWs - expect a mark, but got nothing")
+	}
+
+	// Subscribe and unsubscribe on the connection opened
+	ws.Subscribe("ETH-PERP")
+	expectRequest("subscribe", []string{"publicTrade.ETHUSDT"})
+	ws.Unsubscribe("BTC-PERP")
+	expectRequest("unsubscribe", []string{"publicTrade.BTCUSDT"})
+
+	// Ping is sent to keep the connection
+	select {
+	case req := <-requestCh:
+		if req.Op != "ping" {
+			t.Errorf("TestBybitWs - expect ping, but got %s", req.Op)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("TestBybitWs - expect ping, but got nothing")
+	}
+
+	// Marks of the symbol unsubscribed are dropped
+	messageCh <- `{"topic":"publicTrade.BTCUSDT","type":"snapshot","ts":1637402401101,"data":[{"T":1637402401000,"s":"BTCUSDT","S":"Buy","v":"0.001","p":"60001","L":"PlusTick","i":"20f43950-d8dd-5b31-9112-a178eb6023b1","BT":false}]}`
+	messageCh <- `{"topic":"publicTrade.ETHUSDT","type":"snapshot","ts":1637402401201,"data":[{"T":1637402401100,"s":"ETHUSDT","S":"Buy","v":"0.01","p":"4000.5","L":"PlusTick","i":"3a1e2b6c-11aa-5c7d-8e9f-0a1b2c3d4e5f","BT":false}]}`
+	expectMark("ETH-PERP")
+
+	// Failed subscribe doesn't close the connection, the topic is dropped and subscribed again on the next change
+	ws.Subscribe("XYZ-PERP")
+	req := expectRequest("subscribe", []string{"publicTrade.XYZUSDT"})
+	messageCh <- `{"success":false,"ret_msg":"error:handler not found,topic:publicTrade.XYZUSDT","conn_id":"c6a5b4e3","req_id":"` + req.ReqId + `","op":"subscribe"}`
+	// NOTE Marks of the same symbol within 200ms are dropped
+	time.Sleep(200 * time.Millisecond)
+	messageCh <- `{"topic":"publicTrade.ETHUSDT","type":"snapshot","ts":1637402402201,"data":[{"T":1637402402100,"s":"ETHUSDT","S":"Buy","v":"0.01","p":"4001","L":"PlusTick","i":"3a1e2b6c-11aa-5c7d-8e9f-0a1b2c3d4e60","BT":false}]}`
+	expectMark("ETH-PERP")
+	ws.Subscribe("BTC-PERP")
+	expectRequest("subscribe", []string{"publicTrade.BTCUSDT", "publicTrade.XYZUSDT"})
+
+	close(stopCh)
+	select {
+	case r := <-resultCh:
+		if !r.end || r.err != nil || !stoppedAll {
+			t.Errorf("TestBybitWs - unexpected end: %t, stoppedAll: %t, err: %v", r.end, stoppedAll, r.err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("TestBybitWs - expect it to be stopped")
+	}
+	close(messageCh)
+}
+
+func TestBybitWsParse(t *testing.T) {
+	ws := NewBybitWs()
+	ws.SetSymbolMap(symbols.NewMap(map[string]string{"BTC-PERP": "BTCUSDT"}))
+	if name := ws.topicName("BTC-PERP"); name != "publicTrade.BTCUSDT" {
+		t.Errorf("TestBybitWsParse - unexpected topic '%s'", name)
+	}
+
+	// Responses of op
+	if _, _, ok, err := ws.parse([]byte(`{"success":true,"ret_msg":"pong","conn_id":"c6a5b4e3","req_id":"","op":"ping"}`)); ok || err != nil {
+		t.Errorf("TestBybitWsParse - expect response not to be a mark, err: %v", err)
+	}
+	ws.requests = map[string][]string{"2": {"publicTrade.XYZUSDT"}}
+	_, _, _, err := ws.parse([]byte(`{"success":false,"ret_msg":"error:handler not found,topic:publicTrade.XYZUSDT","conn_id":"c6a5b4e3","req_id":"2","op":"subscribe"}`))
+	var opErr *bybitOpError
+	if !errors.As(err, &opErr) || !reflect.DeepEqual(opErr.topics, []string{"publicTrade.XYZUSDT"}) {
+		t.Errorf("TestBybitWsParse - expect error of failed subscribe with its topics, but got %v", err)
+	}
+	if len(ws.requests) != 0 {
+		t.Errorf("TestBybitWsParse - expect request to be removed once responded, but got %v", ws.requests)
+	}
+}
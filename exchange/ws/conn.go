@@ -0,0 +1,37 @@
+package ws
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// Read messages of the connection until it's closed or doneCh is closed
+// NOTE The error is sent to errCh, it should be buffered
+func readMessages(conn *websocket.Conn, msgCh chan []byte, errCh chan error, doneCh chan bool) {
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		select {
+		case msgCh <- msg:
+		case <-doneCh:
+			return
+		}
+	}
+}
+
+// Topics to subscribe and unsubscribe so that the current ones become the expected ones
+func diffTopics(expected map[string]bool, current map[string]bool) (subscribe []string, unsubscribe []string) {
+	for topic := range expected {
+		if !current[topic] {
+			subscribe = append(subscribe, topic)
+		}
+	}
+	for topic := range current {
+		if !expected[topic] {
+			unsubscribe = append(unsubscribe, topic)
+		}
+	}
+	return
+}
@@ -14,10 +14,13 @@ type FtxWs struct {
 	broadcastMark func(string, contract.Mark)
 	stopCh        chan bool
 	stopAll       func()
+	subs          *subscriptions
 }
 
 func NewFtxWs() *FtxWs {
-	return &FtxWs{}
+	return &FtxWs{
+		subs: newSubscriptions(),
+	}
 }
 
 func (ws *FtxWs) SetBroadcastMarkFunc(f func(string, contract.Mark)) {
@@ -32,7 +35,45 @@ func (ws *FtxWs) SetStopAllFunc(f func()) {
 	ws.stopAll = f
 }
 
+func (ws *FtxWs) Subscribe(symbol string) error {
+	ws.subs.add(symbol)
+	return nil
+}
+
+func (ws *FtxWs) Unsubscribe(symbol string) error {
+	ws.subs.remove(symbol)
+	return nil
+}
+
+// symbols are subscribed in addition to the ones of Subscribe
+// NOTE goftx can't subscribe on the connection opened, so it reconnects with all symbols if a new one is subscribed
 func (ws *FtxWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bool, err error) {
+	for _, symbol := range symbols {
+		ws.subs.add(symbol)
+	}
+
+	for {
+		symbols = ws.subs.list()
+
+		// Wait until there is any symbol
+		if len(symbols) == 0 {
+			select {
+			case <-ws.subs.changedCh:
+				continue
+			case <-ws.stopCh:
+				ws.stopAll()
+				return true, nil
+			}
+		}
+
+		resubscribe, end, err := ws.listen(symbols, debug)
+		if !resubscribe {
+			return end, err
+		}
+	}
+}
+
+func (ws *FtxWs) listen(symbols []string, debug bool) (resubscribe bool, end bool, err error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	client := goftx.New()
@@ -40,7 +81,7 @@ func (ws *FtxWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bo
 
 	trades, err := client.Stream.SubscribeToTrades(ctx, symbols...)
 	if err != nil {
-		return end, err
+		return false, end, err
 	}
 
 	// Filter incoming response by last timestamp
@@ -57,7 +98,11 @@ func (ws *FtxWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bo
 				halted = true
 				break
 			}
-			if ws.ignoreResp(&lastTradeTs, trade.BaseResponse.Symbol) {
+			// Unsubscribed symbols are dropped until the next reconnection
+			if !ws.subs.has(trade.BaseResponse.Symbol) {
+				break
+			}
+			if ignoreResp(&lastTradeTs, trade.BaseResponse.Symbol) {
 				break
 			}
 
@@ -66,6 +111,11 @@ func (ws *FtxWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bo
 				Time:  trade.Trade.Time,
 			}
 			ws.broadcastMark(trade.BaseResponse.Symbol, mark)
+		case <-ws.subs.changedCh:
+			if ws.subs.notIn(symbols) {
+				resubscribe = true
+				halted = true
+			}
 		case <-ws.stopCh:
 			ws.stopAll()
 			end = true
@@ -74,10 +124,11 @@ func (ws *FtxWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bo
 		}
 	}
 
-	return end, err
+	return resubscribe, end, err
 }
 
-func (ws *FtxWs) ignoreResp(m *sync.Map, symbol string) bool {
+// Filter incoming marks of the symbol by last timestamp
+func ignoreResp(m *sync.Map, symbol string) bool {
 	ts := time.Now().UnixMilli()
 	lastTs, ok := m.Load(symbol)
 	if ok {
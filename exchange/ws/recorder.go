@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"compress/gzip"
+	"crypto-trading-bot-engine/strategy/contract"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	RECORDER_FLUSH_COUNT = 100 // flush compressed data every N records
+)
+
+// One line of the recording
+type RecordedMark struct {
+	Symbol string          `json:"symbol"`
+	Price  decimal.Decimal `json:"price"`
+	Time   time.Time       `json:"time"`
+}
+
+// Append marks to compressed JSONL files, a new file is created every rotate interval
+// e.g. marks-20211120-1000.jsonl.gz
+type Recorder struct {
+	mutex          sync.Mutex
+	dir            string
+	rotateInterval time.Duration
+
+	file      *os.File
+	gz        *gzip.Writer
+	encoder   *json.Encoder
+	fileStart time.Time
+	count     int
+}
+
+func NewRecorder(dir string, rotateInterval time.Duration) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if rotateInterval <= 0 {
+		rotateInterval = time.Hour
+	}
+	return &Recorder{
+		dir:            dir,
+		rotateInterval: rotateInterval,
+	}, nil
+}
+
+func (r *Recorder) Record(symbol string, mark contract.Mark) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if r.file == nil || now.Sub(r.fileStart) >= r.rotateInterval {
+		if err := r.rotate(now); err != nil {
+			return err
+		}
+	}
+	if err := r.encoder.Encode(RecordedMark{Symbol: symbol, Price: mark.Price, Time: mark.Time}); err != nil {
+		return err
+	}
+
+	// NOTE Data that hasn't been flushed will be lost if the process crashes
+	r.count++
+	if r.count%RECORDER_FLUSH_COUNT == 0 {
+		return r.gz.Flush()
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closeFile()
+}
+
+func (r *Recorder) rotate(now time.Time) error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+
+	// Align the file to the interval, so that the file name tells the period
+	start := now.UTC().Truncate(r.rotateInterval)
+	name := filepath.Join(r.dir, fmt.Sprintf("marks-%s.jsonl.gz", start.Format("20060102-1504")))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	r.gz = gzip.NewWriter(f)
+	r.encoder = json.NewEncoder(r.gz)
+	r.fileStart = start
+	return nil
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.gz.Close()
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	r.file = nil
+	return err
+}
@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto-trading-bot-engine/strategy/contract"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Feed the recording of Recorder back as if it's the live stream
+type ReplayWs struct {
+	broadcastMark func(string, contract.Mark)
+	stopCh        chan bool
+	stopAll       func()
+	subs          *subscriptions
+
+	// File or directory of the recording, files of directory are replayed in the order of names
+	path string
+
+	// 1 is the original speed, 10 is 10 times faster, 0 means as fast as possible
+	speed float64
+}
+
+func NewReplayWs(path string, speed float64) *ReplayWs {
+	return &ReplayWs{
+		path:  path,
+		speed: speed,
+		subs:  newSubscriptions(),
+	}
+}
+
+func (ws *ReplayWs) SetBroadcastMarkFunc(f func(string, contract.Mark)) {
+	ws.broadcastMark = f
+}
+
+func (ws *ReplayWs) SetStopCh(ch chan bool) {
+	ws.stopCh = ch
+}
+
+func (ws *ReplayWs) SetStopAllFunc(f func()) {
+	ws.stopAll = f
+}
+
+func (ws *ReplayWs) Subscribe(symbol string) error {
+	ws.subs.add(symbol)
+	return nil
+}
+
+func (ws *ReplayWs) Unsubscribe(symbol string) error {
+	ws.subs.remove(symbol)
+	return nil
+}
+
+// Only the marks of symbols subscribed at the moment are replayed
+// NOTE Once the recording is finished, it blocks until stop signal, so that the result can be checked via http api
+func (ws *ReplayWs) ListenPublicTradesChannel(symbols []string, debug bool) (end bool, err error) {
+	files, err := ws.files()
+	if err != nil {
+		return true, err
+	}
+	for _, symbol := range symbols {
+		ws.subs.add(symbol)
+	}
+
+	var last time.Time
+	for _, name := range files {
+		stopped, err := ws.replayFile(name, &last)
+		if err != nil {
+			return true, err
+		}
+		if stopped {
+			ws.stopAll()
+			return true, nil
+		}
+	}
+	log.Printf("[replay] finished, %d files", len(files))
+
+	<-ws.stopCh
+	ws.stopAll()
+	return true, nil
+}
+
+func (ws *ReplayWs) files() ([]string, error) {
+	info, err := os.Stat(ws.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{ws.path}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(ws.path, "*.jsonl*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (ws *ReplayWs) replayFile(name string, last *time.Time) (stopped bool, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return false, fmt.Errorf("'%s' err: %v", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var rm RecordedMark
+		if err = json.Unmarshal(scanner.Bytes(), &rm); err != nil {
+			return false, fmt.Errorf("'%s' err: %v", name, err)
+		}
+		if !ws.subs.has(rm.Symbol) {
+			continue
+		}
+
+		// Keep the interval between marks
+		var wait time.Duration
+		if ws.speed > 0 && !last.IsZero() && rm.Time.After(*last) {
+			wait = time.Duration(float64(rm.Time.Sub(*last)) / ws.speed)
+		}
+		*last = rm.Time
+		select {
+		case <-ws.stopCh:
+			return true, nil
+		case <-time.After(wait):
+		}
+
+		ws.broadcastMark(rm.Symbol, contract.Mark{Price: rm.Price, Time: rm.Time})
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,60 @@
+package ws
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("TestRecordAndReplay - unexpected error: %v", err)
+	}
+	start := time.Date(2021, 11, 20, 10, 0, 0, 0, time.UTC)
+	recorded := []RecordedMark{
+		{Symbol: "BTC-PERP", Price: decimal.NewFromInt(60000), Time: start},
+		{Symbol: "ETH-PERP", Price: decimal.NewFromInt(4000), Time: start.Add(time.Second)},
+		{Symbol: "BTC-PERP", Price: decimal.NewFromFloat(60001.5), Time: start.Add(time.Second * 2)},
+	}
+	for _, rm := range recorded {
+		if err := recorder.Record(rm.Symbol, contract.Mark{Price: rm.Price, Time: rm.Time}); err != nil {
+			t.Fatalf("TestRecordAndReplay - unexpected error: %v", err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("TestRecordAndReplay - unexpected error: %v", err)
+	}
+
+	// Replay BTC-PERP only, as fast as possible
+	var replayed []RecordedMark
+	stopCh := make(chan bool)
+	stoppedAll := false
+	ws := NewReplayWs(dir, 0)
+	ws.SetStopCh(stopCh)
+	ws.SetStopAllFunc(func() { stoppedAll = true })
+	ws.SetBroadcastMarkFunc(func(symbol string, mark contract.Mark) {
+		replayed = append(replayed, RecordedMark{Symbol: symbol, Price: mark.Price, Time: mark.Time})
+		if len(replayed) == 2 {
+			// Stop signal is sent after the recording is finished
+			go close(stopCh)
+		}
+	})
+	end, err := ws.ListenPublicTradesChannel([]string{"BTC-PERP"}, false)
+	if err != nil || !end || !stoppedAll {
+		t.Fatalf("TestRecordAndReplay - unexpected end: %t, stoppedAll: %t, err: %v", end, stoppedAll, err)
+	}
+
+	expected := []RecordedMark{recorded[0], recorded[2]}
+	if len(replayed) != len(expected) {
+		t.Fatalf("TestRecordAndReplay - expect %d marks, but got %d", len(expected), len(replayed))
+	}
+	for i := range expected {
+		if replayed[i].Symbol != expected[i].Symbol || !replayed[i].Price.Equal(expected[i].Price) || !replayed[i].Time.Equal(expected[i].Time) {
+			t.Errorf("TestRecordAndReplay - expect %+v, but got %+v", expected[i], replayed[i])
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"sort"
+	"sync"
+)
+
+// Symbols subscribed, they can be changed while listening
+type subscriptions struct {
+	mu      sync.RWMutex
+	symbols map[string]bool
+
+	// Notify the listener that symbols have been changed, multiple changes are merged into one
+	changedCh chan bool
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		symbols:   make(map[string]bool),
+		changedCh: make(chan bool, 1),
+	}
+}
+
+// Return true if the symbol wasn't subscribed
+func (s *subscriptions) add(symbol string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.symbols[symbol] {
+		return false
+	}
+	s.symbols[symbol] = true
+	s.notify()
+	return true
+}
+
+// Return true if the symbol was subscribed
+func (s *subscriptions) remove(symbol string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.symbols[symbol] {
+		return false
+	}
+	delete(s.symbols, symbol)
+	s.notify()
+	return true
+}
+
+func (s *subscriptions) has(symbol string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.symbols[symbol]
+}
+
+func (s *subscriptions) list() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	symbols := make([]string, 0, len(s.symbols))
+	for symbol := range s.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Return true if any symbol subscribed isn't in the given list
+func (s *subscriptions) notIn(symbols []string) bool {
+	listed := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		listed[symbol] = true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for symbol := range s.symbols {
+		if !listed[symbol] {
+			return true
+		}
+	}
+	return false
+}
+
+// NOTE Never block the caller, the listener reads the latest symbols anyway
+func (s *subscriptions) notify() {
+	select {
+	case s.changedCh <- true:
+	default:
+	}
+}
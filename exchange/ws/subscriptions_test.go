@@ -0,0 +1,45 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubscriptions(t *testing.T) {
+	s := newSubscriptions()
+	if !s.add("BTC-PERP") || !s.add("ETH-PERP") {
+		t.Fatal("TestSubscriptions - expect new symbols to be added")
+	}
+	if s.add("BTC-PERP") {
+		t.Error("TestSubscriptions - expect 'BTC-PERP' to be subscribed already")
+	}
+
+	// Changes are merged into one notification
+	select {
+	case <-s.changedCh:
+	default:
+		t.Fatal("TestSubscriptions - expect a notification")
+	}
+	select {
+	case <-s.changedCh:
+		t.Fatal("TestSubscriptions - expect only one notification")
+	default:
+	}
+
+	if expected := []string{"BTC-PERP", "ETH-PERP"}; !reflect.DeepEqual(s.list(), expected) {
+		t.Errorf("TestSubscriptions - expect %v, but got %v", expected, s.list())
+	}
+	if s.notIn([]string{"BTC-PERP", "ETH-PERP", "SOL-PERP"}) {
+		t.Error("TestSubscriptions - expect all symbols to be listed")
+	}
+	if !s.notIn([]string{"BTC-PERP"}) {
+		t.Error("TestSubscriptions - expect 'ETH-PERP' not to be listed")
+	}
+
+	if !s.remove("ETH-PERP") || s.remove("ETH-PERP") {
+		t.Error("TestSubscriptions - expect 'ETH-PERP' to be removed once")
+	}
+	if s.has("ETH-PERP") || !s.has("BTC-PERP") {
+		t.Errorf("TestSubscriptions - unexpected symbols %v", s.list())
+	}
+}
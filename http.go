@@ -92,6 +92,12 @@ func (h *httpHandler) show(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	uuid := strings.Trim(query.Get("uuid"), " ")
 	_, ok := h.runnerHandler.runnerByUuidMap.Load(uuid)
+	if !ok {
+		_, ok = h.runnerHandler.spotRunnerByUuidMap.Load(uuid)
+	}
+	if !ok {
+		_, ok = h.runnerHandler.pairRunnerByUuidMap.Load(uuid)
+	}
 	resp := map[string]interface{}{
 		"exist": ok,
 	}
@@ -106,6 +112,14 @@ func (h *httpHandler) list(w http.ResponseWriter, r *http.Request) {
 		list[key.(string)] = fmt.Sprintf("%s %s", r.(*runner.ContractStrategyRunner).ContractStrategy.Symbol, r.(*runner.ContractStrategyRunner).LastPriceCheckedTime.Format("2006-01-02 15:04:05"))
 		return true
 	})
+	h.runnerHandler.spotRunnerByUuidMap.Range(func(key, r interface{}) bool {
+		list[key.(string)] = fmt.Sprintf("%s %s", r.(*runner.SpotStrategyRunner).SpotStrategy.Symbol, r.(*runner.SpotStrategyRunner).LastPriceCheckedTime.Format("2006-01-02 15:04:05"))
+		return true
+	})
+	h.runnerHandler.pairRunnerByUuidMap.Range(func(key, r interface{}) bool {
+		list[key.(string)] = fmt.Sprintf("%s %s", pairSymbols(r.(*runner.PairStrategyRunner).PairStrategy), r.(*runner.PairStrategyRunner).LastPriceCheckedTime.Format("2006-01-02 15:04:05"))
+		return true
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(list)
@@ -115,7 +129,8 @@ func (h *httpHandler) event(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	action := strings.Trim(query.Get("action"), " ")
 	uuid := strings.Trim(query.Get("uuid"), " ")
-	h.logger.Printf("action: '%s', uuid: '%s'", action, uuid)
+	market := strings.Trim(query.Get("market"), " ") // 'contract' (default), 'spot' or 'pair'
+	h.logger.Printf("action: '%s', uuid: '%s', market: '%s'", action, uuid, market)
 
 	if action == "" || uuid == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -123,11 +138,32 @@ func (h *httpHandler) event(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	eventsCh := &h.runnerHandler.eventsCh
+	switch market {
+	case "", "contract":
+	case "spot":
+		eventsCh = &h.runnerHandler.spotEventsCh
+	case "pair":
+		eventsCh = &h.runnerHandler.pairEventsCh
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "market '%s' not supported", market)
+		return
+	}
+
 	switch action {
 	case "enable":
-		h.runnerHandler.eventsCh.Enable <- uuid
+		eventsCh.Enable <- uuid
 	case "disable":
-		h.runnerHandler.eventsCh.Disable <- uuid
+		eventsCh.Disable <- uuid
+	case "restart":
+		// NOTE Only contract strategies are listened
+		if eventsCh != &h.runnerHandler.eventsCh {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "action '%s' not supported for market '%s'", action, market)
+			return
+		}
+		eventsCh.Restart <- uuid
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "action '%s' not supported", action)
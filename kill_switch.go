@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/db"
+	"fmt"
+	"sync"
+)
+
+// Disable all strategies of the user once the daily loss limit is hit, only once a day
+// NOTE Opened positions and their stop-loss orders are left on the exchange
+func (h *runnerHandler) killSwitch(userUuid string) {
+	triggered, err := h.riskManager.TriggerKillSwitch(userUuid)
+	if err != nil {
+		h.logger.Printf("[ERROR] killSwitch user: '%s', err: %v", userUuid, err)
+		return
+	}
+	if !triggered {
+		return
+	}
+
+	var count int
+	if css, _, err := h.db.GetContractStrategiesByUser(userUuid); err != nil {
+		h.logger.Printf("[ERROR] killSwitch user: '%s', failed to get contract strategies, err: %v", userUuid, err)
+	} else {
+		for _, cs := range css {
+			if cs.Enabled == 1 && h.killStrategy(db.MARKET_CONTRACT, cs.Uuid, &h.runnerByUuidMap, h.disableContractStrategy, h.db.UpdateContractStrategy) {
+				count++
+			}
+		}
+	}
+	if sss, _, err := h.db.GetSpotStrategiesByUser(userUuid); err != nil {
+		h.logger.Printf("[ERROR] killSwitch user: '%s', failed to get spot strategies, err: %v", userUuid, err)
+	} else {
+		for _, ss := range sss {
+			if ss.Enabled == 1 && h.killStrategy(db.MARKET_SPOT, ss.Uuid, &h.spotRunnerByUuidMap, h.disableSpotStrategy, h.db.UpdateSpotStrategy) {
+				count++
+			}
+		}
+	}
+	if pss, _, err := h.db.GetPairStrategiesByUser(userUuid); err != nil {
+		h.logger.Printf("[ERROR] killSwitch user: '%s', failed to get pair strategies, err: %v", userUuid, err)
+	} else {
+		for _, ps := range pss {
+			if ps.Enabled == 1 && h.killStrategy(db.MARKET_PAIR, ps.Uuid, &h.pairRunnerByUuidMap, h.disablePairStrategy, h.db.UpdatePairStrategy) {
+				count++
+			}
+		}
+	}
+
+	h.logger.Printf("[Warn] killSwitch user: '%s', %d strategies have been disabled", userUuid, count)
+	if user, ok := h.userMap.Load(userUuid); ok {
+		text := fmt.Sprintf("[警告] 已達每日虧損上限, 已停用 %d 個策略, 未平倉位及停損單仍在交易所, 請手動確認", count)
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+	}
+}
+
+// Disable the running strategy via handler, or in DB only if it isn't running
+func (h *runnerHandler) killStrategy(market string, uuid string, runnerByUuidMap *sync.Map, disable func(string), update func(string, map[string]interface{}) (int64, error)) bool {
+	if _, ok := runnerByUuidMap.Load(uuid); ok {
+		h.auditEvent(market, AUDIT_EVENT_DISABLE, uuid, disable)
+		return true
+	}
+	data := map[string]interface{}{
+		"enabled": 0,
+	}
+	if _, err := update(uuid, data); err != nil {
+		h.logger.Printf("[ERROR] killSwitch %s strategy: '%s', err: %v", market, uuid, err)
+		return false
+	}
+	return true
+}
@@ -4,11 +4,18 @@ import (
 	"crypto-trading-bot-engine/db"
 	"crypto-trading-bot-engine/util/logger"
 	"fmt"
+	"os"
 
 	"github.com/spf13/viper"
 )
 
 func main() {
+	// Offline commands don't need config, DB and exchange
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	// Read config
 	loadConfig()
 
@@ -47,6 +54,22 @@ func main() {
 	sh.capture()
 }
 
+func runCommand(name string, args []string) {
+	var err error
+	switch name {
+	case "backtest":
+		err = runBacktest(args)
+	case "sweep":
+		err = runSweep(args)
+	default:
+		err = fmt.Errorf("command '%s' not supported", name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 func loadConfig() {
 	viper.SetConfigName("config") // name of config file (without extension)
 	viper.SetConfigType("yaml")   // REQUIRED if the config file does not have the extension in the name
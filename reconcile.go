@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/runner"
+	"crypto-trading-bot-engine/strategy/order"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Runners of the same user on the same exchange share one snapshot
+type reconcileGroup struct {
+	exchange exchange.Exchanger
+	userUuid string
+	runners  []*runner.ContractStrategyRunner
+}
+
+// Snapshots taken at startup by exchange and user, the failure is kept as well so that the exchange isn't called
+// again for every strategy of the user
+type snapshotCache map[string]*snapshotResult
+
+type snapshotResult struct {
+	snapshot *runner.ExchangeSnapshot
+	err      error
+}
+
+// Nil cache takes a new snapshot every time e.g. the strategy is enabled
+func (c snapshotCache) get(ex exchange.Exchanger, exchangeName string, userUuid string) (*runner.ExchangeSnapshot, error) {
+	key := exchangeName + ":" + userUuid
+	if r, ok := c[key]; ok {
+		return r.snapshot, r.err
+	}
+	snapshot, err := runner.NewExchangeSnapshot(ex)
+	if c != nil {
+		c[key] = &snapshotResult{snapshot: snapshot, err: err}
+	}
+	return snapshot, err
+}
+
+// Check opened strategies against the exchange periodically, as the user might close the position or cancel the
+// stop-loss order on the exchange app, which the engine doesn't know until a trigger fires
+// NOTE It's disabled if RECONCILE_INTERVAL_SECONDS isn't set
+func (h *runnerHandler) reconcilePeriodically() {
+	interval := viper.GetInt64("RECONCILE_INTERVAL_SECONDS")
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second * time.Duration(interval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.reconcileStopCh:
+			return
+		case <-ticker.C:
+			h.reconcileAll()
+		}
+	}
+}
+
+func (h *runnerHandler) reconcileAll() {
+	// Pause between users, as every user costs 2 requests to the exchange
+	pause := time.Millisecond * time.Duration(viper.GetInt64("RECONCILE_PAUSE_MILLISECONDS"))
+
+	for i, g := range h.reconcileGroups() {
+		if i > 0 {
+			select {
+			case <-h.reconcileStopCh:
+				return
+			case <-time.After(pause):
+			}
+		}
+
+		snapshot, err := runner.NewExchangeSnapshot(g.exchange)
+		if err != nil {
+			h.logger.Printf("[Warn] reconcileAll - user: '%s', err: %v\n", g.userUuid, err)
+			continue
+		}
+		for _, r := range g.runners {
+			h.reconcileRunner(r, snapshot)
+		}
+	}
+}
+
+// Group opened strategies placing orders on the exchange by exchange and user
+func (h *runnerHandler) reconcileGroups() []*reconcileGroup {
+	var groups []*reconcileGroup
+	groupMap := make(map[string]*reconcileGroup) // key is exchange and user_uuid
+	h.runnerByUuidMap.Range(func(_, value interface{}) bool {
+		r := value.(*runner.ContractStrategyRunner)
+		if !r.Reconcilable() {
+			return true
+		}
+		cs := r.ContractStrategy
+		key := cs.Exchange + ":" + cs.UserUuid
+		g, ok := groupMap[key]
+		if !ok {
+			g = &reconcileGroup{exchange: r.Exchange(), userUuid: cs.UserUuid}
+			groupMap[key] = g
+			groups = append(groups, g)
+		}
+		g.runners = append(g.runners, r)
+		return true
+	})
+	return groups
+}
+
+func (h *runnerHandler) reconcileRunner(r *runner.ContractStrategyRunner, snapshot *runner.ExchangeSnapshot) {
+	cs := r.ContractStrategy
+	user, ok := h.userMap.Load(cs.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] reconcileRunner - user '%s' not found", cs.UserUuid)
+		return
+	}
+	chatId := user.(*db.User).TelegramChatId
+
+	// NOTE Stop-loss order isn't placed again, the user might have cancelled it on purpose
+	report := r.Reconcile(snapshot, false)
+	if len(report.Diffs) == 0 {
+		h.reconcileAlerts.Delete(cs.Uuid)
+		return
+	}
+
+	// Position has been closed on the exchange, reset and disable the strategy
+	if report.PositionGone {
+		if err := r.CancelStopLossOrderLeft(snapshot); err != nil {
+			h.logger.Printf("[Error] reconcileRunner - strategy: '%s', failed to cancel stop-loss order, err: %v", cs.Uuid, err)
+		}
+		r.RecordManualExit()
+		h.reconcileAlerts.Delete(cs.Uuid)
+		text := fmt.Sprintf("[提示] '%s %s' 倉位已不在 %s, 策略已設為 CLOSED 並停用", order.TranslateSideByInt(cs.Side), cs.Symbol, cs.Exchange)
+		go h.sender.Send(chatId, text)
+		select {
+		case h.eventsCh.Reset <- cs.Uuid:
+		case <-h.reconcileStopCh:
+		}
+		return
+	}
+
+	// Alert once until the differences change, otherwise the user gets the same message every interval
+	diffs := strings.Join(report.Diffs, "\n- ")
+	if last, ok := h.reconcileAlerts.Load(cs.Uuid); ok && last.(string) == diffs {
+		return
+	}
+	h.reconcileAlerts.Store(cs.Uuid, diffs)
+	title := "與 %s 資料不一致"
+	if report.StopLossMissing {
+		title = "停損單已不在 %s"
+	}
+	text := fmt.Sprintf("[警告] '%s %s' "+title+", 請手動確認:\n- %s", order.TranslateSideByInt(cs.Side), cs.Symbol, cs.Exchange, diffs)
+	go h.sender.Send(chatId, text)
+}
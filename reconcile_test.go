@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/runner"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+	"gorm.io/datatypes"
+)
+
+// Only the calls of reconciliation are implemented, the others panic
+type reconcileExchange struct {
+	exchange.Exchanger
+	mu             sync.Mutex
+	snapshots      int
+	positions      map[string]map[string]interface{}
+	stopLossOrders map[int64]map[string]interface{}
+}
+
+func (ex *reconcileExchange) GetPositions() (map[string]map[string]interface{}, error) {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+	ex.snapshots++
+	return ex.positions, nil
+}
+
+func (ex *reconcileExchange) GetOpenStopLossOrders() (map[int64]map[string]interface{}, error) {
+	return ex.stopLossOrders, nil
+}
+
+type reconcileSender struct {
+	textCh chan string
+}
+
+func (s *reconcileSender) Send(chatId int64, text string) {
+	s.textCh <- text
+}
+
+func newReconcileHandler() (*runnerHandler, *reconcileSender) {
+	sender := &reconcileSender{textCh: make(chan string, 10)}
+	h := newRunnerHandler(log.New(io.Discard, "", 0))
+	h.sender = sender
+	h.userMap.Store("user-a", &db.User{Uuid: "user-a"})
+	h.userMap.Store("user-b", &db.User{Uuid: "user-b"})
+	return h, sender
+}
+
+// Opened long position of ETH-PERP with the stop-loss order 2
+func newReconcileRunner(t *testing.T, h *runnerHandler, uuid string, userUuid string, status contract.Status, execution string, ex exchange.Exchanger) *runner.ContractStrategyRunner {
+	cs := &db.ContractStrategy{
+		Uuid:           uuid,
+		UserUuid:       userUuid,
+		Symbol:         "ETH-PERP",
+		Exchange:       "FTX",
+		Execution:      execution,
+		Side:           int64(order.LONG),
+		Margin:         decimal.NewFromInt(100),
+		PositionStatus: int64(status),
+		Params: datatypes.JSONMap{
+			"entry_type":      order.ENTRY_LIMIT,
+			"entry_order":     map[string]interface{}{"trigger": map[string]interface{}{"trigger_type": "limit", "operator": "<=", "price": "3000"}},
+			"stop_loss_order": map[string]interface{}{"trigger": map[string]interface{}{"trigger_type": "limit", "operator": "<=", "price": "2900"}},
+		},
+		ExchangeOrdersDetails: datatypes.JSONMap{
+			"entry_order":     map[string]interface{}{"order_id": float64(1), "price": "3000", "size": "2"},
+			"stop_loss_order": map[string]interface{}{"order_id": float64(2)},
+		},
+	}
+	r, err := runner.NewContractStrategyRunner(cs)
+	if err != nil {
+		t.Fatalf("newReconcileRunner - unexpected error: %v", err)
+	}
+	r.SetLogger(log.New(io.Discard, "", 0))
+	if execution != db.EXECUTION_ALERT_ONLY {
+		r.SetExchangeForHook(ex)
+	}
+	h.addIntoRunnerByUuidMap(uuid, r)
+	return r
+}
+
+func newReconcileExchange(stopLossOrderIds ...int64) *reconcileExchange {
+	ex := &reconcileExchange{
+		positions:      map[string]map[string]interface{}{"ETH-PERP": {"symbol": "ETH-PERP", "side": float64(order.LONG), "size": "2"}},
+		stopLossOrders: make(map[int64]map[string]interface{}),
+	}
+	for _, id := range stopLossOrderIds {
+		ex.stopLossOrders[id] = map[string]interface{}{"order_id": float64(id)}
+	}
+	return ex
+}
+
+func TestReconcileGroups(t *testing.T) {
+	h, _ := newReconcileHandler()
+	exA := newReconcileExchange(2)
+	exB := newReconcileExchange(2)
+	newReconcileRunner(t, h, "a-1", "user-a", contract.OPENED, db.EXECUTION_EXCHANGE, exA)
+	newReconcileRunner(t, h, "a-2", "user-a", contract.OPENED, db.EXECUTION_EXCHANGE, exA)
+	newReconcileRunner(t, h, "a-3", "user-a", contract.CLOSED, db.EXECUTION_EXCHANGE, exA)
+	newReconcileRunner(t, h, "a-4", "user-a", contract.OPENED, db.EXECUTION_ALERT_ONLY, nil)
+	newReconcileRunner(t, h, "b-1", "user-b", contract.OPENED, db.EXECUTION_EXCHANGE, exB)
+
+	runners := make(map[string]int)
+	for _, g := range h.reconcileGroups() {
+		runners[g.userUuid] = len(g.runners)
+	}
+	if len(runners) != 2 || runners["user-a"] != 2 || runners["user-b"] != 1 {
+		t.Errorf("TestReconcileGroups - expect 2 runners of user-a and 1 of user-b, but got %v", runners)
+	}
+}
+
+func TestReconcileRunner(t *testing.T) {
+	h, sender := newReconcileHandler()
+	r := newReconcileRunner(t, h, "a-1", "user-a", contract.OPENED, db.EXECUTION_EXCHANGE, nil)
+
+	testcases := []struct {
+		title         string
+		snapshot      *runner.ExchangeSnapshot
+		expectedAlert bool
+	}{
+		{
+			title:         "stop-loss order missing",
+			snapshot:      newReconcileSnapshot(t, newReconcileExchange(3)),
+			expectedAlert: true,
+		},
+		{
+			title:         "same differences aren't sent again",
+			snapshot:      newReconcileSnapshot(t, newReconcileExchange(3)),
+			expectedAlert: false,
+		},
+		{
+			title:         "in sync",
+			snapshot:      newReconcileSnapshot(t, newReconcileExchange(2)),
+			expectedAlert: false,
+		},
+		{
+			title:         "stop-loss order missing again",
+			snapshot:      newReconcileSnapshot(t, newReconcileExchange()),
+			expectedAlert: true,
+		},
+	}
+	for _, tc := range testcases {
+		h.reconcileRunner(r, tc.snapshot)
+
+		var alerted bool
+		select {
+		case <-sender.textCh:
+			alerted = true
+		case <-time.After(100 * time.Millisecond):
+		}
+		if alerted != tc.expectedAlert {
+			t.Errorf("TestReconcileRunner case '%s' - expect alert '%t', but got '%t'", tc.title, tc.expectedAlert, alerted)
+		}
+	}
+}
+
+func TestReconcileAllStopped(t *testing.T) {
+	viper.Set("RECONCILE_PAUSE_MILLISECONDS", 60000)
+	defer viper.Set("RECONCILE_PAUSE_MILLISECONDS", nil)
+
+	h, _ := newReconcileHandler()
+	exA := newReconcileExchange(2)
+	exB := newReconcileExchange(2)
+	newReconcileRunner(t, h, "a-1", "user-a", contract.OPENED, db.EXECUTION_EXCHANGE, exA)
+	newReconcileRunner(t, h, "b-1", "user-b", contract.OPENED, db.EXECUTION_EXCHANGE, exB)
+
+	doneCh := make(chan bool)
+	go func() {
+		h.reconcileAll()
+		close(doneCh)
+	}()
+
+	// Stopped while pausing between the users
+	time.Sleep(100 * time.Millisecond)
+	close(h.reconcileStopCh)
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second * 3):
+		t.Fatal("TestReconcileAllStopped - expect it to be stopped")
+	}
+	exA.mu.Lock()
+	exB.mu.Lock()
+	defer exA.mu.Unlock()
+	defer exB.mu.Unlock()
+	if exA.snapshots+exB.snapshots != 1 {
+		t.Errorf("TestReconcileAllStopped - expect 1 snapshot, but got %d", exA.snapshots+exB.snapshots)
+	}
+}
+
+func newReconcileSnapshot(t *testing.T, ex exchange.Exchanger) *runner.ExchangeSnapshot {
+	s, err := runner.NewExchangeSnapshot(ex)
+	if err != nil {
+		t.Fatalf("newReconcileSnapshot - unexpected error: %v", err)
+	}
+	return s
+}
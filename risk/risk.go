@@ -0,0 +1,141 @@
+package risk
+
+import (
+	"crypto-trading-bot-engine/db"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var (
+	// The entry would exceed one of the limits, the strategy can't take it
+	ErrLimitExceeded = errors.New("risk limit exceeded")
+
+	// Realized loss of the day has reached the limit, all strategies of the user should be disabled
+	ErrDailyLossLimit = errors.New("daily loss limit reached")
+)
+
+// What the user is holding before the new entry
+type Exposure struct {
+	OpenPositions    int64
+	TotalMargin      decimal.Decimal
+	SymbolNotional   decimal.Decimal // opened positions of the same symbol, valued at the current price
+	DailyRealizedPnl decimal.Decimal
+}
+
+// Check if the new entry is within the limits, 0 means no limit
+func Check(l *db.UserRiskLimit, e Exposure, margin decimal.Decimal) error {
+	if DailyLossLimitHit(l, e.DailyRealizedPnl) {
+		return fmt.Errorf("%w, realized pnl: %s, limit: -%s", ErrDailyLossLimit, e.DailyRealizedPnl.StringFixed(2), l.DailyLossLimit.String())
+	}
+	if l.MaxOpenPositions > 0 && e.OpenPositions+1 > l.MaxOpenPositions {
+		return fmt.Errorf("%w, open positions: %d, limit: %d", ErrLimitExceeded, e.OpenPositions, l.MaxOpenPositions)
+	}
+	if l.MaxTotalMargin.IsPositive() && e.TotalMargin.Add(margin).GreaterThan(l.MaxTotalMargin) {
+		return fmt.Errorf("%w, total margin: %s + %s, limit: %s", ErrLimitExceeded, e.TotalMargin.String(), margin.String(), l.MaxTotalMargin.String())
+	}
+	if l.MaxSymbolNotional.IsPositive() && e.SymbolNotional.Add(margin).GreaterThan(l.MaxSymbolNotional) {
+		return fmt.Errorf("%w, symbol notional: %s + %s, limit: %s", ErrLimitExceeded, e.SymbolNotional.StringFixed(2), margin.String(), l.MaxSymbolNotional.String())
+	}
+	return nil
+}
+
+// Realized loss has reached the limit
+func DailyLossLimitHit(l *db.UserRiskLimit, pnl decimal.Decimal) bool {
+	return l.DailyLossLimit.IsPositive() && pnl.Neg().GreaterThanOrEqual(l.DailyLossLimit)
+}
+
+// The day of daily loss limit starts at 00:00 UTC
+func StartOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Consulted before entry orders are sent, limits are read from 'user_risk_limits' every time so that changes take effect immediately
+type Manager struct {
+	db *db.DB
+}
+
+func NewManager(db *db.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// Limits of the user, nil if there is none
+func (m *Manager) limits(userUuid string) (*db.UserRiskLimit, error) {
+	l, err := m.db.GetUserRiskLimitByUser(userUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return l, err
+}
+
+// Check if the strategy can take the entry at the price
+func (m *Manager) CheckEntry(cs *db.ContractStrategy, p decimal.Decimal) error {
+	l, err := m.limits(cs.UserUuid)
+	if err != nil || l == nil {
+		return err
+	}
+	e, err := m.exposure(cs, p)
+	if err != nil {
+		return err
+	}
+	return Check(l, e, cs.Margin)
+}
+
+func (m *Manager) exposure(cs *db.ContractStrategy, p decimal.Decimal) (e Exposure, err error) {
+	css, _, err := m.db.GetOpenedContractStrategiesByUser(cs.UserUuid, cs.Uuid)
+	if err != nil {
+		return e, fmt.Errorf("failed to get opened strategies, err: %v", err)
+	}
+	for _, s := range css {
+		e.OpenPositions++
+		e.TotalMargin = e.TotalMargin.Add(s.Margin)
+		if s.Symbol != cs.Symbol {
+			continue
+		}
+		// Value the position by its size at the current price, fall back to the margin if the size is unknown
+		notional := s.Margin
+		if entryOrder, ok := s.ExchangeOrdersDetails["entry_order"].(map[string]interface{}); ok {
+			if size, err := decimal.NewFromString(fmt.Sprint(entryOrder["size"])); err == nil {
+				notional = size.Mul(p)
+			}
+		}
+		e.SymbolNotional = e.SymbolNotional.Add(notional)
+	}
+	if e.DailyRealizedPnl, err = m.db.GetRealizedPnlByUserSince(cs.UserUuid, StartOfDay(time.Now())); err != nil {
+		return e, fmt.Errorf("failed to get realized pnl, err: %v", err)
+	}
+	return e, nil
+}
+
+// Check if the daily loss limit has been hit
+func (m *Manager) DailyLossLimitHit(userUuid string) (bool, error) {
+	l, err := m.limits(userUuid)
+	if err != nil || l == nil {
+		return false, err
+	}
+	pnl, err := m.db.GetRealizedPnlByUserSince(userUuid, StartOfDay(time.Now()))
+	if err != nil {
+		return false, err
+	}
+	return DailyLossLimitHit(l, pnl), nil
+}
+
+// Mark the kill switch as triggered, false if it has been triggered today already
+func (m *Manager) TriggerKillSwitch(userUuid string) (bool, error) {
+	l, err := m.limits(userUuid)
+	if err != nil || l == nil {
+		return false, err
+	}
+	now := time.Now()
+	if l.KillSwitchAt != nil && !l.KillSwitchAt.Before(StartOfDay(now)) {
+		return false, nil
+	}
+	if _, err = m.db.UpdateUserRiskLimit(userUuid, map[string]interface{}{"kill_switch_at": now}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
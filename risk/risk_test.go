@@ -0,0 +1,91 @@
+package risk
+
+import (
+	"crypto-trading-bot-engine/db"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheck(t *testing.T) {
+	limits := &db.UserRiskLimit{
+		MaxOpenPositions:  3,
+		MaxTotalMargin:    decimal.NewFromInt(1000),
+		MaxSymbolNotional: decimal.NewFromInt(500),
+		DailyLossLimit:    decimal.NewFromInt(100),
+	}
+	testcases := []struct {
+		title         string
+		limits        *db.UserRiskLimit
+		exposure      Exposure
+		margin        decimal.Decimal
+		expectedError error
+	}{
+		{
+			title:         "no limits",
+			limits:        &db.UserRiskLimit{},
+			exposure:      Exposure{OpenPositions: 10, TotalMargin: decimal.NewFromInt(99999), DailyRealizedPnl: decimal.NewFromInt(-99999)},
+			margin:        decimal.NewFromInt(100),
+			expectedError: nil,
+		},
+		{
+			title:         "within limits",
+			limits:        limits,
+			exposure:      Exposure{OpenPositions: 2, TotalMargin: decimal.NewFromInt(800), SymbolNotional: decimal.NewFromInt(300), DailyRealizedPnl: decimal.NewFromInt(-99)},
+			margin:        decimal.NewFromInt(200),
+			expectedError: nil,
+		},
+		{
+			title:         "max open positions",
+			limits:        limits,
+			exposure:      Exposure{OpenPositions: 3},
+			margin:        decimal.NewFromInt(100),
+			expectedError: ErrLimitExceeded,
+		},
+		{
+			title:         "max total margin",
+			limits:        limits,
+			exposure:      Exposure{OpenPositions: 1, TotalMargin: decimal.NewFromInt(900)},
+			margin:        decimal.NewFromInt(101),
+			expectedError: ErrLimitExceeded,
+		},
+		{
+			title:         "max symbol notional",
+			limits:        limits,
+			exposure:      Exposure{OpenPositions: 1, SymbolNotional: decimal.NewFromInt(450)},
+			margin:        decimal.NewFromInt(51),
+			expectedError: ErrLimitExceeded,
+		},
+		{
+			title:         "daily loss limit",
+			limits:        limits,
+			exposure:      Exposure{DailyRealizedPnl: decimal.NewFromInt(-100)},
+			margin:        decimal.NewFromInt(10),
+			expectedError: ErrDailyLossLimit,
+		},
+		{
+			title:         "daily profit",
+			limits:        limits,
+			exposure:      Exposure{DailyRealizedPnl: decimal.NewFromInt(500)},
+			margin:        decimal.NewFromInt(10),
+			expectedError: nil,
+		},
+	}
+	for _, tc := range testcases {
+		err := Check(tc.limits, tc.exposure, tc.margin)
+		if !errors.Is(err, tc.expectedError) {
+			t.Errorf("TestCheck case '%s' - expect '%v', but got '%v'", tc.title, tc.expectedError, err)
+		}
+	}
+}
+
+func TestStartOfDay(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	got := StartOfDay(time.Date(2022, 3, 2, 5, 30, 0, 0, loc))
+	expected := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	if !got.Equal(expected) {
+		t.Errorf("TestStartOfDay - expect '%v', but got '%v'", expected, got)
+	}
+}
@@ -3,37 +3,82 @@ package main
 import (
 	"crypto-trading-bot-engine/db"
 	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/exchange/paper"
+	"crypto-trading-bot-engine/exchange/symbols"
 	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/risk"
 	"crypto-trading-bot-engine/runner"
 	"crypto-trading-bot-engine/strategy"
 	"crypto-trading-bot-engine/strategy/contract"
 	"crypto-trading-bot-engine/strategy/order"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
 	"gorm.io/datatypes"
 )
 
+// Marks are broadcast by exchange and symbol, as the same symbol could be listed on different exchanges
+type markKey struct {
+	exchange string
+	symbol   string
+}
+
+// NOTE Paper trading follows the marks of DEFAULT_EXCHANGE
+func newMarkKey(exchangeName string, symbol string) markKey {
+	if exchangeName == "PAPER" {
+		exchangeName = viper.GetString("DEFAULT_EXCHANGE")
+	}
+	return markKey{exchange: exchangeName, symbol: symbol}
+}
+
+// Runners of all kinds of strategies receive marks from handler
+type markReceiver interface {
+	SendMark(symbol string, mark contract.Mark)
+}
+
 type runnerHandler struct {
 	logger *log.Logger
 
-	// Send the mark price to each runner channel of the same symbol
+	// Send the mark price to each runner channel of the same exchange and symbol, runners of all kinds of strategies
 	runnersBySymbolMutex sync.RWMutex
-	runnersBySymbolMap   map[string]map[string]*runner.ContractStrategyRunner
+	runnersBySymbolMap   map[markKey]map[string]markReceiver
 
 	// Strategy runner by strategy uuid
 	runnerByUuidMap sync.Map // map[strategy.Uuid]chan runner.ContractStrategyRunner
 
-	// Check if entry order of symbol has been triggered already
-	// One user can only trigger 1 entry order of symbol at once
-	// NOTE: key is user_id
-	symbolEntryTakenMutex map[string]*sync.Mutex
+	// Same as above, but for spot strategies
+	spotRunnerByUuidMap sync.Map // map[strategy.Uuid]*runner.SpotStrategyRunner
+
+	// Same as above, but for pair strategies, a runner is listed under the symbols of both legs in runnersBySymbolMap
+	pairRunnerByUuidMap sync.Map // map[strategy.Uuid]*runner.PairStrategyRunner
+
+	// Runners of contract, spot and pair strategies by symbol, the symbol is subscribed on ws of the exchange as long as it's referenced
+	symbolRefsMutex sync.Mutex
+	symbolRefs      map[markKey]int
+	wsExchangeMap   map[string]exchange.WsExchanger // key is exchange name, it's set when ws of the exchange is connected
+
+	// Requests to the exchange of the user are processed one at a time, shared by all strategies of the user
+	executorByUserMap sync.Map // map[userUuid]*runner.Executor
 
 	// Exchange clients
-	exchangeUserMap map[string]exchange.Exchanger // key is user_uuid
+	exchangeUserMap sync.Map // map[exchange name:user_uuid]exchange.Exchanger
+
+	// Symbols of strategies and the ones on the exchange, shared by exchange clients and ws of the same exchange
+	symbolMapByExchange sync.Map // map[exchange name]*symbols.Map
+
+	// Circuit breakers of the exchange clients above
+	circuitBreakerMap sync.Map // map[exchange name:user_uuid]*exchange.CircuitBreaker
+
+	// Limits of users above strategies
+	riskManager *risk.Manager
+
+	// Simulated exchange for paper trading, stop orders are filled by the mark stream
+	paperMarket *paper.Market
 
 	// User data
 	userMap sync.Map // map[userUuid]db.User
@@ -44,9 +89,21 @@ type runnerHandler struct {
 	// This is the stop channel for handler itself
 	eventsStopCh chan bool
 
+	// Stop the periodic reconciliation
+	reconcileStopCh chan bool
+
+	// Differences that have been sent to the user by periodic reconciliation
+	reconcileAlerts sync.Map // map[strategy.Uuid]string
+
 	// There are channels for runner to communicate with handler
 	eventsCh strategy.EventsCh
 
+	// Same as above, but for spot strategies
+	spotEventsCh strategy.EventsCh
+
+	// Same as above, but for pair strategies
+	pairEventsCh strategy.EventsCh
+
 	// DB
 	db *db.DB
 
@@ -56,12 +113,31 @@ type runnerHandler struct {
 
 func newRunnerHandler(l *log.Logger) *runnerHandler {
 	return &runnerHandler{
-		logger:                l,
-		runnersBySymbolMap:    make(map[string]map[string]*runner.ContractStrategyRunner),
-		symbolEntryTakenMutex: make(map[string]*sync.Mutex),
-		exchangeUserMap:       make(map[string]exchange.Exchanger),
-		eventsStopCh:          make(chan bool),
+		logger:             l,
+		runnersBySymbolMap: make(map[markKey]map[string]markReceiver),
+		symbolRefs:         make(map[markKey]int),
+		wsExchangeMap:      make(map[string]exchange.WsExchanger),
+		eventsStopCh:       make(chan bool),
+		reconcileStopCh:    make(chan bool),
+		paperMarket:        newPaperMarket(),
 		eventsCh: strategy.EventsCh{
+			Restart:    make(chan string),
+			Enable:     make(chan string),
+			Disable:    make(chan string),
+			OutOfSync:  make(chan string),
+			Reset:      make(chan string),
+			Outcome:    make(chan strategy.Outcome),
+			KillSwitch: make(chan string),
+			Stopped:    make(chan struct{}),
+		},
+		spotEventsCh: strategy.EventsCh{
+			Restart:   make(chan string),
+			Enable:    make(chan string),
+			Disable:   make(chan string),
+			OutOfSync: make(chan string),
+			Reset:     make(chan string),
+		},
+		pairEventsCh: strategy.EventsCh{
 			Restart:   make(chan string),
 			Enable:    make(chan string),
 			Disable:   make(chan string),
@@ -77,6 +153,8 @@ func (h *runnerHandler) setLogger(l *log.Logger) {
 
 func (h *runnerHandler) setDB(db *db.DB) {
 	h.db = db
+	h.riskManager = risk.NewManager(db)
+	h.paperMarket.SetStore(db)
 }
 
 // NOTE only one event will be processed at a time.
@@ -86,25 +164,58 @@ func (h *runnerHandler) listenEvents() {
 		select {
 		case <-h.eventsStopCh:
 			// Exit
+			close(h.eventsCh.Stopped)
 			h.eventsStopCh <- true
 			return
 
 		// Enable/start a strategy
 		case uuid := <-h.eventsCh.Enable:
-			go h.enableContractStrategy(uuid)
+			go h.auditEvent(db.MARKET_CONTRACT, AUDIT_EVENT_ENABLE, uuid, h.enableContractStrategy)
 
 		// Disable a strategy
 		case uuid := <-h.eventsCh.Disable:
-			go h.disableContractStrategy(uuid)
+			go h.auditEvent(db.MARKET_CONTRACT, AUDIT_EVENT_DISABLE, uuid, h.disableContractStrategy)
 
 		// Process a strategy out of sync
 		case uuid := <-h.eventsCh.OutOfSync:
 			// Need to use goroutine, otherwise CheckPrice will get blocked as mutext can't be released
-			go h.outOfSyncContractStrategy(uuid)
+			go h.auditEvent(db.MARKET_CONTRACT, AUDIT_EVENT_OUT_OF_SYNC, uuid, h.outOfSyncContractStrategy)
 
 		// Reset a strategy
 		case uuid := <-h.eventsCh.Reset:
-			go h.resetContractStrategy(uuid)
+			go h.auditEvent(db.MARKET_CONTRACT, AUDIT_EVENT_RESET, uuid, h.resetContractStrategy)
+
+		// Reload params of a running strategy
+		case uuid := <-h.eventsCh.Restart:
+			go h.auditParamsEvent(db.MARKET_CONTRACT, AUDIT_EVENT_RESTART, uuid, h.restartContractStrategy)
+
+		// Process the outcome of hooks e.g. group, chained strategies
+		case outcome := <-h.eventsCh.Outcome:
+			go h.auditOutcome(outcome)
+
+		// Disable all strategies of the user as the daily loss limit is hit
+		case userUuid := <-h.eventsCh.KillSwitch:
+			go h.killSwitch(userUuid)
+
+		// Spot strategies
+		case uuid := <-h.spotEventsCh.Enable:
+			go h.auditEvent(db.MARKET_SPOT, AUDIT_EVENT_ENABLE, uuid, h.enableSpotStrategy)
+		case uuid := <-h.spotEventsCh.Disable:
+			go h.auditEvent(db.MARKET_SPOT, AUDIT_EVENT_DISABLE, uuid, h.disableSpotStrategy)
+		case uuid := <-h.spotEventsCh.OutOfSync:
+			go h.auditEvent(db.MARKET_SPOT, AUDIT_EVENT_OUT_OF_SYNC, uuid, h.outOfSyncSpotStrategy)
+		case uuid := <-h.spotEventsCh.Reset:
+			go h.auditEvent(db.MARKET_SPOT, AUDIT_EVENT_RESET, uuid, h.resetSpotStrategy)
+
+		// Pair strategies
+		case uuid := <-h.pairEventsCh.Enable:
+			go h.auditEvent(db.MARKET_PAIR, AUDIT_EVENT_ENABLE, uuid, h.enablePairStrategy)
+		case uuid := <-h.pairEventsCh.Disable:
+			go h.auditEvent(db.MARKET_PAIR, AUDIT_EVENT_DISABLE, uuid, h.disablePairStrategy)
+		case uuid := <-h.pairEventsCh.OutOfSync:
+			go h.auditEvent(db.MARKET_PAIR, AUDIT_EVENT_OUT_OF_SYNC, uuid, h.outOfSyncPairStrategy)
+		case uuid := <-h.pairEventsCh.Reset:
+			go h.auditEvent(db.MARKET_PAIR, AUDIT_EVENT_RESET, uuid, h.resetPairStrategy)
 		}
 	}
 }
@@ -114,6 +225,7 @@ func (h *runnerHandler) start() {
 	h.newSender()
 
 	go h.listenEvents()
+	go h.paperMarket.Run()
 
 	// Get enabled contract strategies
 	contractStrategies, _, err := h.db.GetEnabledContractStrategies()
@@ -121,16 +233,27 @@ func (h *runnerHandler) start() {
 		h.logger.Fatal("err:", err)
 	}
 
+	// Strategies of the same user on the same exchange are reconciled with one snapshot
+	snapshots := make(snapshotCache)
 	for _, cs := range contractStrategies {
 		user, err := h.getAndSetUserMap(cs.UserUuid)
 		if err != nil {
 			continue
 		}
-		h.startContractStrategyRunner(cs, user)
+		h.startContractStrategyRunner(cs, user, snapshots)
 
 		// NOTE avoid sening too many messages at a time
 		time.Sleep(time.Millisecond * 100)
 	}
+
+	// Get enabled spot strategies
+	h.startSpotStrategyRunners()
+
+	// Get enabled pair strategies
+	h.startPairStrategyRunners()
+
+	// Check positions and stop-loss orders on the exchange periodically
+	go h.reconcilePeriodically()
 }
 
 // NOTE FIXME Getting data from DB every time could make performance issue in the future
@@ -147,8 +270,8 @@ func (h *runnerHandler) getAndSetUserMap(uuid string) (*db.User, error) {
 
 // NOTE Do not pass pointer of db.ContractStrategy because the last step of goroutine could be overriden by the next one in the loop
 //      and causes 2 runnes for the same strategy
-func (h *runnerHandler) startContractStrategyRunner(cs db.ContractStrategy, user *db.User) (err error) {
-	if err = h.newContractStrategyRunner(&cs, user); err != nil {
+func (h *runnerHandler) startContractStrategyRunner(cs db.ContractStrategy, user *db.User, snapshots snapshotCache) (err error) {
+	if err = h.newContractStrategyRunner(&cs, user, snapshots); err != nil {
 		h.logger.Printf("[ERROR] strategy: '%s', user: '%s', symbol: '%s', err: %v\n", cs.Uuid, cs.UserUuid, cs.Symbol, err)
 
 		// Disable the contract strategy
@@ -160,7 +283,7 @@ func (h *runnerHandler) startContractStrategyRunner(cs db.ContractStrategy, user
 	return nil
 }
 
-func (h *runnerHandler) newContractStrategyRunner(cs *db.ContractStrategy, user *db.User) error {
+func (h *runnerHandler) newContractStrategyRunner(cs *db.ContractStrategy, user *db.User, snapshots snapshotCache) error {
 	r, err := runner.NewContractStrategyRunner(cs)
 	if err != nil {
 		return fmt.Errorf("Failed to new contract strategy runner, err: %v", err)
@@ -171,17 +294,24 @@ func (h *runnerHandler) newContractStrategyRunner(cs *db.ContractStrategy, user
 	r.SetHandlerBlockWg(&h.blockWg)
 	r.SetHandlerEventsCh(&h.eventsCh)
 
-	// New exchange client and set to the hook
-	if err = h.newExchangeUserMap(cs.Exchange, user); err != nil {
+	// Marks come from ws of the exchange
+	key := newMarkKey(cs.Exchange, cs.Symbol)
+	if err := h.checkWsExchange(key); err != nil {
 		return err
 	}
-	r.SetExchangeForHook(h.exchangeUserMap[user.Uuid])
 
-	// Set symbolEntryTakenMutex
-	if h.symbolEntryTakenMutex[cs.UserUuid] == nil {
-		h.symbolEntryTakenMutex[cs.UserUuid] = new(sync.Mutex)
+	// New exchange client and set to the hook, alert-only strategy doesn't need it
+	var ex exchange.Exchanger
+	if cs.Execution != db.EXECUTION_ALERT_ONLY {
+		if ex, err = h.getExchangeByUser(cs.Exchange, user); err != nil {
+			return err
+		}
+		r.SetExchangeForHook(ex)
 	}
-	r.SetSymbolEntryTakenMutexForHook(h.symbolEntryTakenMutex)
+
+	// Set executor of the user
+	r.SetExecutorForHook(h.getExecutor(cs.UserUuid))
+	r.SetRiskManagerForHook(h.riskManager)
 
 	// Set user for hook
 	r.SetUser(user)
@@ -189,14 +319,68 @@ func (h *runnerHandler) newContractStrategyRunner(cs *db.ContractStrategy, user
 	// Set sender for contract strategy runner and hook
 	r.SetSender(h.sender)
 
+	// Adopt the order placed but not saved before the process died
+	// NOTE The runner isn't in the map yet, so the status is changed here instead of via event channel
+	if err := r.RecoverPendingOrder(); err != nil {
+		h.flagContractStrategyUnknown(r, user, fmt.Sprintf("[錯誤] '%s %s' 無法確認未記錄的訂單, 請手動確認", order.TranslateSideByInt(cs.Side), cs.Symbol))
+		return fmt.Errorf("Failed to recover pending order, err: %v", err)
+	}
+
+	// Don't trust DB state blindly, compare it with the exchange before receiving marks
+	if ex != nil {
+		if err := h.reconcileContractStrategy(r, ex, user, snapshots); err != nil {
+			return err
+		}
+	}
+
 	// Manage contract strategy channel
-	h.addIntoRunnersBySymbolMap(cs.Symbol, cs.Uuid, r)
+	h.addIntoRunnersBySymbolMap(key, cs.Uuid, r)
 	h.addIntoRunnerByUuidMap(cs.Uuid, r)
 
 	go r.Run()
 	return nil
 }
 
+// NOTE Only opened position is compared, see runner.Reconcile
+func (h *runnerHandler) reconcileContractStrategy(r *runner.ContractStrategyRunner, ex exchange.Exchanger, user *db.User, snapshots snapshotCache) error {
+	cs := r.ContractStrategy
+	if contract.Status(cs.PositionStatus) != contract.OPENED {
+		return nil
+	}
+
+	// It isn't out of sync if the exchange can't be reached, carry on and let the user know
+	snapshot, err := snapshots.get(ex, cs.Exchange, cs.UserUuid)
+	if err != nil {
+		h.logger.Printf("[Warn] strategy: '%s', user: '%s', symbol: '%s', failed to reconcile, err: %v\n", cs.Uuid, cs.UserUuid, cs.Symbol, err)
+		text := fmt.Sprintf("[提示] '%s %s' 無法與 %s 對帳, err: %v", order.TranslateSideByInt(cs.Side), cs.Symbol, cs.Exchange, err)
+		go h.sender.Send(user.TelegramChatId, text)
+		return nil
+	}
+
+	report := r.Reconcile(snapshot, true)
+	if len(report.Diffs) == 0 {
+		return nil
+	}
+	text := fmt.Sprintf("[錯誤] '%s %s' 與 %s 資料不一致, 已停用並設為 UNKNOWN, 請手動確認:\n- %s", order.TranslateSideByInt(cs.Side), cs.Symbol, cs.Exchange, strings.Join(report.Diffs, "\n- "))
+	h.flagContractStrategyUnknown(r, user, text)
+	return fmt.Errorf("out of sync with exchange, diffs: %v", report.Diffs)
+}
+
+// Disable the strategy and change the status to 'UNKNOWN' before the runner is started, the open trade is closed as
+// the position is left to the user
+func (h *runnerHandler) flagContractStrategyUnknown(r *runner.ContractStrategyRunner, user *db.User, text string) {
+	cs := r.ContractStrategy
+	data := map[string]interface{}{
+		"enabled":         0,
+		"position_status": int64(contract.UNKNOWN),
+	}
+	if _, err := h.db.UpdateContractStrategy(cs.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] strategy: '%s', user: '%s', symbol: '%s', failed to change status, err: %v\n", cs.Uuid, cs.UserUuid, cs.Symbol, err)
+	}
+	r.RecordExit(db.TRADE_EXIT_UNKNOWN)
+	go h.sender.Send(user.TelegramChatId, text)
+}
+
 func (h *runnerHandler) newSender() {
 	data := map[string]interface{}{
 		"token": viper.Get("TELEGRAM_TOKEN"),
@@ -208,59 +392,220 @@ func (h *runnerHandler) newSender() {
 	h.sender = sender
 }
 
+func newPaperMarket() *paper.Market {
+	config := paper.Config{
+		SlippagePercent: decimal.NewFromFloat(viper.GetFloat64("PAPER_SLIPPAGE_PERCENT")),
+		MakerFee:        decimal.NewFromFloat(viper.GetFloat64("PAPER_MAKER_FEE")),
+		TakerFee:        decimal.NewFromFloat(viper.GetFloat64("PAPER_TAKER_FEE")),
+		InitialBalance:  decimal.NewFromFloat(viper.GetFloat64("PAPER_INITIAL_BALANCE")),
+	}
+	return paper.NewMarket(config)
+}
+
+// NOTE Users without exchange credentials are skipped e.g. users who only have alert-only strategies
 func (h *runnerHandler) newExchangeUserMap(name string, user *db.User) error {
+	var ex exchange.Exchanger
+	var err error
 	switch name {
-	case "FTX":
-		ex, err := exchange.NewExchange(viper.GetString("DEFAULT_EXCHANGE"), user.ExchangeApiKey)
+	case "PAPER":
+		// Paper trading doesn't need api key, balances and positions are simulated
+		ex, err = h.paperMarket.Account(user.Uuid)
 		if err != nil {
-			return fmt.Errorf("Failed to new exchange, err: %v", err)
+			return fmt.Errorf("Failed to get paper account, err: %v", err)
 		}
-		// NOTE don't check if it exists, otherwise it won't get updated if api info changed
-		h.exchangeUserMap[user.Uuid] = ex
 	default:
-		return fmt.Errorf("exchange '%s' not supported", name)
+		if user.ExchangeApiKey == "" {
+			return nil
+		}
+		// NOTE don't check if it exists, otherwise it won't get updated if api info changed
+		// NOTE the api key of the exchange is looked up by name, it fails if the exchange isn't supported
+		ex, err = exchange.NewExchange(name, user.ExchangeApiKey)
+		if err != nil {
+			return fmt.Errorf("Failed to new exchange, err: %v", err)
+		}
+		if m, ok := ex.(exchange.SymbolMapper); ok {
+			m.SetSymbolMap(h.getSymbolMap(name))
+		}
 	}
+	h.exchangeUserMap.Store(name+":"+user.Uuid, exchange.NewBreakerExchanger(ex, h.getCircuitBreaker(name, user)))
 	return nil
 }
 
-func (h *runnerHandler) stopContractStrategyRunner(symbol string, strategyUuid string) {
-	h.removeFromRunnersBySymbolMap(symbol, strategyUuid)
+// Circuit breaker of the account, it's kept when the exchange client is renewed
+func (h *runnerHandler) getCircuitBreaker(name string, user *db.User) *exchange.CircuitBreaker {
+	key := name + ":" + user.Uuid
+	if cb, ok := h.circuitBreakerMap.Load(key); ok {
+		return cb.(*exchange.CircuitBreaker)
+	}
+
+	threshold := viper.GetInt("CIRCUIT_BREAKER_FAILURES")
+	if threshold <= 0 {
+		threshold = 5
+	}
+	cooldown := viper.GetInt64("CIRCUIT_BREAKER_COOLDOWN_SECONDS")
+	if cooldown <= 0 {
+		cooldown = 60
+	}
+	chatId := user.TelegramChatId
+	cb := exchange.NewCircuitBreaker(threshold, time.Second*time.Duration(cooldown), func(open bool, failures int, err error) {
+		var text string
+		if open {
+			h.logger.Printf("[Warn] circuit breaker of '%s' user '%s' is open, err: %v", name, user.Uuid, err)
+			text = fmt.Sprintf("[警告] %s API 連續失敗 %d 次, 暫停開倉, 停損及平倉仍會繼續重試\nerr: %v", name, failures, err)
+		} else {
+			h.logger.Printf("[Info] circuit breaker of '%s' user '%s' is closed", name, user.Uuid)
+			text = fmt.Sprintf("[提示] %s API 已恢復, 恢復開倉", name)
+		}
+		go h.sender.Send(chatId, text)
+	})
+	actual, _ := h.circuitBreakerMap.LoadOrStore(key, cb)
+	return actual.(*exchange.CircuitBreaker)
+}
+
+// Executor of the user, it's started when it's created
+func (h *runnerHandler) getExecutor(userUuid string) *runner.Executor {
+	e, loaded := h.executorByUserMap.LoadOrStore(userUuid, runner.NewExecutor())
+	if !loaded {
+		go e.(*runner.Executor).Run()
+	}
+	return e.(*runner.Executor)
+}
+
+// Get exchange client of the user, fail if the user doesn't have exchange credentials
+// NOTE Every strategy trades on PAPER while replaying, so that no real order is placed at the replayed prices
+func (h *runnerHandler) getExchangeByUser(name string, user *db.User) (exchange.Exchanger, error) {
+	if viper.GetString("REPLAY_PATH") != "" {
+		name = "PAPER"
+	}
+	if err := h.newExchangeUserMap(name, user); err != nil {
+		return nil, err
+	}
+	ex, ok := h.exchangeUserMap.Load(name + ":" + user.Uuid)
+	if !ok {
+		return nil, fmt.Errorf("user '%s' doesn't have %s api key", user.Uuid, name)
+	}
+	return ex.(exchange.Exchanger), nil
+}
+
+func (h *runnerHandler) stopContractStrategyRunner(exchangeName string, symbol string, strategyUuid string) {
+	h.removeFromRunnersBySymbolMap(newMarkKey(exchangeName, symbol), strategyUuid)
 	h.removeFromRunnerByUuidMap(strategyUuid)
 }
 
-func (h *runnerHandler) addIntoRunnersBySymbolMap(symbol string, strategyUuid string, r *runner.ContractStrategyRunner) {
+// Add the runner of any kind of strategy into runnersBySymbolMap
+func (h *runnerHandler) addIntoRunnersBySymbolMap(key markKey, strategyUuid string, r markReceiver) {
 	h.runnersBySymbolMutex.Lock()
 	defer h.runnersBySymbolMutex.Unlock()
 
-	list, ok := h.runnersBySymbolMap[symbol]
-	if ok {
-		list[strategyUuid] = r
-	} else {
+	list, ok := h.runnersBySymbolMap[key]
+	if !ok {
 		// If the key doesn't exist before, create one
-		newList := make(map[string]*runner.ContractStrategyRunner)
-		newList[strategyUuid] = r
-		list = newList
+		list = make(map[string]markReceiver)
+	}
+	if _, ok := list[strategyUuid]; !ok {
+		h.refSymbol(key)
 	}
-	h.runnersBySymbolMap[symbol] = list
+	list[strategyUuid] = r
+	h.runnersBySymbolMap[key] = list
 }
 
-// Remove contract strategy from runnersBySymbolMap
-func (h *runnerHandler) removeFromRunnersBySymbolMap(symbol string, strategyUuid string) {
+// Remove the runner of any kind of strategy from runnersBySymbolMap
+func (h *runnerHandler) removeFromRunnersBySymbolMap(key markKey, strategyUuid string) {
 	h.runnersBySymbolMutex.Lock()
 	defer h.runnersBySymbolMutex.Unlock()
 
-	list, ok := h.runnersBySymbolMap[symbol]
+	list, ok := h.runnersBySymbolMap[key]
 	if ok {
+		if _, ok := list[strategyUuid]; ok {
+			h.unrefSymbol(key)
+		}
 		delete(list, strategyUuid)
 	}
-	if len(list) > 0 {
-		h.runnersBySymbolMap[symbol] = list
-	} else {
+	if len(list) == 0 {
 		// If there is no item in the key, remove the key from the map
-		delete(h.runnersBySymbolMap, symbol)
+		delete(h.runnersBySymbolMap, key)
 	}
 }
 
+// Subscribe the symbol on ws of the exchange when it's referenced by the first runner
+func (h *runnerHandler) refSymbol(key markKey) {
+	h.symbolRefsMutex.Lock()
+	defer h.symbolRefsMutex.Unlock()
+
+	h.symbolRefs[key]++
+	ws, ok := h.wsExchangeMap[key.exchange]
+	if h.symbolRefs[key] == 1 && ok {
+		if err := ws.Subscribe(key.symbol); err != nil {
+			h.logger.Printf("[ERROR] failed to subscribe '%s' of %s, err: %v", key.symbol, key.exchange, err)
+		}
+	}
+}
+
+// Unsubscribe the symbol on ws of the exchange when there is no runner of it
+func (h *runnerHandler) unrefSymbol(key markKey) {
+	h.symbolRefsMutex.Lock()
+	defer h.symbolRefsMutex.Unlock()
+
+	h.symbolRefs[key]--
+	if h.symbolRefs[key] > 0 {
+		return
+	}
+	delete(h.symbolRefs, key)
+	if ws, ok := h.wsExchangeMap[key.exchange]; ok {
+		if err := ws.Unsubscribe(key.symbol); err != nil {
+			h.logger.Printf("[ERROR] failed to unsubscribe '%s' of %s, err: %v", key.symbol, key.exchange, err)
+		}
+	}
+}
+
+// Subscribe the symbols of the exchange referenced before ws is connected
+func (h *runnerHandler) setWsExchange(name string, ws exchange.WsExchanger) {
+	h.symbolRefsMutex.Lock()
+	defer h.symbolRefsMutex.Unlock()
+
+	h.wsExchangeMap[name] = ws
+	for key := range h.symbolRefs {
+		if key.exchange != name {
+			continue
+		}
+		if err := ws.Subscribe(key.symbol); err != nil {
+			h.logger.Printf("[ERROR] failed to subscribe '%s' of %s, err: %v", key.symbol, key.exchange, err)
+		}
+	}
+}
+
+// Make sure the marks of the strategy will come, ws of the exchange is connected later when the engine boots
+// NOTE The symbol map is reloaded, so that the symbol added after the engine boots can be subscribed
+func (h *runnerHandler) checkWsExchange(key markKey) error {
+	for _, name := range wsExchangeNames() {
+		if name == key.exchange {
+			return h.reloadSymbolMap(name)
+		}
+	}
+	return fmt.Errorf("ws of exchange '%s' isn't connected, see WS_EXCHANGES", key.exchange)
+}
+
+func (h *runnerHandler) getSymbolMap(name string) *symbols.Map {
+	m, _ := h.symbolMapByExchange.LoadOrStore(name, symbols.NewMap(nil))
+	return m.(*symbols.Map)
+}
+
+// Load symbols of the exchange that have a different name on the exchange
+func (h *runnerHandler) reloadSymbolMap(name string) error {
+	rows, _, err := h.db.GetEnabledSymbolsByExchange(name)
+	if err != nil {
+		return fmt.Errorf("Failed to get symbols of '%s', err: %v", name, err)
+	}
+	m := make(map[string]string)
+	for _, s := range rows {
+		if s.ExchangeSymbol != "" {
+			m[s.Name] = s.ExchangeSymbol
+		}
+	}
+	h.getSymbolMap(name).Set(m)
+	return nil
+}
+
 func (h *runnerHandler) addIntoRunnerByUuidMap(strategyUuid string, r *runner.ContractStrategyRunner) {
 	h.runnerByUuidMap.Store(strategyUuid, r)
 }
@@ -273,33 +618,48 @@ func (h *runnerHandler) removeFromRunnerByUuidMap(strategyUuid string) {
 }
 
 func (h *runnerHandler) stopAll() {
+	close(h.reconcileStopCh)
+
 	// Send stop signal to each contract strategy runner
 	h.runnerByUuidMap.Range(func(_, value interface{}) bool {
 		r := value.(*runner.ContractStrategyRunner)
 		r.Stop()
 		return true
 	})
+	h.stopAllSpot()
+	h.stopAllPair()
 
 	// Wait until everything in progress has been completed
 	h.blockWg.Wait()
 
+	// Nothing will be submitted by strategies anymore
+	h.executorByUserMap.Range(func(_, value interface{}) bool {
+		value.(*runner.Executor).Stop()
+		return true
+	})
+
+	// Save the paper accounts changed by the orders above
+	h.paperMarket.Stop()
+
 	// Make sure all strategies have been stopped then stop listening events
 	h.eventsStopCh <- true
 	<-h.eventsStopCh
 }
 
-func (h *runnerHandler) broadcastMark(symbol string, mark contract.Mark) {
+// NOTE It's called by ws of each exchange concurrently
+func (h *runnerHandler) broadcastMark(exchangeName string, symbol string, mark contract.Mark) {
+	key := markKey{exchange: exchangeName, symbol: symbol}
+
+	// NOTE Fill the paper stop orders first, so that runners will see them filled like the real exchange
+	if key == newMarkKey("PAPER", symbol) {
+		h.paperMarket.UpdateMark(symbol, mark.Price)
+	}
+
 	h.runnersBySymbolMutex.RLock()
 	defer h.runnersBySymbolMutex.RUnlock()
 
-	runners, ok := h.runnersBySymbolMap[symbol]
-	if ok {
-		for _, r := range runners {
-			// NOTE Check this first, otherwise it might block ws handler due to no receiver if StopCh has been closed
-			if r.CheckPriceEnabled {
-				r.MarkCh <- mark
-			}
-		}
+	for _, r := range h.runnersBySymbolMap[key] {
+		r.SendMark(symbol, mark)
 	}
 }
 
@@ -337,7 +697,7 @@ func (h *runnerHandler) enableContractStrategy(uuid string) {
 	}
 
 	// Start and new contract strategy runner
-	if err := h.startContractStrategyRunner(*cs, user); err != nil {
+	if err := h.startContractStrategyRunner(*cs, user, nil); err != nil {
 		h.logger.Printf("[ERROR] enableContractStrategy strategy: '%s', user: '%s', symbol: '%s', err: %v", cs.Uuid, cs.UserUuid, cs.Symbol, err)
 		text := fmt.Sprintf("[Error] '%s %s' Internal Server Error. Please disable your strategy", order.TranslateSideByInt(cs.Side), cs.Symbol)
 		go h.sender.Send(user.TelegramChatId, text)
@@ -354,6 +714,9 @@ func (h *runnerHandler) disableContractStrategy(uuid string) {
 		return
 	}
 
+	// Don't wait for the retries of the check in progress
+	r.(*runner.ContractStrategyRunner).CancelCheck()
+
 	// Block until finished
 	r.(*runner.ContractStrategyRunner).RunnerBlockWg.Add(1)
 	defer r.(*runner.ContractStrategyRunner).RunnerBlockWg.Done()
@@ -456,6 +819,7 @@ func (h *runnerHandler) resetContractStrategy(uuid string) {
 		"enabled":                 0,
 		"position_status":         int64(contract.CLOSED),
 		"exchange_orders_details": datatypes.JSONMap{},
+		"pending_client_order_id": "",
 	}
 	if _, err := h.db.UpdateContractStrategy(cs.Uuid, data); err != nil {
 		h.logger.Printf("[ERROR] resetContractStrategy strategy: '%s', user: '%s', symbol: '%s', err: %v", cs.Uuid, cs.UserUuid, cs.Symbol, err)
@@ -464,9 +828,135 @@ func (h *runnerHandler) resetContractStrategy(uuid string) {
 		return
 	}
 
+	r.(*runner.ContractStrategyRunner).RecordExit(db.TRADE_EXIT_RESET)
 	r.(*runner.ContractStrategyRunner).Stop()
 
 	h.logger.Printf("[Info] strategy: '%s', user: '%s', symbol: '%s' has been reset", cs.Uuid, cs.UserUuid, cs.Symbol)
 	text := fmt.Sprintf("[提示] 已重置 '%s %s'", order.TranslateSideByInt(cs.Side), cs.Symbol)
 	h.sender.Send(user.(*db.User).TelegramChatId, text)
 }
+
+// Reload the strategy from DB without stopping the runner, the position opened is kept, the params changed are returned for the audit
+// NOTE If the changes are rejected, the running values are written back to DB, so that DB keeps matching the position
+func (h *runnerHandler) restartContractStrategy(uuid string) datatypes.JSONMap {
+	r, ok := h.runnerByUuidMap.Load(uuid)
+	if !ok {
+		h.logger.Printf("[Error] restartContractStrategy - strategy '%s' isn't in the map", uuid)
+		return datatypes.JSONMap{}
+	}
+	cs, err := h.db.GetContractStrategyByUuid(uuid)
+	if err != nil {
+		h.logger.Printf("[Error] restartContractStrategy - strategy '%s' not found, err: %v", uuid, err)
+		return datatypes.JSONMap{}
+	}
+
+	// Get user data
+	user, ok := h.userMap.Load(cs.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] restartContractStrategy - user '%s' not found", cs.UserUuid)
+		return datatypes.JSONMap{}
+	}
+
+	// Params before reloading, for the audit
+	before := r.(*runner.ContractStrategyRunner).ReloadableFields()
+	if err = r.(*runner.ContractStrategyRunner).Reload(cs); err != nil {
+		h.logger.Printf("[Warn] restartContractStrategy strategy: '%s', user: '%s', symbol: '%s', rejected, err: %v", cs.Uuid, cs.UserUuid, cs.Symbol, err)
+		if _, err := h.db.UpdateContractStrategy(cs.Uuid, r.(*runner.ContractStrategyRunner).ReloadableFields()); err != nil {
+			h.logger.Printf("[ERROR] restartContractStrategy strategy: '%s', failed to write back, err: %v", cs.Uuid, err)
+		}
+		text := fmt.Sprintf("[錯誤] '%s %s' 無法更新參數, 已還原設定, err: %v", order.TranslateSideByInt(cs.Side), cs.Symbol, err)
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		return datatypes.JSONMap{}
+	}
+
+	h.logger.Printf("[Info] strategy: '%s', user: '%s', symbol: '%s' has been reloaded", cs.Uuid, cs.UserUuid, cs.Symbol)
+	text := fmt.Sprintf("[提示] '%s %s' 已更新參數", order.TranslateSideByInt(cs.Side), cs.Symbol)
+	go h.sender.Send(user.(*db.User).TelegramChatId, text)
+	return runner.ReloadDiff(before, r.(*runner.ContractStrategyRunner).ReloadableFields())
+}
+
+// Disable the other strategies in the same group of the strategy that has taken the entry
+func (h *runnerHandler) disableGroupMembers(uuid string) {
+	cs, err := h.db.GetContractStrategyByUuid(uuid)
+	if err != nil {
+		h.logger.Printf("[Error] disableGroupMembers - strategy '%s' not found", uuid)
+		return
+	}
+	if cs.GroupUuid == "" {
+		return
+	}
+
+	members, _, err := h.db.GetContractStrategiesByGroupUuid(cs.GroupUuid, cs.Uuid)
+	if err != nil {
+		h.logger.Printf("[ERROR] disableGroupMembers strategy: '%s', group: '%s', err: %v", cs.Uuid, cs.GroupUuid, err)
+		return
+	}
+
+	for _, m := range members {
+		if m.Enabled == 0 {
+			continue
+		}
+		// NOTE Shouldn't happen as entry is refused when any member has opened the position
+		//      , but don't leave the opened position unmanaged anyway
+		if contract.Status(m.PositionStatus) != contract.CLOSED {
+			h.logger.Printf("[Warn] disableGroupMembers strategy: '%s', group: '%s', member '%s' is '%s', skipped", cs.Uuid, cs.GroupUuid, m.Uuid, contract.TranslateStatusByInt(m.PositionStatus))
+			continue
+		}
+
+		if _, ok := h.runnerByUuidMap.Load(m.Uuid); ok {
+			h.disableContractStrategy(m.Uuid)
+		} else {
+			// Runner isn't running, disable it in DB only
+			data := map[string]interface{}{
+				"enabled": 0,
+			}
+			if _, err := h.db.UpdateContractStrategy(m.Uuid, data); err != nil {
+				h.logger.Printf("[ERROR] disableGroupMembers strategy: '%s', group: '%s', member: '%s', err: %v", cs.Uuid, cs.GroupUuid, m.Uuid, err)
+				continue
+			}
+		}
+
+		user, ok := h.userMap.Load(m.UserUuid)
+		if ok {
+			text := fmt.Sprintf("[提示] '%s %s' 同群組策略已開倉, 已停用 '%s %s'", order.TranslateSideByInt(cs.Side), cs.Symbol, order.TranslateSideByInt(m.Side), m.Symbol)
+			go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		}
+	}
+}
+
+func (h *runnerHandler) handleOutcome(outcome strategy.Outcome) {
+	// Disable the other strategies of the group
+	if outcome.Event == strategy.OUTCOME_ENTRY {
+		h.disableGroupMembers(outcome.Uuid)
+	}
+
+	// Enable the chained strategies
+	h.enableTriggeredStrategies(outcome.Uuid, outcome.Event)
+}
+
+func (h *runnerHandler) enableTriggeredStrategies(uuid string, event string) {
+	triggersOn, _, err := h.db.GetEnabledTriggersOnBySourceByEvent(uuid, event)
+	if err != nil {
+		h.logger.Printf("[ERROR] enableTriggeredStrategies strategy: '%s', event: '%s', err: %v", uuid, event, err)
+		return
+	}
+
+	for _, t := range triggersOn {
+		cs, err := h.db.GetContractStrategyByUuid(t.TargetUuid)
+		if err != nil {
+			h.logger.Printf("[Error] enableTriggeredStrategies - target strategy '%s' not found", t.TargetUuid)
+			continue
+		}
+		if _, ok := h.runnerByUuidMap.Load(cs.Uuid); ok {
+			h.logger.Printf("[Warn] enableTriggeredStrategies strategy: '%s', event: '%s', target '%s' is already enabled, skipped", uuid, event, cs.Uuid)
+			continue
+		}
+		h.eventsCh.Enable <- cs.Uuid
+
+		user, ok := h.userMap.Load(cs.UserUuid)
+		if ok {
+			text := fmt.Sprintf("[提示] 觸發 '%s', 已啟用 '%s %s'", event, order.TranslateSideByInt(cs.Side), cs.Symbol)
+			go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package runner
+
+import (
+	"context"
+	"crypto-trading-bot-engine/strategy"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+)
+
+// Hook for strategies with execution 'alert_only'
+// It runs the same state machine as contractHook, but only sends the notifications without touching the exchange
+// NOTE Methods that don't touch the exchange e.g. ParamsUpdated, BreakoutPeakUpdated are inherited from contractHook
+type alertHook struct {
+	*contractHook
+}
+
+func newAlertHook(ch *contractHook) *alertHook {
+	return &alertHook{
+		contractHook: ch,
+	}
+}
+
+// NOTE The context-aware variants of contractHook must be overridden too, otherwise they would place the orders
+func (ah *alertHook) EntryTriggeredContext(_ context.Context, c *contract.Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	return ah.EntryTriggered(c, t, p)
+}
+
+func (ah *alertHook) StopLossTriggerCreatedContext(_ context.Context, c *contract.Contract) (bool, error) {
+	return ah.StopLossTriggerCreated(c)
+}
+
+func (ah *alertHook) StopLossTriggeredContext(_ context.Context, c *contract.Contract, p decimal.Decimal) (bool, error) {
+	return ah.StopLossTriggered(c, p)
+}
+
+func (ah *alertHook) TakeProfitTriggeredContext(_ context.Context, c *contract.Contract, p decimal.Decimal) error {
+	return ah.TakeProfitTriggered(c, p)
+}
+
+func (ah *alertHook) EntryTriggered(c *contract.Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	// Only one strategy of the group can take the entry
+	if ah.contractStrategy.GroupUuid != "" {
+		taken, err := ah.groupEntryTaken()
+		if err != nil {
+			return p, false, fmt.Errorf("EntryTriggered - failed to get strategies of group, err: %v", err)
+		}
+		if taken {
+			ah.notify("[提示] '%s %s' 同群組策略已開倉, 停用此策略", order.TranslateSideByInt(ah.contractStrategy.Side), ah.contractStrategy.Symbol)
+			return p, true, nil
+		}
+	}
+
+	// Notification
+	ah.notify("[提醒] '%s %s' 已觸發進場 @%s", order.TranslateSideByInt(ah.contractStrategy.Side), ah.contractStrategy.Symbol, p.String())
+
+	// For memory data
+	// NOTE There is no order on the exchange, 'order_id' is kept to be consistent with contractHook
+	ah.contractStrategy.PositionStatus = int64(contract.OPENED)
+	ah.contractStrategy.ExchangeOrdersDetails = datatypes.JSONMap{
+		"entry_order": map[string]interface{}{
+			"order_id": float64(0),
+			"price":    p.String(),
+			"size":     ah.contractStrategy.Margin.DivRound(p, 8).String(),
+		},
+	}
+	ah.contractStrategy.LastPositionAt = time.Now()
+
+	// For DB
+	contractStrategy := map[string]interface{}{
+		"position_status":         ah.contractStrategy.PositionStatus,
+		"exchange_orders_details": ah.contractStrategy.ExchangeOrdersDetails,
+		"last_position_at":        ah.contractStrategy.LastPositionAt,
+	}
+	if _, err := ah.db.UpdateContractStrategy(ah.contractStrategy.Uuid, contractStrategy); err != nil {
+		ah.notify("[Error] '%s %s' Internal Server Error. Please reset your strategy", order.TranslateSideByInt(ah.contractStrategy.Side), ah.contractStrategy.Symbol)
+		return p, true, fmt.Errorf("EntryTriggered - failed to update 'exchange_orders_details', err: %v", err)
+	}
+
+	ah.sendOutcome(strategy.OUTCOME_ENTRY)
+	return p, false, nil
+}
+
+func (ah *alertHook) StopLossTriggerCreated(c *contract.Contract) (bool, error) {
+	p := c.StopLossOrder.(*order.StopLoss).Trigger.GetPrice(time.Now())
+	ah.notify("[提醒] '%s %s' 停損價 @%s", order.TranslateSideByInt(ah.contractStrategy.Side), ah.contractStrategy.Symbol, p)
+	return false, nil
+}
+
+func (ah *alertHook) StopLossTriggered(c *contract.Contract, p decimal.Decimal) (bool, error) {
+	ah.notify("[提醒] '%s %s' 已觸發停損 @%s", order.TranslateSideByInt(ah.contractStrategy.Side), ah.contractStrategy.Symbol, p.String())
+
+	// Reset status and exchange_orders_details
+	contractStrategy := map[string]interface{}{
+		"position_status":         int64(contract.CLOSED),
+		"exchange_orders_details": datatypes.JSONMap{},
+	}
+	if _, err := ah.db.UpdateContractStrategy(ah.contractStrategy.Uuid, contractStrategy); err != nil {
+		ah.notify("[錯誤] '%s %s' Internal Server Error. Please reset your strategy", order.TranslateSideByInt(ah.contractStrategy.Side), ah.contractStrategy.Symbol)
+		return true, fmt.Errorf("StopLossTriggered - failed to update 'position_status', err: %v", err)
+	}
+
+	// Update memory data
+	ah.contractStrategy.PositionStatus = int64(contract.CLOSED)
+	ah.contractStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
+
+	ah.sendOutcome(strategy.OUTCOME_STOP_LOSS)
+	return false, nil
+}
+
+// NOTE Take-profit will always halt the strategy, DB data will be updated via event channel
+func (ah *alertHook) TakeProfitTriggered(c *contract.Contract, p decimal.Decimal) error {
+	ah.notify("[提醒] '%s %s' 已觸發停利 @%s", order.TranslateSideByInt(ah.contractStrategy.Side), ah.contractStrategy.Symbol, p.String())
+
+	// Update memory data
+	ah.contractStrategy.Enabled = 0
+	ah.contractStrategy.PositionStatus = int64(contract.CLOSED)
+	ah.contractStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
+
+	ah.sendOutcome(strategy.OUTCOME_TAKE_PROFIT)
+	return nil
+}
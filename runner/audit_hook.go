@@ -0,0 +1,222 @@
+package runner
+
+import (
+	"context"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+)
+
+// Record every callback of the wrapped hook into 'strategy_events', so that the changes e.g. trendline moved can be
+// explained from the data
+// NOTE It doesn't change the result of the callback, failing to record is logged only
+type auditHook struct {
+	hook   contract.Hooker
+	market string // contract, spot, pair
+	uuid   string
+	params func() map[string]interface{} // params of the strategy in memory
+
+	log *log.Logger
+	db  *db.DB
+
+	// Mark price being checked, for the callbacks without price e.g. ParamsUpdated
+	markPrice decimal.Decimal
+}
+
+func newAuditHook(hook contract.Hooker, market string, uuid string, params func() map[string]interface{}) *auditHook {
+	return &auditHook{
+		hook:   hook,
+		market: market,
+		uuid:   uuid,
+		params: params,
+	}
+}
+
+func (ah *auditHook) setLogger(l *log.Logger) {
+	ah.log = l
+}
+
+func (ah *auditHook) setDB(db *db.DB) {
+	ah.db = db
+}
+
+func (ah *auditHook) setMarkPrice(p decimal.Decimal) {
+	ah.markPrice = p
+}
+
+func (ah *auditHook) EntryTriggered(c *contract.Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	return ah.EntryTriggeredContext(context.Background(), c, t, p)
+}
+
+// NOTE Context-aware variants are passed along only if the wrapped hook implements them
+func (ah *auditHook) EntryTriggeredContext(ctx context.Context, c *contract.Contract, t time.Time, p decimal.Decimal) (price decimal.Decimal, halted bool, err error) {
+	before := ah.snapshot()
+	if h, ok := ah.hook.(contract.ContextHooker); ok {
+		price, halted, err = h.EntryTriggeredContext(ctx, c, t, p)
+	} else {
+		price, halted, err = ah.hook.EntryTriggered(c, t, p)
+	}
+	ah.record("EntryTriggered", p, before, hookOutcome(halted, err))
+	return price, halted, err
+}
+
+func (ah *auditHook) StopLossTriggerCreated(c *contract.Contract) (bool, error) {
+	return ah.StopLossTriggerCreatedContext(context.Background(), c)
+}
+
+func (ah *auditHook) StopLossTriggerCreatedContext(ctx context.Context, c *contract.Contract) (halted bool, err error) {
+	before := ah.snapshot()
+	if h, ok := ah.hook.(contract.ContextHooker); ok {
+		halted, err = h.StopLossTriggerCreatedContext(ctx, c)
+	} else {
+		halted, err = ah.hook.StopLossTriggerCreated(c)
+	}
+	ah.record("StopLossTriggerCreated", decimal.Zero, before, hookOutcome(halted, err))
+	return halted, err
+}
+
+func (ah *auditHook) StopLossTriggered(c *contract.Contract, p decimal.Decimal) (bool, error) {
+	return ah.StopLossTriggeredContext(context.Background(), c, p)
+}
+
+func (ah *auditHook) StopLossTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) (halted bool, err error) {
+	before := ah.snapshot()
+	if h, ok := ah.hook.(contract.ContextHooker); ok {
+		halted, err = h.StopLossTriggeredContext(ctx, c, p)
+	} else {
+		halted, err = ah.hook.StopLossTriggered(c, p)
+	}
+	ah.record("StopLossTriggered", p, before, hookOutcome(halted, err))
+	return halted, err
+}
+
+func (ah *auditHook) EntryTrendlineTriggerUpdated(c *contract.Contract) {
+	before := ah.snapshot()
+	ah.hook.EntryTrendlineTriggerUpdated(c)
+	ah.record("EntryTrendlineTriggerUpdated", decimal.Zero, before, hookOutcome(false, nil))
+}
+
+func (ah *auditHook) EntryTriggerOperatorUpdated(c *contract.Contract) {
+	before := ah.snapshot()
+	ah.hook.EntryTriggerOperatorUpdated(c)
+	ah.record("EntryTriggerOperatorUpdated", decimal.Zero, before, hookOutcome(false, nil))
+}
+
+func (ah *auditHook) TakeProfitTriggered(c *contract.Contract, p decimal.Decimal) error {
+	return ah.TakeProfitTriggeredContext(context.Background(), c, p)
+}
+
+func (ah *auditHook) TakeProfitTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) (err error) {
+	before := ah.snapshot()
+	if h, ok := ah.hook.(contract.ContextHooker); ok {
+		err = h.TakeProfitTriggeredContext(ctx, c, p)
+	} else {
+		err = ah.hook.TakeProfitTriggered(c, p)
+	}
+	// NOTE Take-profit always halts the strategy
+	ah.record("TakeProfitTriggered", p, before, hookOutcome(true, err))
+	return err
+}
+
+func (ah *auditHook) ParamsUpdated(c *contract.Contract) (bool, error) {
+	before := ah.snapshot()
+	halted, err := ah.hook.ParamsUpdated(c)
+	ah.record("ParamsUpdated", decimal.Zero, before, hookOutcome(halted, err))
+	return halted, err
+}
+
+func (ah *auditHook) BreakoutPeakUpdated(c *contract.Contract) {
+	before := ah.snapshot()
+	ah.hook.BreakoutPeakUpdated(c)
+	ah.record("BreakoutPeakUpdated", decimal.Zero, before, hookOutcome(false, nil))
+}
+
+// Params are marshalled, as the hooks replace or modify the map in place
+func (ah *auditHook) snapshot() []byte {
+	b, err := json.Marshal(ah.params())
+	if err != nil {
+		ah.log.Printf("[Warn] auditHook - strategy: '%s', failed to marshal params, err: %v", ah.uuid, err)
+	}
+	return b
+}
+
+func (ah *auditHook) record(event string, p decimal.Decimal, before []byte, outcome string) {
+	if p.IsZero() {
+		p = ah.markPrice
+	}
+	e := db.StrategyEvent{
+		StrategyUuid: ah.uuid,
+		Market:       ah.market,
+		Source:       db.STRATEGY_EVENT_SOURCE_HOOK,
+		Event:        event,
+		MarkPrice:    p,
+		ParamsDiff:   paramsDiff(before, ah.snapshot()),
+		Outcome:      outcome,
+	}
+	if _, _, err := ah.db.CreateStrategyEvent(e); err != nil {
+		ah.log.Printf("[Error] auditHook - strategy: '%s', event: '%s', failed to record, err: %v", ah.uuid, event, err)
+	}
+}
+
+func hookOutcome(halted bool, err error) string {
+	switch {
+	case err != nil && halted:
+		return fmt.Sprintf("halted, error: %v", err)
+	case err != nil:
+		return fmt.Sprintf("error: %v", err)
+	case halted:
+		return "halted"
+	}
+	return "ok"
+}
+
+// Compare params by path e.g. 'entry_order.trendline_trigger.price_1', only the changed ones are returned
+func paramsDiff(before []byte, after []byte) datatypes.JSONMap {
+	b := make(map[string]interface{})
+	a := make(map[string]interface{})
+	flattenParams("", unmarshalParams(before), b)
+	flattenParams("", unmarshalParams(after), a)
+
+	diff := datatypes.JSONMap{}
+	for path, v := range b {
+		if !reflect.DeepEqual(v, a[path]) {
+			diff[path] = map[string]interface{}{"before": v, "after": a[path]}
+		}
+	}
+	for path, v := range a {
+		if _, ok := b[path]; !ok {
+			diff[path] = map[string]interface{}{"before": nil, "after": v}
+		}
+	}
+	return diff
+}
+
+func unmarshalParams(b []byte) map[string]interface{} {
+	m := make(map[string]interface{})
+	if len(b) > 0 {
+		json.Unmarshal(b, &m)
+	}
+	return m
+}
+
+// Nested maps are flattened, the other values including arrays are compared as a whole
+func flattenParams(prefix string, m map[string]interface{}, out map[string]interface{}) {
+	for k, v := range m {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenParams(path, nested, out)
+			continue
+		}
+		out[path] = v
+	}
+}
@@ -0,0 +1,43 @@
+package runner
+
+import (
+	"testing"
+)
+
+func TestParamsDiff(t *testing.T) {
+	before := []byte(`{"entry_type": "trendline", "entry_order": {"trendline_trigger": {"price_1": 100, "price_2": 110}, "trendline_offset_percent": 0.01}}`)
+	after := []byte(`{"entry_type": "trendline", "entry_order": {"trendline_trigger": {"price_1": 100, "price_2": 120}}, "breakout_peak": {"price": "130"}}`)
+
+	diff := paramsDiff(before, after)
+	expected := map[string][2]interface{}{
+		"entry_order.trendline_trigger.price_2": {float64(110), float64(120)},
+		"entry_order.trendline_offset_percent":  {0.01, nil},
+		"breakout_peak.price":                   {nil, "130"},
+	}
+	if len(diff) != len(expected) {
+		t.Fatalf("TestParamsDiff - expect %d diffs, but got %v", len(expected), diff)
+	}
+	for path, e := range expected {
+		d, ok := diff[path].(map[string]interface{})
+		if !ok {
+			t.Errorf("TestParamsDiff - expect '%s' in diff, but got %v", path, diff)
+			continue
+		}
+		if d["before"] != e[0] || d["after"] != e[1] {
+			t.Errorf("TestParamsDiff '%s' - expect %v -> %v, but got %v -> %v", path, e[0], e[1], d["before"], d["after"])
+		}
+	}
+
+	if diff := paramsDiff(before, before); len(diff) != 0 {
+		t.Errorf("TestParamsDiff - expect no diff, but got %v", diff)
+	}
+}
+
+func TestHookOutcome(t *testing.T) {
+	if o := hookOutcome(false, nil); o != "ok" {
+		t.Errorf("TestHookOutcome - expect 'ok', but got '%s'", o)
+	}
+	if o := hookOutcome(true, nil); o != "halted" {
+		t.Errorf("TestHookOutcome - expect 'halted', but got '%s'", o)
+	}
+}
@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"context"
+	"time"
+)
+
+// Keep the values of the parent, but it isn't cancelled with the parent
+// It's for the safety-critical steps e.g. placing stop-loss order for the opened position, closing position, they're
+// bounded by the deadline of retry policies instead
+type detachedContext struct {
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
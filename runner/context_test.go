@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDetach(t *testing.T) {
+	type ctxKey struct{}
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	ctx := detach(parent)
+	cancel()
+
+	if parent.Err() == nil {
+		t.Fatal("TestDetach - expect parent to be cancelled")
+	}
+	if ctx.Err() != nil {
+		t.Errorf("TestDetach - expect detached context not to be cancelled, but got '%v'", ctx.Err())
+	}
+	if ctx.Value(ctxKey{}) != "value" {
+		t.Error("TestDetach - expect the values of parent to be kept")
+	}
+
+	// Derived context works as usual
+	child, cancelChild := context.WithCancel(ctx)
+	cancelChild()
+	if child.Err() == nil {
+		t.Error("TestDetach - expect the derived context to be cancelled by itself")
+	}
+}
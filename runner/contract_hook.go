@@ -1,20 +1,26 @@
 package runner
 
 import (
+	"context"
 	"crypto-trading-bot-engine/db"
 	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/exchange/exerr"
 	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/risk"
+	"crypto-trading-bot-engine/strategy"
 	"crypto-trading-bot-engine/strategy/contract"
 	"crypto-trading-bot-engine/strategy/order"
 	"crypto-trading-bot-engine/strategy/trigger"
+	"crypto-trading-bot-engine/util/retry"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/shopspring/decimal"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // For storing the func names that are triggered and being used to compare with expected results
@@ -29,10 +35,28 @@ type contractHook struct {
 	// Send the notification, only support telegram atm
 	sender message.Messenger // all users use the same one, but sent with different chat_id
 
-	// Check if entry order by symbol has been triggered already
-	symbolEntryTakenMutex map[string]*sync.Mutex
+	// Requests to the exchange of the user are processed one at a time, stop-loss and close first
+	executor *Executor
+
+	// To let handler know the outcome of hooks
+	handlerEventsCh *strategy.EventsCh
+
+	// Limits of the user above strategies, shared by all strategies
+	riskManager riskChecker
+
+	// The entry has been refused by the limits, notify only once until the entry is allowed again
+	riskRefused bool
 }
 
+// Limits consulted by the hook, implemented by risk.Manager
+type riskChecker interface {
+	CheckEntry(*db.ContractStrategy, decimal.Decimal) error
+	DailyLossLimitHit(string) (bool, error)
+}
+
+// Stop-loss order is still open on the exchange, keep checking until it's executed
+var errStopLossOrderOpen = errors.New("stop-loss order is still open")
+
 func newContractHook(cs *db.ContractStrategy) *contractHook {
 	return &contractHook{
 		contractStrategy: cs,
@@ -47,8 +71,8 @@ func (ch *contractHook) setDB(db *db.DB) {
 	ch.db = db
 }
 
-func (ch *contractHook) setSymbolEntryTakenMutex(m map[string]*sync.Mutex) {
-	ch.symbolEntryTakenMutex = m
+func (ch *contractHook) setExecutor(e *Executor) {
+	ch.executor = e
 }
 
 func (ch *contractHook) setExchange(ex exchange.Exchanger) {
@@ -63,52 +87,256 @@ func (ch *contractHook) setUser(u *db.User) {
 	ch.user = u
 }
 
+func (ch *contractHook) setHandlerEventsCh(eventsCh *strategy.EventsCh) {
+	ch.handlerEventsCh = eventsCh
+}
+
+func (ch *contractHook) setRiskManager(m *risk.Manager) {
+	ch.riskManager = m
+}
+
 func (ch *contractHook) EntryTriggered(c *contract.Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
-	// Make sure only one order by symbol can be triggered at once
-	// Also, from FTX doc: One websocket connection may be logged in to at most one user.
-	mutex := ch.symbolEntryTakenMutex[ch.contractStrategy.UserUuid]
-	mutex.Lock()
-	defer mutex.Unlock()
+	return ch.EntryTriggeredContext(context.Background(), c, t, p)
+}
+
+// Make sure only one entry of the user is processed at once, so that the checks below see the entries taken by the others
+// NOTE Stop-loss and closing positions of the other strategies jump ahead of the entries waiting in the queue
+func (ch *contractHook) EntryTriggeredContext(ctx context.Context, c *contract.Contract, t time.Time, p decimal.Decimal) (price decimal.Decimal, halted bool, err error) {
+	ch.executor.Do(ORDER_PRIORITY_ENTRY, func() {
+		price, halted, err = ch.entryTriggered(ctx, p)
+	})
+	return
+}
+
+func (ch *contractHook) entryTriggered(ctx context.Context, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	// Cancelled while waiting in the queue e.g. shutdown, disable, nothing has been sent
+	if err := ctx.Err(); err != nil {
+		return p, false, fmt.Errorf("EntryTriggered - %v", err)
+	}
+
+	// Only one strategy of the group can take the entry
+	// NOTE Strategies of the group belong to the same user, so they are serialised by the executor
+	if ch.contractStrategy.GroupUuid != "" {
+		taken, err := ch.groupEntryTaken()
+		if err != nil {
+			return p, false, fmt.Errorf("EntryTriggered - failed to get strategies of group, err: %v", err)
+		}
+		if taken {
+			// Halt without error, the strategy will be reset and disabled
+			ch.notify("[提示] '%s %s' 同群組策略已開倉, 停用此策略", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
+			return p, true, nil
+		}
+	}
+
+	// The exchange keeps failing, the user has been notified by the circuit breaker
+	if err := exchange.EntryAllowed(ch.exchange); err != nil {
+		return p, false, fmt.Errorf("EntryTriggered - %v", err)
+	}
+
+	// The last entry order might have been placed without knowing the result e.g. timeout, adopt it if it was filled
+	if ch.contractStrategy.PendingClientOrderId != "" {
+		adopted, price, err := ch.adoptPendingEntryOrder()
+		if err != nil {
+			ch.notify("[錯誤] 無法確認上一筆開倉單, err: %v", err)
+			return p, false, fmt.Errorf("EntryTriggered - failed to check pending entry order, err: %v", err)
+		}
+		if adopted {
+			return price, false, nil
+		}
+	}
+
+	// Check the limits of the user before sending anything to the exchange
+	if err := ch.riskManager.CheckEntry(ch.contractStrategy, p); err != nil {
+		if errors.Is(err, risk.ErrDailyLossLimit) {
+			// Halt without error, the strategy will be reset and disabled along with the others of the user
+			ch.sendKillSwitch(ctx)
+			ch.notify("[警告] '%s %s' 超過風控限制, 停用此策略, %v", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, err)
+			return p, true, nil
+		}
+		if errors.Is(err, risk.ErrLimitExceeded) {
+			// Keep the strategy running, the entry is taken once the other positions are closed
+			if !ch.riskRefused {
+				ch.riskRefused = true
+				ch.notify("[警告] '%s %s' 超過風控限制, 暫不開倉, %v", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, err)
+			}
+			return p, false, fmt.Errorf("EntryTriggered - %v", err)
+		}
+		return p, false, fmt.Errorf("EntryTriggered - failed to check risk limits, err: %v", err)
+	}
+	ch.riskRefused = false
 
 	// Calculate the size
 	size := ch.contractStrategy.Margin.DivRound(p, 8)
 
+	// Save client order id before placing the order, so that the order can be found even if the result is lost
+	clientId, err := ch.newClientOrderId()
+	if err != nil {
+		ch.notify("[Error] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
+		return p, false, fmt.Errorf("EntryTriggered - failed to save client order id, err: %v", err)
+	}
+
 	// Place entry order
-	orderId, err := ch.exchange.PlaceEntryOrder(ch.contractStrategy.Symbol, order.Side(ch.contractStrategy.Side), size)
+	// NOTE Client order id is kept if it fails, as the order might have been placed, it'll be checked next time
+	orderId, err := ch.exchange.PlaceEntryOrder(ch.contractStrategy.Symbol, order.Side(ch.contractStrategy.Side), size, clientId)
 	if err != nil {
-		ch.notify("[錯誤] 無法開倉, err: %v", err)
+		if !errors.Is(err, exchange.ErrCircuitOpen) {
+			ch.notify("[錯誤] 無法開倉, err: %v", err)
+		}
 		return p, false, fmt.Errorf("EntryTriggered - failed to place entry order, err: %v", err)
 	}
 
 	// Notification
 	ch.notify("[開倉] '%s %s $%s' @%s", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, ch.contractStrategy.Margin.StringFixed(0), p.String())
 
+	if err = ch.saveEntryOrder(orderId, p, size); err != nil {
+		ch.notify("[Error] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
+		return p, true, fmt.Errorf("EntryTriggered - failed to update 'exchange_orders_details', err: %v", err)
+	}
+
+	// Disable the other strategies of the group and enable the chained strategies
+	ch.sendOutcome(strategy.OUTCOME_ENTRY)
+	return p, false, nil
+}
+
+// Save the filled entry order, client order id is cleared as the result is known
+func (ch *contractHook) saveEntryOrder(orderId int64, p decimal.Decimal, size decimal.Decimal) error {
 	// For memory data
 	ch.contractStrategy.PositionStatus = int64(contract.OPENED)
 	ch.contractStrategy.ExchangeOrdersDetails = datatypes.JSONMap{
 		"entry_order": map[string]interface{}{
-			"order_id": float64(orderId),
-			"price":    p.String(),
-			"size":     size.String(),
+			"order_id":        float64(orderId),
+			"client_order_id": ch.contractStrategy.PendingClientOrderId,
+			"price":           p.String(),
+			"size":            size.String(),
 		},
 	}
+	ch.contractStrategy.PendingClientOrderId = ""
 	ch.contractStrategy.LastPositionAt = time.Now()
 
 	// For DB
 	contractStrategy := map[string]interface{}{
 		"position_status":         ch.contractStrategy.PositionStatus,
 		"exchange_orders_details": ch.contractStrategy.ExchangeOrdersDetails,
+		"pending_client_order_id": ch.contractStrategy.PendingClientOrderId,
 		"last_position_at":        ch.contractStrategy.LastPositionAt,
 	}
-	_, err = ch.db.UpdateContractStrategy(ch.contractStrategy.Uuid, contractStrategy)
+	if _, err := ch.db.UpdateContractStrategy(ch.contractStrategy.Uuid, contractStrategy); err != nil {
+		return err
+	}
+	ch.recordTradeEntry(p, size)
+	return nil
+}
+
+// Look up the entry order by client order id, adopt it if it has been filled, otherwise forget it
+func (ch *contractHook) adoptPendingEntryOrder() (bool, decimal.Decimal, error) {
+	clientId := ch.contractStrategy.PendingClientOrderId
+	o, found, err := ch.exchange.GetOrderByClientId(ch.contractStrategy.Symbol, clientId)
 	if err != nil {
-		ch.notify("[Error] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
-		return p, true, fmt.Errorf("EntryTriggered - failed to update 'exchange_orders_details', err: %v", err)
+		return false, decimal.Zero, fmt.Errorf("failed to get order '%s', err: %v", clientId, err)
 	}
-	return p, false, nil
+	var size decimal.Decimal
+	if found {
+		if size, err = decimal.NewFromString(o["size"].(string)); err != nil {
+			return false, decimal.Zero, fmt.Errorf("failed to convert size of order '%s', err: %v", clientId, err)
+		}
+	}
+	if !size.IsPositive() {
+		ch.logWithInfof("order '%s' wasn't placed or filled, found: %t", clientId, found)
+		return false, decimal.Zero, ch.clearPendingClientOrderId()
+	}
+	price, err := decimal.NewFromString(o["price"].(string))
+	if err != nil {
+		return false, decimal.Zero, fmt.Errorf("failed to convert price of order '%s', err: %v", clientId, err)
+	}
+
+	ch.notify("[提示] '%s %s' 已找到未記錄的開倉單, 沿用此倉位 @%s", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, price)
+	if err = ch.saveEntryOrder(int64(o["order_id"].(float64)), price, size); err != nil {
+		return false, decimal.Zero, fmt.Errorf("failed to update 'exchange_orders_details', err: %v", err)
+	}
+	ch.sendOutcome(strategy.OUTCOME_ENTRY)
+	return true, price, nil
+}
+
+// Closing order is reduce-only that can't open a position, so it's fine to forget it if it wasn't filled
+// If it has been filled, the position might have been closed, it needs to be checked manually
+func (ch *contractHook) checkPendingCloseOrder() error {
+	clientId := ch.contractStrategy.PendingClientOrderId
+	o, found, err := ch.exchange.GetOrderByClientId(ch.contractStrategy.Symbol, clientId)
+	if err != nil {
+		return fmt.Errorf("failed to get order '%s', err: %v", clientId, err)
+	}
+	if found {
+		if size, err := decimal.NewFromString(o["size"].(string)); err != nil || size.IsPositive() {
+			return fmt.Errorf("closing order '%s' might have been filled, size: %s", clientId, o["size"])
+		}
+	}
+	return ch.clearPendingClientOrderId()
+}
+
+// Increase the attempt and save the client order id derived from it before the order is placed
+func (ch *contractHook) newClientOrderId() (string, error) {
+	attempt := ch.contractStrategy.OrderAttempt + 1
+	clientId := clientOrderId(ch.contractStrategy.Uuid, attempt)
+	contractStrategy := map[string]interface{}{
+		"order_attempt":           attempt,
+		"pending_client_order_id": clientId,
+	}
+	if _, err := ch.db.UpdateContractStrategy(ch.contractStrategy.Uuid, contractStrategy); err != nil {
+		return "", err
+	}
+	ch.contractStrategy.OrderAttempt = attempt
+	ch.contractStrategy.PendingClientOrderId = clientId
+	return clientId, nil
+}
+
+func (ch *contractHook) clearPendingClientOrderId() error {
+	contractStrategy := map[string]interface{}{
+		"pending_client_order_id": "",
+	}
+	if _, err := ch.db.UpdateContractStrategy(ch.contractStrategy.Uuid, contractStrategy); err != nil {
+		return err
+	}
+	ch.contractStrategy.PendingClientOrderId = ""
+	return nil
+}
+
+// e.g. '3f1c2e7a9b0d4c5e8f6a7b1c-12', the first 24 chars of uuid without dashes and the attempt
+// NOTE Keep it within 36 chars, which is the limit of Binance and Bybit
+func clientOrderId(uuid string, attempt int64) string {
+	id := strings.ReplaceAll(uuid, "-", "")
+	if len(id) > 24 {
+		id = id[:24]
+	}
+	return fmt.Sprintf("%s-%d", id, attempt)
+}
+
+// Check if any other strategy of the group has opened the position
+func (ch *contractHook) groupEntryTaken() (bool, error) {
+	css, _, err := ch.db.GetContractStrategiesByGroupUuid(ch.contractStrategy.GroupUuid, ch.contractStrategy.Uuid)
+	if err != nil {
+		return false, err
+	}
+	for _, cs := range css {
+		if contract.Status(cs.PositionStatus) != contract.CLOSED {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func (ch *contractHook) StopLossTriggerCreated(c *contract.Contract) (bool, error) {
+	return ch.StopLossTriggerCreatedContext(context.Background(), c)
+}
+
+// NOTE The position has been opened, it must be protected even if ctx is cancelled
+func (ch *contractHook) StopLossTriggerCreatedContext(ctx context.Context, c *contract.Contract) (halted bool, err error) {
+	ch.executor.Do(ORDER_PRIORITY_STOP_LOSS, func() {
+		halted, err = ch.stopLossTriggerCreated(detach(ctx), c)
+	})
+	return
+}
+
+func (ch *contractHook) stopLossTriggerCreated(ctx context.Context, c *contract.Contract) (bool, error) {
 	// entry_type 'limit' and 'trendline' both are using Limit Trigger, time doesn't matter
 	p := c.StopLossOrder.(*order.StopLoss).Trigger.GetPrice(time.Now())
 	size, err := decimal.NewFromString(ch.contractStrategy.ExchangeOrdersDetails["entry_order"].(map[string]interface{})["size"].(string))
@@ -117,17 +345,30 @@ func (ch *contractHook) StopLossTriggerCreated(c *contract.Contract) (bool, erro
 		return true, fmt.Errorf("StopLossTriggerCreated - failed to convert 'size' from order info, err: %v", err)
 	}
 
-	// Place stop-loss order - retyr 10 times, interval 2 secs
-	orderId, err := ch.exchange.RetryPlaceStopLossOrder(ch.contractStrategy.Symbol, order.Side(ch.contractStrategy.Side), p, size, 10, 2)
+	// Place stop-loss order
+	orderId, err := exchange.RetryPlaceStopLossOrder(ctx, ch.exchange, ch.contractStrategy.Symbol, order.Side(ch.contractStrategy.Side), p, size, retry.PolicyOf(retry.PLACE_ORDER))
 	if err != nil {
 		ch.notify("[Error] %s %s - failed to place stop-loss order, err: %v", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, err)
-		ch.closePosition()
+		// NOTE It's closed right after entry, the entry price is the closest to the exit price known here
+		entryPrice, _ := decimal.NewFromString(fmt.Sprint(ch.contractStrategy.ExchangeOrdersDetails["entry_order"].(map[string]interface{})["price"]))
+		if fillPrice, err := ch.closePosition(ctx); err == nil {
+			ch.recordTradeExit(ctx, exitPrice(fillPrice, entryPrice), db.TRADE_EXIT_ERROR)
+		}
 		return true, fmt.Errorf("StopLossTriggerCreated - failed to place stop-loss order, err: %v", err)
 	}
 
 	// Notification
 	ch.notify("[提示] 已設定 %s 停損單 @%s", ch.contractStrategy.Symbol, p)
 
+	if err = ch.saveStopLossOrder(orderId); err != nil {
+		ch.notify("[Error] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
+		return true, fmt.Errorf("StopLossTriggerCreated - failed to update 'exchange_orders_details', err: %v", err)
+	}
+
+	return false, nil
+}
+
+func (ch *contractHook) saveStopLossOrder(orderId int64) error {
 	// update memory data
 	ch.contractStrategy.ExchangeOrdersDetails["stop_loss_order"] = map[string]interface{}{
 		"order_id": float64(orderId), // make it more consistent by turning it into float64
@@ -136,48 +377,81 @@ func (ch *contractHook) StopLossTriggerCreated(c *contract.Contract) (bool, erro
 	contractStrategy := map[string]interface{}{
 		"exchange_orders_details": ch.contractStrategy.ExchangeOrdersDetails,
 	}
-	_, err = ch.db.UpdateContractStrategy(ch.contractStrategy.Uuid, contractStrategy)
+	_, err := ch.db.UpdateContractStrategy(ch.contractStrategy.Uuid, contractStrategy)
+	return err
+}
+
+// Place the stop-loss order again for the open position e.g. it was cancelled on the exchange
+// NOTE Unlike StopLossTriggerCreated, the position isn't closed if it fails, it's up to the caller
+func (ch *contractHook) replaceStopLossOrder(ctx context.Context, c *contract.Contract) error {
+	sl, ok := c.StopLossOrder.(*order.StopLoss)
+	if !ok || sl.Trigger == nil {
+		return fmt.Errorf("stop-loss trigger is missing")
+	}
+	p := sl.Trigger.GetPrice(time.Now())
+	entryOrder, ok := ch.contractStrategy.ExchangeOrdersDetails["entry_order"].(map[string]interface{})
+	if !ok {
+		return errors.New("'entry_order' is missing from order info")
+	}
+	sizeStr, ok := entryOrder["size"].(string)
+	if !ok {
+		return errors.New("'size' is missing from entry order info")
+	}
+	size, err := decimal.NewFromString(sizeStr)
 	if err != nil {
-		ch.notify("[Error] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
-		return true, fmt.Errorf("StopLossTriggerCreated - failed to update 'exchange_orders_details', err: %v", err)
+		return fmt.Errorf("failed to convert 'size' from order info, err: %v", err)
 	}
 
-	return false, nil
+	orderId, err := exchange.RetryPlaceStopLossOrder(ctx, ch.exchange, ch.contractStrategy.Symbol, order.Side(ch.contractStrategy.Side), p, size, retry.PolicyOf(retry.PLACE_ORDER))
+	if err != nil {
+		return fmt.Errorf("failed to place stop-loss order, err: %v", err)
+	}
+	ch.notify("[提示] 已重新設定 %s 停損單 @%s", ch.contractStrategy.Symbol, p)
+
+	if err = ch.saveStopLossOrder(orderId); err != nil {
+		return fmt.Errorf("failed to update 'exchange_orders_details', err: %v", err)
+	}
+	return nil
 }
 
 func (ch *contractHook) StopLossTriggered(c *contract.Contract, p decimal.Decimal) (bool, error) {
+	return ch.StopLossTriggeredContext(context.Background(), c, p)
+}
+
+// NOTE Waiting for the stop-loss order is given up if ctx is cancelled, the state is kept and checked next time
+func (ch *contractHook) StopLossTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) (bool, error) {
 	ch.notify("[提示] '%s %s $%s' 停損程序已觸發 @%s", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, ch.contractStrategy.Margin.StringFixed(0), p.String())
 
-	retry := 30
-	interval := 2
-	var err error
+	// Wait for the exchange to execute the stop-loss order
 	var existed bool
-	for i := 1; i <= retry; i++ {
+	err := retry.Do(ctx, retry.PolicyOf(retry.CONFIRM), func(attempt int) (e error) {
 		stopLossDetail, ok := ch.contractStrategy.ExchangeOrdersDetails["stop_loss_order"].(map[string]interface{})
-		if ok {
-			stopLossOrderId := int64(stopLossDetail["order_id"].(float64))
-			existed, err = ch.exchange.StopLostOrderExists(ch.contractStrategy.Symbol, stopLossOrderId)
-			if err != nil {
-				ch.log.Println("[ERROR] failed to check stop-loss order, err: ", err)
-				time.Sleep(time.Second * time.Duration(interval))
-				continue
-			}
-			if existed {
-				ch.notify("[提示] %s 尚未執行 '%s %s' 停損單 (檢查次數: %d)", ch.contractStrategy.Exchange, order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, i)
-				time.Sleep(time.Second * time.Duration(interval))
-				continue
-			}
+		if !ok {
+			return nil
+		}
+		stopLossOrderId := int64(stopLossDetail["order_id"].(float64))
+		existed, e = ch.exchange.StopLostOrderExists(ch.contractStrategy.Symbol, stopLossOrderId)
+		if e != nil {
+			ch.log.Println("[ERROR] failed to check stop-loss order, err: ", e)
+			return e
 		}
-		break
+		if existed {
+			ch.notify("[提示] %s 尚未執行 '%s %s' 停損單 (檢查次數: %d)", ch.contractStrategy.Exchange, order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, attempt)
+			return errStopLossOrderOpen
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("StopLossTriggered - gave up checking stop-loss order, err: %v", ctx.Err())
+	}
+	if errors.Is(err, errStopLossOrderOpen) {
+		ch.notify("[錯誤] %s 並未執行 '%s %s' 停損單, 請手動確認", ch.contractStrategy.Exchange, order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
+		return true, fmt.Errorf("'%s %s' wasn't closed by %s", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, ch.contractStrategy.Exchange)
 	}
 	if err != nil {
 		ch.notify("[錯誤] %s 並未執行 '%s %s' 停損單 err: %s, 請手動確認", ch.contractStrategy.Exchange, order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, err)
 		return true, fmt.Errorf("failed to get info of trigger order, %s server error: '%s'", ch.contractStrategy.Exchange, err.Error())
 	}
-	if existed {
-		ch.notify("[錯誤] %s 並未執行 '%s %s' 停損單, 請手動確認", ch.contractStrategy.Exchange, order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
-		return true, fmt.Errorf("'%s %s' wasn't closed by %s", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, ch.contractStrategy.Exchange)
-	}
 	ch.notify("[停損] '%s %s $%s' @%s", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, ch.contractStrategy.Margin.StringFixed(0), p.String())
 
 	// Reset status and exchange_orders_details
@@ -194,6 +468,10 @@ func (ch *contractHook) StopLossTriggered(c *contract.Contract, p decimal.Decima
 	// Update memory data
 	ch.contractStrategy.PositionStatus = int64(contract.CLOSED)
 	ch.contractStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
+	ch.recordTradeExit(ctx, p, db.TRADE_EXIT_STOP_LOSS)
+
+	// Enable the chained strategies
+	ch.sendOutcome(strategy.OUTCOME_STOP_LOSS)
 	return false, nil
 }
 
@@ -214,15 +492,32 @@ func (ch *contractHook) EntryTriggerOperatorUpdated(c *contract.Contract) {
 	ch.notify("[提示] '%s %s' 已更新 operator", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
 }
 
-// NOTE Take-profit will always halt the strategy regardless of whether err is thrown
 func (ch *contractHook) TakeProfitTriggered(c *contract.Contract, p decimal.Decimal) error {
+	return ch.TakeProfitTriggeredContext(context.Background(), c, p)
+}
+
+// NOTE Take-profit will always halt the strategy regardless of whether err is thrown
+// NOTE Closing position isn't cancelled with ctx, as the strategy can't carry on once it's started
+func (ch *contractHook) TakeProfitTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) error {
 	ch.notify("[提示] '%s %s $%s' 停利程序已觸發 @%s", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, ch.contractStrategy.Margin.StringFixed(0), p.String())
 
 	// Update memory data
 	ch.contractStrategy.Enabled = 0
 
 	// NOTE DB data will be updated via event channel
-	return ch.closePosition()
+	var fillPrice decimal.Decimal
+	var err error
+	ch.executor.Do(ORDER_PRIORITY_CLOSE, func() {
+		fillPrice, err = ch.closePosition(detach(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	ch.recordTradeExit(ctx, exitPrice(fillPrice, p), db.TRADE_EXIT_TAKE_PROFIT)
+
+	// Enable the chained strategies
+	ch.sendOutcome(strategy.OUTCOME_TAKE_PROFIT)
+	return nil
 }
 
 // NOTE datatypes.JSONMap will escapte `<` into `\u003c`, but it's fine. It can still be unmarchal and turned back to `=` without issue
@@ -280,35 +575,32 @@ func (ch *contractHook) BreakoutPeakUpdated(c *contract.Contract) {
 	}
 }
 
-func (ch *contractHook) closePosition() error {
+// Average fill price of the closing order is returned, zero if the exchange doesn't tell
+func (ch *contractHook) closePosition(ctx context.Context) (decimal.Decimal, error) {
 	var closedAlready bool
+	var fillPrice decimal.Decimal
 	var err error
 
 	// NOTE There is a situation that engine and stop-loss trigger on FTX will compete to close open position
 	//      When FTX is processing or just finished after engine got the position that hasn't been closed fully (size != 0),
 	//		, engine will get 'Status Code: 400 Error: Invalid reduce-only order', because engine is tring to close a
 	//		closed position.
-	retry := 10
-	interval := 2
-	for i := 1; i <= retry; i++ {
-		closedAlready, err = ch.closeOpenPosition()
-		ch.logWithInfof("count: %d, closedAlready: %t, err: %v", i, closedAlready, err)
-		if err != nil {
-			ch.notify("[提示] 嘗試關閉 '%s %s' 倉位 (執行次數: %d)", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, i)
-			time.Sleep(time.Second * time.Duration(interval))
-			continue
+	err = retry.Do(ctx, retry.PolicyOf(retry.PLACE_ORDER), func(attempt int) (e error) {
+		closedAlready, fillPrice, e = ch.closeOpenPosition()
+		ch.logWithInfof("count: %d, closedAlready: %t, err: %v", attempt, closedAlready, e)
+		if e != nil {
+			ch.notify("[提示] 嘗試關閉 '%s %s' 倉位 (執行次數: %d)", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, attempt)
 		}
-		// success
-		break
-	}
+		return e
+	})
 	if err != nil {
 		ch.notify("[錯誤] 無法關閉 '%s %s' 倉位, err: %v", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol, err)
-		return fmt.Errorf("closePosition err: %v", err)
+		return fillPrice, fmt.Errorf("closePosition err: %v", err)
 	}
 
 	if closedAlready {
 		ch.notify("[提示] '%s %s' 倉位已不存在", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
-		return nil
+		return fillPrice, nil
 	} else {
 		// Notification
 		ch.notify("[提示] '%s %s' 倉位已成功關閉", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
@@ -321,15 +613,15 @@ func (ch *contractHook) closePosition() error {
 		tmpId, ok := orderInfo["order_id"].(float64)
 		if !ok {
 			ch.notify("[Error] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(ch.contractStrategy.Side), ch.contractStrategy.Symbol)
-			return fmt.Errorf("closePosition - stop_loss_order.order_id is missing")
+			return fillPrice, fmt.Errorf("closePosition - stop_loss_order.order_id is missing")
 		}
 		stopLossOrderId := int64(tmpId)
-		if err = ch.exchange.RetryCancelOpenTriggerOrder(stopLossOrderId, 10, 2); err != nil {
-			if strings.Contains(err.Error(), "Order already closed") {
+		if err = exchange.RetryCancelOpenTriggerOrder(ctx, ch.exchange, stopLossOrderId, retry.PolicyOf(retry.CANCEL)); err != nil {
+			if errors.Is(err, exerr.ErrOrderAlreadyClosed) {
 				ch.notify("[提示] %s 停損單已經被關閉", ch.contractStrategy.Symbol)
 			} else {
 				ch.notify("[錯誤] 無法取消 %s 停損單, err: %v", ch.contractStrategy.Symbol, err)
-				return err
+				return fillPrice, err
 			}
 		}
 	}
@@ -337,11 +629,11 @@ func (ch *contractHook) closePosition() error {
 	// Update memory data
 	ch.contractStrategy.PositionStatus = int64(contract.CLOSED)
 	ch.contractStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
-	return nil
+	return fillPrice, nil
 }
 
 // When closed is true, it means that it might have been closed by stop-loss trigger order by FTX
-func (ch *contractHook) closeOpenPosition() (closed bool, err error) {
+func (ch *contractHook) closeOpenPosition() (closed bool, fillPrice decimal.Decimal, err error) {
 	// If size is zero, it means that it might be closed already
 	size, err := decimal.NewFromString(ch.contractStrategy.ExchangeOrdersDetails["entry_order"].(map[string]interface{})["size"].(string))
 	if err != nil {
@@ -349,15 +641,132 @@ func (ch *contractHook) closeOpenPosition() (closed bool, err error) {
 		return
 	}
 
+	clientId, err := ch.newClientOrderId()
+	if err != nil {
+		ch.logWithInfof("closeOpenPosition - failed to save client order id, err: %v", err)
+		return
+	}
+
 	// Close position
-	if err = ch.exchange.ClosePosition(ch.contractStrategy.Symbol, order.Side(ch.contractStrategy.Side), size); err != nil {
+	if err = ch.exchange.ClosePosition(ch.contractStrategy.Symbol, order.Side(ch.contractStrategy.Side), size, clientId); err != nil {
 		ch.logWithInfof("closeOpenPosition - failed to close open position, err: %v", err)
 		return
 	}
 
+	// The closing order has been filled, look it up for the average fill price
+	// NOTE Don't return the errors, the position has been closed, retrying would fail with reduce-only order
+	if o, found, e := ch.exchange.GetOrderByClientId(ch.contractStrategy.Symbol, clientId); e != nil {
+		ch.logWithInfof("closeOpenPosition - failed to get closing order, err: %v", e)
+	} else if found {
+		fillPrice, _ = decimal.NewFromString(fmt.Sprint(o["price"]))
+	}
+	if e := ch.clearPendingClientOrderId(); e != nil {
+		ch.logWithInfof("closeOpenPosition - failed to clear client order id, err: %v", e)
+	}
 	return
 }
 
+// Journal the entry, it doesn't halt the strategy if it fails as the position has been opened anyway
+func (ch *contractHook) recordTradeEntry(p decimal.Decimal, size decimal.Decimal) {
+	trade := db.Trade{
+		UserUuid:     ch.contractStrategy.UserUuid,
+		StrategyUuid: ch.contractStrategy.Uuid,
+		Exchange:     ch.contractStrategy.Exchange,
+		Symbol:       ch.contractStrategy.Symbol,
+		Side:         ch.contractStrategy.Side,
+		EntryPrice:   p,
+		EntrySize:    size,
+		EntryAt:      ch.contractStrategy.LastPositionAt,
+	}
+	if _, _, err := ch.db.CreateTrade(trade); err != nil {
+		ch.logWithInfof("[Error] failed to create trade, err: %v", err)
+	}
+}
+
+// Journal the exit of the open trade, nothing is done if there is none e.g. it's been closed already
+// NOTE Exit price is the fill price of the closing order if it's known, otherwise the mark price that triggered the exit
+// NOTE Fees are estimated by taker fee of the account, zero price means the exit price isn't known, PnL is recorded as 0
+func (ch *contractHook) recordTradeExit(ctx context.Context, p decimal.Decimal, reason string) {
+	t, err := ch.db.GetOpenTradeByStrategyUuid(ch.contractStrategy.Uuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		ch.logWithInfof("[Error] failed to get open trade, err: %v", err)
+		return
+	}
+
+	var fees, pnl decimal.Decimal
+	if p.IsPositive() {
+		if info, err := ch.exchange.GetAccountInfo(); err != nil {
+			ch.logWithInfof("[Warn] failed to get taker fee, fees are recorded as 0, err: %v", err)
+		} else if takerFee, ok := info["taker_fee"].(decimal.Decimal); ok {
+			fees = t.EntryPrice.Add(p).Mul(t.EntrySize).Mul(takerFee)
+		}
+		pnl = tradePnl(order.Side(t.Side), t.EntryPrice, p, t.EntrySize, fees)
+	}
+
+	data := map[string]interface{}{
+		"exit_price":   p,
+		"exit_reason":  reason,
+		"exit_at":      time.Now(),
+		"fees":         fees,
+		"realized_pnl": pnl,
+	}
+	if _, err = ch.db.UpdateTrade(t.Id, data); err != nil {
+		ch.logWithInfof("[Error] failed to update trade '%d', err: %v", t.Id, err)
+		return
+	}
+	ch.checkDailyLoss(ctx)
+}
+
+// Disable all strategies of the user once the realized loss of the day reaches the limit
+func (ch *contractHook) checkDailyLoss(ctx context.Context) {
+	hit, err := ch.riskManager.DailyLossLimitHit(ch.contractStrategy.UserUuid)
+	if err != nil {
+		ch.logWithInfof("[Error] failed to check daily loss limit, err: %v", err)
+		return
+	}
+	if hit {
+		ch.sendKillSwitch(ctx)
+	}
+}
+
+// Don't block once the check is cancelled or handler stops listening events e.g. shutdown
+// NOTE It's fine to give up, the entries of the other strategies of the user are still refused by risk manager
+func (ch *contractHook) sendKillSwitch(ctx context.Context) {
+	select {
+	case ch.handlerEventsCh.KillSwitch <- ch.contractStrategy.UserUuid:
+	case <-ctx.Done():
+		ch.logWithInfof("[Warn] kill switch isn't sent, err: %v", ctx.Err())
+	case <-ch.handlerEventsCh.Stopped:
+	}
+}
+
+// Fill price of the closing order if it's known, otherwise the price given e.g. the mark
+func exitPrice(fillPrice decimal.Decimal, p decimal.Decimal) decimal.Decimal {
+	if fillPrice.IsPositive() {
+		return fillPrice
+	}
+	return p
+}
+
+// Realized PnL with fees deducted
+func tradePnl(side order.Side, entryPrice decimal.Decimal, exitPrice decimal.Decimal, size decimal.Decimal, fees decimal.Decimal) decimal.Decimal {
+	diff := exitPrice.Sub(entryPrice)
+	if side == order.SHORT {
+		diff = diff.Neg()
+	}
+	return diff.Mul(size).Sub(fees)
+}
+
+func (ch *contractHook) sendOutcome(event string) {
+	ch.handlerEventsCh.Outcome <- strategy.Outcome{
+		Uuid:  ch.contractStrategy.Uuid,
+		Event: event,
+	}
+}
+
 func (ch *contractHook) notify(format string, v ...interface{}) {
 	ch.logWithInfof(format, v...)
 	go ch.sender.Send(ch.user.TelegramChatId, fmt.Sprintf(format, v...))
@@ -0,0 +1,196 @@
+package runner
+
+import (
+	"context"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/risk"
+	"crypto-trading-bot-engine/strategy"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/trigger"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+)
+
+func TestTradePnl(t *testing.T) {
+	testcases := []struct {
+		side     order.Side
+		entry    string
+		exit     string
+		size     string
+		fees     string
+		expected string
+	}{
+		{side: order.LONG, entry: "100", exit: "110", size: "2", fees: "0.5", expected: "19.5"},
+		{side: order.LONG, entry: "100", exit: "90", size: "2", fees: "0.5", expected: "-20.5"},
+		{side: order.SHORT, entry: "100", exit: "90", size: "2", fees: "0.5", expected: "19.5"},
+		{side: order.SHORT, entry: "100", exit: "110", size: "2", fees: "0", expected: "-20"},
+	}
+	for i, tc := range testcases {
+		pnl := tradePnl(tc.side, decimal.RequireFromString(tc.entry), decimal.RequireFromString(tc.exit), decimal.RequireFromString(tc.size), decimal.RequireFromString(tc.fees))
+		if !pnl.Equal(decimal.RequireFromString(tc.expected)) {
+			t.Errorf("TestTradePnl case %d - expect '%s', but got '%s'", i, tc.expected, pnl)
+		}
+	}
+}
+
+type fakeRiskChecker struct {
+	err error
+}
+
+func (f *fakeRiskChecker) CheckEntry(*db.ContractStrategy, decimal.Decimal) error {
+	return f.err
+}
+
+func (f *fakeRiskChecker) DailyLossLimitHit(string) (bool, error) {
+	return false, nil
+}
+
+type fakeSender struct{}
+
+func (fakeSender) Send(int64, string) {}
+
+func TestEntryTriggeredRiskRefused(t *testing.T) {
+	testcases := []struct {
+		title              string
+		err                error
+		expectedHalted     bool
+		expectedKillSwitch bool
+	}{
+		{
+			title: "max open positions",
+			err:   fmt.Errorf("%w, open positions: %d, limit: %d", risk.ErrLimitExceeded, 3, 3),
+		},
+		{
+			title:              "daily loss limit",
+			err:                fmt.Errorf("%w, realized pnl: -100.00, limit: -100", risk.ErrDailyLossLimit),
+			expectedHalted:     true,
+			expectedKillSwitch: true,
+		},
+	}
+	for _, tc := range testcases {
+		cs := &db.ContractStrategy{Uuid: "uuid", UserUuid: "user", Symbol: "ETH-PERP", Enabled: 1, Margin: decimal.NewFromInt(100)}
+		ch := newContractHook(cs)
+		ch.setLogger(log.New(io.Discard, "", 0))
+		ch.setSender(fakeSender{})
+		ch.setUser(&db.User{})
+		ch.setHandlerEventsCh(&strategy.EventsCh{KillSwitch: make(chan string, 1)})
+		ch.riskManager = &fakeRiskChecker{err: tc.err}
+
+		// Entry is triggered on every mark while it's refused
+		for i := 0; i < 2; i++ {
+			_, halted, err := ch.entryTriggered(context.Background(), decimal.NewFromInt(2000))
+			if halted != tc.expectedHalted {
+				t.Errorf("TestEntryTriggeredRiskRefused case '%s' - expect halted '%t', but got '%t'", tc.title, tc.expectedHalted, halted)
+			}
+			if !halted && err == nil {
+				t.Errorf("TestEntryTriggeredRiskRefused case '%s' - expect error, but got nil", tc.title)
+			}
+			if halted {
+				break
+			}
+		}
+		if killSwitch := len(ch.handlerEventsCh.KillSwitch) == 1; killSwitch != tc.expectedKillSwitch {
+			t.Errorf("TestEntryTriggeredRiskRefused case '%s' - expect kill switch '%t', but got '%t'", tc.title, tc.expectedKillSwitch, killSwitch)
+		}
+		if cs.Enabled != 1 || cs.PositionStatus != int64(contract.CLOSED) {
+			t.Errorf("TestEntryTriggeredRiskRefused case '%s' - expect strategy enabled and closed, but got enabled '%d', status '%d'", tc.title, cs.Enabled, cs.PositionStatus)
+		}
+	}
+}
+
+// Nobody receives the kill switch once the check is cancelled or handler stops listening events, it mustn't block
+func TestSendKillSwitchUnblocked(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	stopped := make(chan struct{})
+	close(stopped)
+
+	testcases := []struct {
+		title   string
+		ctx     context.Context
+		stopped chan struct{}
+	}{
+		{
+			title:   "check cancelled",
+			ctx:     cancelled,
+			stopped: make(chan struct{}),
+		},
+		{
+			title:   "events stopped",
+			ctx:     context.Background(),
+			stopped: stopped,
+		},
+	}
+	for _, tc := range testcases {
+		cs := &db.ContractStrategy{Uuid: "uuid", UserUuid: "user", Symbol: "ETH-PERP"}
+		ch := newContractHook(cs)
+		ch.setLogger(log.New(io.Discard, "", 0))
+		ch.setHandlerEventsCh(&strategy.EventsCh{KillSwitch: make(chan string), Stopped: tc.stopped})
+
+		done := make(chan struct{})
+		go func() {
+			ch.sendKillSwitch(tc.ctx)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("TestSendKillSwitchUnblocked case '%s' - expect returned, but it's blocked", tc.title)
+		}
+	}
+}
+
+// Order info written by hand might be broken, the stop-loss order isn't placed and the strategy is flagged by the caller
+func TestReplaceStopLossOrderMalformed(t *testing.T) {
+	testcases := []struct {
+		title   string
+		details datatypes.JSONMap
+	}{
+		{
+			title:   "entry_order missing",
+			details: datatypes.JSONMap{},
+		},
+		{
+			title:   "entry_order isn't a map",
+			details: datatypes.JSONMap{"entry_order": "100"},
+		},
+		{
+			title:   "size missing",
+			details: datatypes.JSONMap{"entry_order": map[string]interface{}{"order_id": float64(1), "price": "100"}},
+		},
+		{
+			title:   "size isn't a string",
+			details: datatypes.JSONMap{"entry_order": map[string]interface{}{"order_id": float64(1), "price": "100", "size": float64(2)}},
+		},
+	}
+	c := &contract.Contract{StopLossOrder: &order.StopLoss{Trigger: &trigger.Limit{TriggerType: "limit", Operator: "<=", Price: decimal.NewFromInt(90)}}}
+	for _, tc := range testcases {
+		ch := newContractHook(&db.ContractStrategy{Symbol: "ETH-PERP", ExchangeOrdersDetails: tc.details})
+		if err := ch.replaceStopLossOrder(context.Background(), c); err == nil {
+			t.Errorf("TestReplaceStopLossOrderMalformed case '%s' - expect error, but got nil", tc.title)
+		}
+	}
+}
+
+func TestExitPrice(t *testing.T) {
+	testcases := []struct {
+		title     string
+		fillPrice decimal.Decimal
+		expected  decimal.Decimal
+	}{
+		{title: "fill price returned", fillPrice: decimal.NewFromInt(101), expected: decimal.NewFromInt(101)},
+		{title: "fill price unknown", fillPrice: decimal.Zero, expected: decimal.NewFromInt(100)},
+	}
+	for _, tc := range testcases {
+		if p := exitPrice(tc.fillPrice, decimal.NewFromInt(100)); !p.Equal(tc.expected) {
+			t.Errorf("TestExitPrice case '%s' - expect '%s', but got '%s'", tc.title, tc.expected, p)
+		}
+	}
+}
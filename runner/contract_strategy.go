@@ -4,13 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"runtime/debug"
-	"sync"
 	"time"
 
 	"crypto-trading-bot-engine/db"
 	"crypto-trading-bot-engine/exchange"
 	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/risk"
 	"crypto-trading-bot-engine/strategy"
 	"crypto-trading-bot-engine/strategy/contract"
 	"crypto-trading-bot-engine/strategy/order"
@@ -21,47 +20,13 @@ const (
 )
 
 type ContractStrategyRunner struct {
-	ContractStrategy *db.ContractStrategy
-	user             *db.User
-
-	log *log.Logger
-
-	// Channel
-	StopCh chan bool
-	MarkCh chan contract.Mark
+	runnerCore
 
-	// Status control
-	CheckPriceEnabled bool // Don't check price when runner is gonna to stop
-	stopChClosed      bool // Let handler know whether StopCh has been closed
-
-	// DB
-	db *db.DB
+	ContractStrategy *db.ContractStrategy
 
 	// Support contract only atm
-	contract     *contract.Contract
-	contractHook *contractHook
-
-	// Deal with the sig for stopping the strategy, all strategies share the same sync.WaitGroup
-	handlerBlockWg  *sync.WaitGroup
-	beforeCloseFunc func(string, string)
-
-	// To disable/reset, etc.. a strategy from handler
-	handlerEventsCh *strategy.EventsCh
-
-	// Make sure strategy finishes its work before being killed
-	RunnerBlockWg sync.WaitGroup
-
-	// Make sure there is only one task processed at one time
-	RunnerMutex sync.Mutex
-
-	// Send the notification, only support telegram atm
-	sender message.Messenger // all users use the same one, but sent with different chat_id
-
-	// Check mark price once a time
-	ignoreIncomingMark bool
-
-	// Last time the price being checked
-	LastPriceCheckedTime time.Time
+	contractHook    *contractHook
+	beforeCloseFunc func(string, string, string)
 }
 
 func NewContractStrategyRunner(cs *db.ContractStrategy) (*ContractStrategyRunner, error) {
@@ -74,16 +39,19 @@ func NewContractStrategyRunner(cs *db.ContractStrategy) (*ContractStrategyRunner
 	if err != nil {
 		return &ContractStrategyRunner{}, err
 	}
-	c.SetHook(ch)
+	var hook contract.Hooker = ch
+	if cs.Execution == db.EXECUTION_ALERT_ONLY {
+		// Setters of runner still go to contractHook as it's embedded by alertHook
+		hook = newAlertHook(ch)
+	}
+	ah := newAuditHook(hook, db.MARKET_CONTRACT, cs.Uuid, func() map[string]interface{} { return cs.Params })
+	c.SetHook(ah)
 	c.SetStatus(contract.Status(cs.PositionStatus))
 
 	s := &ContractStrategyRunner{
-		ContractStrategy:  cs,
-		contract:          c,
-		contractHook:      ch,
-		StopCh:            make(chan bool),
-		MarkCh:            make(chan contract.Mark),
-		CheckPriceEnabled: true,
+		runnerCore:       newRunnerCore(cs.Uuid, c, ah),
+		ContractStrategy: cs,
+		contractHook:     ch,
 	}
 	return s, err
 }
@@ -91,29 +59,35 @@ func NewContractStrategyRunner(cs *db.ContractStrategy) (*ContractStrategyRunner
 func (r *ContractStrategyRunner) SetLogger(l *log.Logger) {
 	r.log = l
 	r.contractHook.setLogger(l)
+	r.auditHook.setLogger(l)
 }
 
 func (r *ContractStrategyRunner) SetDB(db *db.DB) {
 	r.db = db
 	r.contractHook.db = db
+	r.auditHook.setDB(db)
 }
 
-func (r *ContractStrategyRunner) SetBeforeCloseFunc(f func(string, string)) {
+func (r *ContractStrategyRunner) SetBeforeCloseFunc(f func(string, string, string)) {
 	r.beforeCloseFunc = f
 }
 
-func (r *ContractStrategyRunner) SetHandlerBlockWg(wg *sync.WaitGroup) {
-	r.handlerBlockWg = wg
-}
-
-func (r *ContractStrategyRunner) SetSymbolEntryTakenMutexForHook(m map[string]*sync.Mutex) {
-	r.contractHook.setSymbolEntryTakenMutex(m)
+func (r *ContractStrategyRunner) SetExecutorForHook(e *Executor) {
+	r.contractHook.setExecutor(e)
 }
 
 func (r *ContractStrategyRunner) SetExchangeForHook(ex exchange.Exchanger) {
 	r.contractHook.setExchange(ex)
 }
 
+func (r *ContractStrategyRunner) SetRiskManagerForHook(m *risk.Manager) {
+	r.contractHook.setRiskManager(m)
+}
+
+func (r *ContractStrategyRunner) Exchange() exchange.Exchanger {
+	return r.contractHook.exchange
+}
+
 func (r *ContractStrategyRunner) SetSender(m message.Messenger) {
 	r.sender = m
 	r.contractHook.setSender(m)
@@ -126,102 +100,55 @@ func (r *ContractStrategyRunner) SetUser(u *db.User) {
 
 func (r *ContractStrategyRunner) SetHandlerEventsCh(ch *strategy.EventsCh) {
 	r.handlerEventsCh = ch
+	r.contractHook.setHandlerEventsCh(ch)
 }
 
-func (r *ContractStrategyRunner) Stop() {
-	if !r.stopChClosed {
-		// In order to avoid `panic: close of closed channel`, check first
-		r.stopChClosed = true
-
-		// This is necessary, if stopCh sent first and broadcastMark keeps sending Mark to nowhere, it would get stuck
-		// If runner MarhCh get stuck, handler stopContractStrategyRunner will get stuck too, it would cause that
-		// runner beforeCloseFunc can't be finished and gets stuck. Basically, it's a deadlock hell
-		r.CheckPriceEnabled = false
-		close(r.StopCh)
+// Adopt the order that was placed but not saved e.g. the process died right after the order was placed
+// NOTE Call it before Run, marks aren't received until it's done
+func (r *ContractStrategyRunner) RecoverPendingOrder() error {
+	if r.ContractStrategy.PendingClientOrderId == "" || r.ContractStrategy.Execution == db.EXECUTION_ALERT_ONLY {
+		return nil
 	}
-}
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
 
-// Start
-func (r *ContractStrategyRunner) Run() {
-	// NOTE For graceful shutdown
-	r.handlerBlockWg.Add(1)
-	defer r.handlerBlockWg.Done()
-
-	halted := false
-	for {
-		select {
-		case <-r.StopCh:
-			halted = true
-			break
-		case mark := <-r.MarkCh:
-			// If 'CheckPrice' is still in progress, ignore the incoming prices unitl it's finished
-			if !r.CheckPriceEnabled || r.ignoreIncomingMark {
-				break
-			}
-			r.ignoreIncomingMark = true
-			go r.checkPrice(&mark)
+	switch contract.Status(r.ContractStrategy.PositionStatus) {
+	case contract.CLOSED:
+		adopted, price, err := r.contractHook.adoptPendingEntryOrder()
+		if err != nil || !adopted {
+			return err
 		}
-		if halted {
-			break
+		// Create stop-loss order for the adopted position like the entry has just been triggered
+		if _, err = r.contract.AdoptEntry(contract.Mark{Price: price, Time: time.Now()}, price); err != nil {
+			return fmt.Errorf("failed to adopt entry order, err: %v", err)
 		}
+	case contract.OPENED:
+		return r.contractHook.checkPendingCloseOrder()
 	}
-
-	r.RunnerBlockWg.Wait()
-	r.beforeCloseFunc(r.ContractStrategy.Symbol, r.ContractStrategy.Uuid)
+	return nil
 }
 
-// Check mark price
-func (r *ContractStrategyRunner) checkPrice(mark *contract.Mark) {
-	r.RunnerMutex.Lock()
-	defer r.RunnerMutex.Unlock()
-
-	// for graceful shutdown, block everything in progress until they are done
-	r.handlerBlockWg.Add(1)
-	defer r.handlerBlockWg.Done()
-
-	defer func() { r.ignoreIncomingMark = false }()
-	defer func() {
-		if e := recover(); e != nil {
-			r.log.Printf("strategy '%s' panic: %v stack: %s\n", r.ContractStrategy.Uuid, e, string(debug.Stack()))
-			text := fmt.Sprintf("[錯誤] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(r.ContractStrategy.Side), r.ContractStrategy.Symbol)
-			go r.sender.Send(r.user.TelegramChatId, text)
-			r.handlerEventsCh.OutOfSync <- r.ContractStrategy.Uuid
-			r.handlerEventsCh.Disable <- r.ContractStrategy.Uuid
-		}
-	}()
+// Start
+func (r *ContractStrategyRunner) Run() {
+	r.run(r)
+}
 
+func (r *ContractStrategyRunner) markToCheck(sm SymbolMark) (contract.Mark, bool) {
 	// NOTE For DEBUG
-	// r.log.Println(r.ContractStrategy.Symbol, r.ContractStrategy.Uuid, mark.Time.Format("2006-01-02 15:04:05"), mark.Price)
-
-	// Make sure the data is valid
-	if err := r.validateExchangeOrdersDetails(); err != nil {
-		r.log.Printf("[ERROR] strategy: '%s', user: '%s', symbol: '%s', positionStatus: '%s' invalid 'exchange_orders_details', err: %s\n", r.ContractStrategy.Uuid, r.ContractStrategy.UserUuid, r.ContractStrategy.Symbol, contract.TranslateStatusByInt(r.ContractStrategy.PositionStatus), err)
-		r.CheckPriceEnabled = false
-		r.handlerEventsCh.OutOfSync <- r.ContractStrategy.Uuid
-		r.handlerEventsCh.Disable <- r.ContractStrategy.Uuid
-		return
-	}
+	// r.log.Println(r.ContractStrategy.Symbol, r.ContractStrategy.Uuid, sm.Mark.Time.Format("2006-01-02 15:04:05"), sm.Mark.Price)
+	return sm.Mark, true
+}
 
-	halted, err := r.contract.CheckPrice(*mark)
-	if err != nil && halted { // scenario: DB fails
-		// Stop receiving Mark
-		r.log.Printf("[ERROR] strategy: '%s', user: '%s', symbol: '%s', positionStatus: '%s' halted with err: %s\n", r.ContractStrategy.Uuid, r.ContractStrategy.UserUuid, r.ContractStrategy.Symbol, contract.TranslateStatusByInt(r.ContractStrategy.PositionStatus), err)
-		r.CheckPriceEnabled = false
-		r.handlerEventsCh.OutOfSync <- r.ContractStrategy.Uuid
-		r.handlerEventsCh.Disable <- r.ContractStrategy.Uuid
-	} else if err != nil { // scenario: ftx api 400, still want to retry
-		r.log.Printf("[ERROR] strategy: '%s', user: '%s', symbol: '%s', positionStatus: '%s' err: %v\n", r.ContractStrategy.Uuid, r.ContractStrategy.UserUuid, r.ContractStrategy.Symbol, contract.TranslateStatusByInt(r.ContractStrategy.PositionStatus), err)
-
-		// Sleep a while and try again
-		time.Sleep(time.Second * 3)
-	} else if halted { // scenario: take-profit, err is nil
-		// Stop receiving Mark
-		r.CheckPriceEnabled = false
-		r.log.Printf("[INFO] strategy: '%s', user: '%s', symbol: '%s', positionStatus: '%s' is done!\n", r.ContractStrategy.Uuid, r.ContractStrategy.UserUuid, r.ContractStrategy.Symbol, contract.TranslateStatusByInt(r.ContractStrategy.PositionStatus))
-		r.handlerEventsCh.Reset <- r.ContractStrategy.Uuid
-	}
+func (r *ContractStrategyRunner) describe() string {
+	return fmt.Sprintf("strategy: '%s', user: '%s', symbol: '%s', positionStatus: '%s'", r.ContractStrategy.Uuid, r.ContractStrategy.UserUuid, r.ContractStrategy.Symbol, contract.TranslateStatusByInt(r.ContractStrategy.PositionStatus))
+}
+
+func (r *ContractStrategyRunner) panicText() string {
+	return fmt.Sprintf("[錯誤] '%s %s' Internal Server Error. Please check and reset your position and order", order.TranslateSideByInt(r.ContractStrategy.Side), r.ContractStrategy.Symbol)
+}
 
-	r.LastPriceCheckedTime = time.Now()
+func (r *ContractStrategyRunner) beforeClose() {
+	r.beforeCloseFunc(r.ContractStrategy.Exchange, r.ContractStrategy.Symbol, r.ContractStrategy.Uuid)
 }
 
 // Check exchange_orders_details, halt the strategy if the data is out of sync
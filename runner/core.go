@@ -0,0 +1,284 @@
+package runner
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/strategy"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/util/retry"
+
+	"github.com/shopspring/decimal"
+)
+
+// Mark of the symbol sent by handler, the symbol tells the legs of pair strategy apart
+type SymbolMark struct {
+	Symbol string
+	Mark   contract.Mark
+}
+
+// The steps that differ between the kinds of strategies, the rest of the flow is shared by runnerCore
+type markChecker interface {
+	// Mark to be checked, false if there is nothing to check yet
+	markToCheck(sm SymbolMark) (contract.Mark, bool)
+
+	// Check exchange_orders_details, halt the strategy if the data is out of sync
+	validateExchangeOrdersDetails() error
+
+	// For logs e.g. "strategy: 'uuid', user: 'uuid', symbol: 'ETH-PERP', positionStatus: 'OPENED'"
+	describe() string
+
+	// Sent to the user when the check panics
+	panicText() string
+
+	// Called once the runner has been stopped
+	beforeClose()
+}
+
+// Implemented by the runner that needs to set things up for the hook before the mark is checked
+type markPreparer interface {
+	prepareMark(mark contract.Mark)
+}
+
+// Lifecycle of the runner shared by all kinds of strategies, it's embedded by each of them
+type runnerCore struct {
+	uuid string // of the strategy, sent to handler with events
+	user *db.User
+
+	log *log.Logger
+
+	// Channel
+	StopCh chan bool
+	MarkCh chan SymbolMark
+
+	// Status control
+	CheckPriceEnabled bool // Don't check price when runner is gonna to stop
+	stopChClosed      bool // Let handler know whether StopCh has been closed
+
+	// DB
+	db *db.DB
+
+	// State machine of the strategy
+	contract  *contract.Contract
+	auditHook *auditHook // wraps the hook of contract
+
+	// Deal with the sig for stopping the strategy, all strategies share the same sync.WaitGroup
+	handlerBlockWg *sync.WaitGroup
+
+	// To disable/reset, etc.. a strategy from handler
+	handlerEventsCh *strategy.EventsCh
+
+	// Make sure strategy finishes its work before being killed
+	RunnerBlockWg sync.WaitGroup
+
+	// Make sure there is only one task processed at one time
+	RunnerMutex sync.Mutex
+
+	// Cancel the exchange calls of the check in progress, so that shutdown and disable don't wait for the retries
+	ctx         context.Context // cancelled by Stop
+	cancel      context.CancelFunc
+	checkMutex  sync.Mutex
+	checkCancel context.CancelFunc // of the check in progress
+
+	// Send the notification, only support telegram atm
+	sender message.Messenger // all users use the same one, but sent with different chat_id
+
+	// Check mark price once a time, marks arriving in the meantime are kept in mailbox
+	mailbox markMailbox
+
+	// Checks failed in a row, the backoff before the next mark grows with it, only accessed under RunnerMutex
+	checkFailures int
+
+	// Last time the price being checked
+	LastPriceCheckedTime time.Time
+}
+
+func newRunnerCore(uuid string, c *contract.Contract, ah *auditHook) runnerCore {
+	ctx, cancel := context.WithCancel(context.Background())
+	return runnerCore{
+		uuid:              uuid,
+		ctx:               ctx,
+		cancel:            cancel,
+		contract:          c,
+		auditHook:         ah,
+		StopCh:            make(chan bool),
+		MarkCh:            make(chan SymbolMark),
+		CheckPriceEnabled: true,
+	}
+}
+
+func (r *runnerCore) SetHandlerBlockWg(wg *sync.WaitGroup) {
+	r.handlerBlockWg = wg
+}
+
+// Mark price being checked or checked last time
+func (r *runnerCore) LastMarkPrice() decimal.Decimal {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+	return r.auditHook.markPrice
+}
+
+// NOTE It's called by ws of each exchange concurrently via handler
+func (r *runnerCore) SendMark(symbol string, mark contract.Mark) {
+	// NOTE Check this first, otherwise it might block ws handler due to no receiver if StopCh has been closed
+	if !r.CheckPriceEnabled {
+		return
+	}
+	// NOTE ws of the other exchange could still be broadcasting while runners are being stopped
+	select {
+	case r.MarkCh <- SymbolMark{Symbol: symbol, Mark: mark}:
+	case <-r.StopCh:
+	}
+}
+
+func (r *runnerCore) Stop() {
+	if !r.stopChClosed {
+		// In order to avoid `panic: close of closed channel`, check first
+		r.stopChClosed = true
+
+		// This is necessary, if stopCh sent first and broadcastMark keeps sending Mark to nowhere, it would get stuck
+		// If runner MarhCh get stuck, handler stopContractStrategyRunner will get stuck too, it would cause that
+		// runner beforeCloseFunc can't be finished and gets stuck. Basically, it's a deadlock hell
+		r.CheckPriceEnabled = false
+		close(r.StopCh)
+		r.cancel()
+	}
+}
+
+// Give up the retries of the check in progress e.g. waiting for stop-loss order to be executed, so that the caller
+// gets RunnerMutex sooner. Safety-critical steps e.g. placing stop-loss order aren't cancelled
+func (r *runnerCore) CancelCheck() {
+	r.checkMutex.Lock()
+	defer r.checkMutex.Unlock()
+	if r.checkCancel != nil {
+		r.checkCancel()
+	}
+}
+
+func (r *runnerCore) newCheckContext() (context.Context, context.CancelFunc) {
+	r.checkMutex.Lock()
+	defer r.checkMutex.Unlock()
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.checkCancel = cancel
+	return ctx, cancel
+}
+
+// Receive marks until the runner is stopped
+func (r *runnerCore) run(mc markChecker) {
+	// NOTE For graceful shutdown
+	r.handlerBlockWg.Add(1)
+	defer r.handlerBlockWg.Done()
+
+	halted := false
+	for {
+		select {
+		case <-r.StopCh:
+			halted = true
+			break
+		case sm := <-r.MarkCh:
+			if !r.CheckPriceEnabled {
+				break
+			}
+			mark, ok := mc.markToCheck(sm)
+			if !ok {
+				break
+			}
+			// If 'CheckPrice' is still in progress, keep the mark in mailbox until it's finished
+			if r.mailbox.deliver(mark) {
+				go r.checkPrice(mc, mark)
+			}
+		}
+		if halted {
+			break
+		}
+	}
+
+	r.RunnerBlockWg.Wait()
+	mc.beforeClose()
+}
+
+// Check mark price, then the marks kept in mailbox during the check
+func (r *runnerCore) checkPrice(mc markChecker, mark contract.Mark) {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+
+	// for graceful shutdown, block everything in progress until they are done
+	r.handlerBlockWg.Add(1)
+	defer r.handlerBlockWg.Done()
+
+	defer func() {
+		if e := recover(); e != nil {
+			r.mailbox.reset()
+			r.log.Printf("strategy '%s' panic: %v stack: %s\n", r.uuid, e, string(debug.Stack()))
+			go r.sender.Send(r.user.TelegramChatId, mc.panicText())
+			r.handlerEventsCh.OutOfSync <- r.uuid
+			r.handlerEventsCh.Disable <- r.uuid
+		}
+	}()
+
+	ctx, cancel := r.newCheckContext()
+	defer cancel()
+
+	for marks := []contract.Mark{mark}; len(marks) > 0; marks = r.mailbox.next() {
+		for _, m := range marks {
+			// Stop checking once the strategy is halted, but keep draining mailbox
+			if r.CheckPriceEnabled {
+				r.checkMark(ctx, mc, m)
+			}
+		}
+	}
+}
+
+// Check a single mark price
+func (r *runnerCore) checkMark(ctx context.Context, mc markChecker, mark contract.Mark) {
+	// Make sure the data is valid
+	if err := mc.validateExchangeOrdersDetails(); err != nil {
+		r.log.Printf("[ERROR] %s invalid 'exchange_orders_details', err: %s\n", mc.describe(), err)
+		r.CheckPriceEnabled = false
+		r.handlerEventsCh.OutOfSync <- r.uuid
+		r.handlerEventsCh.Disable <- r.uuid
+		return
+	}
+
+	if p, ok := mc.(markPreparer); ok {
+		p.prepareMark(mark)
+	}
+
+	r.auditHook.setMarkPrice(mark.Price)
+
+	halted, err := r.contract.CheckPriceContext(ctx, mark)
+	if err == nil {
+		r.checkFailures = 0
+	}
+	if err != nil && halted { // scenario: DB fails
+		// Stop receiving Mark
+		r.log.Printf("[ERROR] %s halted with err: %s\n", mc.describe(), err)
+		r.CheckPriceEnabled = false
+		r.handlerEventsCh.OutOfSync <- r.uuid
+		r.handlerEventsCh.Disable <- r.uuid
+	} else if err != nil { // scenario: ftx api 400, still want to retry
+		r.checkFailures++
+		r.log.Printf("[ERROR] %s failures: %d, err: %v\n", mc.describe(), r.checkFailures, err)
+
+		// Drop the mark, back off before checking the next one unless it's been cancelled
+		// NOTE The failed mark isn't checked again, as the marks kept in mailbox are more recent
+		timer := time.NewTimer(retry.PolicyOf(retry.CHECK).Delay(r.checkFailures))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	} else if halted { // scenario: take-profit, err is nil
+		// Stop receiving Mark
+		r.CheckPriceEnabled = false
+		r.log.Printf("[INFO] %s is done!\n", mc.describe())
+		r.handlerEventsCh.Reset <- r.uuid
+	}
+
+	r.LastPriceCheckedTime = time.Now()
+}
@@ -0,0 +1,150 @@
+package runner
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// Priorities of the requests to the exchange, the lower the sooner
+const (
+	ORDER_PRIORITY_STOP_LOSS = iota // place stop-loss order for the opened position
+	ORDER_PRIORITY_CLOSE            // close position, cancel order
+	ORDER_PRIORITY_ENTRY            // open a new position
+)
+
+// Process the requests that change the state on the exchange of a user one at a time, all strategies of the user share the same one
+// NOTE Only the hooks should submit requests, submitting from a request would deadlock
+type Executor struct {
+	mutex   sync.Mutex
+	queue   orderRequestQueue
+	seq     uint64
+	stopped bool
+
+	// Held while processing the requests, so that they're still one at a time when callers drain the queue after Stop
+	runMutex sync.Mutex
+
+	wakeCh chan struct{}
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+type orderRequest struct {
+	priority int
+	seq      uint64 // requests with the same priority are processed in order
+	fn       func()
+	panicked interface{}
+	done     chan struct{}
+}
+
+func NewExecutor() *Executor {
+	return &Executor{
+		wakeCh: make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// Block until the request has been processed
+// NOTE The panic of the request is raised by the caller, so that runner can recover it like before
+func (e *Executor) Do(priority int, fn func()) {
+	e.mutex.Lock()
+	req := &orderRequest{priority: priority, seq: e.seq, fn: fn, done: make(chan struct{})}
+	e.seq++
+	heap.Push(&e.queue, req)
+	stopped := e.stopped
+	e.mutex.Unlock()
+
+	if stopped {
+		// Shutting down, Run won't pick it up, drain the queue here after the request in progress
+		e.drain()
+	} else {
+		select {
+		case e.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+	<-req.done
+	if req.panicked != nil {
+		panic(req.panicked)
+	}
+}
+
+func (e *Executor) Run() {
+	defer close(e.doneCh)
+	for {
+		select {
+		case <-e.stopCh:
+			return
+		case <-e.wakeCh:
+		}
+		e.drain()
+	}
+}
+
+// Requests in the queue are still processed before it stops
+func (e *Executor) Stop() {
+	e.mutex.Lock()
+	if e.stopped {
+		e.mutex.Unlock()
+		return
+	}
+	e.stopped = true
+	e.mutex.Unlock()
+
+	close(e.stopCh)
+	<-e.doneCh
+	e.drain()
+}
+
+// Process the requests in the queue until it's empty
+func (e *Executor) drain() {
+	e.runMutex.Lock()
+	defer e.runMutex.Unlock()
+	for req := e.next(); req != nil; req = e.next() {
+		e.process(req)
+	}
+}
+
+func (e *Executor) next() *orderRequest {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.queue.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(&e.queue).(*orderRequest)
+}
+
+func (e *Executor) process(req *orderRequest) {
+	defer close(req.done)
+	defer func() {
+		req.panicked = recover()
+	}()
+	req.fn()
+}
+
+// Implement heap.Interface
+type orderRequestQueue []*orderRequest
+
+func (q orderRequestQueue) Len() int { return len(q) }
+
+func (q orderRequestQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q orderRequestQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *orderRequestQueue) Push(x interface{}) {
+	*q = append(*q, x.(*orderRequest))
+}
+
+func (q *orderRequestQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	req := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return req
+}
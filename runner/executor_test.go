@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecutorPriority(t *testing.T) {
+	e := NewExecutor()
+	go e.Run()
+	defer e.Stop()
+
+	// Keep the executor busy until all requests are queued
+	blockCh := make(chan struct{})
+	startedCh := make(chan struct{})
+	go e.Do(ORDER_PRIORITY_ENTRY, func() {
+		close(startedCh)
+		<-blockCh
+	})
+	<-startedCh
+
+	var mutex sync.Mutex
+	var processed []string
+	var wg sync.WaitGroup
+	var queued int
+	submit := func(name string, priority int) {
+		queued++
+		wg.Add(1)
+		go e.Do(priority, func() {
+			defer wg.Done()
+			mutex.Lock()
+			processed = append(processed, name)
+			mutex.Unlock()
+		})
+		// Make sure requests are queued in order
+		for {
+			e.mutex.Lock()
+			n := e.queue.Len()
+			e.mutex.Unlock()
+			if n == queued {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	submit("entry 1", ORDER_PRIORITY_ENTRY)
+	submit("entry 2", ORDER_PRIORITY_ENTRY)
+	submit("close", ORDER_PRIORITY_CLOSE)
+	submit("stop-loss", ORDER_PRIORITY_STOP_LOSS)
+	close(blockCh)
+	wg.Wait()
+
+	expected := []string{"stop-loss", "close", "entry 1", "entry 2"}
+	if !reflect.DeepEqual(processed, expected) {
+		t.Errorf("TestExecutorPriority - expected '%v', but got '%v'", expected, processed)
+	}
+}
+
+func TestExecutorPanic(t *testing.T) {
+	e := NewExecutor()
+	go e.Run()
+	defer e.Stop()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("TestExecutorPanic - expected panic 'boom' raised by the caller, but got '%v'", r)
+			}
+		}()
+		e.Do(ORDER_PRIORITY_ENTRY, func() { panic("boom") })
+	}()
+
+	// Keep working after the panic
+	var done bool
+	e.Do(ORDER_PRIORITY_ENTRY, func() { done = true })
+	if !done {
+		t.Error("TestExecutorPanic - expected the request after the panic to be processed")
+	}
+}
+
+func TestExecutorStopped(t *testing.T) {
+	e := NewExecutor()
+	go e.Run()
+	e.Stop()
+
+	var done bool
+	e.Do(ORDER_PRIORITY_CLOSE, func() { done = true })
+	if !done {
+		t.Error("TestExecutorStopped - expected the request to be processed after stopped")
+	}
+}
+
+func TestExecutorStoppedOneAtATime(t *testing.T) {
+	e := NewExecutor()
+	go e.Run()
+
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	track := func(fn func()) func() {
+		return func() {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			fn()
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}
+	}
+
+	// The request in progress is still running when it's stopped
+	releaseCh := make(chan struct{})
+	startedCh := make(chan struct{})
+	go e.Do(ORDER_PRIORITY_CLOSE, track(func() {
+		close(startedCh)
+		<-releaseCh
+	}))
+	<-startedCh
+	go e.Stop()
+	for stopped := false; !stopped; {
+		e.mutex.Lock()
+		stopped = e.stopped
+		e.mutex.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.Do(ORDER_PRIORITY_CLOSE, track(func() { time.Sleep(10 * time.Millisecond) }))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(releaseCh)
+	wg.Wait()
+
+	if maxRunning != 1 {
+		t.Errorf("TestExecutorStoppedOneAtATime - expect 1 request running at a time, but got %d", maxRunning)
+	}
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"sort"
+	"sync"
+)
+
+// Keep the marks arriving while CheckPrice is in progress instead of dropping them
+// Only the latest mark and the highest/lowest marks since last check are kept, so that the next check won't miss
+// the stop-loss or take-profit level that was crossed and reversed in between
+type markMailbox struct {
+	mutex   sync.Mutex
+	busy    bool // CheckPrice is in progress
+	pending bool // there are marks that haven't been checked
+
+	latest contract.Mark
+	high   contract.Mark
+	low    contract.Mark
+}
+
+// Return true if CheckPrice isn't in progress, the caller should check the mark right away
+func (m *markMailbox) deliver(mark contract.Mark) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.busy {
+		m.busy = true
+		return true
+	}
+	if !m.pending {
+		m.pending = true
+		m.latest, m.high, m.low = mark, mark, mark
+		return false
+	}
+	m.latest = mark
+	if mark.Price.GreaterThan(m.high.Price) {
+		m.high = mark
+	}
+	if mark.Price.LessThan(m.low.Price) {
+		m.low = mark
+	}
+	return false
+}
+
+// Called once the check is done, return the marks to be checked next in time order
+// If there is nothing pending, CheckPrice is considered finished
+func (m *markMailbox) next() []contract.Mark {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.pending {
+		m.busy = false
+		return nil
+	}
+	m.pending = false
+
+	var marks []contract.Mark
+	for _, mark := range []contract.Mark{m.high, m.low, m.latest} {
+		duplicated := false
+		for _, added := range marks {
+			if added.Time.Equal(mark.Time) && added.Price.Equal(mark.Price) {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			marks = append(marks, mark)
+		}
+	}
+	sort.SliceStable(marks, func(i, j int) bool {
+		return marks[i].Time.Before(marks[j].Time)
+	})
+	return marks
+}
+
+// Drop the pending marks and consider CheckPrice finished e.g. after panic
+func (m *markMailbox) reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.busy = false
+	m.pending = false
+}
@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMarkMailbox(t *testing.T) {
+	start := time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC)
+	newMark := func(second int, price int64) contract.Mark {
+		return contract.Mark{Price: decimal.NewFromInt(price), Time: start.Add(time.Second * time.Duration(second))}
+	}
+
+	testcases := []struct {
+		title          string
+		incoming       []contract.Mark // arriving while CheckPrice is in progress
+		expectedPrices []int64
+	}{
+		{title: "nothing arrived", incoming: nil, expectedPrices: nil},
+		{title: "single mark", incoming: []contract.Mark{newMark(1, 100)}, expectedPrices: []int64{100}},
+		{title: "high then low", incoming: []contract.Mark{newMark(1, 100), newMark(2, 120), newMark(3, 80), newMark(4, 100)}, expectedPrices: []int64{120, 80, 100}},
+		{title: "low then high", incoming: []contract.Mark{newMark(1, 100), newMark(2, 80), newMark(3, 120), newMark(4, 110)}, expectedPrices: []int64{80, 120, 110}},
+		{title: "latest is the high", incoming: []contract.Mark{newMark(1, 100), newMark(2, 90), newMark(3, 120)}, expectedPrices: []int64{90, 120}},
+	}
+
+	for _, tc := range testcases {
+		var m markMailbox
+		if !m.deliver(newMark(0, 100)) {
+			t.Errorf("TestMarkMailbox case '%s' - expect the first mark to be checked right away", tc.title)
+		}
+		for _, mark := range tc.incoming {
+			if m.deliver(mark) {
+				t.Errorf("TestMarkMailbox case '%s' - expect mark to be kept while busy", tc.title)
+			}
+		}
+
+		var prices []int64
+		for _, mark := range m.next() {
+			prices = append(prices, mark.Price.IntPart())
+		}
+		if len(prices) != len(tc.expectedPrices) {
+			t.Errorf("TestMarkMailbox case '%s' - expect %v, but got %v", tc.title, tc.expectedPrices, prices)
+			continue
+		}
+		for i := range prices {
+			if prices[i] != tc.expectedPrices[i] {
+				t.Errorf("TestMarkMailbox case '%s' - expect %v, but got %v", tc.title, tc.expectedPrices, prices)
+				break
+			}
+		}
+
+		// Nothing is pending, CheckPrice is finished
+		if len(tc.incoming) > 0 && m.next() != nil {
+			t.Errorf("TestMarkMailbox case '%s' - expect mailbox to be empty", tc.title)
+		}
+		if !m.deliver(newMark(10, 100)) {
+			t.Errorf("TestMarkMailbox case '%s' - expect mark to be checked right away after finished", tc.title)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package runner
+
+import (
+	"context"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/pair"
+	"crypto-trading-bot-engine/util/retry"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+)
+
+// Pair strategy reuses the state machine of contract.Contract, triggers are evaluated against the synthetic price
+// Both legs are opened and closed together, side 'LONG' means long leg A and short leg B
+// NOTE Stop-loss is watched by the engine, as there is no synthetic price on the exchange
+type pairHook struct {
+	pairStrategy *db.PairStrategy
+	pair         *pair.Pair
+	user         *db.User
+
+	log      *log.Logger
+	db       *db.DB
+	exchange exchange.Exchanger // by user_id
+
+	// Send the notification, only support telegram atm
+	sender message.Messenger // all users use the same one, but sent with different chat_id
+
+	// Requests to the exchange of the user, see contractHook
+	executor *Executor
+}
+
+func newPairHook(ps *db.PairStrategy, p *pair.Pair) *pairHook {
+	return &pairHook{
+		pairStrategy: ps,
+		pair:         p,
+	}
+}
+
+func (ph *pairHook) setLogger(l *log.Logger) {
+	ph.log = l
+}
+
+func (ph *pairHook) setExchange(ex exchange.Exchanger) {
+	ph.exchange = ex
+}
+
+func (ph *pairHook) setSender(m message.Messenger) {
+	ph.sender = m
+}
+
+func (ph *pairHook) setUser(u *db.User) {
+	ph.user = u
+}
+
+func (ph *pairHook) setExecutor(e *Executor) {
+	ph.executor = e
+}
+
+func (ph *pairHook) EntryTriggered(c *contract.Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	return ph.EntryTriggeredContext(context.Background(), c, t, p)
+}
+
+// NOTE See contractHook.EntryTriggeredContext
+func (ph *pairHook) EntryTriggeredContext(ctx context.Context, c *contract.Contract, t time.Time, p decimal.Decimal) (price decimal.Decimal, halted bool, err error) {
+	ph.executor.Do(ORDER_PRIORITY_ENTRY, func() {
+		price, halted, err = ph.entryTriggered(ctx, p)
+	})
+	return
+}
+
+func (ph *pairHook) entryTriggered(ctx context.Context, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	// Cancelled while waiting in the queue e.g. shutdown, disable, nothing has been sent
+	if err := ctx.Err(); err != nil {
+		return p, false, fmt.Errorf("EntryTriggered - %v", err)
+	}
+
+	// The exchange keeps failing, the user has been notified by the circuit breaker
+	if err := exchange.EntryAllowed(ph.exchange); err != nil {
+		return p, false, fmt.Errorf("EntryTriggered - %v", err)
+	}
+
+	// The last entry orders might have been placed without knowing the results e.g. timeout, close the legs filled
+	if ph.pairStrategy.PendingClientOrderId != "" {
+		if err := ph.closePendingEntryOrders(detach(ctx)); err != nil {
+			ph.notify("[錯誤] 無法確認上一筆開倉單, err: %v", err)
+			return p, false, fmt.Errorf("EntryTriggered - failed to check pending entry orders, err: %v", err)
+		}
+	}
+
+	priceA := ph.pair.MarkA.Price
+	priceB := ph.pair.MarkB.Price
+	sizeA, sizeB := ph.pair.LegSizes(ph.pairStrategy.Margin, priceA, priceB)
+	sideA := order.Side(ph.pairStrategy.Side)
+	sideB := flipSide(sideA)
+
+	// Save client order id before placing the orders, so that the legs can be found even if the results are lost
+	clientId, err := ph.newEntryClientOrderId()
+	if err != nil {
+		ph.notify("[Error] '%s' Internal Server Error. Please check and reset your positions", ph.name())
+		return p, false, fmt.Errorf("EntryTriggered - failed to save client order id, err: %v", err)
+	}
+	clientIdA := legClientOrderId(clientId, "leg_a")
+	clientIdB := legClientOrderId(clientId, "leg_b")
+
+	// Place the order of leg A
+	// NOTE Client order id is kept if it fails, as the order might have been placed, it'll be checked next time
+	orderIdA, err := ph.exchange.PlaceEntryOrder(ph.pair.SymbolA, sideA, sizeA, clientIdA)
+	if err != nil {
+		ph.notify("[錯誤] 無法開倉 %s, err: %v", ph.pair.SymbolA, err)
+		return p, false, fmt.Errorf("EntryTriggered - failed to place entry order of leg A, err: %v", err)
+	}
+
+	// Place the order of leg B, close the legs filled if it fails, as one leg only isn't what the strategy is about
+	// NOTE Legs are never adopted, leg B might have been filled without knowing the result, so both are looked up
+	orderIdB, err := ph.exchange.PlaceEntryOrder(ph.pair.SymbolB, sideB, sizeB, clientIdB)
+	if err != nil {
+		ph.notify("[錯誤] 無法開倉 %s, 關閉 %s 倉位, err: %v", ph.pair.SymbolB, ph.pair.SymbolA, err)
+		if closeErr := ph.closePendingEntryOrders(detach(ctx)); closeErr != nil {
+			return p, true, fmt.Errorf("EntryTriggered - failed to close leg A after leg B failed, err: %v", closeErr)
+		}
+		return p, false, fmt.Errorf("EntryTriggered - failed to place entry order of leg B, err: %v", err)
+	}
+
+	// Notification
+	ph.notify("[開倉] '%s' %s %s @%s, %s %s @%s", ph.name(), order.TranslateSide(sideA), ph.pair.SymbolA, priceA, order.TranslateSide(sideB), ph.pair.SymbolB, priceB)
+
+	// For memory data
+	ph.pairStrategy.PositionStatus = int64(contract.OPENED)
+	ph.pairStrategy.ExchangeOrdersDetails = datatypes.JSONMap{
+		"entry_order": map[string]interface{}{
+			"price": p.String(), // synthetic price
+		},
+		"leg_a": map[string]interface{}{
+			"order_id":        float64(orderIdA),
+			"client_order_id": clientIdA,
+			"price":           priceA.String(),
+			"size":            sizeA.String(),
+		},
+		"leg_b": map[string]interface{}{
+			"order_id":        float64(orderIdB),
+			"client_order_id": clientIdB,
+			"price":           priceB.String(),
+			"size":            sizeB.String(),
+		},
+	}
+	ph.pairStrategy.PendingClientOrderId = ""
+	ph.pairStrategy.LastPositionAt = time.Now()
+
+	// For DB
+	pairStrategy := map[string]interface{}{
+		"position_status":         ph.pairStrategy.PositionStatus,
+		"exchange_orders_details": ph.pairStrategy.ExchangeOrdersDetails,
+		"pending_client_order_id": ph.pairStrategy.PendingClientOrderId,
+		"last_position_at":        ph.pairStrategy.LastPositionAt,
+	}
+	if _, err = ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		ph.notify("[Error] '%s' Internal Server Error. Please check and reset your positions", ph.name())
+		return p, true, fmt.Errorf("EntryTriggered - failed to update 'exchange_orders_details', err: %v", err)
+	}
+	return p, false, nil
+}
+
+// Stop-loss is watched by the engine, there is no order to be placed
+func (ph *pairHook) StopLossTriggerCreated(c *contract.Contract) (bool, error) {
+	p := c.StopLossOrder.(*order.StopLoss).Trigger.GetPrice(time.Now())
+	ph.notify("[提示] 已設定 '%s' 停損價 @%s", ph.name(), p)
+	return false, nil
+}
+
+func (ph *pairHook) StopLossTriggerCreatedContext(_ context.Context, c *contract.Contract) (bool, error) {
+	return ph.StopLossTriggerCreated(c)
+}
+
+func (ph *pairHook) StopLossTriggered(c *contract.Contract, p decimal.Decimal) (bool, error) {
+	return ph.StopLossTriggeredContext(context.Background(), c, p)
+}
+
+// NOTE Closing the legs isn't cancelled with ctx, as the strategy can't carry on once it's started
+func (ph *pairHook) StopLossTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) (bool, error) {
+	ph.notify("[提示] '%s $%s' 停損程序已觸發 @%s", ph.name(), ph.pairStrategy.Margin.StringFixed(0), p.String())
+
+	var err error
+	ph.executor.Do(ORDER_PRIORITY_CLOSE, func() {
+		err = ph.closePositions(detach(ctx))
+	})
+	if err != nil {
+		return true, fmt.Errorf("StopLossTriggered - %v", err)
+	}
+	ph.notify("[停損] '%s $%s' @%s", ph.name(), ph.pairStrategy.Margin.StringFixed(0), p.String())
+
+	// Reset status and exchange_orders_details
+	pairStrategy := map[string]interface{}{
+		"position_status":         int64(contract.CLOSED),
+		"exchange_orders_details": datatypes.JSONMap{},
+	}
+	if _, err := ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		ph.notify("[錯誤] '%s' Internal Server Error. Please check and reset your positions", ph.name())
+		return true, fmt.Errorf("StopLossTriggered - failed to update 'position_status', err: %v", err)
+	}
+
+	// Update memory data
+	ph.pairStrategy.PositionStatus = int64(contract.CLOSED)
+	ph.pairStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
+	return false, nil
+}
+
+func (ph *pairHook) EntryTrendlineTriggerUpdated(c *contract.Contract) {
+	ph.notify("[提示] '%s' 已更新趨勢線", ph.name())
+}
+
+func (ph *pairHook) EntryTriggerOperatorUpdated(c *contract.Contract) {
+	ph.notify("[提示] '%s' 已更新 operator", ph.name())
+}
+
+func (ph *pairHook) TakeProfitTriggered(c *contract.Contract, p decimal.Decimal) error {
+	return ph.TakeProfitTriggeredContext(context.Background(), c, p)
+}
+
+// NOTE Take-profit will always halt the strategy regardless of whether err is thrown
+// NOTE Closing the legs isn't cancelled with ctx, see StopLossTriggeredContext
+func (ph *pairHook) TakeProfitTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) error {
+	ph.notify("[提示] '%s $%s' 停利程序已觸發 @%s", ph.name(), ph.pairStrategy.Margin.StringFixed(0), p.String())
+
+	// Update memory data
+	ph.pairStrategy.Enabled = 0
+
+	// NOTE DB data will be updated via event channel
+	var err error
+	ph.executor.Do(ORDER_PRIORITY_CLOSE, func() {
+		err = ph.closePositions(detach(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	ph.notify("[停利] '%s $%s' @%s", ph.name(), ph.pairStrategy.Margin.StringFixed(0), p.String())
+
+	// Update memory data
+	ph.pairStrategy.PositionStatus = int64(contract.CLOSED)
+	ph.pairStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
+	return nil
+}
+
+// NOTE See contractHook.ParamsUpdated
+func (ph *pairHook) ParamsUpdated(c *contract.Contract) (bool, error) {
+	// Update memory data
+	ph.pairStrategy.Params = datatypes.JSONMap{
+		"entry_type":  c.EntryType,
+		"entry_order": c.EntryOrder,
+	}
+	if c.StopLossOrder != nil {
+		ph.pairStrategy.Params["stop_loss_order"] = c.StopLossOrder
+	}
+	if c.TakeProfitOrder != nil {
+		ph.pairStrategy.Params["take_profit_order"] = c.TakeProfitOrder
+	}
+
+	// Update db
+	pairStrategy := map[string]interface{}{
+		"params": ph.pairStrategy.Params,
+	}
+	if _, err := ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		ph.notify("[Error] '%s' Internal Server Error. Please check and reset your positions", ph.name())
+		return true, fmt.Errorf("ParamsUpdated - failed to update 'params', err: %v", err)
+	}
+
+	return false, nil
+}
+
+// NOTE See contractHook.BreakoutPeakUpdated
+func (ph *pairHook) BreakoutPeakUpdated(c *contract.Contract) {
+	// Update memory data
+	ph.pairStrategy.Params["breakout_peak"] = map[string]interface{}{
+		"time":  c.BreakoutPeak.Time,
+		"price": c.BreakoutPeak.Price,
+	}
+
+	// Update db
+	pairStrategy := map[string]interface{}{
+		"params": ph.pairStrategy.Params,
+	}
+	if _, err := ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		ph.log.Printf("[Error] failed to save breakout peak, err: %v", err)
+	}
+}
+
+// Close both legs, keep going with leg B even if leg A fails, so that it won't leave a naked leg behind
+// NOTE The leg closed is saved right away, so that only the other leg is closed next time e.g. after a restart
+func (ph *pairHook) closePositions(ctx context.Context) error {
+	var errs []error
+	legs := []struct {
+		key    string
+		symbol string
+		side   order.Side
+	}{
+		{key: "leg_a", symbol: ph.pair.SymbolA, side: order.Side(ph.pairStrategy.Side)},
+		{key: "leg_b", symbol: ph.pair.SymbolB, side: flipSide(order.Side(ph.pairStrategy.Side))},
+	}
+	for _, leg := range legs {
+		detail, ok := ph.pairStrategy.ExchangeOrdersDetails[leg.key].(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("'%s' is missing", leg.key))
+			continue
+		}
+		if closed, _ := detail["closed"].(bool); closed {
+			continue
+		}
+		size, err := decimal.NewFromString(detail["size"].(string))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to convert 'size' of '%s', err: %v", leg.key, err))
+			continue
+		}
+		if err = ph.closeLeg(ctx, leg.key, leg.symbol, leg.side, size); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err = ph.saveLegClosed(leg.key, detail); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		ph.notify("[Error] '%s' Internal Server Error. Please check and reset your positions", ph.name())
+		return fmt.Errorf("closePositions err: %v", errs)
+	}
+	return nil
+}
+
+func (ph *pairHook) saveLegClosed(key string, detail map[string]interface{}) error {
+	// For memory data
+	detail["closed"] = true
+	ph.pairStrategy.ExchangeOrdersDetails[key] = detail
+
+	// For DB
+	pairStrategy := map[string]interface{}{
+		"exchange_orders_details": ph.pairStrategy.ExchangeOrdersDetails,
+	}
+	if _, err := ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		return fmt.Errorf("failed to save '%s' closed, err: %v", key, err)
+	}
+	return nil
+}
+
+// Closing order is placed with a new client order id each time, the last one is looked up before retrying
+// NOTE The last one might have been filled without knowing the result, retrying would fail with reduce-only order
+func (ph *pairHook) closeLeg(ctx context.Context, key string, symbol string, side order.Side, size decimal.Decimal) (err error) {
+	var clientId string
+	err = retry.Do(ctx, retry.PolicyOf(retry.PLACE_ORDER), func(attempt int) error {
+		if clientId != "" {
+			filled, err := ph.legOrderFilled(symbol, clientId)
+			if err != nil {
+				return err
+			}
+			if filled.IsPositive() {
+				return nil
+			}
+		}
+		id, err := ph.newClientOrderId()
+		if err != nil {
+			return err
+		}
+		clientId = legClientOrderId(id, key)
+		if err := ph.exchange.ClosePosition(symbol, side, size, clientId); err != nil {
+			ph.notify("[提示] 嘗試關閉 '%s %s' 倉位 (執行次數: %d)", order.TranslateSide(side), symbol, attempt)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		ph.notify("[錯誤] 無法關閉 '%s %s' 倉位, err: %v", order.TranslateSide(side), symbol, err)
+		return fmt.Errorf("closeLeg '%s' err: %v", symbol, err)
+	}
+	ph.notify("[提示] '%s %s' 倉位已成功關閉", order.TranslateSide(side), symbol)
+	return nil
+}
+
+// Look up the entry orders of the legs by the pending client order id, close the legs filled then forget it
+// NOTE Legs are never adopted, the entry is taken again by the next trigger
+func (ph *pairHook) closePendingEntryOrders(ctx context.Context) error {
+	legs := []struct {
+		key    string
+		symbol string
+		side   order.Side
+	}{
+		{key: "leg_a", symbol: ph.pair.SymbolA, side: order.Side(ph.pairStrategy.Side)},
+		{key: "leg_b", symbol: ph.pair.SymbolB, side: flipSide(order.Side(ph.pairStrategy.Side))},
+	}
+
+	// Look up both legs first, nothing is closed if either of them can't be found out
+	sizes := make([]decimal.Decimal, len(legs))
+	for i, leg := range legs {
+		size, err := ph.legOrderFilled(leg.symbol, legClientOrderId(ph.pairStrategy.PendingClientOrderId, leg.key))
+		if err != nil {
+			return err
+		}
+		sizes[i] = size
+	}
+	for i, leg := range legs {
+		if !sizes[i].IsPositive() {
+			continue
+		}
+		ph.notify("[提示] '%s' 已找到未記錄的 %s 開倉單, 關閉此倉位", ph.name(), leg.symbol)
+		if err := ph.closeLeg(ctx, leg.key, leg.symbol, leg.side, sizes[i]); err != nil {
+			return err
+		}
+	}
+	return ph.clearPendingClientOrderId()
+}
+
+// Filled size of the order, zero means it wasn't placed or filled
+func (ph *pairHook) legOrderFilled(symbol string, clientId string) (decimal.Decimal, error) {
+	o, found, err := ph.exchange.GetOrderByClientId(symbol, clientId)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get order '%s', err: %v", clientId, err)
+	}
+	if !found {
+		return decimal.Zero, nil
+	}
+	size, err := decimal.NewFromString(fmt.Sprint(o["size"]))
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to convert size of order '%s', err: %v", clientId, err)
+	}
+	return size, nil
+}
+
+// Increase the attempt and save it, so that the client order id derived from it is never reused, see contractHook.newClientOrderId
+func (ph *pairHook) newClientOrderId() (string, error) {
+	attempt := ph.pairStrategy.OrderAttempt + 1
+	pairStrategy := map[string]interface{}{
+		"order_attempt": attempt,
+	}
+	if _, err := ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		return "", err
+	}
+	ph.pairStrategy.OrderAttempt = attempt
+	return clientOrderId(ph.pairStrategy.Uuid, attempt), nil
+}
+
+// Same as above, it's saved as pending until the entry has been saved
+func (ph *pairHook) newEntryClientOrderId() (string, error) {
+	attempt := ph.pairStrategy.OrderAttempt + 1
+	clientId := clientOrderId(ph.pairStrategy.Uuid, attempt)
+	pairStrategy := map[string]interface{}{
+		"order_attempt":           attempt,
+		"pending_client_order_id": clientId,
+	}
+	if _, err := ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		return "", err
+	}
+	ph.pairStrategy.OrderAttempt = attempt
+	ph.pairStrategy.PendingClientOrderId = clientId
+	return clientId, nil
+}
+
+func (ph *pairHook) clearPendingClientOrderId() error {
+	pairStrategy := map[string]interface{}{
+		"pending_client_order_id": "",
+	}
+	if _, err := ph.db.UpdatePairStrategy(ph.pairStrategy.Uuid, pairStrategy); err != nil {
+		return err
+	}
+	ph.pairStrategy.PendingClientOrderId = ""
+	return nil
+}
+
+// e.g. '3f1c2e7a9b0d4c5e8f6a7b1c-12a', client order id of leg A is suffixed with 'a', and 'b' for leg B
+func legClientOrderId(clientId string, key string) string {
+	if key == "leg_a" {
+		return clientId + "a"
+	}
+	return clientId + "b"
+}
+
+// e.g. 'ETH-PERP/BTC-PERP' or 'ETH-PERP-0.07*BTC-PERP'
+func (ph *pairHook) name() string {
+	if ph.pair.Formula == pair.FORMULA_SPREAD {
+		return fmt.Sprintf("%s %s-%s*%s", order.TranslateSideByInt(ph.pairStrategy.Side), ph.pair.SymbolA, ph.pair.K, ph.pair.SymbolB)
+	}
+	return fmt.Sprintf("%s %s/%s", order.TranslateSideByInt(ph.pairStrategy.Side), ph.pair.SymbolA, ph.pair.SymbolB)
+}
+
+func (ph *pairHook) notify(format string, v ...interface{}) {
+	ph.logWithInfof(format, v...)
+	go ph.sender.Send(ph.user.TelegramChatId, fmt.Sprintf(format, v...))
+}
+
+func (ph *pairHook) logWithInfof(format string, v ...interface{}) {
+	ph.log.Printf("sid: %s uid: %s sym: %s/%s - %s", ph.pairStrategy.Uuid, ph.pairStrategy.UserUuid, ph.pair.SymbolA, ph.pair.SymbolB, fmt.Sprintf(format, v...))
+}
+
+func flipSide(s order.Side) order.Side {
+	if s == order.LONG {
+		return order.SHORT
+	}
+	return order.LONG
+}
@@ -0,0 +1,225 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/strategy"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/pair"
+)
+
+// NOTE See runnerCore, most of the flow is shared with ContractStrategyRunner
+type PairStrategyRunner struct {
+	runnerCore
+
+	PairStrategy *db.PairStrategy
+
+	// Triggers of contract are evaluated against the synthetic price of pair
+	pair            *pair.Pair
+	pairHook        *pairHook
+	beforeCloseFunc func(*db.PairStrategy)
+
+	// The latest marks of legs, only accessed by Run
+	latestMarks map[string]contract.Mark
+
+	// Marks of legs that each synthetic mark waiting to be checked is built from, the hook sizes the legs with them
+	legMarksMutex sync.Mutex
+	legMarks      map[string]legSnapshot // keyed by markId of the synthetic mark
+}
+
+// Snapshot of the marks of legs taken when the synthetic mark is built
+type legSnapshot struct {
+	time  time.Time // of the synthetic mark
+	markA contract.Mark
+	markB contract.Mark
+}
+
+// NOTE The mailbox tells the marks apart with time and price as well
+func markId(mark contract.Mark) string {
+	return fmt.Sprintf("%d:%s", mark.Time.UnixNano(), mark.Price.String())
+}
+
+func NewPairStrategyRunner(ps *db.PairStrategy) (*PairStrategyRunner, error) {
+	p, err := pair.NewPair(ps.SymbolA, ps.SymbolB, ps.Formula, ps.HedgeRatio)
+	if err != nil {
+		return &PairStrategyRunner{}, err
+	}
+
+	// New pair hook
+	ph := newPairHook(ps, p)
+
+	// New contract
+	c, err := contract.NewContract(order.Side(ps.Side), ps.Params)
+	if err != nil {
+		return &PairStrategyRunner{}, err
+	}
+	ah := newAuditHook(ph, db.MARKET_PAIR, ps.Uuid, func() map[string]interface{} { return ps.Params })
+	c.SetHook(ah)
+	c.SetStatus(contract.Status(ps.PositionStatus))
+
+	s := &PairStrategyRunner{
+		runnerCore:   newRunnerCore(ps.Uuid, c, ah),
+		PairStrategy: ps,
+		pair:         p,
+		pairHook:     ph,
+		latestMarks:  make(map[string]contract.Mark),
+		legMarks:     make(map[string]legSnapshot),
+	}
+	return s, err
+}
+
+func (r *PairStrategyRunner) SetLogger(l *log.Logger) {
+	r.log = l
+	r.pairHook.setLogger(l)
+	r.auditHook.setLogger(l)
+}
+
+func (r *PairStrategyRunner) SetDB(db *db.DB) {
+	r.db = db
+	r.pairHook.db = db
+	r.auditHook.setDB(db)
+}
+
+func (r *PairStrategyRunner) SetBeforeCloseFunc(f func(*db.PairStrategy)) {
+	r.beforeCloseFunc = f
+}
+
+func (r *PairStrategyRunner) SetExchangeForHook(ex exchange.Exchanger) {
+	r.pairHook.setExchange(ex)
+}
+
+func (r *PairStrategyRunner) SetExecutorForHook(e *Executor) {
+	r.pairHook.setExecutor(e)
+}
+
+func (r *PairStrategyRunner) SetSender(m message.Messenger) {
+	r.sender = m
+	r.pairHook.setSender(m)
+}
+
+func (r *PairStrategyRunner) SetUser(u *db.User) {
+	r.user = u
+	r.pairHook.setUser(u)
+}
+
+func (r *PairStrategyRunner) SetHandlerEventsCh(ch *strategy.EventsCh) {
+	r.handlerEventsCh = ch
+}
+
+// Close the legs of the entry that was placed but not saved e.g. the process died right after the orders were placed
+// NOTE Call it before Run, see ContractStrategyRunner.RecoverPendingOrder
+func (r *PairStrategyRunner) RecoverPendingOrder() error {
+	if r.PairStrategy.PendingClientOrderId == "" {
+		return nil
+	}
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+
+	var err error
+	r.pairHook.executor.Do(ORDER_PRIORITY_CLOSE, func() {
+		err = r.pairHook.closePendingEntryOrders(detach(r.ctx))
+	})
+	return err
+}
+
+// Start
+func (r *PairStrategyRunner) Run() {
+	r.run(r)
+}
+
+// Synthetic mark built from the latest marks of both legs, false if either of them hasn't arrived yet
+// NOTE It's called by Run, the marks of legs are kept for the hook as the mark might be checked later
+func (r *PairStrategyRunner) markToCheck(sm SymbolMark) (contract.Mark, bool) {
+	// Always keep the latest mark of each leg, even if 'CheckPrice' is still in progress
+	r.latestMarks[sm.Symbol] = sm.Mark
+
+	markA, okA := r.latestMarks[r.pair.SymbolA]
+	markB, okB := r.latestMarks[r.pair.SymbolB]
+	if !okA || !okB {
+		return contract.Mark{}, false
+	}
+	price, err := r.pair.SyntheticPrice(markA.Price, markB.Price)
+	if err != nil {
+		r.log.Printf("[ERROR] pair strategy: '%s', user: '%s', failed to get synthetic mark, err: %v\n", r.PairStrategy.Uuid, r.PairStrategy.UserUuid, err)
+		return contract.Mark{}, false
+	}
+	t := markA.Time
+	if markB.Time.After(t) {
+		t = markB.Time
+	}
+
+	mark := contract.Mark{Price: price, Time: t}
+
+	r.legMarksMutex.Lock()
+	defer r.legMarksMutex.Unlock()
+	r.legMarks[markId(mark)] = legSnapshot{time: t, markA: markA, markB: markB}
+	return mark, true
+}
+
+// The hook sizes the legs with the marks that the synthetic mark being checked is built from
+// NOTE Marks are checked in time order, the snapshots older than the mark won't be checked anymore e.g. dropped by
+// mailbox, so they are cleared here
+func (r *PairStrategyRunner) prepareMark(mark contract.Mark) {
+	r.legMarksMutex.Lock()
+	defer r.legMarksMutex.Unlock()
+
+	id := markId(mark)
+	legs, ok := r.legMarks[id]
+	for key, l := range r.legMarks {
+		if key == id || l.time.Before(mark.Time) {
+			delete(r.legMarks, key)
+		}
+	}
+	if !ok {
+		// Shouldn't happen, the hook keeps the marks of legs used last time
+		r.log.Printf("[Warn] %s marks of legs for the synthetic mark '%s' are missing\n", r.describe(), id)
+		return
+	}
+	r.pair.UpdateMark(r.pair.SymbolA, legs.markA)
+	r.pair.UpdateMark(r.pair.SymbolB, legs.markB)
+}
+
+func (r *PairStrategyRunner) describe() string {
+	return fmt.Sprintf("pair strategy: '%s', user: '%s', positionStatus: '%s'", r.PairStrategy.Uuid, r.PairStrategy.UserUuid, contract.TranslateStatusByInt(r.PairStrategy.PositionStatus))
+}
+
+func (r *PairStrategyRunner) panicText() string {
+	return fmt.Sprintf("[錯誤] '%s' Internal Server Error. Please check and reset your positions", r.pairHook.name())
+}
+
+func (r *PairStrategyRunner) beforeClose() {
+	r.beforeCloseFunc(r.PairStrategy)
+}
+
+// Check exchange_orders_details, halt the strategy if the data is out of sync
+func (r *PairStrategyRunner) validateExchangeOrdersDetails() error {
+	switch contract.Status(r.PairStrategy.PositionStatus) {
+	case contract.CLOSED:
+		if len(r.PairStrategy.ExchangeOrdersDetails) > 0 {
+			return errors.New("position status: 'CLOSED', 'exchange_orders_details' isn't empty")
+		}
+	case contract.OPENED:
+		for _, key := range []string{"leg_a", "leg_b"} {
+			leg, ok := r.PairStrategy.ExchangeOrdersDetails[key].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("position status: 'OPENED', 'exchange_orders_details.%s' is missing", key)
+			}
+			if _, ok = leg["size"].(string); !ok {
+				return fmt.Errorf("position status: 'OPENED', 'exchange_orders_details.%s.size' is missing", key)
+			}
+		}
+	case contract.UNKNOWN:
+		return errors.New("unknown status")
+	default:
+		return errors.New("undefined status")
+	}
+	return nil
+}
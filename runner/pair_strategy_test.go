@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/pair"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Legs are sized with the marks that the synthetic mark checked is built from, not the ones arriving later
+func TestPrepareMarkSnapshot(t *testing.T) {
+	p, err := pair.NewPair("ETH-PERP", "BTC-PERP", pair.FORMULA_RATIO, decimal.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &PairStrategyRunner{
+		runnerCore:   runnerCore{log: log.New(io.Discard, "", 0)},
+		PairStrategy: &db.PairStrategy{Uuid: "uuid", UserUuid: "user"},
+		pair:         p,
+		latestMarks:  make(map[string]contract.Mark),
+		legMarks:     make(map[string]legSnapshot),
+	}
+
+	now := time.Now()
+	markB := contract.Mark{Price: decimal.NewFromInt(20000), Time: now}
+	legsA := []contract.Mark{
+		{Price: decimal.NewFromInt(2000), Time: now},
+		{Price: decimal.NewFromInt(2100), Time: now.Add(time.Second)},
+		{Price: decimal.NewFromInt(2200), Time: now.Add(2 * time.Second)},
+	}
+	r.markToCheck(SymbolMark{Symbol: "BTC-PERP", Mark: markB})
+	var marks []contract.Mark
+	for _, m := range legsA {
+		mark, ok := r.markToCheck(SymbolMark{Symbol: "ETH-PERP", Mark: m})
+		if !ok {
+			t.Fatalf("TestPrepareMarkSnapshot - expect synthetic mark of '%s'", m.Price)
+		}
+		marks = append(marks, mark)
+	}
+
+	// The marks are checked after all of them have arrived e.g. kept in mailbox
+	for i, mark := range marks {
+		r.prepareMark(mark)
+		if !p.MarkA.Price.Equal(legsA[i].Price) || !p.MarkB.Price.Equal(markB.Price) {
+			t.Errorf("TestPrepareMarkSnapshot mark '%d' - expect legs '%s' and '%s', but got '%s' and '%s'", i, legsA[i].Price, markB.Price, p.MarkA.Price, p.MarkB.Price)
+		}
+	}
+	if len(r.legMarks) != 0 {
+		t.Errorf("TestPrepareMarkSnapshot - expect snapshots cleared, but got %d", len(r.legMarks))
+	}
+}
+
+// The snapshots of the marks dropped by mailbox are cleared once a later mark is checked
+func TestPrepareMarkSnapshotDropped(t *testing.T) {
+	p, err := pair.NewPair("ETH-PERP", "BTC-PERP", pair.FORMULA_RATIO, decimal.Zero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &PairStrategyRunner{
+		runnerCore:   runnerCore{log: log.New(io.Discard, "", 0)},
+		PairStrategy: &db.PairStrategy{Uuid: "uuid", UserUuid: "user"},
+		pair:         p,
+		latestMarks:  make(map[string]contract.Mark),
+		legMarks:     make(map[string]legSnapshot),
+	}
+
+	now := time.Now()
+	r.markToCheck(SymbolMark{Symbol: "BTC-PERP", Mark: contract.Mark{Price: decimal.NewFromInt(20000), Time: now}})
+	var last contract.Mark
+	for i := int64(0); i < 5; i++ {
+		last, _ = r.markToCheck(SymbolMark{Symbol: "ETH-PERP", Mark: contract.Mark{Price: decimal.NewFromInt(2000 + i), Time: now.Add(time.Duration(i) * time.Second)}})
+	}
+	r.prepareMark(last)
+	if len(r.legMarks) != 0 {
+		t.Errorf("TestPrepareMarkSnapshotDropped - expect snapshots cleared, but got %d", len(r.legMarks))
+	}
+	if !p.MarkA.Price.Equal(decimal.NewFromInt(2004)) {
+		t.Errorf("TestPrepareMarkSnapshotDropped - expect leg A '2004', but got '%s'", p.MarkA.Price)
+	}
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"context"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Open positions and stop-loss orders of the account on the exchange
+// NOTE Take it once per user, so that strategies of the same user don't call the exchange again and again
+type ExchangeSnapshot struct {
+	Positions      map[string]map[string]interface{} // key is symbol
+	StopLossOrders map[int64]map[string]interface{}  // key is order id
+	TakenAt        time.Time
+}
+
+func NewExchangeSnapshot(ex exchange.Exchanger) (*ExchangeSnapshot, error) {
+	takenAt := time.Now()
+	positions, err := ex.GetPositions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get positions, err: %v", err)
+	}
+	stopLossOrders, err := ex.GetOpenStopLossOrders()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stop-loss orders, err: %v", err)
+	}
+	return &ExchangeSnapshot{
+		Positions:      positions,
+		StopLossOrders: stopLossOrders,
+		TakenAt:        takenAt,
+	}, nil
+}
+
+// Result of comparing DB state of the strategy with the exchange
+type ReconcileReport struct {
+	PositionGone    bool     // position is opened in DB, but there is none on the exchange
+	StopLossMissing bool     // stop-loss order of DB isn't open on the exchange
+	StopLossFixed   bool     // missing stop-loss order has been placed again
+	Diffs           []string // what doesn't match, it's sent to the user
+}
+
+// Opened position placed on the exchange, alert-only strategy doesn't have one
+func (r *ContractStrategyRunner) Reconcilable() bool {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+
+	cs := r.ContractStrategy
+	return cs.Execution != db.EXECUTION_ALERT_ONLY && contract.Status(cs.PositionStatus) == contract.OPENED && r.contractHook.exchange != nil
+}
+
+// Compare DB state with the exchange, the stop-loss order is placed again if it's the only thing missing
+func (r *ContractStrategyRunner) Reconcile(s *ExchangeSnapshot, fixStopLoss bool) ReconcileReport {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+
+	report := compareWithExchange(r.ContractStrategy, r.contract, s)
+	if fixStopLoss && report.StopLossMissing && len(report.Diffs) == 1 {
+		// NOTE The open position must be protected even if the runner is being stopped, see StopLossTriggerCreatedContext
+		var err error
+		r.contractHook.executor.Do(ORDER_PRIORITY_STOP_LOSS, func() {
+			err = r.contractHook.replaceStopLossOrder(detach(r.ctx), r.contract)
+		})
+		if err != nil {
+			report.Diffs = append(report.Diffs, fmt.Sprintf("failed to place stop-loss order again, err: %v", err))
+		} else {
+			report.StopLossFixed = true
+			report.Diffs = nil
+		}
+	}
+	return report
+}
+
+// Position has gone on the exchange e.g. closed on the exchange app, cancel the stop-loss order left behind
+// NOTE Otherwise it might close the position opened by the user later
+func (r *ContractStrategyRunner) CancelStopLossOrderLeft(s *ExchangeSnapshot) error {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+
+	stopLossOrder, _ := r.ContractStrategy.ExchangeOrdersDetails["stop_loss_order"].(map[string]interface{})
+	orderId, ok := stopLossOrder["order_id"].(float64)
+	if !ok {
+		return nil
+	}
+	if _, ok := s.StopLossOrders[int64(orderId)]; !ok {
+		return nil
+	}
+	var err error
+	r.contractHook.executor.Do(ORDER_PRIORITY_CLOSE, func() {
+		err = r.contractHook.exchange.CancelOpenTriggerOrder(int64(orderId))
+	})
+	if err != nil && !errors.Is(err, exerr.ErrOrderAlreadyClosed) {
+		return err
+	}
+	return nil
+}
+
+// Only opened position is compared, a position of closed strategy might belong to the other strategies or the user
+// NOTE The position opened after the snapshot was taken isn't compared, as it's not in the snapshot
+func compareWithExchange(cs *db.ContractStrategy, c *contract.Contract, s *ExchangeSnapshot) (report ReconcileReport) {
+	if contract.Status(cs.PositionStatus) != contract.OPENED || cs.LastPositionAt.After(s.TakenAt) {
+		return
+	}
+
+	// Position
+	var size decimal.Decimal
+	if entryOrder, ok := cs.ExchangeOrdersDetails["entry_order"].(map[string]interface{}); ok {
+		size, _ = decimal.NewFromString(fmt.Sprint(entryOrder["size"]))
+	}
+	p, ok := s.Positions[cs.Symbol]
+	if !ok {
+		report.PositionGone = true
+		report.Diffs = append(report.Diffs, fmt.Sprintf("position: DB '%s %s', exchange none", order.TranslateSideByInt(cs.Side), size))
+	} else {
+		positionSize, _ := decimal.NewFromString(fmt.Sprint(p["size"]))
+		positionSide, _ := p["side"].(float64)
+		if int64(positionSide) != cs.Side {
+			report.Diffs = append(report.Diffs, fmt.Sprintf("position: DB '%s %s', exchange '%s %s'", order.TranslateSideByInt(cs.Side), size, order.TranslateSideByInt(int64(positionSide)), positionSize))
+		} else if positionSize.LessThan(size) {
+			// NOTE Bigger size is fine, the position might be shared with the other strategies
+			report.Diffs = append(report.Diffs, fmt.Sprintf("position: DB size %s, exchange size %s", size, positionSize))
+		}
+	}
+
+	// Stop-loss order
+	if c.StopLossOrder == nil {
+		return
+	}
+	stopLossOrder, _ := cs.ExchangeOrdersDetails["stop_loss_order"].(map[string]interface{})
+	orderId, ok := stopLossOrder["order_id"].(float64)
+	if !ok {
+		report.StopLossMissing = true
+		report.Diffs = append(report.Diffs, "stop-loss order: DB none")
+		return
+	}
+	if _, ok := s.StopLossOrders[int64(orderId)]; !ok {
+		report.StopLossMissing = true
+		report.Diffs = append(report.Diffs, fmt.Sprintf("stop-loss order: DB '%d', exchange none", int64(orderId)))
+	}
+	return
+}
+
+// Journal the exit of the position closed on the exchange by the user
+func (r *ContractStrategyRunner) RecordManualExit() {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+
+	r.RecordExit(db.TRADE_EXIT_MANUAL)
+}
+
+// Journal the exit that isn't done by the engine e.g. reset, the exit price is the last mark price checked as the
+// real one isn't known, zero if the runner hasn't checked any
+// NOTE RunnerMutex must be held by the caller if the runner is running
+func (r *ContractStrategyRunner) RecordExit(reason string) {
+	// NOTE Not cancelled with the runner, it's usually stopped right after e.g. reset
+	r.contractHook.recordTradeExit(context.Background(), r.auditHook.markPrice, reason)
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"testing"
+
+	"gorm.io/datatypes"
+)
+
+func TestCompareWithExchange(t *testing.T) {
+	openedDetails := datatypes.JSONMap{
+		"entry_order":     map[string]interface{}{"order_id": float64(1), "price": "100", "size": "2"},
+		"stop_loss_order": map[string]interface{}{"order_id": float64(2)},
+	}
+	newSnapshot := func(side order.Side, size string, stopLossOrderIds ...int64) *ExchangeSnapshot {
+		s := &ExchangeSnapshot{
+			Positions:      make(map[string]map[string]interface{}),
+			StopLossOrders: make(map[int64]map[string]interface{}),
+		}
+		if size != "" {
+			s.Positions["ETH-PERP"] = map[string]interface{}{"symbol": "ETH-PERP", "side": float64(side), "size": size}
+		}
+		for _, id := range stopLossOrderIds {
+			s.StopLossOrders[id] = map[string]interface{}{"order_id": float64(id)}
+		}
+		return s
+	}
+
+	testcases := []struct {
+		title                   string
+		status                  contract.Status
+		snapshot                *ExchangeSnapshot
+		expectedPositionGone    bool
+		expectedStopLossMissing bool
+		expectedDiffs           int
+	}{
+		{title: "in sync", status: contract.OPENED, snapshot: newSnapshot(order.LONG, "2", 2)},
+		{title: "closed isn't compared", status: contract.CLOSED, snapshot: newSnapshot(order.LONG, "")},
+		{title: "bigger position shared with the others", status: contract.OPENED, snapshot: newSnapshot(order.LONG, "3", 2)},
+		{title: "stop-loss order missing", status: contract.OPENED, snapshot: newSnapshot(order.LONG, "2", 3), expectedStopLossMissing: true, expectedDiffs: 1},
+		{title: "position gone", status: contract.OPENED, snapshot: newSnapshot(order.LONG, "", 2), expectedPositionGone: true, expectedDiffs: 1},
+		{title: "position and stop-loss order gone", status: contract.OPENED, snapshot: newSnapshot(order.LONG, ""), expectedPositionGone: true, expectedStopLossMissing: true, expectedDiffs: 2},
+		{title: "wrong side", status: contract.OPENED, snapshot: newSnapshot(order.SHORT, "2", 2), expectedDiffs: 1},
+		{title: "smaller position", status: contract.OPENED, snapshot: newSnapshot(order.LONG, "1", 2), expectedDiffs: 1},
+	}
+
+	c := &contract.Contract{Side: order.LONG, StopLossOrder: &order.StopLoss{}}
+	for _, tc := range testcases {
+		cs := &db.ContractStrategy{
+			Symbol:                "ETH-PERP",
+			Side:                  int64(order.LONG),
+			PositionStatus:        int64(tc.status),
+			ExchangeOrdersDetails: openedDetails,
+		}
+		if tc.status == contract.CLOSED {
+			cs.ExchangeOrdersDetails = datatypes.JSONMap{}
+		}
+
+		report := compareWithExchange(cs, c, tc.snapshot)
+		if report.PositionGone != tc.expectedPositionGone {
+			t.Errorf("TestCompareWithExchange case '%s' - expect position gone '%t', but got '%t'", tc.title, tc.expectedPositionGone, report.PositionGone)
+		}
+		if report.StopLossMissing != tc.expectedStopLossMissing {
+			t.Errorf("TestCompareWithExchange case '%s' - expect stop-loss missing '%t', but got '%t'", tc.title, tc.expectedStopLossMissing, report.StopLossMissing)
+		}
+		if len(report.Diffs) != tc.expectedDiffs {
+			t.Errorf("TestCompareWithExchange case '%s' - expect %d diffs, but got %v", tc.title, tc.expectedDiffs, report.Diffs)
+		}
+	}
+}
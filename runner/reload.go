@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/datatypes"
+)
+
+// Params that can't be changed while the position is opened, as the stop-loss order on the exchange depends on them
+var paramsLockedWhenOpened = []string{"entry_type", "stop_loss_order"}
+
+// Swap the contract with the one built from the data reloaded from DB
+// NOTE Position status, exchange_orders_details, etc. in memory are kept, only params, margin, side, group and comment are taken
+func (r *ContractStrategyRunner) Reload(cs *db.ContractStrategy) error {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+
+	if err := checkReload(r.ContractStrategy, cs); err != nil {
+		return err
+	}
+	c, err := contract.NewContract(order.Side(cs.Side), cs.Params)
+	if err != nil {
+		return fmt.Errorf("invalid params, err: %v", err)
+	}
+	if contract.Status(r.ContractStrategy.PositionStatus) == contract.OPENED {
+		// It's unchanged, but the trigger might have been set by the entry price in memory
+		c.StopLossOrder = r.contract.StopLossOrder
+	}
+	r.contract.ReplaceOrders(c)
+
+	// NOTE Update the fields instead of replacing the pointer, hooks share the same one
+	r.ContractStrategy.Params = cs.Params
+	r.ContractStrategy.Margin = cs.Margin
+	r.ContractStrategy.Side = cs.Side
+	r.ContractStrategy.GroupUuid = cs.GroupUuid
+	r.ContractStrategy.Comment = cs.Comment
+	return nil
+}
+
+// Fields of the running strategy that Reload would change, for writing them back to DB when the reload is rejected
+func (r *ContractStrategyRunner) ReloadableFields() map[string]interface{} {
+	r.RunnerMutex.Lock()
+	defer r.RunnerMutex.Unlock()
+	return map[string]interface{}{
+		"params":     r.ContractStrategy.Params,
+		"margin":     r.ContractStrategy.Margin,
+		"side":       r.ContractStrategy.Side,
+		"group_uuid": r.ContractStrategy.GroupUuid,
+	}
+}
+
+// Compare the fields of ReloadableFields, params are compared by path e.g. 'params.entry_order.trendline_trigger.price_1'
+func ReloadDiff(before map[string]interface{}, after map[string]interface{}) datatypes.JSONMap {
+	b, _ := json.Marshal(before)
+	a, _ := json.Marshal(after)
+	return paramsDiff(b, a)
+}
+
+// Check if the changes are safe for the position status of the running strategy
+func checkReload(running *db.ContractStrategy, reloaded *db.ContractStrategy) error {
+	if reloaded.Symbol != running.Symbol || reloaded.Exchange != running.Exchange || reloaded.Execution != running.Execution {
+		return errors.New("symbol, exchange and execution can't be changed, disable and enable the strategy instead")
+	}
+
+	switch contract.Status(running.PositionStatus) {
+	case contract.CLOSED:
+		return nil
+	case contract.OPENED:
+		if reloaded.Side != running.Side {
+			return errors.New("side can't be changed while the position is opened")
+		}
+		if !reloaded.Margin.Equal(running.Margin) {
+			return errors.New("margin can't be changed while the position is opened")
+		}
+		for _, key := range paramsLockedWhenOpened {
+			same, err := sameParam(running.Params[key], reloaded.Params[key])
+			if err != nil {
+				return fmt.Errorf("failed to compare '%s', err: %v", key, err)
+			}
+			if !same {
+				return fmt.Errorf("'%s' can't be changed while the position is opened", key)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("position status '%s' can't be reloaded", contract.TranslateStatusByInt(running.PositionStatus))
+	}
+}
+
+// Params in memory might be the orders instead of the maps read from DB, compare them in JSON
+func sameParam(a interface{}, b interface{}) (bool, error) {
+	var normalized [2]interface{}
+	for i, v := range []interface{}{a, b} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false, err
+		}
+		if err = json.Unmarshal(data, &normalized[i]); err != nil {
+			return false, err
+		}
+	}
+	return reflect.DeepEqual(normalized[0], normalized[1]), nil
+}
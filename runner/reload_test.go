@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/trigger"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+)
+
+func TestCheckReload(t *testing.T) {
+	// Params in memory hold the orders after ParamsUpdated, DB holds the maps
+	running := func(status contract.Status) *db.ContractStrategy {
+		return &db.ContractStrategy{
+			Symbol:         "ETH-PERP",
+			Exchange:       "FTX",
+			Execution:      db.EXECUTION_EXCHANGE,
+			Side:           int64(order.LONG),
+			Margin:         decimal.NewFromInt(100),
+			PositionStatus: int64(status),
+			Params: datatypes.JSONMap{
+				"entry_type": order.ENTRY_LIMIT,
+				"stop_loss_order": &order.StopLoss{Trigger: &trigger.Limit{
+					TriggerType: "limit",
+					Operator:    "<=",
+					Price:       decimal.NewFromInt(3000),
+				}},
+			},
+		}
+	}
+	reloaded := func(f func(cs *db.ContractStrategy)) *db.ContractStrategy {
+		cs := &db.ContractStrategy{
+			Symbol:    "ETH-PERP",
+			Exchange:  "FTX",
+			Execution: db.EXECUTION_EXCHANGE,
+			Side:      int64(order.LONG),
+			Margin:    decimal.NewFromInt(100),
+			Params: datatypes.JSONMap{
+				"entry_type": order.ENTRY_LIMIT,
+				"stop_loss_order": map[string]interface{}{
+					"trigger":                        map[string]interface{}{"price": "3000", "operator": "<=", "trigger_type": "limit"},
+					"trendline_readjustment_enabled": false,
+					"loss_tolerance_percent":         float64(0),
+				},
+				"take_profit_order": map[string]interface{}{
+					"trigger": map[string]interface{}{"price": "4000", "operator": ">=", "trigger_type": "limit"},
+				},
+			},
+		}
+		if f != nil {
+			f(cs)
+		}
+		return cs
+	}
+
+	testcases := []struct {
+		title       string
+		status      contract.Status
+		reloaded    *db.ContractStrategy
+		expectedErr bool
+	}{
+		{title: "closed, anything in params", status: contract.CLOSED, reloaded: reloaded(func(cs *db.ContractStrategy) {
+			cs.Params["entry_type"] = order.ENTRY_TRENDLINE
+			cs.Margin = decimal.NewFromInt(200)
+		})},
+		{title: "closed, symbol", status: contract.CLOSED, reloaded: reloaded(func(cs *db.ContractStrategy) { cs.Symbol = "BTC-PERP" }), expectedErr: true},
+		{title: "opened, take-profit", status: contract.OPENED, reloaded: reloaded(nil)},
+		{title: "opened, stop-loss", status: contract.OPENED, reloaded: reloaded(func(cs *db.ContractStrategy) {
+			cs.Params["stop_loss_order"].(map[string]interface{})["trigger"].(map[string]interface{})["price"] = "2900"
+		}), expectedErr: true},
+		{title: "opened, entry type", status: contract.OPENED, reloaded: reloaded(func(cs *db.ContractStrategy) { cs.Params["entry_type"] = order.ENTRY_TRENDLINE }), expectedErr: true},
+		{title: "opened, side", status: contract.OPENED, reloaded: reloaded(func(cs *db.ContractStrategy) { cs.Side = int64(order.SHORT) }), expectedErr: true},
+		{title: "opened, margin", status: contract.OPENED, reloaded: reloaded(func(cs *db.ContractStrategy) { cs.Margin = decimal.NewFromInt(200) }), expectedErr: true},
+		{title: "unknown", status: contract.UNKNOWN, reloaded: reloaded(nil), expectedErr: true},
+	}
+
+	for _, tc := range testcases {
+		err := checkReload(running(tc.status), tc.reloaded)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("TestCheckReload case '%s' - expected err: %t, but got '%v'", tc.title, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestReloadDiff(t *testing.T) {
+	before := map[string]interface{}{
+		"params":     datatypes.JSONMap{"entry_type": order.ENTRY_LIMIT, "entry_order": map[string]interface{}{"trigger": map[string]interface{}{"price": "3000"}}},
+		"margin":     decimal.NewFromInt(100),
+		"side":       int64(order.LONG),
+		"group_uuid": "",
+	}
+	after := map[string]interface{}{
+		"params":     datatypes.JSONMap{"entry_type": order.ENTRY_LIMIT, "entry_order": map[string]interface{}{"trigger": map[string]interface{}{"price": "3100"}}},
+		"margin":     decimal.NewFromInt(200),
+		"side":       int64(order.LONG),
+		"group_uuid": "",
+	}
+
+	diff := ReloadDiff(before, after)
+	if len(diff) != 2 || diff["params.entry_order.trigger.price"] == nil || diff["margin"] == nil {
+		t.Errorf("TestReloadDiff - expect price and margin changed, but got %v", diff)
+	}
+	if diff := ReloadDiff(before, before); len(diff) != 0 {
+		t.Errorf("TestReloadDiff - expect no diff, but got %v", diff)
+	}
+}
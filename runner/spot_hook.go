@@ -0,0 +1,340 @@
+package runner
+
+import (
+	"context"
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/exchange/exerr"
+	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+	"crypto-trading-bot-engine/strategy/trigger"
+	"crypto-trading-bot-engine/util/retry"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/datatypes"
+)
+
+// Spot strategy reuses the state machine of contract.Contract with side 'LONG'
+// Entry buys base asset with quote balance, stop-loss and take-profit sell the base asset being held
+// NOTE Stop-loss is watched by the engine instead of a trigger order on the exchange
+type spotHook struct {
+	spotStrategy *db.SpotStrategy
+	user         *db.User
+
+	log      *log.Logger
+	db       *db.DB
+	exchange exchange.Exchanger // by user_id
+
+	// Send the notification, only support telegram atm
+	sender message.Messenger // all users use the same one, but sent with different chat_id
+
+	// Requests to the exchange of the user, see contractHook
+	executor *Executor
+}
+
+func newSpotHook(ss *db.SpotStrategy) *spotHook {
+	return &spotHook{
+		spotStrategy: ss,
+	}
+}
+
+func (sh *spotHook) setLogger(l *log.Logger) {
+	sh.log = l
+}
+
+func (sh *spotHook) setExchange(ex exchange.Exchanger) {
+	sh.exchange = ex
+}
+
+func (sh *spotHook) setSender(m message.Messenger) {
+	sh.sender = m
+}
+
+func (sh *spotHook) setUser(u *db.User) {
+	sh.user = u
+}
+
+func (sh *spotHook) setExecutor(e *Executor) {
+	sh.executor = e
+}
+
+func (sh *spotHook) EntryTriggered(c *contract.Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	return sh.EntryTriggeredContext(context.Background(), c, t, p)
+}
+
+// NOTE See contractHook.EntryTriggeredContext
+func (sh *spotHook) EntryTriggeredContext(ctx context.Context, c *contract.Contract, t time.Time, p decimal.Decimal) (price decimal.Decimal, halted bool, err error) {
+	sh.executor.Do(ORDER_PRIORITY_ENTRY, func() {
+		price, halted, err = sh.entryTriggered(ctx, p)
+	})
+	return
+}
+
+func (sh *spotHook) entryTriggered(ctx context.Context, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	// Cancelled while waiting in the queue e.g. shutdown, disable, nothing has been sent
+	if err := ctx.Err(); err != nil {
+		return p, false, fmt.Errorf("EntryTriggered - %v", err)
+	}
+
+	base, quote, err := splitSpotSymbol(sh.spotStrategy.Symbol)
+	if err != nil {
+		sh.notify("[Error] '%s' Internal Server Error. Please check your strategy", sh.spotStrategy.Symbol)
+		return p, true, fmt.Errorf("EntryTriggered - %v", err)
+	}
+
+	// The exchange keeps failing, the user has been notified by the circuit breaker
+	if err := exchange.EntryAllowed(sh.exchange); err != nil {
+		return p, false, fmt.Errorf("EntryTriggered - %v", err)
+	}
+
+	// Spend the amount given, but not more than what quote balance has
+	balance, err := sh.exchange.GetBalance(quote)
+	if err != nil {
+		sh.notify("[錯誤] 無法取得 %s 餘額, err: %v", quote, err)
+		return p, false, fmt.Errorf("EntryTriggered - failed to get '%s' balance, err: %v", quote, err)
+	}
+	amount := sh.spotStrategy.Amount
+	if balance.LessThan(amount) {
+		amount = balance
+	}
+	size := amount.DivRound(p, 8)
+	if !size.IsPositive() {
+		sh.notify("[錯誤] %s 餘額不足, 無法買入 %s", quote, base)
+		return p, false, fmt.Errorf("EntryTriggered - insufficient '%s' balance", quote)
+	}
+
+	// Place buy order
+	orderId, err := sh.exchange.PlaceSpotMarketOrder(sh.spotStrategy.Symbol, order.LONG, size)
+	if err != nil {
+		sh.notify("[錯誤] 無法買入, err: %v", err)
+		return p, false, fmt.Errorf("EntryTriggered - failed to place buy order, err: %v", err)
+	}
+
+	// Notification
+	sh.notify("[買入] '%s $%s' @%s", sh.spotStrategy.Symbol, amount.StringFixed(0), p.String())
+
+	// For memory data
+	sh.spotStrategy.PositionStatus = int64(contract.OPENED)
+	sh.spotStrategy.ExchangeOrdersDetails = datatypes.JSONMap{
+		"entry_order": map[string]interface{}{
+			"order_id": float64(orderId),
+			"price":    p.String(),
+			"size":     size.String(),
+		},
+	}
+	sh.spotStrategy.LastPositionAt = time.Now()
+
+	// For DB
+	spotStrategy := map[string]interface{}{
+		"position_status":         sh.spotStrategy.PositionStatus,
+		"exchange_orders_details": sh.spotStrategy.ExchangeOrdersDetails,
+		"last_position_at":        sh.spotStrategy.LastPositionAt,
+	}
+	if _, err = sh.db.UpdateSpotStrategy(sh.spotStrategy.Uuid, spotStrategy); err != nil {
+		sh.notify("[Error] '%s' Internal Server Error. Please check your balance and order", sh.spotStrategy.Symbol)
+		return p, true, fmt.Errorf("EntryTriggered - failed to update 'exchange_orders_details', err: %v", err)
+	}
+	return p, false, nil
+}
+
+// Stop-loss is watched by the engine, there is no order to be placed
+func (sh *spotHook) StopLossTriggerCreated(c *contract.Contract) (bool, error) {
+	p := c.StopLossOrder.(*order.StopLoss).Trigger.GetPrice(time.Now())
+	sh.notify("[提示] 已設定 %s 停損價 @%s", sh.spotStrategy.Symbol, p)
+	return false, nil
+}
+
+func (sh *spotHook) StopLossTriggerCreatedContext(_ context.Context, c *contract.Contract) (bool, error) {
+	return sh.StopLossTriggerCreated(c)
+}
+
+func (sh *spotHook) StopLossTriggered(c *contract.Contract, p decimal.Decimal) (bool, error) {
+	return sh.StopLossTriggeredContext(context.Background(), c, p)
+}
+
+// NOTE Selling the holding isn't cancelled with ctx, as the strategy can't carry on once it's started
+func (sh *spotHook) StopLossTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) (bool, error) {
+	sh.notify("[提示] '%s $%s' 停損程序已觸發 @%s", sh.spotStrategy.Symbol, sh.spotStrategy.Amount.StringFixed(0), p.String())
+
+	var err error
+	sh.executor.Do(ORDER_PRIORITY_CLOSE, func() {
+		err = sh.sellHolding(detach(ctx))
+	})
+	if err != nil {
+		return true, fmt.Errorf("StopLossTriggered - %v", err)
+	}
+	sh.notify("[停損] '%s $%s' @%s", sh.spotStrategy.Symbol, sh.spotStrategy.Amount.StringFixed(0), p.String())
+
+	// Reset status and exchange_orders_details
+	spotStrategy := map[string]interface{}{
+		"position_status":         int64(contract.CLOSED),
+		"exchange_orders_details": datatypes.JSONMap{},
+	}
+	if _, err := sh.db.UpdateSpotStrategy(sh.spotStrategy.Uuid, spotStrategy); err != nil {
+		sh.notify("[錯誤] '%s' Internal Server Error. Please check your balance and order", sh.spotStrategy.Symbol)
+		return true, fmt.Errorf("StopLossTriggered - failed to update 'position_status', err: %v", err)
+	}
+
+	// Update memory data
+	sh.spotStrategy.PositionStatus = int64(contract.CLOSED)
+	sh.spotStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
+	return false, nil
+}
+
+func (sh *spotHook) EntryTrendlineTriggerUpdated(c *contract.Contract) {
+	t := c.EntryOrder.(*order.Entry).TrendlineTrigger
+	// trigger shouldn't be 'nil', but just in case that it won't blow up
+	if t != nil {
+		p1 := t.(*trigger.Line).Price1
+		t1 := t.(*trigger.Line).Time1
+		p2 := t.(*trigger.Line).Price2
+		t2 := t.(*trigger.Line).Time2
+		sh.notify("[提示] '%s' 已更新趨勢線:\n第一點: $%s, '%s'\n第二點: $%s, '%s'", sh.spotStrategy.Symbol, p1, t1.Format("2006-01-02 15:04"), p2, t2.Format("2006-01-02 15:04"))
+	}
+}
+
+func (sh *spotHook) EntryTriggerOperatorUpdated(c *contract.Contract) {
+	sh.notify("[提示] '%s' 已更新 operator", sh.spotStrategy.Symbol)
+}
+
+func (sh *spotHook) TakeProfitTriggered(c *contract.Contract, p decimal.Decimal) error {
+	return sh.TakeProfitTriggeredContext(context.Background(), c, p)
+}
+
+// NOTE Take-profit will always halt the strategy regardless of whether err is thrown
+// NOTE Selling the holding isn't cancelled with ctx, see StopLossTriggeredContext
+func (sh *spotHook) TakeProfitTriggeredContext(ctx context.Context, c *contract.Contract, p decimal.Decimal) error {
+	sh.notify("[提示] '%s $%s' 停利程序已觸發 @%s", sh.spotStrategy.Symbol, sh.spotStrategy.Amount.StringFixed(0), p.String())
+
+	// Update memory data
+	sh.spotStrategy.Enabled = 0
+
+	// NOTE DB data will be updated via event channel
+	var err error
+	sh.executor.Do(ORDER_PRIORITY_CLOSE, func() {
+		err = sh.sellHolding(detach(ctx))
+	})
+	if err != nil {
+		return err
+	}
+	sh.notify("[停利] '%s $%s' @%s", sh.spotStrategy.Symbol, sh.spotStrategy.Amount.StringFixed(0), p.String())
+
+	// Update memory data
+	sh.spotStrategy.PositionStatus = int64(contract.CLOSED)
+	sh.spotStrategy.ExchangeOrdersDetails = datatypes.JSONMap{}
+	return nil
+}
+
+// NOTE See contractHook.ParamsUpdated
+func (sh *spotHook) ParamsUpdated(c *contract.Contract) (bool, error) {
+	// Update memory data
+	sh.spotStrategy.Params = datatypes.JSONMap{
+		"entry_type":  c.EntryType,
+		"entry_order": c.EntryOrder,
+	}
+	if c.StopLossOrder != nil {
+		sh.spotStrategy.Params["stop_loss_order"] = c.StopLossOrder
+	}
+	if c.TakeProfitOrder != nil {
+		sh.spotStrategy.Params["take_profit_order"] = c.TakeProfitOrder
+	}
+
+	// Update db
+	spotStrategy := map[string]interface{}{
+		"params": sh.spotStrategy.Params,
+	}
+	if _, err := sh.db.UpdateSpotStrategy(sh.spotStrategy.Uuid, spotStrategy); err != nil {
+		sh.notify("[Error] '%s' Internal Server Error. Please check your balance and order", sh.spotStrategy.Symbol)
+		return true, fmt.Errorf("ParamsUpdated - failed to update 'params', err: %v", err)
+	}
+
+	return false, nil
+}
+
+// NOTE See contractHook.BreakoutPeakUpdated
+func (sh *spotHook) BreakoutPeakUpdated(c *contract.Contract) {
+	// Update memory data
+	sh.spotStrategy.Params["breakout_peak"] = map[string]interface{}{
+		"time":  c.BreakoutPeak.Time,
+		"price": c.BreakoutPeak.Price,
+	}
+
+	// Update db
+	spotStrategy := map[string]interface{}{
+		"params": sh.spotStrategy.Params,
+	}
+	if _, err := sh.db.UpdateSpotStrategy(sh.spotStrategy.Uuid, spotStrategy); err != nil {
+		sh.log.Printf("[Error] failed to save breakout peak, err: %v", err)
+	}
+}
+
+// Sell base asset that was bought by entry order
+// NOTE Sell the smaller one between entry size and free balance, as fee might have been deducted from base asset
+//      , and the user might have sold some manually
+func (sh *spotHook) sellHolding(ctx context.Context) error {
+	base, _, err := splitSpotSymbol(sh.spotStrategy.Symbol)
+	if err != nil {
+		return err
+	}
+	size, err := decimal.NewFromString(sh.spotStrategy.ExchangeOrdersDetails["entry_order"].(map[string]interface{})["size"].(string))
+	if err != nil {
+		sh.notify("[Error] '%s' Internal Server Error. Please check your balance and order", sh.spotStrategy.Symbol)
+		return fmt.Errorf("failed to convert 'size' from order info, err: %v", err)
+	}
+
+	var soldOut bool
+	err = retry.Do(ctx, retry.PolicyOf(retry.PLACE_ORDER), func(attempt int) error {
+		balance, err := sh.exchange.GetBalance(base)
+		if err != nil {
+			sh.logWithInfof("count: %d, failed to get '%s' balance, err: %v", attempt, base, err)
+			return err
+		}
+		if balance.LessThan(size) {
+			size = balance
+		}
+		if !size.IsPositive() {
+			soldOut = true
+			return nil
+		}
+		if _, err = sh.exchange.PlaceSpotMarketOrder(sh.spotStrategy.Symbol, order.SHORT, size); err != nil {
+			sh.notify("[提示] 嘗試賣出 '%s' (執行次數: %d)", sh.spotStrategy.Symbol, attempt)
+			if exerr.IsPermanent(err) {
+				return retry.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	})
+	if soldOut {
+		sh.notify("[提示] '%s' 已無 %s 可賣出", sh.spotStrategy.Symbol, base)
+		return nil
+	}
+	if err != nil {
+		sh.notify("[錯誤] 無法賣出 '%s', err: %v", sh.spotStrategy.Symbol, err)
+		return fmt.Errorf("sellHolding err: %v", err)
+	}
+	return nil
+}
+
+func (sh *spotHook) notify(format string, v ...interface{}) {
+	sh.logWithInfof(format, v...)
+	go sh.sender.Send(sh.user.TelegramChatId, fmt.Sprintf(format, v...))
+}
+
+func (sh *spotHook) logWithInfof(format string, v ...interface{}) {
+	sh.log.Printf("sid: %s uid: %s sym: %s - %s", sh.spotStrategy.Uuid, sh.spotStrategy.UserUuid, sh.spotStrategy.Symbol, fmt.Sprintf(format, v...))
+}
+
+// e.g. 'BTC/USD' => 'BTC', 'USD'
+func splitSpotSymbol(symbol string) (base string, quote string, err error) {
+	s := strings.Split(symbol, "/")
+	if len(s) != 2 || s[0] == "" || s[1] == "" {
+		return "", "", fmt.Errorf("invalid spot symbol '%s'", symbol)
+	}
+	return s[0], s[1], nil
+}
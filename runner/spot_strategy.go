@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/message"
+	"crypto-trading-bot-engine/strategy"
+	"crypto-trading-bot-engine/strategy/contract"
+	"crypto-trading-bot-engine/strategy/order"
+)
+
+// NOTE See runnerCore, most of the flow is shared with ContractStrategyRunner
+type SpotStrategyRunner struct {
+	runnerCore
+
+	SpotStrategy *db.SpotStrategy
+
+	// Spot strategy reuses the state machine of contract with side 'LONG'
+	spotHook        *spotHook
+	beforeCloseFunc func(string, string, string)
+}
+
+func NewSpotStrategyRunner(ss *db.SpotStrategy) (*SpotStrategyRunner, error) {
+	// New spot hook
+	sh := newSpotHook(ss)
+
+	// New contract, spot can only buy first
+	c, err := contract.NewContract(order.LONG, ss.Params)
+	if err != nil {
+		return &SpotStrategyRunner{}, err
+	}
+	ah := newAuditHook(sh, db.MARKET_SPOT, ss.Uuid, func() map[string]interface{} { return ss.Params })
+	c.SetHook(ah)
+	c.SetStatus(contract.Status(ss.PositionStatus))
+
+	s := &SpotStrategyRunner{
+		runnerCore:   newRunnerCore(ss.Uuid, c, ah),
+		SpotStrategy: ss,
+		spotHook:     sh,
+	}
+	return s, err
+}
+
+func (r *SpotStrategyRunner) SetLogger(l *log.Logger) {
+	r.log = l
+	r.spotHook.setLogger(l)
+	r.auditHook.setLogger(l)
+}
+
+func (r *SpotStrategyRunner) SetDB(db *db.DB) {
+	r.db = db
+	r.spotHook.db = db
+	r.auditHook.setDB(db)
+}
+
+func (r *SpotStrategyRunner) SetBeforeCloseFunc(f func(string, string, string)) {
+	r.beforeCloseFunc = f
+}
+
+func (r *SpotStrategyRunner) SetExchangeForHook(ex exchange.Exchanger) {
+	r.spotHook.setExchange(ex)
+}
+
+func (r *SpotStrategyRunner) SetExecutorForHook(e *Executor) {
+	r.spotHook.setExecutor(e)
+}
+
+func (r *SpotStrategyRunner) SetSender(m message.Messenger) {
+	r.sender = m
+	r.spotHook.setSender(m)
+}
+
+func (r *SpotStrategyRunner) SetUser(u *db.User) {
+	r.user = u
+	r.spotHook.setUser(u)
+}
+
+func (r *SpotStrategyRunner) SetHandlerEventsCh(ch *strategy.EventsCh) {
+	r.handlerEventsCh = ch
+}
+
+// Start
+func (r *SpotStrategyRunner) Run() {
+	r.run(r)
+}
+
+func (r *SpotStrategyRunner) markToCheck(sm SymbolMark) (contract.Mark, bool) {
+	return sm.Mark, true
+}
+
+func (r *SpotStrategyRunner) describe() string {
+	return fmt.Sprintf("spot strategy: '%s', user: '%s', symbol: '%s', positionStatus: '%s'", r.SpotStrategy.Uuid, r.SpotStrategy.UserUuid, r.SpotStrategy.Symbol, contract.TranslateStatusByInt(r.SpotStrategy.PositionStatus))
+}
+
+func (r *SpotStrategyRunner) panicText() string {
+	return fmt.Sprintf("[錯誤] '%s' Internal Server Error. Please check your balance and order", r.SpotStrategy.Symbol)
+}
+
+func (r *SpotStrategyRunner) beforeClose() {
+	r.beforeCloseFunc(r.SpotStrategy.Exchange, r.SpotStrategy.Symbol, r.SpotStrategy.Uuid)
+}
+
+// Check exchange_orders_details, halt the strategy if the data is out of sync
+func (r *SpotStrategyRunner) validateExchangeOrdersDetails() error {
+	switch contract.Status(r.SpotStrategy.PositionStatus) {
+	case contract.CLOSED:
+		if len(r.SpotStrategy.ExchangeOrdersDetails) > 0 {
+			return errors.New("position status: 'CLOSED', 'exchange_orders_details' isn't empty")
+		}
+	case contract.OPENED:
+		entryOrder, ok := r.SpotStrategy.ExchangeOrdersDetails["entry_order"].(map[string]interface{})
+		if !ok {
+			return errors.New("position status: 'OPENED', 'exchange_orders_details.entry_order' is missing")
+		}
+		_, ok = entryOrder["size"].(string)
+		if !ok {
+			return errors.New("position status: 'OPENED', 'exchange_orders_details.entry_order.size' is missing")
+		}
+	case contract.UNKNOWN:
+		return errors.New("unknown status")
+	default:
+		return errors.New("undefined status")
+	}
+	return nil
+}
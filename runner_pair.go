@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/runner"
+	"crypto-trading-bot-engine/strategy/contract"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func (h *runnerHandler) startPairStrategyRunners() {
+	pairStrategies, _, err := h.db.GetEnabledPairStrategies()
+	if err != nil {
+		h.logger.Fatal("err:", err)
+	}
+
+	for _, ps := range pairStrategies {
+		user, err := h.getAndSetUserMap(ps.UserUuid)
+		if err != nil {
+			continue
+		}
+		h.startPairStrategyRunner(ps, user)
+
+		// NOTE avoid sening too many messages at a time
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// NOTE Do not pass pointer of db.PairStrategy, see startContractStrategyRunner
+func (h *runnerHandler) startPairStrategyRunner(ps db.PairStrategy, user *db.User) (err error) {
+	if err = h.newPairStrategyRunner(&ps, user); err != nil {
+		h.logger.Printf("[ERROR] pair strategy: '%s', user: '%s', symbols: '%s', err: %v\n", ps.Uuid, ps.UserUuid, pairSymbols(&ps), err)
+
+		// Disable the pair strategy
+		h.pairEventsCh.OutOfSync <- ps.Uuid
+		h.pairEventsCh.Disable <- ps.Uuid
+		return
+	}
+	return nil
+}
+
+func (h *runnerHandler) newPairStrategyRunner(ps *db.PairStrategy, user *db.User) error {
+	r, err := runner.NewPairStrategyRunner(ps)
+	if err != nil {
+		return fmt.Errorf("Failed to new pair strategy runner, err: %v", err)
+	}
+	r.SetDB(h.db)
+	r.SetLogger(h.logger)
+	r.SetBeforeCloseFunc(h.stopPairStrategyRunner)
+	r.SetHandlerBlockWg(&h.blockWg)
+	r.SetHandlerEventsCh(&h.pairEventsCh)
+
+	// Marks of both legs come from ws of the exchange
+	if err := h.checkWsExchange(newMarkKey(ps.Exchange, ps.SymbolA)); err != nil {
+		return err
+	}
+
+	// New exchange client and set to the hook
+	ex, err := h.getExchangeByUser(ps.Exchange, user)
+	if err != nil {
+		return err
+	}
+	r.SetExchangeForHook(ex)
+	r.SetExecutorForHook(h.getExecutor(ps.UserUuid))
+
+	// Set user for hook
+	r.SetUser(user)
+
+	// Set sender for pair strategy runner and hook
+	r.SetSender(h.sender)
+
+	// Close the legs placed but not saved before the process died
+	// NOTE The runner isn't in the map yet, so the status is changed here instead of via event channel
+	if err := r.RecoverPendingOrder(); err != nil {
+		data := map[string]interface{}{
+			"enabled":         0,
+			"position_status": int64(contract.UNKNOWN),
+		}
+		if _, dbErr := h.db.UpdatePairStrategy(ps.Uuid, data); dbErr != nil {
+			h.logger.Printf("[ERROR] pair strategy: '%s', user: '%s', symbols: '%s', failed to change status, err: %v\n", ps.Uuid, ps.UserUuid, pairSymbols(ps), dbErr)
+		}
+		text := fmt.Sprintf("[錯誤] '%s' 無法確認未記錄的訂單, 請手動確認", pairSymbols(ps))
+		go h.sender.Send(user.TelegramChatId, text)
+		return fmt.Errorf("Failed to recover pending order, err: %v", err)
+	}
+
+	// Manage pair strategy channel, the runner receives marks of both legs
+	h.addIntoRunnersBySymbolMap(newMarkKey(ps.Exchange, ps.SymbolA), ps.Uuid, r)
+	h.addIntoRunnersBySymbolMap(newMarkKey(ps.Exchange, ps.SymbolB), ps.Uuid, r)
+	h.pairRunnerByUuidMap.Store(ps.Uuid, r)
+
+	go r.Run()
+	return nil
+}
+
+func (h *runnerHandler) stopPairStrategyRunner(ps *db.PairStrategy) {
+	h.removeFromRunnersBySymbolMap(newMarkKey(ps.Exchange, ps.SymbolA), ps.Uuid)
+	h.removeFromRunnersBySymbolMap(newMarkKey(ps.Exchange, ps.SymbolB), ps.Uuid)
+	h.pairRunnerByUuidMap.Delete(ps.Uuid)
+}
+
+func (h *runnerHandler) stopAllPair() {
+	h.pairRunnerByUuidMap.Range(func(_, value interface{}) bool {
+		r := value.(*runner.PairStrategyRunner)
+		r.Stop()
+		return true
+	})
+}
+
+func (h *runnerHandler) enablePairStrategy(uuid string) {
+	// Make sure runner isn't in the list
+	_, ok := h.pairRunnerByUuidMap.Load(uuid)
+	if ok {
+		h.logger.Printf("[Error] enablePairStrategy - strategy '%s' is already in the map", uuid)
+		return
+	}
+
+	// Get strategy from DB
+	ps, err := h.db.GetPairStrategyByUuid(uuid)
+	if err != nil {
+		h.logger.Printf("[Error] enablePairStrategy - strategy '%s' not found", uuid)
+		return
+	}
+
+	// Get user data
+	user, err := h.getAndSetUserMap(ps.UserUuid)
+	if err != nil {
+		h.logger.Printf("[Error] enablePairStrategy - user '%s' not found", ps.UserUuid)
+		return
+	}
+
+	// Enable pair strategy
+	data := map[string]interface{}{
+		"enabled": 1,
+	}
+	if _, err := h.db.UpdatePairStrategy(ps.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] enablePairStrategy strategy: '%s', user: '%s', symbols: '%s', err: %v", ps.Uuid, ps.UserUuid, pairSymbols(ps), err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check and reset your positions", pairSymbols(ps))
+		go h.sender.Send(user.TelegramChatId, text)
+		return
+	}
+
+	// Start and new pair strategy runner
+	if err := h.startPairStrategyRunner(*ps, user); err != nil {
+		h.logger.Printf("[ERROR] enablePairStrategy strategy: '%s', user: '%s', symbols: '%s', err: %v", ps.Uuid, ps.UserUuid, pairSymbols(ps), err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please disable your strategy", pairSymbols(ps))
+		go h.sender.Send(user.TelegramChatId, text)
+		return
+	}
+
+	h.logger.Printf("[Info] pair strategy: '%s', user: '%s', symbols: '%s' has been enabled", ps.Uuid, ps.UserUuid, pairSymbols(ps))
+}
+
+func (h *runnerHandler) disablePairStrategy(uuid string) {
+	r, ok := h.pairRunnerByUuidMap.Load(uuid)
+	if !ok {
+		h.logger.Printf("[Error] disablePairStrategy - strategy '%s' isn't in the map", uuid)
+		return
+	}
+
+	// Block until finished
+	// NOTE Must be before RunnerMutex, see disableContractStrategy
+	r.(*runner.PairStrategyRunner).RunnerBlockWg.Add(1)
+	defer r.(*runner.PairStrategyRunner).RunnerBlockWg.Done()
+
+	r.(*runner.PairStrategyRunner).RunnerMutex.Lock()
+	defer r.(*runner.PairStrategyRunner).RunnerMutex.Unlock()
+
+	ps := r.(*runner.PairStrategyRunner).PairStrategy
+
+	// Get user data
+	user, ok := h.userMap.Load(ps.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] disablePairStrategy - user '%s' not found", ps.UserUuid)
+		return
+	}
+
+	// Disable pair strategy
+	data := map[string]interface{}{
+		"enabled": 0,
+	}
+	if _, err := h.db.UpdatePairStrategy(ps.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] disablePairStrategy strategy: '%s', user: '%s', symbols: '%s', err: %v", ps.Uuid, ps.UserUuid, pairSymbols(ps), err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check and reset your positions", pairSymbols(ps))
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		return
+	}
+
+	r.(*runner.PairStrategyRunner).Stop()
+
+	h.logger.Printf("[Info] pair strategy: '%s', user: '%s', symbols: '%s' has been disabled", ps.Uuid, ps.UserUuid, pairSymbols(ps))
+}
+
+func (h *runnerHandler) outOfSyncPairStrategy(uuid string) {
+	r, ok := h.pairRunnerByUuidMap.Load(uuid)
+	if !ok {
+		h.logger.Printf("[Error] outOfSyncPairStrategy - strategy '%s' isn't in the map", uuid)
+		return
+	}
+
+	// Block until finished
+	r.(*runner.PairStrategyRunner).RunnerBlockWg.Add(1)
+	defer r.(*runner.PairStrategyRunner).RunnerBlockWg.Done()
+
+	r.(*runner.PairStrategyRunner).RunnerMutex.Lock()
+	defer r.(*runner.PairStrategyRunner).RunnerMutex.Unlock()
+
+	// Get user data
+	ps := r.(*runner.PairStrategyRunner).PairStrategy
+	user, ok := h.userMap.Load(ps.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] outOfSyncPairStrategy - user '%s' not found", ps.UserUuid)
+		return
+	}
+
+	// Change status
+	data := map[string]interface{}{
+		"position_status": int64(contract.UNKNOWN),
+	}
+	if _, err := h.db.UpdatePairStrategy(ps.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] outOfSyncPairStrategy strategy: '%s', user: '%s', symbols: '%s', err: %v", ps.Uuid, ps.UserUuid, pairSymbols(ps), err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check and reset your positions", pairSymbols(ps))
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		return
+	}
+
+	h.logger.Printf("[Warn] pair strategy: '%s', user: '%s', symbols: '%s' status has been changed to 'UNKNOWN'", ps.Uuid, ps.UserUuid, pairSymbols(ps))
+	text := fmt.Sprintf("[錯誤] '%s' 資料不同步, 請手動確認", pairSymbols(ps))
+	h.sender.Send(user.(*db.User).TelegramChatId, text)
+}
+
+func (h *runnerHandler) resetPairStrategy(uuid string) {
+	r, ok := h.pairRunnerByUuidMap.Load(uuid)
+	if !ok {
+		h.logger.Printf("[Error] resetPairStrategy - strategy '%s' isn't in the map", uuid)
+		return
+	}
+
+	// Block until finished
+	r.(*runner.PairStrategyRunner).RunnerBlockWg.Add(1)
+	defer r.(*runner.PairStrategyRunner).RunnerBlockWg.Done()
+
+	r.(*runner.PairStrategyRunner).RunnerMutex.Lock()
+	defer r.(*runner.PairStrategyRunner).RunnerMutex.Unlock()
+
+	// Get strategy data
+	ps := r.(*runner.PairStrategyRunner).PairStrategy
+
+	// Get user data
+	user, ok := h.userMap.Load(ps.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] resetPairStrategy - user '%s' not found", ps.UserUuid)
+		return
+	}
+
+	// Reset status
+	data := map[string]interface{}{
+		"enabled":                 0,
+		"position_status":         int64(contract.CLOSED),
+		"exchange_orders_details": datatypes.JSONMap{},
+		"pending_client_order_id": "",
+	}
+	if _, err := h.db.UpdatePairStrategy(ps.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] resetPairStrategy strategy: '%s', user: '%s', symbols: '%s', err: %v", ps.Uuid, ps.UserUuid, pairSymbols(ps), err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check and reset your positions", pairSymbols(ps))
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		return
+	}
+
+	r.(*runner.PairStrategyRunner).Stop()
+
+	h.logger.Printf("[Info] pair strategy: '%s', user: '%s', symbols: '%s' has been reset", ps.Uuid, ps.UserUuid, pairSymbols(ps))
+	text := fmt.Sprintf("[提示] 已重置 '%s'", pairSymbols(ps))
+	h.sender.Send(user.(*db.User).TelegramChatId, text)
+}
+
+// e.g. 'ETH-PERP/BTC-PERP'
+func pairSymbols(ps *db.PairStrategy) string {
+	return fmt.Sprintf("%s/%s", ps.SymbolA, ps.SymbolB)
+}
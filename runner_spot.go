@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/runner"
+	"crypto-trading-bot-engine/strategy/contract"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+func (h *runnerHandler) startSpotStrategyRunners() {
+	spotStrategies, _, err := h.db.GetEnabledSpotStrategies()
+	if err != nil {
+		h.logger.Fatal("err:", err)
+	}
+
+	for _, ss := range spotStrategies {
+		user, err := h.getAndSetUserMap(ss.UserUuid)
+		if err != nil {
+			continue
+		}
+		h.startSpotStrategyRunner(ss, user)
+
+		// NOTE avoid sening too many messages at a time
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// NOTE Do not pass pointer of db.SpotStrategy, see startContractStrategyRunner
+func (h *runnerHandler) startSpotStrategyRunner(ss db.SpotStrategy, user *db.User) (err error) {
+	if err = h.newSpotStrategyRunner(&ss, user); err != nil {
+		h.logger.Printf("[ERROR] spot strategy: '%s', user: '%s', symbol: '%s', err: %v\n", ss.Uuid, ss.UserUuid, ss.Symbol, err)
+
+		// Disable the spot strategy
+		h.spotEventsCh.OutOfSync <- ss.Uuid
+		h.spotEventsCh.Disable <- ss.Uuid
+		return
+	}
+	return nil
+}
+
+func (h *runnerHandler) newSpotStrategyRunner(ss *db.SpotStrategy, user *db.User) error {
+	r, err := runner.NewSpotStrategyRunner(ss)
+	if err != nil {
+		return fmt.Errorf("Failed to new spot strategy runner, err: %v", err)
+	}
+	r.SetDB(h.db)
+	r.SetLogger(h.logger)
+	r.SetBeforeCloseFunc(h.stopSpotStrategyRunner)
+	r.SetHandlerBlockWg(&h.blockWg)
+	r.SetHandlerEventsCh(&h.spotEventsCh)
+
+	// Marks come from ws of the exchange
+	key := newMarkKey(ss.Exchange, ss.Symbol)
+	if err := h.checkWsExchange(key); err != nil {
+		return err
+	}
+
+	// New exchange client and set to the hook
+	ex, err := h.getExchangeByUser(ss.Exchange, user)
+	if err != nil {
+		return err
+	}
+	r.SetExchangeForHook(ex)
+	r.SetExecutorForHook(h.getExecutor(ss.UserUuid))
+
+	// Set user for hook
+	r.SetUser(user)
+
+	// Set sender for spot strategy runner and hook
+	r.SetSender(h.sender)
+
+	// Manage spot strategy channel
+	h.addIntoRunnersBySymbolMap(key, ss.Uuid, r)
+	h.spotRunnerByUuidMap.Store(ss.Uuid, r)
+
+	go r.Run()
+	return nil
+}
+
+func (h *runnerHandler) stopSpotStrategyRunner(exchangeName string, symbol string, strategyUuid string) {
+	h.removeFromRunnersBySymbolMap(newMarkKey(exchangeName, symbol), strategyUuid)
+	h.spotRunnerByUuidMap.Delete(strategyUuid)
+}
+
+func (h *runnerHandler) stopAllSpot() {
+	h.spotRunnerByUuidMap.Range(func(_, value interface{}) bool {
+		r := value.(*runner.SpotStrategyRunner)
+		r.Stop()
+		return true
+	})
+}
+
+func (h *runnerHandler) enableSpotStrategy(uuid string) {
+	// Make sure runner isn't in the list
+	_, ok := h.spotRunnerByUuidMap.Load(uuid)
+	if ok {
+		h.logger.Printf("[Error] enableSpotStrategy - strategy '%s' is already in the map", uuid)
+		return
+	}
+
+	// Get strategy from DB
+	ss, err := h.db.GetSpotStrategyByUuid(uuid)
+	if err != nil {
+		h.logger.Printf("[Error] enableSpotStrategy - strategy '%s' not found", uuid)
+		return
+	}
+
+	// Get user data
+	user, err := h.getAndSetUserMap(ss.UserUuid)
+	if err != nil {
+		h.logger.Printf("[Error] enableSpotStrategy - user '%s' not found", ss.UserUuid)
+		return
+	}
+
+	// Enable spot strategy
+	data := map[string]interface{}{
+		"enabled": 1,
+	}
+	if _, err := h.db.UpdateSpotStrategy(ss.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] enableSpotStrategy strategy: '%s', user: '%s', symbol: '%s', err: %v", ss.Uuid, ss.UserUuid, ss.Symbol, err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check your balance and order", ss.Symbol)
+		go h.sender.Send(user.TelegramChatId, text)
+		return
+	}
+
+	// Start and new spot strategy runner
+	if err := h.startSpotStrategyRunner(*ss, user); err != nil {
+		h.logger.Printf("[ERROR] enableSpotStrategy strategy: '%s', user: '%s', symbol: '%s', err: %v", ss.Uuid, ss.UserUuid, ss.Symbol, err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please disable your strategy", ss.Symbol)
+		go h.sender.Send(user.TelegramChatId, text)
+		return
+	}
+
+	h.logger.Printf("[Info] spot strategy: '%s', user: '%s', symbol: '%s' has been enabled", ss.Uuid, ss.UserUuid, ss.Symbol)
+}
+
+func (h *runnerHandler) disableSpotStrategy(uuid string) {
+	r, ok := h.spotRunnerByUuidMap.Load(uuid)
+	if !ok {
+		h.logger.Printf("[Error] disableSpotStrategy - strategy '%s' isn't in the map", uuid)
+		return
+	}
+
+	// Block until finished
+	// NOTE Must be before RunnerMutex, see disableContractStrategy
+	r.(*runner.SpotStrategyRunner).RunnerBlockWg.Add(1)
+	defer r.(*runner.SpotStrategyRunner).RunnerBlockWg.Done()
+
+	r.(*runner.SpotStrategyRunner).RunnerMutex.Lock()
+	defer r.(*runner.SpotStrategyRunner).RunnerMutex.Unlock()
+
+	ss := r.(*runner.SpotStrategyRunner).SpotStrategy
+
+	// Get user data
+	user, ok := h.userMap.Load(ss.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] disableSpotStrategy - user '%s' not found", ss.UserUuid)
+		return
+	}
+
+	// Disable spot strategy
+	data := map[string]interface{}{
+		"enabled": 0,
+	}
+	if _, err := h.db.UpdateSpotStrategy(ss.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] disableSpotStrategy strategy: '%s', user: '%s', symbol: '%s', err: %v", ss.Uuid, ss.UserUuid, ss.Symbol, err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check your balance and order", ss.Symbol)
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		return
+	}
+
+	r.(*runner.SpotStrategyRunner).Stop()
+
+	h.logger.Printf("[Info] spot strategy: '%s', user: '%s', symbol: '%s' has been disabled", ss.Uuid, ss.UserUuid, ss.Symbol)
+}
+
+func (h *runnerHandler) outOfSyncSpotStrategy(uuid string) {
+	r, ok := h.spotRunnerByUuidMap.Load(uuid)
+	if !ok {
+		h.logger.Printf("[Error] outOfSyncSpotStrategy - strategy '%s' isn't in the map", uuid)
+		return
+	}
+
+	// Block until finished
+	r.(*runner.SpotStrategyRunner).RunnerBlockWg.Add(1)
+	defer r.(*runner.SpotStrategyRunner).RunnerBlockWg.Done()
+
+	r.(*runner.SpotStrategyRunner).RunnerMutex.Lock()
+	defer r.(*runner.SpotStrategyRunner).RunnerMutex.Unlock()
+
+	// Get user data
+	ss := r.(*runner.SpotStrategyRunner).SpotStrategy
+	user, ok := h.userMap.Load(ss.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] outOfSyncSpotStrategy - user '%s' not found", ss.UserUuid)
+		return
+	}
+
+	// Change status
+	data := map[string]interface{}{
+		"position_status": int64(contract.UNKNOWN),
+	}
+	if _, err := h.db.UpdateSpotStrategy(ss.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] outOfSyncSpotStrategy strategy: '%s', user: '%s', symbol: '%s', err: %v", ss.Uuid, ss.UserUuid, ss.Symbol, err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check your balance and order", ss.Symbol)
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		return
+	}
+
+	h.logger.Printf("[Warn] spot strategy: '%s', user: '%s', symbol: '%s' status has been changed to 'UNKNOWN'", ss.Uuid, ss.UserUuid, ss.Symbol)
+	text := fmt.Sprintf("[錯誤] '%s' 資料不同步, 請手動確認", ss.Symbol)
+	h.sender.Send(user.(*db.User).TelegramChatId, text)
+}
+
+func (h *runnerHandler) resetSpotStrategy(uuid string) {
+	r, ok := h.spotRunnerByUuidMap.Load(uuid)
+	if !ok {
+		h.logger.Printf("[Error] resetSpotStrategy - strategy '%s' isn't in the map", uuid)
+		return
+	}
+
+	// Block until finished
+	r.(*runner.SpotStrategyRunner).RunnerBlockWg.Add(1)
+	defer r.(*runner.SpotStrategyRunner).RunnerBlockWg.Done()
+
+	r.(*runner.SpotStrategyRunner).RunnerMutex.Lock()
+	defer r.(*runner.SpotStrategyRunner).RunnerMutex.Unlock()
+
+	// Get strategy data
+	ss := r.(*runner.SpotStrategyRunner).SpotStrategy
+
+	// Get user data
+	user, ok := h.userMap.Load(ss.UserUuid)
+	if !ok {
+		h.logger.Printf("[Error] resetSpotStrategy - user '%s' not found", ss.UserUuid)
+		return
+	}
+
+	// Reset status
+	data := map[string]interface{}{
+		"enabled":                 0,
+		"position_status":         int64(contract.CLOSED),
+		"exchange_orders_details": datatypes.JSONMap{},
+	}
+	if _, err := h.db.UpdateSpotStrategy(ss.Uuid, data); err != nil {
+		h.logger.Printf("[ERROR] resetSpotStrategy strategy: '%s', user: '%s', symbol: '%s', err: %v", ss.Uuid, ss.UserUuid, ss.Symbol, err)
+		text := fmt.Sprintf("[Error] '%s' Internal Server Error. Please check your balance and order", ss.Symbol)
+		go h.sender.Send(user.(*db.User).TelegramChatId, text)
+		return
+	}
+
+	r.(*runner.SpotStrategyRunner).Stop()
+
+	h.logger.Printf("[Info] spot strategy: '%s', user: '%s', symbol: '%s' has been reset", ss.Uuid, ss.UserUuid, ss.Symbol)
+	text := fmt.Sprintf("[提示] 已重置 '%s'", ss.Symbol)
+	h.sender.Send(user.(*db.User).TelegramChatId, text)
+}
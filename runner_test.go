@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto-trading-bot-engine/db"
+	"crypto-trading-bot-engine/exchange"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// Paper accounts aren't saved
+type replayStore struct{}
+
+func (replayStore) GetPaperAccountByUserUuid(string) (*db.PaperAccount, error) {
+	return &db.PaperAccount{}, gorm.ErrRecordNotFound
+}
+
+func (replayStore) CreatePaperAccount(*db.PaperAccount) (int64, error) {
+	return 1, nil
+}
+
+func (replayStore) UpdatePaperAccount(string, map[string]interface{}) (int64, error) {
+	return 1, nil
+}
+
+func TestGetExchangeByUserReplay(t *testing.T) {
+	viper.Set("REPLAY_PATH", "./recordings")
+	defer viper.Set("REPLAY_PATH", nil)
+
+	h := newRunnerHandler(log.New(io.Discard, "", 0))
+	h.paperMarket.SetStore(replayStore{})
+	user := &db.User{Uuid: "user-a", ExchangeApiKey: "iv;data"}
+	ex, err := h.getExchangeByUser("FTX", user)
+	if err != nil {
+		t.Fatalf("TestGetExchangeByUserReplay - unexpected error: %v", err)
+	}
+	paperEx, _ := h.exchangeUserMap.Load("PAPER:" + user.Uuid)
+	if ex != paperEx.(exchange.Exchanger) {
+		t.Error("TestGetExchangeByUserReplay - expect the paper account while replaying")
+	}
+	if _, ok := h.exchangeUserMap.Load("FTX:" + user.Uuid); ok {
+		t.Error("TestGetExchangeByUserReplay - expect no client of FTX while replaying")
+	}
+}
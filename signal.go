@@ -10,7 +10,9 @@ import (
 )
 
 const (
-	SHUTDOWN_TIMEOUT = 300
+	// Retries of contract strategies are cancelled, only the safety-critical steps are waited for
+	// e.g. placing stop-loss order (30s), then closing position (30s) and cancelling stop-loss order (30s) if it fails
+	SHUTDOWN_TIMEOUT = 90
 )
 
 // Gracefull shutdown
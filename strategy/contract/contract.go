@@ -1,6 +1,7 @@
 package contract
 
 import (
+	"context"
 	"crypto-trading-bot-engine/strategy/order"
 	"errors"
 	"fmt"
@@ -46,6 +47,17 @@ type Hooker interface {
 	BreakoutPeakUpdated(*Contract)
 }
 
+// Context-aware variant of the hooks talking to the exchange, ctx of CheckPriceContext is passed along, so that they
+// can give up the retries e.g. shutdown, disable
+// NOTE It's up to the hook which steps are safety-critical and shouldn't be cancelled e.g. placing stop-loss order
+type ContextHooker interface {
+	Hooker
+	EntryTriggeredContext(context.Context, *Contract, time.Time, decimal.Decimal) (decimal.Decimal, bool, error)
+	StopLossTriggerCreatedContext(context.Context, *Contract) (bool, error)
+	StopLossTriggeredContext(context.Context, *Contract, decimal.Decimal) (bool, error)
+	TakeProfitTriggeredContext(context.Context, *Contract, decimal.Decimal) error
+}
+
 type Contract struct {
 	Side            order.Side
 	EntryType       string
@@ -164,7 +176,21 @@ func (c *Contract) SetStatus(status Status) {
 	c.Status = status
 }
 
+// Take the orders of the contract built from the new params, the state e.g. status, breakout peak and hook are kept
+func (c *Contract) ReplaceOrders(n *Contract) {
+	c.Side = n.Side
+	c.EntryType = n.EntryType
+	c.EntryOrder = n.EntryOrder
+	c.TakeProfitOrder = n.TakeProfitOrder
+	c.StopLossOrder = n.StopLossOrder
+}
+
 func (c *Contract) CheckPrice(mark Mark) (halted bool, err error) {
+	return c.CheckPriceContext(context.Background(), mark)
+}
+
+// Same as CheckPrice, ctx is passed to the hook if it implements ContextHooker
+func (c *Contract) CheckPriceContext(ctx context.Context, mark Mark) (halted bool, err error) {
 	switch c.Status {
 	case CLOSED:
 		// Check if entry order is triggered
@@ -180,51 +206,11 @@ func (c *Contract) CheckPrice(mark Mark) (halted bool, err error) {
 
 			// Entry order is triggered
 			var entryPrice decimal.Decimal
-			if entryPrice, halted, err = c.hook.EntryTriggered(c, mark.Time, mark.Price); err != nil || halted {
+			if entryPrice, halted, err = c.entryTriggered(ctx, mark.Time, mark.Price); err != nil || halted {
 				return
 			}
 			c.Status = OPENED
-
-			// Set stop-loss trigger & order
-			if c.StopLossOrder != nil {
-				switch c.EntryType {
-				case order.ENTRY_LIMIT:
-					if halted, err = c.hook.StopLossTriggerCreated(c); err != nil || halted {
-						return
-					}
-				case order.ENTRY_TRENDLINE:
-					// For entry_type 'trendline', stop-loss order will depend on entry price
-					c.setStopLossTrigger(entryPrice)
-					if halted, err = c.hook.StopLossTriggerCreated(c); err != nil || halted {
-						return
-					}
-
-					// Record breakout peak
-					if c.StopLossOrder.(*order.StopLoss).TrendlineReadjustmentEnabled {
-						// Set breakout peak because price is default '0', it casues a bug in Short position
-						c.setBreakoutPeak(mark.Time, mark.Price)
-					}
-				}
-			}
-
-			// To avoid a certain scenario that entry and stop-loss orders are triggered in turn constantly
-			// For example:
-			//      - entry trigger: mark price <= 43000
-			//      - stop-loss trigger: mark price  <= 42000
-			//			These 2 orders will be constantly triggered when the mark price fluctuates around 42000 above and below
-			//			Fix this issue by changing the operator of entry trigger
-			if c.EntryOrder.(*order.Entry).FlipOperatorEnabled {
-				c.EntryOrder.(*order.Entry).FlipOperator(c.Side)
-				c.hook.EntryTriggerOperatorUpdated(c)
-				c.EntryOrder.(*order.Entry).FlipOperatorEnabled = false
-			}
-
-			// For entry trigger during initialisation and setStopLossTrigger
-			if halted, err = c.hook.ParamsUpdated(c); err != nil || halted {
-				return
-			}
-
-			return
+			return c.entered(ctx, mark, entryPrice)
 		}
 	case OPENED:
 		if c.EntryType == order.ENTRY_TRENDLINE && c.StopLossOrder != nil && c.StopLossOrder.(*order.StopLoss).TrendlineReadjustmentEnabled {
@@ -240,7 +226,7 @@ func (c *Contract) CheckPrice(mark Mark) (halted bool, err error) {
 		// Check if stop-loss order is triggered
 		if c.StopLossOrder != nil && c.StopLossOrder.IsTriggered(mark.Time, mark.Price) {
 			// Stop-loss order is triggered
-			if halted, err = c.hook.StopLossTriggered(c, mark.Price); err != nil || halted {
+			if halted, err = c.stopLossTriggered(ctx, mark.Price); err != nil || halted {
 				return
 			}
 			c.Status = CLOSED
@@ -269,7 +255,7 @@ func (c *Contract) CheckPrice(mark Mark) (halted bool, err error) {
 			// Take-profit order is triggered
 			c.Status = CLOSED
 			halted = true
-			if err = c.hook.TakeProfitTriggered(c, mark.Price); err != nil {
+			if err = c.takeProfitTriggered(ctx, mark.Price); err != nil {
 				return
 			}
 
@@ -294,6 +280,88 @@ func (c *Contract) CheckPrice(mark Mark) (halted bool, err error) {
 	return
 }
 
+// Entry order has been filled but the state wasn't saved e.g. the process died right after the order was placed
+// Carry on with the steps after entry, so that stop-loss order is created for the adopted position
+func (c *Contract) AdoptEntry(mark Mark, entryPrice decimal.Decimal) (halted bool, err error) {
+	if c.Status != CLOSED {
+		return true, fmt.Errorf("status '%s' can't adopt entry", TranslateStatus(c.Status))
+	}
+	c.Status = OPENED
+	return c.entered(context.Background(), mark, entryPrice)
+}
+
+// Steps after entry order is filled
+func (c *Contract) entered(ctx context.Context, mark Mark, entryPrice decimal.Decimal) (halted bool, err error) {
+	// Set stop-loss trigger & order
+	if c.StopLossOrder != nil {
+		switch c.EntryType {
+		case order.ENTRY_LIMIT:
+			if halted, err = c.stopLossTriggerCreated(ctx); err != nil || halted {
+				return
+			}
+		case order.ENTRY_TRENDLINE:
+			// For entry_type 'trendline', stop-loss order will depend on entry price
+			c.setStopLossTrigger(entryPrice)
+			if halted, err = c.stopLossTriggerCreated(ctx); err != nil || halted {
+				return
+			}
+
+			// Record breakout peak
+			if c.StopLossOrder.(*order.StopLoss).TrendlineReadjustmentEnabled {
+				// Set breakout peak because price is default '0', it casues a bug in Short position
+				c.setBreakoutPeak(mark.Time, mark.Price)
+			}
+		}
+	}
+
+	// To avoid a certain scenario that entry and stop-loss orders are triggered in turn constantly
+	// For example:
+	//      - entry trigger: mark price <= 43000
+	//      - stop-loss trigger: mark price  <= 42000
+	//			These 2 orders will be constantly triggered when the mark price fluctuates around 42000 above and below
+	//			Fix this issue by changing the operator of entry trigger
+	if c.EntryOrder.(*order.Entry).FlipOperatorEnabled {
+		c.EntryOrder.(*order.Entry).FlipOperator(c.Side)
+		c.hook.EntryTriggerOperatorUpdated(c)
+		c.EntryOrder.(*order.Entry).FlipOperatorEnabled = false
+	}
+
+	// For entry trigger during initialisation and setStopLossTrigger
+	if halted, err = c.hook.ParamsUpdated(c); err != nil || halted {
+		return
+	}
+
+	return
+}
+
+func (c *Contract) entryTriggered(ctx context.Context, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	if h, ok := c.hook.(ContextHooker); ok {
+		return h.EntryTriggeredContext(ctx, c, t, p)
+	}
+	return c.hook.EntryTriggered(c, t, p)
+}
+
+func (c *Contract) stopLossTriggerCreated(ctx context.Context) (bool, error) {
+	if h, ok := c.hook.(ContextHooker); ok {
+		return h.StopLossTriggerCreatedContext(ctx, c)
+	}
+	return c.hook.StopLossTriggerCreated(c)
+}
+
+func (c *Contract) stopLossTriggered(ctx context.Context, p decimal.Decimal) (bool, error) {
+	if h, ok := c.hook.(ContextHooker); ok {
+		return h.StopLossTriggeredContext(ctx, c, p)
+	}
+	return c.hook.StopLossTriggered(c, p)
+}
+
+func (c *Contract) takeProfitTriggered(ctx context.Context, p decimal.Decimal) error {
+	if h, ok := c.hook.(ContextHooker); ok {
+		return h.TakeProfitTriggeredContext(ctx, c, p)
+	}
+	return c.hook.TakeProfitTriggered(c, p)
+}
+
 // entry_type 'trendline' only
 // Set trendline price as cost price
 func (c *Contract) setStopLossTrigger(p decimal.Decimal) {
@@ -1,6 +1,7 @@
 package contract
 
 import (
+	"context"
 	"crypto-trading-bot-engine/strategy/order"
 	"crypto-trading-bot-engine/strategy/trigger"
 	"reflect"
@@ -1269,3 +1270,101 @@ func TestTrendlineReadjustmentTrue(t *testing.T) {
 		}
 	}
 }
+
+func TestAdoptEntry(t *testing.T) {
+	c := &Contract{
+		Side:      order.LONG,
+		EntryType: order.ENTRY_LIMIT,
+		EntryOrder: &order.Entry{Trigger: &trigger.Limit{
+			Operator: "<=",
+			Price:    decimal.NewFromFloat(47000),
+		}},
+		StopLossOrder: &order.StopLoss{Trigger: &trigger.Limit{
+			Operator: "<=",
+			Price:    decimal.NewFromFloat(46000),
+		}},
+	}
+	h := &testHook{}
+	c.SetHook(h)
+
+	// Entry hook isn't called, as the order has been placed
+	mark := Mark{Time: time.Now(), Price: decimal.NewFromFloat(47100)}
+	if halted, err := c.AdoptEntry(mark, mark.Price); halted || err != nil {
+		t.Fatalf("TestAdoptEntry - unexpected halted: '%t', err: %v", halted, err)
+	}
+	if expected := []string{"StopLossTriggerCreated"}; !reflect.DeepEqual(expected, h.funcNames) {
+		t.Errorf("TestAdoptEntry - expect '%v', but got '%v'", expected, h.funcNames)
+	}
+	if c.Status != OPENED {
+		t.Errorf("TestAdoptEntry - expect status 'OPENED', but got '%s'", TranslateStatus(c.Status))
+	}
+
+	// Only closed contract can adopt the entry
+	if _, err := c.AdoptEntry(mark, mark.Price); err == nil {
+		t.Error("TestAdoptEntry - expect error when the position has been opened")
+	}
+}
+
+// Record the context passed to the context-aware hooks
+type testContextHook struct {
+	testHook
+	ctxs []context.Context
+}
+
+func (th *testContextHook) EntryTriggeredContext(ctx context.Context, c *Contract, t time.Time, p decimal.Decimal) (decimal.Decimal, bool, error) {
+	th.ctxs = append(th.ctxs, ctx)
+	return th.EntryTriggered(c, t, p)
+}
+
+func (th *testContextHook) StopLossTriggerCreatedContext(ctx context.Context, c *Contract) (bool, error) {
+	th.ctxs = append(th.ctxs, ctx)
+	return th.StopLossTriggerCreated(c)
+}
+
+func (th *testContextHook) StopLossTriggeredContext(ctx context.Context, c *Contract, p decimal.Decimal) (bool, error) {
+	th.ctxs = append(th.ctxs, ctx)
+	return th.StopLossTriggered(c, p)
+}
+
+func (th *testContextHook) TakeProfitTriggeredContext(ctx context.Context, c *Contract, p decimal.Decimal) error {
+	th.ctxs = append(th.ctxs, ctx)
+	return th.TakeProfitTriggered(c, p)
+}
+
+func TestCheckPriceContext(t *testing.T) {
+	c := &Contract{
+		Side:      order.LONG,
+		EntryType: order.ENTRY_LIMIT,
+		EntryOrder: &order.Entry{Trigger: &trigger.Limit{
+			Operator: "<=",
+			Price:    decimal.NewFromFloat(47000),
+		}},
+		StopLossOrder: &order.StopLoss{Trigger: &trigger.Limit{
+			Operator: "<=",
+			Price:    decimal.NewFromFloat(46000),
+		}},
+	}
+	h := &testContextHook{}
+	c.SetHook(h)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "check")
+	for _, price := range []float64{47000, 45000} {
+		if halted, err := c.CheckPriceContext(ctx, Mark{Time: time.Now(), Price: decimal.NewFromFloat(price)}); halted || err != nil {
+			t.Fatalf("TestCheckPriceContext - unexpected halted: '%t', err: %v", halted, err)
+		}
+	}
+
+	expected := []string{"EntryTriggered", "StopLossTriggerCreated", "StopLossTriggered"}
+	if !reflect.DeepEqual(expected, h.funcNames) {
+		t.Errorf("TestCheckPriceContext - expect '%v', but got '%v'", expected, h.funcNames)
+	}
+	if len(h.ctxs) != len(expected) {
+		t.Fatalf("TestCheckPriceContext - expect %d context-aware calls, but got %d", len(expected), len(h.ctxs))
+	}
+	for i, got := range h.ctxs {
+		if got.Value(ctxKey{}) != "check" {
+			t.Errorf("TestCheckPriceContext - expect the context of CheckPriceContext passed to '%s'", expected[i])
+		}
+	}
+}
@@ -1,5 +1,18 @@
 package strategy
 
+const (
+	// Outcome events of hooks
+	OUTCOME_ENTRY       = "entry"
+	OUTCOME_STOP_LOSS   = "stop_loss"
+	OUTCOME_TAKE_PROFIT = "take_profit"
+)
+
+// The outcome of a hook that has been completed successfully
+type Outcome struct {
+	Uuid  string // contract strategy uuid
+	Event string // e.g. OUTCOME_ENTRY
+}
+
 // Pass contract strategy uuid
 type EventsCh struct {
 	// Restart a strategy for reloading the new data from DB
@@ -16,4 +29,15 @@ type EventsCh struct {
 
 	// Reset contract strategy after fixing some data out of sync
 	Reset chan string
+
+	// Outcome of a strategy e.g. entry taken, stop-loss, take-profit
+	// Handler disables the other strategies of the group and enables the strategies chained to the outcome
+	Outcome chan Outcome
+
+	// Disable all strategies of the user, pass user uuid
+	// NOTE Contract strategies only, sent when the daily loss limit is hit
+	KillSwitch chan string
+
+	// Closed once handler stops listening events, so that the senders that mustn't block forever can give up
+	Stopped chan struct{}
 }
@@ -0,0 +1,111 @@
+package pair
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FORMULA_RATIO  = "ratio"  // price(A) / price(B)
+	FORMULA_SPREAD = "spread" // price(A) - k * price(B)
+)
+
+// Synthetic price of 2 symbols, leg A and leg B
+type Pair struct {
+	SymbolA string
+	SymbolB string
+	Formula string
+	K       decimal.Decimal // hedge ratio, formula 'spread' only
+
+	// The latest marks of each leg
+	MarkA *contract.Mark
+	MarkB *contract.Mark
+}
+
+func NewPair(symbolA string, symbolB string, formula string, k decimal.Decimal) (*Pair, error) {
+	if symbolA == "" || symbolB == "" {
+		return &Pair{}, errors.New("'symbol_a' and 'symbol_b' are required")
+	}
+	if symbolA == symbolB {
+		return &Pair{}, errors.New("'symbol_a' and 'symbol_b' must be different")
+	}
+
+	switch formula {
+	case FORMULA_RATIO:
+	case FORMULA_SPREAD:
+		if !k.IsPositive() {
+			return &Pair{}, errors.New("'hedge_ratio' must be greater than 0")
+		}
+	default:
+		return &Pair{}, fmt.Errorf("formula '%s' not supported", formula)
+	}
+
+	return &Pair{
+		SymbolA: symbolA,
+		SymbolB: symbolB,
+		Formula: formula,
+		K:       k,
+	}, nil
+}
+
+// Update the latest mark of the leg, return false if the symbol doesn't belong to the pair
+func (p *Pair) UpdateMark(symbol string, mark contract.Mark) bool {
+	switch symbol {
+	case p.SymbolA:
+		p.MarkA = &mark
+	case p.SymbolB:
+		p.MarkB = &mark
+	default:
+		return false
+	}
+	return true
+}
+
+// Return false if either of legs hasn't received any mark yet
+func (p *Pair) Ready() bool {
+	return p.MarkA != nil && p.MarkB != nil
+}
+
+// Synthetic mark built from the latest marks of both legs, time is the later one
+func (p *Pair) SyntheticMark() (contract.Mark, error) {
+	if !p.Ready() {
+		return contract.Mark{}, errors.New("mark of leg is missing")
+	}
+	price, err := p.SyntheticPrice(p.MarkA.Price, p.MarkB.Price)
+	if err != nil {
+		return contract.Mark{}, err
+	}
+	t := p.MarkA.Time
+	if p.MarkB.Time.After(t) {
+		t = p.MarkB.Time
+	}
+	return contract.Mark{Price: price, Time: t}, nil
+}
+
+func (p *Pair) SyntheticPrice(a decimal.Decimal, b decimal.Decimal) (decimal.Decimal, error) {
+	switch p.Formula {
+	case FORMULA_RATIO:
+		if b.IsZero() {
+			return decimal.Zero, errors.New("price of leg B is zero")
+		}
+		return a.DivRound(b, 8), nil
+	case FORMULA_SPREAD:
+		return a.Sub(p.K.Mul(b)), nil
+	}
+	return decimal.Zero, fmt.Errorf("formula '%s' not supported", p.Formula)
+}
+
+// Size of each leg
+// 'ratio': both legs have the same notional value
+// 'spread': size of leg B is k times the size of leg A, so that the position follows the spread
+func (p *Pair) LegSizes(margin decimal.Decimal, a decimal.Decimal, b decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+	sizeA := margin.DivRound(a, 8)
+	switch p.Formula {
+	case FORMULA_SPREAD:
+		return sizeA, sizeA.Mul(p.K).Round(8)
+	}
+	return sizeA, margin.DivRound(b, 8)
+}
@@ -0,0 +1,118 @@
+package pair
+
+import (
+	"crypto-trading-bot-engine/strategy/contract"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestNewPair(t *testing.T) {
+	testcases := []struct {
+		title         string
+		symbolA       string
+		symbolB       string
+		formula       string
+		k             decimal.Decimal
+		expectedError bool
+	}{
+		{title: "ratio", symbolA: "ETH-PERP", symbolB: "BTC-PERP", formula: FORMULA_RATIO, expectedError: false},
+		{title: "spread", symbolA: "ETH-PERP", symbolB: "BTC-PERP", formula: FORMULA_SPREAD, k: decimal.NewFromFloat(0.07), expectedError: false},
+		{title: "spread without hedge ratio", symbolA: "ETH-PERP", symbolB: "BTC-PERP", formula: FORMULA_SPREAD, expectedError: true},
+		{title: "same symbols", symbolA: "ETH-PERP", symbolB: "ETH-PERP", formula: FORMULA_RATIO, expectedError: true},
+		{title: "missing symbol", symbolA: "ETH-PERP", formula: FORMULA_RATIO, expectedError: true},
+		{title: "unknown formula", symbolA: "ETH-PERP", symbolB: "BTC-PERP", formula: "sum", expectedError: true},
+	}
+
+	for _, tc := range testcases {
+		_, err := NewPair(tc.symbolA, tc.symbolB, tc.formula, tc.k)
+		hasError := (err != nil)
+		if tc.expectedError != hasError {
+			t.Errorf("TestNewPair case '%s' - expect '%t', but got '%t'", tc.title, tc.expectedError, hasError)
+		}
+	}
+}
+
+func TestSyntheticPrice(t *testing.T) {
+	testcases := []struct {
+		title         string
+		formula       string
+		k             decimal.Decimal
+		a             decimal.Decimal
+		b             decimal.Decimal
+		expectedPrice decimal.Decimal
+		expectedError bool
+	}{
+		{title: "ratio", formula: FORMULA_RATIO, a: decimal.NewFromFloat(3000), b: decimal.NewFromFloat(40000), expectedPrice: decimal.NewFromFloat(0.075)},
+		{title: "ratio with zero leg B", formula: FORMULA_RATIO, a: decimal.NewFromFloat(3000), b: decimal.Zero, expectedError: true},
+		{title: "spread", formula: FORMULA_SPREAD, k: decimal.NewFromFloat(0.07), a: decimal.NewFromFloat(3000), b: decimal.NewFromFloat(40000), expectedPrice: decimal.NewFromFloat(200)},
+		{title: "negative spread", formula: FORMULA_SPREAD, k: decimal.NewFromFloat(0.08), a: decimal.NewFromFloat(3000), b: decimal.NewFromFloat(40000), expectedPrice: decimal.NewFromFloat(-200)},
+	}
+
+	for _, tc := range testcases {
+		p, err := NewPair("ETH-PERP", "BTC-PERP", tc.formula, tc.k)
+		if err != nil {
+			t.Fatalf("TestSyntheticPrice case '%s' - unexpected err: %v", tc.title, err)
+		}
+		price, err := p.SyntheticPrice(tc.a, tc.b)
+		hasError := (err != nil)
+		if tc.expectedError != hasError {
+			t.Errorf("TestSyntheticPrice case '%s' - expect error '%t', but got '%t'", tc.title, tc.expectedError, hasError)
+			continue
+		}
+		if !tc.expectedError && !tc.expectedPrice.Equal(price) {
+			t.Errorf("TestSyntheticPrice case '%s' - expect '%s', but got '%s'", tc.title, tc.expectedPrice, price)
+		}
+	}
+}
+
+func TestSyntheticMark(t *testing.T) {
+	p, _ := NewPair("ETH-PERP", "BTC-PERP", FORMULA_RATIO, decimal.Zero)
+	t1 := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Second)
+
+	p.UpdateMark("ETH-PERP", contract.Mark{Price: decimal.NewFromFloat(4000), Time: t1})
+	if p.Ready() {
+		t.Error("TestSyntheticMark - expect pair isn't ready without mark of leg B")
+	}
+	if _, err := p.SyntheticMark(); err == nil {
+		t.Error("TestSyntheticMark - expect error without mark of leg B")
+	}
+	if p.UpdateMark("SOL-PERP", contract.Mark{Price: decimal.NewFromFloat(200), Time: t2}) {
+		t.Error("TestSyntheticMark - expect symbol 'SOL-PERP' to be ignored")
+	}
+
+	p.UpdateMark("BTC-PERP", contract.Mark{Price: decimal.NewFromFloat(50000), Time: t2})
+	mark, err := p.SyntheticMark()
+	if err != nil {
+		t.Fatalf("TestSyntheticMark - unexpected err: %v", err)
+	}
+	if !mark.Price.Equal(decimal.NewFromFloat(0.08)) {
+		t.Errorf("TestSyntheticMark - expect price '0.08', but got '%s'", mark.Price)
+	}
+	if !mark.Time.Equal(t2) {
+		t.Errorf("TestSyntheticMark - expect time '%s', but got '%s'", t2, mark.Time)
+	}
+}
+
+func TestLegSizes(t *testing.T) {
+	testcases := []struct {
+		title         string
+		formula       string
+		k             decimal.Decimal
+		expectedSizeA decimal.Decimal
+		expectedSizeB decimal.Decimal
+	}{
+		{title: "ratio", formula: FORMULA_RATIO, expectedSizeA: decimal.NewFromFloat(0.25), expectedSizeB: decimal.NewFromFloat(0.02)},
+		{title: "spread", formula: FORMULA_SPREAD, k: decimal.NewFromFloat(0.08), expectedSizeA: decimal.NewFromFloat(0.25), expectedSizeB: decimal.NewFromFloat(0.02)},
+	}
+
+	for _, tc := range testcases {
+		p, _ := NewPair("ETH-PERP", "BTC-PERP", tc.formula, tc.k)
+		sizeA, sizeB := p.LegSizes(decimal.NewFromFloat(1000), decimal.NewFromFloat(4000), decimal.NewFromFloat(50000))
+		if !tc.expectedSizeA.Equal(sizeA) || !tc.expectedSizeB.Equal(sizeB) {
+			t.Errorf("TestLegSizes case '%s' - expect '%s/%s', but got '%s/%s'", tc.title, tc.expectedSizeA, tc.expectedSizeB, sizeA, sizeB)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Operation classes, each of them has its own policy
+const (
+	PLACE_ORDER = "place_order" // e.g. stop-loss order, closing position
+	CANCEL      = "cancel"      // e.g. cancel stop-loss order
+	QUERY       = "query"       // e.g. get position
+	CONFIRM     = "confirm"     // poll the exchange until it's done e.g. stop-loss order executed
+	CHECK       = "check"       // back off before the next mark after the check failed, only the delay is used
+)
+
+type Policy struct {
+	MaxAttempts  int           // including the first one, 0 means no limit but the deadline
+	InitialDelay time.Duration // delay after the first attempt
+	MaxDelay     time.Duration
+	Multiplier   float64       // delay is multiplied after every attempt, 1 means constant
+	Jitter       float64       // 0 ~ 1, delay is reduced by a random fraction up to it, so that retries don't happen at once
+	Deadline     time.Duration // total time of all attempts, 0 means no limit but max attempts
+}
+
+var defaultPolicies = map[string]Policy{
+	PLACE_ORDER: {MaxAttempts: 10, InitialDelay: time.Second, MaxDelay: 8 * time.Second, Multiplier: 2, Jitter: 0.2, Deadline: 30 * time.Second},
+	CANCEL:      {MaxAttempts: 10, InitialDelay: time.Second, MaxDelay: 8 * time.Second, Multiplier: 2, Jitter: 0.2, Deadline: 30 * time.Second},
+	QUERY:       {MaxAttempts: 5, InitialDelay: 500 * time.Millisecond, MaxDelay: 4 * time.Second, Multiplier: 2, Jitter: 0.2, Deadline: 10 * time.Second},
+	CONFIRM:     {MaxAttempts: 30, InitialDelay: 2 * time.Second, MaxDelay: 2 * time.Second, Multiplier: 1, Jitter: 0.1, Deadline: 60 * time.Second},
+	CHECK:       {InitialDelay: 3 * time.Second, MaxDelay: 30 * time.Second, Multiplier: 2, Jitter: 0.1},
+}
+
+// Policy of the class, the fields set in config e.g. 'RETRY.place_order.max_attempts' override the default
+func PolicyOf(class string) Policy {
+	p := defaultPolicies[class]
+	key := "RETRY." + class + "."
+	if viper.IsSet(key + "max_attempts") {
+		p.MaxAttempts = viper.GetInt(key + "max_attempts")
+	}
+	if viper.IsSet(key + "initial_delay_ms") {
+		p.InitialDelay = time.Duration(viper.GetInt64(key+"initial_delay_ms")) * time.Millisecond
+	}
+	if viper.IsSet(key + "max_delay_ms") {
+		p.MaxDelay = time.Duration(viper.GetInt64(key+"max_delay_ms")) * time.Millisecond
+	}
+	if viper.IsSet(key + "multiplier") {
+		p.Multiplier = viper.GetFloat64(key + "multiplier")
+	}
+	if viper.IsSet(key + "jitter") {
+		p.Jitter = viper.GetFloat64(key + "jitter")
+	}
+	if viper.IsSet(key + "deadline_seconds") {
+		p.Deadline = time.Duration(viper.GetInt64(key+"deadline_seconds")) * time.Second
+	}
+	return p
+}
+
+// Delay before the next attempt, attempt starts from 1
+func (p Policy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Stop retrying, the error is returned as it is e.g. size too small, order already closed
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Call op until it succeeds, returns a permanent error, or the policy or ctx gives up
+// The last error of op is returned, ctx error is returned only if op hasn't been called
+func Do(ctx context.Context, p Policy, op func(attempt int) error) error {
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		}
+
+		err = op(attempt)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(p.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestDo(t *testing.T) {
+	errTemp := errors.New("temporary")
+	errFatal := errors.New("fatal")
+	p := Policy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 2}
+
+	testcases := []struct {
+		title            string
+		results          []error
+		expectedErr      error
+		expectedAttempts int
+	}{
+		{title: "succeeded at once", results: []error{nil}, expectedAttempts: 1},
+		{title: "succeeded after retries", results: []error{errTemp, errTemp, nil}, expectedAttempts: 3},
+		{title: "max attempts", results: []error{errTemp, errTemp, errTemp, nil}, expectedErr: errTemp, expectedAttempts: 3},
+		{title: "permanent error", results: []error{errTemp, Permanent(errFatal), nil}, expectedErr: errFatal, expectedAttempts: 2},
+	}
+	for _, tc := range testcases {
+		attempts := 0
+		err := Do(context.Background(), p, func(attempt int) error {
+			attempts = attempt
+			return tc.results[attempt-1]
+		})
+		if err != tc.expectedErr {
+			t.Errorf("TestDo case '%s' - expect err '%v', but got '%v'", tc.title, tc.expectedErr, err)
+		}
+		if attempts != tc.expectedAttempts {
+			t.Errorf("TestDo case '%s' - expect %d attempts, but got %d", tc.title, tc.expectedAttempts, attempts)
+		}
+	}
+}
+
+func TestDoDeadlineAndContext(t *testing.T) {
+	errTemp := errors.New("temporary")
+
+	// Deadline is reached before max attempts
+	p := Policy{MaxAttempts: 100, InitialDelay: 20 * time.Millisecond, Multiplier: 1, Deadline: 50 * time.Millisecond}
+	attempts := 0
+	err := Do(context.Background(), p, func(attempt int) error {
+		attempts = attempt
+		return errTemp
+	})
+	if err != errTemp || attempts >= 100 {
+		t.Errorf("TestDoDeadlineAndContext deadline - expect err '%v' before max attempts, but got '%v' after %d attempts", errTemp, err, attempts)
+	}
+
+	// Cancelled context doesn't call op
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err = Do(ctx, p, func(attempt int) error {
+		called = true
+		return nil
+	})
+	if called || !errors.Is(err, context.Canceled) {
+		t.Errorf("TestDoDeadlineAndContext cancelled - expect op not called and err '%v', but got called '%t' and err '%v'", context.Canceled, called, err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, e := range expected {
+		if d := p.Delay(i + 1); d != e {
+			t.Errorf("TestDelay attempt %d - expect '%s', but got '%s'", i+1, e, d)
+		}
+	}
+
+	// Jitter reduces the delay by up to the fraction
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.Delay(1); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("TestDelay jitter - expect delay within [500ms, 1s], but got '%s'", d)
+		}
+	}
+}
+
+func TestPolicyOf(t *testing.T) {
+	viper.Set("RETRY.query.max_attempts", 7)
+	viper.Set("RETRY.query.deadline_seconds", 3)
+	defer viper.Reset()
+
+	p := PolicyOf(QUERY)
+	if p.MaxAttempts != 7 || p.Deadline != 3*time.Second {
+		t.Errorf("TestPolicyOf - expect overridden max attempts 7 and deadline 3s, but got %d and '%s'", p.MaxAttempts, p.Deadline)
+	}
+	if p.InitialDelay != defaultPolicies[QUERY].InitialDelay {
+		t.Errorf("TestPolicyOf - expect default initial delay '%s', but got '%s'", defaultPolicies[QUERY].InitialDelay, p.InitialDelay)
+	}
+}
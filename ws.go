@@ -3,7 +3,10 @@ package main
 import (
 	"crypto-trading-bot-engine/db"
 	"crypto-trading-bot-engine/exchange"
+	"crypto-trading-bot-engine/exchange/ws"
+	"crypto-trading-bot-engine/strategy/contract"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/spf13/viper"
@@ -19,6 +22,7 @@ type wsHandler struct {
 	signalDoneCh  chan bool
 	db            *db.DB
 	runnerHandler *runnerHandler
+	recorder      *ws.Recorder // record the mark stream for replay, nil if it's disabled
 }
 
 func newWsHandler(l *log.Logger) *wsHandler {
@@ -40,44 +44,102 @@ func (h *wsHandler) setRunnerHandler(rh *runnerHandler) {
 	h.runnerHandler = rh
 }
 
-func (h *wsHandler) connect() {
-	rows, count, err := h.db.GetEnabledContractSymbols("FTX")
-	if err != nil {
-		h.logger.Fatal("err:", err)
+// Exchanges of which ws is connected, WS_EXCHANGES defaults to DEFAULT_EXCHANGE
+// NOTE Only DEFAULT_EXCHANGE is connected if it's replaying, see REPLAY_PATH
+func wsExchangeNames() []string {
+	names := viper.GetStringSlice("WS_EXCHANGES")
+	if len(names) == 0 || viper.GetString("REPLAY_PATH") != "" {
+		names = []string{viper.GetString("DEFAULT_EXCHANGE")}
 	}
-	if count == 0 {
-		h.logger.Fatal("There is no symbol")
-	}
-	var symbols []string
-	for _, symbol := range rows {
-		symbols = append(symbols, symbol.Name)
+	return names
+}
+
+// Connect ws of each exchange, it's blocked until all of them are closed
+func (h *wsHandler) connect() {
+	// The first ws receiving stop signal stops all runners, the others wait until it's done
+	var once sync.Once
+	stopAll := func() { once.Do(h.runnerHandler.stopAll) }
+
+	var wg sync.WaitGroup
+	for _, name := range wsExchangeNames() {
+		// New exchange for ws, replay the recording instead if it's given
+		exName := name
+		if viper.GetString("REPLAY_PATH") != "" {
+			exName = "REPLAY"
+		}
+		ws, err := exchange.NewWsExchange(exName)
+		if err != nil {
+			h.logger.Fatal(err)
+		}
+		ws.SetBroadcastMarkFunc(h.newBroadcastMarkFunc(name))
+		ws.SetStopCh(h.wsStopCh)
+		ws.SetStopAllFunc(stopAll)
+		if m, ok := ws.(exchange.SymbolMapper); ok {
+			if err := h.runnerHandler.reloadSymbolMap(name); err != nil {
+				h.logger.Fatal(err)
+			}
+			m.SetSymbolMap(h.runnerHandler.getSymbolMap(name))
+		}
+
+		// NOTE Symbols are subscribed by runner handler when strategies are enabled, it could start with no symbol
+		h.runnerHandler.setWsExchange(name, ws)
+
+		wg.Add(1)
+		go func(name string, ws exchange.WsExchanger) {
+			defer wg.Done()
+			h.listen(name, ws)
+		}(name, ws)
 	}
+	wg.Wait()
 
-	// New exchange for ws
-	ws, err := exchange.NewWsExchange(viper.GetString("DEFAULT_EXCHANGE"))
-	if err != nil {
-		h.logger.Fatal(err)
+	if h.recorder != nil {
+		if err := h.recorder.Close(); err != nil {
+			h.logger.Printf("[ws] failed to close recorder, err: %v", err)
+		}
 	}
-	ws.SetBroadcastMarkFunc(h.runnerHandler.broadcastMark)
-	ws.SetStopCh(h.wsStopCh)
-	ws.SetStopAllFunc(h.runnerHandler.stopAll)
+	h.signalDoneCh <- true
+}
+
+func (h *wsHandler) listen(name string, ws exchange.WsExchanger) {
 	debug := true
 
 	// TODO FIXME if network is cut off, it won't come back
 	for {
-		h.logger.Println("[ws] connecting...")
-		end, err := ws.ListenPublicTradesChannel(symbols, debug)
+		h.logger.Printf("[ws] %s connecting...", name)
+		end, err := ws.ListenPublicTradesChannel(nil, debug)
 		if err != nil {
-			h.logger.Printf("[ws] error: %s", err)
+			h.logger.Printf("[ws] %s error: %s", name, err)
 		}
 		if end {
 			break
 		}
-		h.logger.Println("[ws] disconnected")
-		h.logger.Printf("[ws] retry after %d seconds\n", WS_RETRY_SLEEP_SECONDS)
+		h.logger.Printf("[ws] %s disconnected", name)
+		h.logger.Printf("[ws] %s retry after %d seconds\n", name, WS_RETRY_SLEEP_SECONDS)
 		time.Sleep(time.Second * WS_RETRY_SLEEP_SECONDS)
 	}
-	h.signalDoneCh <- true
+}
+
+// Record marks before broadcasting if recorder is enabled
+// NOTE Only the marks of DEFAULT_EXCHANGE are recorded, so that they can be replayed in place of it
+func (h *wsHandler) newBroadcastMarkFunc(name string) func(string, contract.Mark) {
+	broadcastMark := func(symbol string, mark contract.Mark) {
+		h.runnerHandler.broadcastMark(name, symbol, mark)
+	}
+	dir := viper.GetString("MARK_RECORDER_DIR")
+	if dir == "" || name != viper.GetString("DEFAULT_EXCHANGE") {
+		return broadcastMark
+	}
+	recorder, err := ws.NewRecorder(dir, time.Minute*time.Duration(viper.GetInt64("MARK_RECORDER_ROTATE_MINUTES")))
+	if err != nil {
+		h.logger.Fatal(err)
+	}
+	h.recorder = recorder
+	return func(symbol string, mark contract.Mark) {
+		if err := h.recorder.Record(symbol, mark); err != nil {
+			h.logger.Printf("[ws] failed to record mark, err: %v", err)
+		}
+		broadcastMark(symbol, mark)
+	}
 }
 
 func (h *wsHandler) close() {